@@ -7,6 +7,7 @@ import (
 	"os/signal"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
@@ -15,32 +16,53 @@ import (
 	"github.com/pkg/errors"
 	"github.com/spf13/viper"
 
+	"github.com/akitasoftware/akita-cli/cfg"
 	"github.com/akitasoftware/akita-cli/ci"
+	"github.com/akitasoftware/akita-cli/control"
+	"github.com/akitasoftware/akita-cli/dashboard"
+	"github.com/akitasoftware/akita-cli/dedup"
 	"github.com/akitasoftware/akita-cli/deployment"
+	"github.com/akitasoftware/akita-cli/endpoint_metrics"
+	"github.com/akitasoftware/akita-cli/filter_expr"
+	"github.com/akitasoftware/akita-cli/har_writer"
+	"github.com/akitasoftware/akita-cli/learn"
+	"github.com/akitasoftware/akita-cli/listen_ports"
 	"github.com/akitasoftware/akita-cli/location"
+	"github.com/akitasoftware/akita-cli/ndjson"
+	"github.com/akitasoftware/akita-cli/otlp_export"
+	"github.com/akitasoftware/akita-cli/pair_cache"
+	"github.com/akitasoftware/akita-cli/path_inference"
 	"github.com/akitasoftware/akita-cli/pcap"
+	"github.com/akitasoftware/akita-cli/pii"
 	"github.com/akitasoftware/akita-cli/plugin"
 	"github.com/akitasoftware/akita-cli/printer"
+	"github.com/akitasoftware/akita-cli/process_info"
+	"github.com/akitasoftware/akita-cli/prom_metrics"
+	"github.com/akitasoftware/akita-cli/redact"
 	"github.com/akitasoftware/akita-cli/rest"
+	"github.com/akitasoftware/akita-cli/sampling"
 	"github.com/akitasoftware/akita-cli/tcp_conn_tracker"
 	"github.com/akitasoftware/akita-cli/tls_conn_tracker"
 	"github.com/akitasoftware/akita-cli/trace"
+	"github.com/akitasoftware/akita-cli/traffic_direction"
+	"github.com/akitasoftware/akita-cli/upload_spool"
 	"github.com/akitasoftware/akita-cli/util"
 	"github.com/akitasoftware/akita-libs/akid"
 	"github.com/akitasoftware/akita-libs/akiuri"
+	pp "github.com/akitasoftware/akita-libs/path_pattern"
 	"github.com/akitasoftware/akita-libs/tags"
 )
 
-// TODO(kku): make pcap timings more robust (e.g. inject a sentinel packet to
-// mark start and end of pcap).
+// Sentinel packets mark when each capture has started and drained. These are
+// the longest we wait for them.
 const (
 	// Empirically, it takes 1s for pcap to be ready to process packets.
 	// We budget for 5x to be safe.
 	pcapStartWaitTime = 5 * time.Second
 
 	// Empirically, it takes 1s for the first packet to become available for
-	// processing.
-	// We budget for 5x to be safe.
+	// processing. If sentinels can't be used, we always wait this long before
+	// stopping.
 	pcapStopWaitTime = 5 * time.Second
 )
 
@@ -48,6 +70,35 @@ const (
 	subcommandOutputDelimiter = "======= _AKITA_SUBCOMMAND_ ======="
 )
 
+// Formats for traces written to a local directory.
+const (
+	HARFormat    = "har"
+	NDJSONFormat = "ndjson"
+)
+
+// Sampling policies.
+const (
+	// Requests are sampled by a hash of their stream ID and sequence number,
+	// and rate-limited in sampling intervals.
+	UniformSampling = "uniform"
+
+	// Requests are sampled once their response has been seen, keeping errors,
+	// slow requests and new endpoints.
+	OutcomeSampling = "outcome"
+)
+
+// Name of the PII report written to the local output directory, unless
+// another path is given.
+const piiReportFileName = "akita_pii_report.json"
+
+// Name of the per-endpoint rate-limit report written to the local output
+// directory, unless another path is given.
+const endpointReportFileName = "akita_endpoint_report.json"
+
+// Name of the per-endpoint metrics file written to the local output directory,
+// unless another path is given.
+const endpointMetricsFileName = "akita_endpoint_metrics.json"
+
 type filterState string
 
 const (
@@ -75,10 +126,95 @@ type Args struct {
 	PathAllowlist  []string
 	HostAllowlist  []string
 
-	// Rate-limiting parameters -- only one should be set to a non-default value.
+	// If set, Filter is built from the TCP ports with listening sockets in
+	// AutoFilterScope, and extended as new listeners appear. Can't be used with
+	// Filter.
+	AutoFilter      bool
+	AutoFilterScope listen_ports.Scope
+
+	// Filter expressions, applied together with the path and host filters.
+	FilterRules filter_expr.Rules
+
+	// If set, witnesses, HAR entries and NDJSON exchanges are tagged with the
+	// process, container and Kubernetes pod that handled them, and filter
+	// expressions may refer to them.
+	AttributeProcesses bool
+
+	// If set, path parameters are inferred locally. Witnesses uploaded to Akita
+	// Cloud use path templates inferred from the traffic seen so far, and local
+	// HAR files are rewritten once capture stops. PathParams overrides
+	// the inferred templates, using the same format as --path-parameters in
+	// apispec.
+	InferPathParams bool
+	PathParams      []string
+
+	// If set, sensitive values are detected and annotated, and a summary is
+	// written to PIIReport. PIIDetectorConfig optionally names a file with
+	// additional user-defined detectors.
+	DetectPII         bool
+	PIIDetectorConfig string
+	PIIReport         string
+
+	// If set, names a file of redaction rules that are applied to all traffic
+	// before it is written or uploaded.
+	RedactionRules string
+
+	// Format of local traces: HARFormat (the default) or NDJSONFormat.
+	LocalFormat string
+
+	// Controls rotation and compression of local HAR files.
+	HAROptions har_writer.Options
+
+	// Bounds the partial witnesses held while waiting for their pair.
+	PairCache pair_cache.Options
+
+	// If set, requests of shapes already uploaded to Akita Cloud are
+	// suppressed and counted instead.
+	Dedup *dedup.Options
+
+	// Which traffic to capture, by direction relative to the host. Defaults to
+	// traffic_direction.CaptureBoth.
+	Direction traffic_direction.Filter
+
+	// Rate-limiting parameters -- only one should be set to a non-default value,
+	// unless SamplePolicy is OutcomeSampling.
 	SampleRate         float64
 	WitnessesPerMinute float64
 
+	// UniformSampling (the default) or OutcomeSampling. With OutcomeSampling,
+	// SampleRate applies to requests that aren't otherwise kept, and requests
+	// that take at least SlowRequestThreshold are always kept.
+	SamplePolicy         string
+	SlowRequestThreshold time.Duration
+
+	// If set, WitnessesPerMinute is divided among endpoints, and a report of
+	// the requests observed and kept per endpoint is written to
+	// EndpointReport. The WitnessesPerMinute field of the options is ignored.
+	EndpointLimit  *sampling.EndpointLimitOptions
+	EndpointReport string
+
+	// If set, request counts, status codes and latencies are collected for
+	// each endpoint, printed as a table at the end, and written as JSON to
+	// EndpointMetricsFile.
+	EndpointMetrics     bool
+	EndpointMetricsFile string
+
+	// If set, a span is exported over OTLP for each HTTP exchange that passes
+	// the filters.
+	OTLP *otlp_export.Options
+
+	// If set, a live dashboard is shown in the terminal until the user stops
+	// the capture. Can't be used with ExecCommand or Phases.
+	TUI bool
+
+	// If any are set, capture stops by itself after Duration, or once
+	// MaxRequests HTTP requests or MaxBytes of TCP traffic have been captured,
+	// whichever comes first. Requests are counted after filtering. Can't be
+	// used with ExecCommand or Phases.
+	Duration    time.Duration
+	MaxRequests int
+	MaxBytes    int64
+
 	// If set, apidump will run the command in a subshell and terminate
 	// automatically when the subcommand terminates.
 	//
@@ -86,10 +222,31 @@ type Args struct {
 	// with non-zero exit code, apidump will also exit with the same exit code.
 	ExecCommand string
 
-	// Username to run ExecCommand as. If not set, defaults to the current user.
+	// If set, apidump runs the command of each phase in turn, like
+	// ExecCommand, and terminates once they have all finished. Traffic is
+	// tagged with the phase it was observed in, and the outcome of each phase
+	// is reported at the end. Can't be used with ExecCommand.
+	//
+	// If a phase fails, later phases are skipped unless they are always run,
+	// and apidump exits with the exit code of the first phase that failed.
+	Phases []Phase
+
+	// Username to run ExecCommand and Phases as. If not set, defaults to the
+	// current user.
 	ExecCommandUser string
 
+	// If set, Prometheus metrics are served over HTTP on this address, e.g.
+	// ":9090".
+	MetricsAddr string
+
+	// If set, commands from "akita apidump ctl" are served on a Unix socket at
+	// this path while capturing.
+	ControlSocket string
+
 	Plugins []plugin.AkitaPlugin
+
+	// Set by RunSelfTest.
+	selfTest *selfTest
 }
 
 func (args *Args) lint() {
@@ -201,6 +358,47 @@ func DumpPacketCounters(interfaces map[string]interfaceInfo, matchedSummary *tra
 
 }
 
+// DumpPairCacheMetrics prints how partial witnesses fared in the pair caches,
+// at Debug level, to stderr.
+func DumpPairCacheMetrics() {
+	m := pair_cache.GetTotals()
+	if m == (pair_cache.Metrics{}) {
+		return
+	}
+
+	printer.Stderr.Debugf("==================================================\n")
+	printer.Stderr.Debugf("Request/response pairing:\n")
+	printer.Stderr.Debugf("%12v %8d\n", "paired", m.Hits)
+	printer.Stderr.Debugf("%12v %8d\n", "cached", m.Misses)
+	printer.Stderr.Debugf("%12v %8d\n", "expired", m.Expirations)
+	printer.Stderr.Debugf("%12v %8d\n", "evicted", m.Evictions)
+	printer.Stderr.Debugf("==================================================\n")
+}
+
+// DumpDecompressionCounters prints the number of HTTP bodies we succeeded or
+// failed to decompress with each content encoding, at Debug level, to stderr.
+func DumpDecompressionCounters() {
+	counts := learn.GetDecompressionCounts()
+	if len(counts) == 0 {
+		return
+	}
+
+	printer.Stderr.Debugf("==================================================\n")
+	printer.Stderr.Debugf("HTTP body decompression by encoding:\n")
+	printer.Stderr.Debugf("%10v %11v %11v\n", "", "header   ", "fallback  ")
+	printer.Stderr.Debugf("%10v %5v %5v %5v %5v\n", "encoding", "ok", "fail", "ok", "fail")
+	for _, c := range counts {
+		printer.Stderr.Debugf("%10s %5d %5d %5d %5d\n",
+			c.Encoding,
+			c.Succeeded,
+			c.Failed,
+			c.FallbackSucceeded,
+			c.FallbackFailed,
+		)
+	}
+	printer.Stderr.Debugf("==================================================\n")
+}
+
 // args.Tags may be initialized via the command line, but automated settings
 // are mainly performed here (for now.)
 func collectTraceTags(args *Args) map[tags.Key]string {
@@ -255,9 +453,9 @@ func compileRegexps(filters []string, name string) ([]*regexp.Regexp, error) {
 func Run(args Args) error {
 	args.lint()
 
-	// During debugging, capture packets not matching the user's filters so we can
-	// report statistics on those packets.
-	capturingNegation := viper.GetBool("debug")
+	// During debugging and self-tests, capture packets not matching the user's
+	// filters so we can report statistics on those packets.
+	capturingNegation := viper.GetBool("debug") || args.selfTest != nil
 
 	if capturingNegation {
 		printer.Debugln("Capturing filtered traffic for debugging.")
@@ -269,6 +467,15 @@ func Run(args Args) error {
 		return errors.Wrap(err, "failed to list network interfaces")
 	}
 
+	var autoFilter *autoFilter
+	if args.AutoFilter {
+		if autoFilter, err = newAutoFilter(args.AutoFilterScope, interfaces, capturingNegation); err != nil {
+			return err
+		}
+		args.Filter = autoFilter.filter()
+		printer.Stderr.Infof("Capturing with --filter %q\n", args.Filter)
+	}
+
 	// Build the user-specified filter and its negation for each interface.
 	userFilters, negationFilters, err := createBPFFilters(interfaces, args.Filter, capturingNegation, 0)
 	if err != nil {
@@ -290,6 +497,10 @@ func Run(args Args) error {
 	if err != nil {
 		return err
 	}
+	// Shared by everything that names endpoints, so that they all agree on path
+	// templates, and honour the user's patterns and exclusions.
+	inferrer := newPathInferrer(args.PathParams, pathExclusions)
+
 	pathAllowlist, err := compileRegexps(args.PathAllowlist, "path filter")
 	if err != nil {
 		return err
@@ -298,6 +509,32 @@ func Run(args Args) error {
 	if err != nil {
 		return err
 	}
+	filterExpr, err := args.FilterRules.Compile()
+	if err != nil {
+		return err
+	}
+	if filterExpr != nil {
+		printer.Debugln("Filter expression:", filterExpr)
+	}
+
+	var owners *process_info.Resolver
+	if args.AttributeProcesses {
+		owners = process_info.NewResolver()
+		defer owners.Close()
+		if filterExpr != nil {
+			filterExpr.SetOwners(owners)
+		}
+	} else if filterExpr != nil && filterExpr.NeedsOwners() {
+		return errors.New("filter expressions on the process, container or pod require AttributeProcesses")
+	}
+
+	if args.ExecCommand != "" && len(args.Phases) > 0 {
+		return errors.New("ExecCommand can't be used with Phases")
+	}
+	var phases *trace.Phases
+	if len(args.Phases) > 0 {
+		phases = trace.NewPhases()
+	}
 
 	// Validate args.Out and fill in any missing defaults.
 	if uri := args.Out.AkitaURI; uri != nil {
@@ -343,25 +580,138 @@ func Run(args Args) error {
 		}
 	}
 
+	// Each interface has its own addresses, and so its own direction detector.
+	directionFilter := args.Direction
+	if directionFilter == "" {
+		directionFilter = traffic_direction.CaptureBoth
+	}
+	detectors := make(map[string]*traffic_direction.Detector, len(interfaces))
+	for interfaceName := range interfaces {
+		addrs, err := pcap.InterfaceAddrs(interfaceName)
+		if err != nil {
+			printer.Warningf("Reporting all traffic on %s as inbound: %v\n", interfaceName, err)
+		}
+		detectors[interfaceName] = traffic_direction.NewDetector(addrs)
+	}
+
 	// Initialize packet counts
 	filterSummary := trace.NewPacketCountSummary()
 	negationSummary := trace.NewPacketCountSummary()
 
-	numUserFilters := len(pathExclusions) + len(hostExclusions) + len(pathAllowlist) + len(hostAllowlist)
+	numUserFilters := len(pathExclusions) + len(hostExclusions) + len(pathAllowlist) + len(hostAllowlist) + len(args.FilterRules.Allow) + len(args.FilterRules.Exclude)
 	prefilterSummary := trace.NewPacketCountSummary()
 
-	// Initialized shared rate object, if we are configured with a rate limit
+	// The dashboard and the control socket show endpoint metrics even if they
+	// aren't reported at the end, and the self-test counts the requests that
+	// pass the filters with them.
+	var endpointMetrics *endpoint_metrics.Metrics
+	if args.EndpointMetrics || args.TUI || args.ControlSocket != "" || args.selfTest != nil {
+		endpointMetrics = endpoint_metrics.NewMetrics(inferrer)
+	}
+
+	// Lets the dashboard pause uploads, and the control socket report on them.
+	var uploads *trace.UploadSwitch
+	if (args.TUI || args.ControlSocket != "") && args.Out.AkitaURI != nil {
+		uploads = &trace.UploadSwitch{}
+	}
+
+	// Shared by all collectors, so that spans from all interfaces are exported
+	// together.
+	var spanExporter *otlp_export.Exporter
+	if args.OTLP != nil {
+		spanExporter, err = otlp_export.NewExporter(*args.OTLP, inferrer)
+		if err != nil {
+			return err
+		}
+		// Closed explicitly once collection stops; this covers early returns.
+		defer spanExporter.Close()
+	}
+
+	// Shared by all back-end collectors, so that a shape seen on one interface
+	// is suppressed on the others.
+	var deduplicator *dedup.Deduplicator
+	if args.Dedup != nil {
+		deduplicator = dedup.New(*args.Dedup, inferrer)
+	}
+
+	// Initialize the shared sampling engine or rate object, depending on the
+	// sampling policy.
+	var samplingEngine *sampling.Engine
+	var endpointLimit *sampling.EndpointRateLimit
 	var rateLimit *trace.SharedRateLimit
-	if args.WitnessesPerMinute != 0.0 {
+	if args.EndpointLimit != nil && args.WitnessesPerMinute != 0.0 {
+		opts := *args.EndpointLimit
+		opts.WitnessesPerMinute = args.WitnessesPerMinute
+		endpointLimit = sampling.NewEndpointRateLimit(opts, inferrer)
+	}
+	if args.SamplePolicy == OutcomeSampling {
+		samplingEngine = sampling.NewEngine(sampling.Policy{
+			SampleRate:         args.SampleRate,
+			WitnessesPerMinute: args.WitnessesPerMinute,
+			SlowThreshold:      args.SlowRequestThreshold,
+			EndpointLimit:      endpointLimit,
+		}, inferrer)
+	} else if endpointLimit == nil && args.WitnessesPerMinute != 0.0 {
 		rateLimit = trace.NewRateLimit(args.WitnessesPerMinute)
 		defer rateLimit.Stop()
 	}
 
+	// The control socket can pause capture and change the sample rate.
+	var capture *trace.CaptureSwitch
+	var sampleRate *trace.SampleRate
+	if args.ControlSocket != "" {
+		capture = &trace.CaptureSwitch{}
+	}
+	if args.ControlSocket != "" && samplingEngine == nil {
+		sampleRate = trace.NewSampleRate(args.SampleRate)
+	}
+
+	if args.MetricsAddr != "" {
+		registerMetrics(prom_metrics.Default, filterSummary, rateLimit, samplingEngine, endpointLimit)
+		server, err := prom_metrics.Serve(args.MetricsAddr, prom_metrics.Default)
+		if err != nil {
+			return err
+		}
+		defer server.Close()
+	}
+
+	// Set up PII detection and redaction, which share a detector registry. The
+	// annotator runs as a plugin ahead of any user-supplied plugins, so that it
+	// sees values before they are modified.
+	plugins := args.Plugins
+	var piiAnnotator *pii.Annotator
+	var redactionEngine *redact.Engine
+	if args.DetectPII || args.RedactionRules != "" {
+		registry, err := pii.NewRegistryFromConfig(args.PIIDetectorConfig)
+		if err != nil {
+			return err
+		}
+
+		if args.DetectPII {
+			piiAnnotator = pii.NewAnnotator(registry, inferrer)
+			plugins = append([]plugin.AkitaPlugin{piiAnnotator}, plugins...)
+		}
+
+		if args.RedactionRules != "" {
+			cfg, err := redact.LoadConfig(args.RedactionRules)
+			if err != nil {
+				return err
+			}
+			if redactionEngine, err = redact.NewEngine(cfg, registry); err != nil {
+				return errors.Wrapf(err, "bad redaction rules in %s", args.RedactionRules)
+			}
+		}
+	}
+
 	// Start collecting
 	var doneWG sync.WaitGroup
 	doneWG.Add(len(userFilters) + len(negationFilters))
+	// Synchronize with each capture when it starts and before it stops.
+	var sentinels []*pcap.Sentinels
 	errChan := make(chan error, len(userFilters)+len(negationFilters)) // buffered enough so it never blocks
 	stop := make(chan struct{})
+	var harCollectors []*trace.HARCollector
+	var backendCollectors []*trace.BackendCollector
 	for _, filterState := range []filterState{matchedFilter, notMatchedFilter} {
 		var summary *trace.PacketCountSummary
 		var filters map[string]string
@@ -375,14 +725,30 @@ func Run(args Args) error {
 
 		for interfaceName, filter := range filters {
 			var collector trace.Collector
+			backendOpts := trace.BackendCollectorOptions{
+				PairCache: args.PairCache,
+				Direction: detectors[interfaceName],
+				Dedup:     deduplicator,
+				Uploads:   uploads,
+				Owners:    owners,
+				Phases:    phases,
+				SpoolDir:  cfg.GetUploadSpoolDir(),
+			}
+			if args.InferPathParams {
+				backendOpts.Inferrer = inferrer
+			}
 
 			// Build collectors from the inside out (last applied to first applied).
-			//  8. Back-end collector (sink).
-			//  7. Statistics.
-			//  6. Subsampling.
-			//  5. Path and host filters.
-			//  4. Eliminate Akita CLI traffic.
-			//  3. Count packets before user filters for diagnostics.
+			// 12. Back-end collector (sink).
+			// 11. Redaction.
+			// 10. Statistics.
+			//  9. Subsampling.
+			//  8. Endpoint metrics and span export.
+			//  7. Path, host and expression filters.
+			//  6. Traffic direction filter.
+			//  5. Eliminate Akita CLI traffic.
+			//  4. Count packets before user filters for diagnostics.
+			//  3. Drop HTTP traffic while capture is paused.
 			//  2. Process TLS traffic into TLS-connection metadata.
 			//  1. Aggregate TCP-packet metadata into TCP-connection metadata.
 
@@ -396,27 +762,50 @@ func Run(args Args) error {
 			} else {
 				var localCollector trace.Collector
 				if args.Out.LocalPath != nil {
-					if lc, err := createLocalCollector(interfaceName, *args.Out.LocalPath, traceTags); err == nil {
+					if lc, err := createLocalCollector(interfaceName, *args.Out.LocalPath, traceTags, args.LocalFormat, args.HAROptions, owners, phases); err == nil {
 						localCollector = lc
+						if hc, ok := lc.(*trace.HARCollector); ok {
+							harCollectors = append(harCollectors, hc)
+						}
 					} else {
 						return err
 					}
 				}
 
+				var backendCollector *trace.BackendCollector
+				if args.Out.AkitaURI != nil {
+					backendCollector = trace.NewBackendCollectorWithOptions(backendSvc, backendLrn, learnClient, plugins, backendOpts)
+					backendCollectors = append(backendCollectors, backendCollector)
+				}
+
 				if args.Out.AkitaURI != nil && args.Out.LocalPath != nil {
 					collector = trace.TeeCollector{
-						Dst1: trace.NewBackendCollector(backendSvc, backendLrn, learnClient, args.Plugins),
+						Dst1: backendCollector,
 						Dst2: localCollector,
 					}
 				} else if args.Out.AkitaURI != nil {
-					collector = trace.NewBackendCollector(backendSvc, backendLrn, learnClient, args.Plugins)
+					collector = backendCollector
 				} else if args.Out.LocalPath != nil {
 					collector = localCollector
+
+					// Without a back-end collector, nothing else turns the traffic into
+					// witnesses for the annotator.
+					if piiAnnotator != nil {
+						collector = trace.TeeCollector{
+							Dst1: pii.NewCollector(piiAnnotator),
+							Dst2: collector,
+						}
+					}
 				} else {
 					return errors.Errorf("invalid output location")
 				}
 			}
 
+			// Redaction.
+			if redactionEngine != nil {
+				collector = redact.NewCollector(redactionEngine, collector)
+			}
+
 			// Statistics.
 			//
 			// Count packets that have *passed* filtering (so that we know whether the
@@ -426,14 +815,42 @@ func Run(args Args) error {
 				PacketCounts: summary,
 				Collector:    collector,
 			}
+			if phases != nil && filterState == matchedFilter {
+				collector = trace.NewPhaseCountCollector(phases, collector)
+			}
 
 			// Subsampling.
-			collector = trace.NewSamplingCollector(args.SampleRate, collector)
-			if rateLimit != nil {
-				collector = rateLimit.NewCollector(collector)
+			if samplingEngine != nil {
+				collector = sampling.NewCollector(samplingEngine, collector)
+			} else {
+				if sampleRate != nil {
+					collector = trace.NewSharedSamplingCollector(sampleRate, collector)
+				} else {
+					collector = trace.NewSamplingCollector(args.SampleRate, collector)
+				}
+				if endpointLimit != nil {
+					collector = sampling.NewEndpointRateLimitCollector(endpointLimit, collector)
+				} else if rateLimit != nil {
+					collector = rateLimit.NewCollector(collector)
+				}
+			}
+
+			// Endpoint metrics. These are collected before subsampling, so that
+			// they reflect all traffic that passes the filters.
+			if endpointMetrics != nil {
+				collector = endpoint_metrics.NewCollector(endpointMetrics, collector)
+			}
+
+			// Span export. Like endpoint metrics, spans are exported for all traffic
+			// that passes the filters, before subsampling.
+			if spanExporter != nil && filterState == matchedFilter {
+				collector = otlp_export.NewCollector(spanExporter, detectors[interfaceName], collector)
 			}
 
-			// Path and host filters.
+			// Path, host and expression filters.
+			if filterExpr != nil {
+				collector = trace.NewExpressionFilterCollector(filterExpr, collector)
+			}
 			if len(hostExclusions) > 0 {
 				collector = trace.NewHTTPHostFilterCollector(hostExclusions, collector)
 			}
@@ -447,6 +864,12 @@ func Run(args Args) error {
 				collector = trace.NewHTTPPathAllowlistCollector(pathAllowlist, collector)
 			}
 
+			// Traffic direction filter. This also feeds TCP metadata to the
+			// detector, so it is installed even when capturing both directions.
+			if filterState == matchedFilter {
+				collector = trace.NewDirectionFilterCollector(detectors[interfaceName], directionFilter, collector)
+			}
+
 			// Eliminate Akita CLI traffic, unless --dogfood has been specified
 			if !viper.GetBool("dogfood") {
 				collector = &trace.UserTrafficCollector{
@@ -455,13 +878,22 @@ func Run(args Args) error {
 			}
 
 			// Count packets before user filters for diagnostics
-			if filterState == matchedFilter && numUserFilters > 0 {
+			if filterState == matchedFilter && (numUserFilters > 0 || args.selfTest != nil) {
 				collector = &trace.PacketCountCollector{
 					PacketCounts: prefilterSummary,
 					Collector:    collector,
 				}
 			}
 
+			// Drop HTTP traffic while capture is paused, or observed during an
+			// uncaptured phase.
+			if capture != nil {
+				collector = trace.NewPausableCollector(capture, collector)
+			}
+			if phases != nil {
+				collector = trace.NewPhaseFilterCollector(phases, collector)
+			}
+
 			// Process TLS traffic into TLS-connection metadata.
 			collector = tls_conn_tracker.NewCollector(collector)
 
@@ -472,23 +904,31 @@ func Run(args Args) error {
 			// (gopacket does not currently permit a unified page cache for packet reassembly.)
 			bufferShare := 1.0 / float32(len(negationFilters)+len(userFilters))
 
+			filterUpdates := autoFilter.updatesFor(filterState, interfaceName)
+			captureSentinels := pcap.NewSentinels()
+			sentinels = append(sentinels, captureSentinels)
+
 			go func(interfaceName, filter string) {
 				defer doneWG.Done()
 				// Collect trace. This blocks until stop is closed or an error occurs.
-				if err := trace.Collect(stop, interfaceName, filter, bufferShare, collector, summary); err != nil {
+				opts := trace.CollectOptions{
+					BufferShare:   bufferShare,
+					FilterUpdates: filterUpdates,
+					PacketCount:   summary,
+					Sentinels:     captureSentinels,
+				}
+				if err := trace.Collect(stop, interfaceName, filter, collector, opts); err != nil {
 					errChan <- errors.Wrapf(err, "failed to collect trace on interface %s", interfaceName)
 				}
 			}(interfaceName, filter)
 		}
 	}
 
-	{
-		iNames := make([]string, 0, len(interfaces))
-		for n := range interfaces {
-			iNames = append(iNames, n)
-		}
-		printer.Stderr.Infof("Running learn mode on interfaces %s\n", strings.Join(iNames, ", "))
+	iNames := make([]string, 0, len(interfaces))
+	for n := range interfaces {
+		iNames = append(iNames, n)
 	}
+	printer.Stderr.Infof("Running learn mode on interfaces %s\n", strings.Join(iNames, ", "))
 
 	unfiltered := true
 	for _, f := range userFilters {
@@ -501,11 +941,94 @@ func Run(args Args) error {
 		printer.Stderr.Warningf("%s\n", printer.Color.Yellow("--filter flag is not set, this means that all network traffic is treated as your API traffic"))
 	}
 
+	limits := captureLimits{
+		duration:    args.Duration,
+		maxRequests: args.MaxRequests,
+		maxBytes:    args.MaxBytes,
+	}
+	if limits.isSet() {
+		printer.Stderr.Infof("Capture will stop after %s, whichever comes first\n", limits)
+	}
+	if autoFilter != nil {
+		autoFilterDone := make(chan struct{})
+		defer close(autoFilterDone)
+		go autoFilter.watch(autoFilterDone)
+	}
+
+	limitsDone := make(chan struct{})
+	defer close(limitsDone)
+	limitReached := limits.watch(filterSummary, limitsDone)
+
+	// Stays nil without a control socket, so that it never receives.
+	var stopRequested chan int
+	var controlServer *control.Server
+	if args.ControlSocket != "" {
+		ctl := &controller{
+			stats: dashboard.Sources{
+				Interfaces: iNames,
+				Packets:    filterSummary,
+				Endpoints:  endpointMetrics,
+				Uploads:    uploads,
+			},
+			start:             time.Now(),
+			capture:           capture,
+			sampleRate:        sampleRate,
+			samplingEngine:    samplingEngine,
+			harCollectors:     harCollectors,
+			backendCollectors: backendCollectors,
+		}
+		if args.ExecCommand == "" && len(args.Phases) == 0 {
+			stopRequested = make(chan int, 1)
+			ctl.stopRequested = stopRequested
+		}
+
+		controlServer, err = control.Listen(args.ControlSocket, ctl)
+		if err != nil {
+			close(stop)
+			doneWG.Wait()
+			return err
+		}
+		printer.Stderr.Infof("Listening for commands on %s\n", controlServer.Path())
+	}
+
+	// Waits until capture has started before running commands or sending
+	// traffic, so that their traffic is captured from the start.
+	waitForStart := func() {
+		waitForCapture(sentinels, func() int {
+			return filterSummary.Total().TCPPackets + negationSummary.Total().TCPPackets
+		}, pcapStartWaitTime)
+	}
+
 	var stopErr error
-	if args.ExecCommand != "" {
+	var phaseResults []phaseResult
+	if len(args.Phases) > 0 {
+		printer.Stderr.Infof("Running %d phases...\n\n\n", len(args.Phases))
+		waitForStart()
+
+		runner := &phaseRunner{
+			user:       args.ExecCommandUser,
+			phases:     phases,
+			runCommand: runCommand,
+		}
+		var phaseErr error
+		phaseResults, phaseErr = runner.run(args.Phases)
+
+		if phaseErr != nil {
+			stopErr = phaseErr
+		} else {
+			// Check if we have any errors on our side.
+			select {
+			case err := <-errChan:
+				stopErr = err
+				printer.Stderr.Errorf("Encountered error while collecting traces, stopping...\n")
+			default:
+				printer.Stderr.Infof("All phases finished successfully, stopping trace collection...\n")
+			}
+		}
+	} else if args.ExecCommand != "" {
 		printer.Stderr.Infof("Running subcommand...\n\n\n")
 
-		time.Sleep(pcapStartWaitTime)
+		waitForStart()
 
 		// Print delimiter so it's easier to differentiate subcommand output from
 		// Akita output.
@@ -536,6 +1059,36 @@ func Run(args Args) error {
 				printer.Stderr.Infof("Subcommand finished successfully, stopping trace collection...\n")
 			}
 		}
+	} else if args.selfTest != nil {
+		waitForStart()
+		stopErr = args.selfTest.generateTraffic()
+		if stopErr == nil {
+			select {
+			case err := <-errChan:
+				stopErr = err
+				printer.Stderr.Errorf("Encountered error while collecting traces, stopping...\n")
+			default:
+				printer.Stderr.Infof("Self-test traffic sent, stopping trace collection...\n")
+			}
+		}
+	} else if args.TUI {
+		dumpDir := ""
+		if args.Out.LocalPath != nil {
+			dumpDir = *args.Out.LocalPath
+		}
+		dash := dashboard.New(dashboard.Sources{
+			Interfaces: iNames,
+			Packets:    filterSummary,
+			Endpoints:  endpointMetrics,
+			Uploads:    uploads,
+			DumpDir:    dumpDir,
+		})
+		stopErr = runDashboard(dash, errChan, limitReached, stopRequested)
+
+		// Upload anything saved while uploads were paused.
+		if uploads != nil {
+			uploads.Resume()
+		}
 	} else {
 		// Don't sleep pcapStartWaitTime in interactive mode since the user can send
 		// SIGINT while we're sleeping too and sleeping introduces visible lag.
@@ -552,6 +1105,11 @@ func Run(args Args) error {
 			select {
 			case received := <-sig:
 				printer.Stderr.Infof("Received %v, stopping trace collection...\n", received.String())
+			case reason := <-limitReached:
+				printer.Stderr.Infof("Reached the %s, stopping trace collection...\n", reason)
+			case exitCode := <-stopRequested:
+				stopErr = controlStopError(exitCode)
+				printer.Stderr.Infof("Received stop command, stopping trace collection...\n")
 			case err := <-errChan:
 				stopErr = err
 				printer.Stderr.Errorf("Encountered error while collecting traces, stopping...\n")
@@ -559,17 +1117,65 @@ func Run(args Args) error {
 		}
 	}
 
-	time.Sleep(pcapStopWaitTime)
+	// Flushing and rotating aren't safe once the collectors start closing.
+	if controlServer != nil {
+		controlServer.Close()
+	}
+
+	// Wait until the last packets have been parsed.
+	drainCaptures(sentinels, pcapStopWaitTime)
 
 	// Signal all processors to stop.
 	close(stop)
 
 	// Wait for processors to exit.
 	doneWG.Wait()
+
+	// Every request has been counted by now.
+	if len(phaseResults) > 0 {
+		printPhaseReport(phaseResults, phases)
+	}
+
+	// Export the remaining spans even if collection failed.
+	if spanExporter != nil {
+		if err := spanExporter.Close(); err != nil {
+			printer.Warningf("%v\n", err)
+		}
+	}
+
 	if stopErr != nil {
 		return errors.Wrap(stopErr, "trace collection failed")
 	}
 
+	if args.selfTest != nil {
+		args.selfTest.record(filterSummary, negationSummary, prefilterSummary, endpointMetrics, harCollectors)
+		return nil
+	}
+
+	if args.InferPathParams && args.Out.LocalPath != nil {
+		if err := inferLocalPathParams(harCollectors, inferrer); err != nil {
+			return errors.Wrap(err, "failed to infer path parameters")
+		}
+	}
+
+	if piiAnnotator != nil {
+		if err := writePIIReport(piiAnnotator.Report(), args); err != nil {
+			return err
+		}
+	}
+
+	if endpointLimit != nil {
+		if err := writeEndpointReport(endpointLimit.Report(), args); err != nil {
+			return err
+		}
+	}
+
+	if args.EndpointMetrics {
+		if err := writeEndpointMetrics(endpointMetrics.Report(), args); err != nil {
+			return err
+		}
+	}
+
 	if viper.GetBool("debug") {
 		if len(negationFilters) == 0 {
 			DumpPacketCounters(interfaces, filterSummary, nil, true)
@@ -582,6 +1188,9 @@ func Run(args Args) error {
 			DumpPacketCounters(interfaces, prefilterSummary, nil, false)
 		}
 
+		DumpDecompressionCounters()
+		DumpPairCacheMetrics()
+
 	}
 
 	// Report on recoverable error counts during trace
@@ -593,6 +1202,15 @@ func Run(args Args) error {
 		printer.Stderr.Infof("These errors may cause some packets to be missing from the trace.")
 	}
 
+	upload_spool.PrintCounts()
+	if samplingEngine != nil {
+		samplingEngine.PrintSummary()
+	}
+	if deduplicator != nil {
+		forwarded, suppressed := deduplicator.Counts()
+		printer.Stderr.Infof("Uploaded %d requests as examples of their shape and suppressed %d duplicates\n", forwarded, suppressed)
+	}
+
 	// Check summary to see if the trace will have anything in it.
 	totalCount := filterSummary.Total()
 	if totalCount.HTTPRequests == 0 && totalCount.HTTPResponses == 0 {
@@ -634,7 +1252,149 @@ func Run(args Args) error {
 	return nil
 }
 
-func createLocalCollector(interfaceName, outDir string, tags map[tags.Key]string) (trace.Collector, error) {
+// Returns an inferrer that uses the given --path-parameters patterns and
+// ignores paths matching the exclusions.
+func newPathInferrer(pathParams []string, pathExclusions []*regexp.Regexp) *path_inference.Inferrer {
+	patterns := make([]pp.Pattern, 0, len(pathParams))
+	for _, raw := range pathParams {
+		patterns = append(patterns, pp.Parse(raw))
+	}
+	return path_inference.NewInferrer(patterns, pathExclusions)
+}
+
+// Rewrites the HAR files written by the given collectors to use path
+// templates inferred from all of them together.
+func inferLocalPathParams(collectors []*trace.HARCollector, inferrer *path_inference.Inferrer) error {
+	// No file is written for interfaces without any traffic.
+	var harPaths []string
+	for _, c := range collectors {
+		harPaths = append(harPaths, c.Files()...)
+	}
+	sort.Strings(harPaths)
+
+	printer.Stderr.Infof("Inferring path parameters in %d local HAR files...\n", len(harPaths))
+	return path_inference.InferHARFiles(harPaths, inferrer)
+}
+
+// Writes the PII report to args.PIIReport, defaulting to a file in the local
+// output directory, if any.
+func writePIIReport(report *pii.Report, args Args) error {
+	endpoints, fields := report.Size()
+	printer.Stderr.Infof("Detected sensitive data in %d fields across %d endpoints\n", fields, endpoints)
+
+	path := args.PIIReport
+	if path == "" && args.Out.LocalPath != nil {
+		path = filepath.Join(*args.Out.LocalPath, piiReportFileName)
+	}
+	if path == "" {
+		return nil
+	}
+
+	if err := report.WriteFile(path); err != nil {
+		return err
+	}
+	printer.Stderr.Infof("Wrote PII report to %s\n", path)
+	return nil
+}
+
+// Writes the per-endpoint rate-limit report to args.EndpointReport,
+// defaulting to a file in the local output directory, if any.
+func writeEndpointReport(report sampling.EndpointReport, args Args) error {
+	observed, kept := 0, 0
+	for _, e := range report.Endpoints {
+		observed += e.Observed
+		kept += e.Kept
+	}
+	printer.Stderr.Infof("Rate limit kept %d of %d requests across %d endpoints\n", kept, observed, len(report.Endpoints))
+
+	path := args.EndpointReport
+	if path == "" && args.Out.LocalPath != nil {
+		path = filepath.Join(*args.Out.LocalPath, endpointReportFileName)
+	}
+	if path == "" {
+		return nil
+	}
+
+	if err := report.WriteFile(path); err != nil {
+		return err
+	}
+	printer.Stderr.Infof("Wrote endpoint report to %s\n", path)
+	return nil
+}
+
+// Shows the dashboard until the user quits it, a signal or stop command is
+// received, a capture limit is reached, or collection fails. Returns the
+// collection error or the exit code requested by the stop command, if any.
+func runDashboard(dash *dashboard.Dashboard, errChan <-chan error, limitReached <-chan string, stopRequested <-chan int) error {
+	// Must use buffered channel for signals since the signal package does not
+	// block when sending signals.
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, os.Interrupt)
+	signal.Notify(sig, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	dashErr := make(chan error, 1)
+	go func() { dashErr <- dash.Run() }()
+	stopDashboard := func() {
+		if dashErr != nil {
+			dash.Stop()
+			<-dashErr
+		}
+	}
+
+	for {
+		select {
+		case err := <-dashErr:
+			if err == nil {
+				printer.Stderr.Infof("Stopping trace collection...\n")
+				return nil
+			}
+
+			// Carry on without the dashboard.
+			printer.Stderr.Errorf("Failed to show the dashboard: %v\n", err)
+			printer.Stderr.Infof("Send SIGINT (Ctrl-C) to stop...\n")
+			dashErr = nil
+		case received := <-sig:
+			stopDashboard()
+			printer.Stderr.Infof("Received %v, stopping trace collection...\n", received.String())
+			return nil
+		case reason := <-limitReached:
+			stopDashboard()
+			printer.Stderr.Infof("Reached the %s, stopping trace collection...\n", reason)
+			return nil
+		case exitCode := <-stopRequested:
+			stopDashboard()
+			printer.Stderr.Infof("Received stop command, stopping trace collection...\n")
+			return controlStopError(exitCode)
+		case err := <-errChan:
+			stopDashboard()
+			printer.Stderr.Errorf("Encountered error while collecting traces, stopping...\n")
+			return err
+		}
+	}
+}
+
+// Prints the per-endpoint metrics and writes them to args.EndpointMetricsFile,
+// or to the local output directory.
+func writeEndpointMetrics(report endpoint_metrics.Report, args Args) error {
+	report.PrintTable()
+
+	path := args.EndpointMetricsFile
+	if path == "" && args.Out.LocalPath != nil {
+		path = filepath.Join(*args.Out.LocalPath, endpointMetricsFileName)
+	}
+	if path == "" {
+		return nil
+	}
+
+	if err := report.WriteFile(path); err != nil {
+		return err
+	}
+	printer.Stderr.Infof("Wrote endpoint metrics to %s\n", path)
+	return nil
+}
+
+func createLocalCollector(interfaceName, outDir string, tags map[tags.Key]string, format string, opts har_writer.Options, owners *process_info.Resolver, phases *trace.Phases) (trace.Collector, error) {
 	if fi, err := os.Stat(outDir); err == nil {
 		// File exists, check if it's a directory.
 		if !fi.IsDir() {
@@ -655,5 +1415,18 @@ func createLocalCollector(interfaceName, outDir string, tags map[tags.Key]string
 		}
 	}
 
-	return trace.NewHARCollector(interfaceName, outDir, tags), nil
+	switch format {
+	case "", HARFormat:
+		c := trace.NewHARCollector(interfaceName, outDir, tags, opts)
+		c.SetOwners(owners)
+		c.SetPhases(phases)
+		return c, nil
+	case NDJSONFormat:
+		c := ndjson.NewCollector(interfaceName, outDir, tags)
+		c.SetOwners(owners)
+		c.SetPhases(phases)
+		return c, nil
+	default:
+		return nil, errors.Errorf("unknown local trace format %q", format)
+	}
 }
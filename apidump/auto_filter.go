@@ -0,0 +1,154 @@
+package apidump
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-cli/listen_ports"
+	"github.com/akitasoftware/akita-cli/printer"
+)
+
+// How often to look for new listening ports with --auto-filter.
+const autoFilterInterval = 10 * time.Second
+
+// Builds the BPF filter from the ports with listening sockets, and updates it
+// on running captures when new listeners appear.
+type autoFilter struct {
+	scope          listen_ports.Scope
+	interfaces     map[string]interfaceInfo
+	createOutbound bool
+
+	// Ports in the current filter. Ports are never removed, so that a
+	// restarting service stays captured.
+	ports map[uint16]bool
+
+	// Receives filter updates for the capture on each interface, for each
+	// filter state.
+	updates map[filterState]map[string]chan string
+}
+
+func newAutoFilter(scope listen_ports.Scope, interfaces map[string]interfaceInfo, createOutbound bool) (*autoFilter, error) {
+	ports, err := listen_ports.Find(scope)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find listening ports for --auto-filter")
+	}
+	if len(ports) == 0 {
+		return nil, errors.Errorf("no listening TCP sockets found in %s; start your service before apidump, or set --filter", scope)
+	}
+
+	a := &autoFilter{
+		scope:          scope,
+		interfaces:     interfaces,
+		createOutbound: createOutbound,
+		ports:          make(map[uint16]bool, len(ports)),
+		updates: map[filterState]map[string]chan string{
+			matchedFilter:    {},
+			notMatchedFilter: {},
+		},
+	}
+	for _, p := range ports {
+		a.ports[p] = true
+	}
+	printer.Stderr.Infof("Found TCP ports %s listening in %s\n", formatPorts(ports), scope)
+	return a, nil
+}
+
+// Returns the BPF filter matching the ports found so far.
+func (a *autoFilter) filter() string {
+	return listen_ports.Filter(a.sortedPorts())
+}
+
+func (a *autoFilter) sortedPorts() []uint16 {
+	ports := make([]uint16, 0, len(a.ports))
+	for p := range a.ports {
+		ports = append(ports, p)
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+	return ports
+}
+
+// Returns the channel of filter updates for the capture on the given
+// interface. Returns nil if a is nil. Must be called before watch.
+func (a *autoFilter) updatesFor(state filterState, interfaceName string) <-chan string {
+	if a == nil {
+		return nil
+	}
+	c := make(chan string, 1)
+	a.updates[state][interfaceName] = c
+	return c
+}
+
+// Looks for new listening ports until done is closed, and sends the updated
+// filters to the captures when any are found.
+func (a *autoFilter) watch(done <-chan struct{}) {
+	ticker := time.NewTicker(autoFilterInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := a.update(); err != nil {
+				printer.Debugf("Failed to update --auto-filter: %v\n", err)
+			}
+		}
+	}
+}
+
+func (a *autoFilter) update() error {
+	ports, err := listen_ports.Find(a.scope)
+	if err != nil {
+		return err
+	}
+	var added []uint16
+	for _, p := range ports {
+		if !a.ports[p] {
+			a.ports[p] = true
+			added = append(added, p)
+		}
+	}
+	if len(added) == 0 {
+		return nil
+	}
+
+	filter := a.filter()
+	userFilters, negationFilters, err := createBPFFilters(a.interfaces, filter, a.createOutbound, 0)
+	if err != nil {
+		return err
+	}
+	printer.Stderr.Infof("Found new TCP ports %s listening in %s, now capturing with --filter %q\n", formatPorts(added), a.scope, filter)
+	for state, filters := range map[filterState]map[string]string{
+		matchedFilter:    userFilters,
+		notMatchedFilter: negationFilters,
+	} {
+		for interfaceName, f := range filters {
+			if c, ok := a.updates[state][interfaceName]; ok {
+				replaceUpdate(c, f)
+			}
+		}
+	}
+	return nil
+}
+
+// Sends the filter on a channel buffering one update, replacing any update
+// that the capture hasn't applied yet.
+func replaceUpdate(c chan string, filter string) {
+	select {
+	case <-c:
+	default:
+	}
+	c <- filter
+}
+
+func formatPorts(ports []uint16) string {
+	strs := make([]string, len(ports))
+	for i, p := range ports {
+		strs[i] = fmt.Sprint(p)
+	}
+	return strings.Join(strs, ", ")
+}
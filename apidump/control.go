@@ -0,0 +1,134 @@
+package apidump
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-cli/control"
+	"github.com/akitasoftware/akita-cli/dashboard"
+	"github.com/akitasoftware/akita-cli/printer"
+	"github.com/akitasoftware/akita-cli/sampling"
+	"github.com/akitasoftware/akita-cli/trace"
+	"github.com/akitasoftware/akita-cli/util"
+)
+
+// Carries out commands received on the control socket.
+type controller struct {
+	stats dashboard.Sources
+	start time.Time
+
+	capture *trace.CaptureSwitch
+
+	// Exactly one of these is set, depending on the sampling policy.
+	sampleRate     *trace.SampleRate
+	samplingEngine *sampling.Engine
+
+	harCollectors     []*trace.HARCollector
+	backendCollectors []*trace.BackendCollector
+
+	// Receives the exit code requested by the stop command. Nil if the capture
+	// stops when ExecCommand finishes instead.
+	stopRequested chan int
+}
+
+var _ control.Handler = (*controller)(nil)
+
+// The stats returned by the stats command.
+type controlStats struct {
+	dashboard.Stats
+
+	CapturePaused bool    `json:"capture_paused"`
+	SampleRate    float64 `json:"sample_rate"`
+}
+
+func (c *controller) Stats() (interface{}, error) {
+	return controlStats{
+		Stats:         c.stats.Snapshot(c.start, time.Now()),
+		CapturePaused: c.capture.Paused(),
+		SampleRate:    c.getSampleRate(),
+	}, nil
+}
+
+func (c *controller) Flush() error {
+	for _, hc := range c.harCollectors {
+		if err := hc.Flush(); err != nil {
+			return errors.Wrap(err, "failed to flush HAR file")
+		}
+	}
+	for _, bc := range c.backendCollectors {
+		bc.Flush()
+	}
+	printer.Stderr.Infof("Flushed output on request from the control socket\n")
+	return nil
+}
+
+func (c *controller) Rotate() ([]string, error) {
+	if len(c.harCollectors) == 0 {
+		return nil, errors.New("no HAR files are being written")
+	}
+
+	var completed []string
+	for _, hc := range c.harCollectors {
+		before := len(hc.Files())
+		if err := hc.Rotate(); err != nil {
+			return completed, errors.Wrap(err, "failed to rotate HAR file")
+		}
+		completed = append(completed, hc.Files()[before:]...)
+	}
+	printer.Stderr.Infof("Rotated %d HAR files on request from the control socket\n", len(completed))
+	return completed, nil
+}
+
+func (c *controller) Pause() error {
+	c.capture.Pause()
+	printer.Stderr.Infof("Capture paused on request from the control socket\n")
+	return nil
+}
+
+func (c *controller) Resume() error {
+	c.capture.Resume()
+	printer.Stderr.Infof("Capture resumed on request from the control socket\n")
+	return nil
+}
+
+func (c *controller) getSampleRate() float64 {
+	if c.samplingEngine != nil {
+		return c.samplingEngine.SampleRate()
+	}
+	return c.sampleRate.Get()
+}
+
+func (c *controller) SetSampleRate(rate float64) error {
+	if c.samplingEngine != nil {
+		c.samplingEngine.SetSampleRate(rate)
+	} else {
+		c.sampleRate.Set(rate)
+	}
+	printer.Stderr.Infof("Sample rate set to %v on request from the control socket\n", rate)
+	return nil
+}
+
+func (c *controller) Stop(exitCode int) error {
+	if c.stopRequested == nil {
+		return errors.New("the capture stops when its command finishes")
+	}
+	select {
+	case c.stopRequested <- exitCode:
+		return nil
+	default:
+		return errors.New("the capture is already stopping")
+	}
+}
+
+// Returns the error to stop with when a stop with the given exit code is
+// requested on the control socket, or nil if the code is 0.
+func controlStopError(exitCode int) error {
+	if exitCode == 0 {
+		return nil
+	}
+	return util.ExitError{
+		ExitCode: exitCode,
+		Err:      errors.New("stopped on request from the control socket"),
+	}
+}
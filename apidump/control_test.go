@@ -0,0 +1,69 @@
+package apidump
+
+import (
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/akitasoftware/akita-cli/dashboard"
+	"github.com/akitasoftware/akita-cli/har_writer"
+	"github.com/akitasoftware/akita-cli/trace"
+	"github.com/akitasoftware/akita-cli/util"
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+func TestController(t *testing.T) {
+	dir := t.TempDir()
+	hc := trace.NewHARCollector("eth0", dir, nil, har_writer.Options{})
+	c := &controller{
+		stats:         dashboard.Sources{Interfaces: []string{"eth0"}, Packets: trace.NewPacketCountSummary()},
+		start:         time.Now(),
+		capture:       &trace.CaptureSwitch{},
+		sampleRate:    trace.NewSampleRate(1.0),
+		harCollectors: []*trace.HARCollector{hc},
+		stopRequested: make(chan int, 1),
+	}
+
+	assert.NoError(t, c.Pause())
+	assert.NoError(t, c.SetSampleRate(0.5))
+	stats, err := c.Stats()
+	if assert.NoError(t, err) {
+		s := stats.(controlStats)
+		assert.True(t, s.CapturePaused)
+		assert.Equal(t, 0.5, s.SampleRate)
+		assert.Len(t, s.Interfaces, 1)
+	}
+
+	// Rotating completes the current HAR file, and the next entry starts a new
+	// one.
+	stream := uuid.New()
+	assert.NoError(t, hc.Process(akinet.ParsedNetworkTraffic{
+		Content: akinet.HTTPRequest{StreamID: stream, Seq: 1, Method: "GET", URL: &url.URL{Path: "/"}, Host: "example.com"},
+	}))
+	assert.NoError(t, hc.Process(akinet.ParsedNetworkTraffic{
+		Content: akinet.HTTPResponse{StreamID: stream, Seq: 1, StatusCode: 200},
+	}))
+	assert.NoError(t, c.Flush())
+	files, err := c.Rotate()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "akita_eth0.har")}, files)
+	assert.NoError(t, hc.Close())
+
+	assert.NoError(t, c.Stop(3))
+	assert.Error(t, c.Stop(0))
+	assert.Equal(t, 3, <-c.stopRequested)
+
+	var exitErr util.ExitError
+	if assert.ErrorAs(t, controlStopError(3), &exitErr) {
+		assert.Equal(t, 3, exitErr.ExitCode)
+	}
+	assert.NoError(t, controlStopError(0))
+
+	// Captures run with --command stop when the command finishes.
+	c.stopRequested = nil
+	assert.Error(t, c.Stop(0))
+}
@@ -0,0 +1,90 @@
+package apidump
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/akitasoftware/akita-cli/trace"
+	"github.com/akitasoftware/akita-cli/util"
+)
+
+// How often the request and byte limits are checked. Captures may overshoot
+// these limits by the traffic seen in this time.
+const limitCheckInterval = time.Second
+
+// Conditions under which a capture stops by itself. Zero values are
+// unlimited.
+type captureLimits struct {
+	duration    time.Duration
+	maxRequests int
+	maxBytes    int64
+}
+
+func (l captureLimits) isSet() bool {
+	return l != captureLimits{}
+}
+
+// Describes the limits, e.g. "10m0s or 5000 requests".
+func (l captureLimits) String() string {
+	var parts []string
+	if l.duration > 0 {
+		parts = append(parts, l.duration.String())
+	}
+	if l.maxRequests > 0 {
+		parts = append(parts, fmt.Sprintf("%d requests", l.maxRequests))
+	}
+	if l.maxBytes > 0 {
+		parts = append(parts, util.FormatSize(l.maxBytes)+" of TCP traffic")
+	}
+	return strings.Join(parts, " or ")
+}
+
+// Returns a description of the request or byte limit reached by the given
+// counts, or the empty string if none has been reached.
+func (l captureLimits) check(total trace.PacketCounters) string {
+	if l.maxRequests > 0 && total.HTTPRequests >= l.maxRequests {
+		return fmt.Sprintf("--max-requests limit of %d requests", l.maxRequests)
+	}
+	if l.maxBytes > 0 && total.TCPBytes >= l.maxBytes {
+		return fmt.Sprintf("--max-bytes limit of %s", util.FormatSize(l.maxBytes))
+	}
+	return ""
+}
+
+// Returns a channel that receives a description of the first limit reached,
+// with requests and bytes counted in the given summary. Stops checking once
+// done is closed. If no limits are set, nothing is ever received.
+func (l captureLimits) watch(summary *trace.PacketCountSummary, done <-chan struct{}) <-chan string {
+	reached := make(chan string, 1)
+	if !l.isSet() {
+		return reached
+	}
+
+	go func() {
+		var deadline <-chan time.Time
+		if l.duration > 0 {
+			timer := time.NewTimer(l.duration)
+			defer timer.Stop()
+			deadline = timer.C
+		}
+		ticker := time.NewTicker(limitCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-deadline:
+				reached <- fmt.Sprintf("--duration limit of %v", l.duration)
+				return
+			case <-ticker.C:
+				if reason := l.check(summary.Total()); reason != "" {
+					reached <- reason
+					return
+				}
+			}
+		}
+	}()
+	return reached
+}
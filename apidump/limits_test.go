@@ -0,0 +1,45 @@
+package apidump
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/akitasoftware/akita-cli/trace"
+)
+
+func TestCaptureLimits(t *testing.T) {
+	assert.False(t, captureLimits{}.isSet())
+
+	l := captureLimits{duration: 10 * time.Minute, maxRequests: 5000, maxBytes: 2 << 30}
+	assert.True(t, l.isSet())
+	assert.Equal(t, "10m0s or 5000 requests or 2GB of TCP traffic", l.String())
+
+	assert.Equal(t, "", l.check(trace.PacketCounters{HTTPRequests: 4999, TCPBytes: 1 << 30}))
+	assert.Equal(t, "--max-requests limit of 5000 requests", l.check(trace.PacketCounters{HTTPRequests: 5000}))
+	assert.Equal(t, "--max-bytes limit of 2GB", l.check(trace.PacketCounters{TCPBytes: 3 << 30}))
+}
+
+func TestWatchCaptureLimits(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+
+	summary := trace.NewPacketCountSummary()
+	reached := captureLimits{duration: 10 * time.Millisecond}.watch(summary, done)
+	select {
+	case reason := <-reached:
+		assert.Equal(t, "--duration limit of 10ms", reason)
+	case <-time.After(5 * time.Second):
+		t.Fatal("duration limit not reached")
+	}
+
+	summary.Update(trace.PacketCounters{Interface: "eth0", HTTPRequests: 3})
+	reached = captureLimits{maxRequests: 3}.watch(summary, done)
+	select {
+	case reason := <-reached:
+		assert.Equal(t, "--max-requests limit of 3 requests", reason)
+	case <-time.After(5 * time.Second):
+		t.Fatal("request limit not reached")
+	}
+}
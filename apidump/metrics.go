@@ -0,0 +1,85 @@
+package apidump
+
+import (
+	"github.com/akitasoftware/akita-cli/prom_metrics"
+	"github.com/akitasoftware/akita-cli/sampling"
+	"github.com/akitasoftware/akita-cli/trace"
+)
+
+// Registers Prometheus metrics for the packet counts and whichever rate
+// limiter is in use. Any of the limiters may be nil.
+func registerMetrics(r *prom_metrics.Registry, summary *trace.PacketCountSummary, rateLimit *trace.SharedRateLimit, engine *sampling.Engine, endpointLimit *sampling.EndpointRateLimit) {
+	trace.RegisterPacketCountMetrics(r, summary)
+
+	if rateLimit != nil {
+		r.NewGaugeFunc(
+			"akita_rate_limit_interval_active",
+			"1 if the rate limiter is capturing witnesses, 0 if it is waiting for the next interval.",
+			nil,
+			func(emit func(float64, ...string)) {
+				active, _, _ := rateLimit.State()
+				if active {
+					emit(1)
+				} else {
+					emit(0)
+				}
+			},
+		)
+		r.NewGaugeFunc(
+			"akita_rate_limit_interval_witnesses",
+			"Witnesses captured in the rate limiter's current interval.",
+			nil,
+			func(emit func(float64, ...string)) {
+				_, count, _ := rateLimit.State()
+				emit(float64(count))
+			},
+		)
+		r.NewGaugeFunc(
+			"akita_rate_limit_estimated_interval_seconds",
+			"Estimated time taken to capture the witnesses allowed per epoch.",
+			nil,
+			func(emit func(float64, ...string)) {
+				_, _, estimate := rateLimit.State()
+				emit(estimate.Seconds())
+			},
+		)
+	}
+
+	if engine != nil {
+		r.NewCounterFunc(
+			"akita_sampling_decisions_total",
+			"Request/response pairs decided by outcome sampling, by reason.",
+			[]string{"reason"},
+			func(emit func(float64, ...string)) {
+				counts := engine.Counts()
+				for _, reason := range []sampling.Reason{
+					sampling.KeptError,
+					sampling.KeptSlow,
+					sampling.KeptNewEndpoint,
+					sampling.KeptSampled,
+					sampling.DroppedSampled,
+					sampling.DroppedRateLimit,
+				} {
+					emit(float64(counts[reason]), reason.String())
+				}
+			},
+		)
+	}
+
+	if endpointLimit != nil {
+		r.NewCounterFunc(
+			"akita_endpoint_rate_limit_requests_total",
+			"Requests seen by the per-endpoint rate limit, by whether they were kept.",
+			[]string{"result"},
+			func(emit func(float64, ...string)) {
+				observed, kept := 0, 0
+				for _, e := range endpointLimit.Report().Endpoints {
+					observed += e.Observed
+					kept += e.Kept
+				}
+				emit(float64(kept), "kept")
+				emit(float64(observed-kept), "dropped")
+			},
+		)
+	}
+}
@@ -0,0 +1,148 @@
+package apidump
+
+import (
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-cli/printer"
+	"github.com/akitasoftware/akita-cli/trace"
+	"github.com/akitasoftware/akita-cli/util"
+)
+
+// A named command run while capturing, such as the setup, test or teardown
+// step of an integration test suite.
+type Phase struct {
+	Name    string
+	Command string
+
+	// If set, HTTP traffic is dropped while the phase runs.
+	Uncaptured bool
+
+	// If set, the phase runs even if an earlier phase failed, e.g. to tear
+	// down what the earlier phases set up.
+	AlwaysRun bool
+}
+
+// The outcome of a phase.
+type phaseResult struct {
+	phase Phase
+
+	// Set if the phase didn't run because an earlier one failed.
+	skipped bool
+
+	// Nil if the command succeeded.
+	err error
+
+	// The command's exit code, or -1 if it couldn't be run or didn't exit
+	// normally.
+	exitCode int
+
+	duration time.Duration
+}
+
+// Runs phases in order, recording when each ran so that traffic can be tagged,
+// filtered and counted by the phase it was observed in.
+type phaseRunner struct {
+	user   string
+	phases *trace.Phases
+
+	// Replaced in tests.
+	runCommand func(username, command string) error
+}
+
+// Runs every phase, skipping those after a failure unless they are always run.
+// Returns the result of each phase, and the error of the first phase that
+// failed. If it exited with a non-zero code, the error is a util.ExitError
+// with the same code.
+func (r *phaseRunner) run(phases []Phase) ([]phaseResult, error) {
+	results := make([]phaseResult, 0, len(phases))
+	var firstErr error
+	for _, p := range phases {
+		if firstErr != nil && !p.AlwaysRun {
+			printer.Stderr.Infof("Skipping phase %q\n", p.Name)
+			results = append(results, phaseResult{phase: p, skipped: true})
+			continue
+		}
+
+		result := r.runPhase(p)
+		if result.err != nil && firstErr == nil {
+			firstErr = errors.Wrapf(result.err, "phase %q failed", p.Name)
+			if result.exitCode > 0 {
+				firstErr = util.ExitError{
+					ExitCode: result.exitCode,
+					Err:      firstErr,
+				}
+			}
+		}
+		results = append(results, result)
+	}
+	return results, firstErr
+}
+
+func (r *phaseRunner) runPhase(p Phase) phaseResult {
+	start := time.Now()
+	if p.Uncaptured {
+		printer.Stderr.Infof("Running phase %q without capturing...\n", p.Name)
+		r.phases.StartUncaptured(p.Name, start)
+	} else {
+		printer.Stderr.Infof("Running phase %q...\n", p.Name)
+		r.phases.Start(p.Name, start)
+	}
+
+	printer.Stdout.RawOutput(subcommandOutputDelimiter)
+	printer.Stderr.RawOutput(subcommandOutputDelimiter)
+	err := r.runCommand(r.user, p.Command)
+	printer.Stdout.RawOutput(subcommandOutputDelimiter)
+	printer.Stderr.RawOutput(subcommandOutputDelimiter)
+
+	end := time.Now()
+	r.phases.End(end)
+	if err != nil {
+		printer.Stderr.Errorf("Phase %q failed: %v\n", p.Name, err)
+	}
+
+	result := phaseResult{
+		phase:    p,
+		err:      err,
+		duration: end.Sub(start),
+	}
+	if exitErr, ok := errors.Cause(err).(*exec.ExitError); ok {
+		result.exitCode = exitErr.ExitCode()
+	} else if err != nil {
+		result.exitCode = -1
+	}
+	return result
+}
+
+// Prints the outcome, duration and captured requests of each phase. Should be
+// called once capture has drained, so that every request has been counted.
+func printPhaseReport(results []phaseResult, phases *trace.Phases) {
+	printer.Stderr.Infof("==================================================\n")
+	printer.Stderr.Infof("Phases:\n")
+	printer.Stderr.Infof("%-20v %-12v %10v %8v\n", "phase", "status", "duration", "requests")
+	for _, r := range results {
+		var status, duration, requests string
+		switch {
+		case r.skipped:
+			status = "skipped"
+		case r.err == nil:
+			status = "ok"
+		case r.exitCode > 0:
+			status = "exit " + strconv.Itoa(r.exitCode)
+		default:
+			status = "error"
+		}
+		if !r.skipped {
+			duration = r.duration.Round(time.Millisecond).String()
+			if r.phase.Uncaptured {
+				requests = "-"
+			} else {
+				requests = strconv.Itoa(phases.Requests(r.phase.Name))
+			}
+		}
+		printer.Stderr.Infof("%-20v %-12v %10v %8v\n", r.phase.Name, status, duration, requests)
+	}
+}
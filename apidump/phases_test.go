@@ -0,0 +1,74 @@
+package apidump
+
+import (
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/akitasoftware/akita-cli/trace"
+	"github.com/akitasoftware/akita-cli/util"
+)
+
+// Returns the error of a command that exits with the given code.
+func exitError(t *testing.T, code int) error {
+	err := exec.Command("sh", "-c", "exit "+strconv.Itoa(code)).Run()
+	if _, ok := err.(*exec.ExitError); !ok {
+		t.Fatalf("expected exit error, got %v", err)
+	}
+	return err
+}
+
+func TestRunPhases(t *testing.T) {
+	phases := trace.NewPhases()
+	var ran []string
+	commandErrs := map[string]error{
+		"run-tests": exitError(t, 3),
+		"cleanup":   errors.New("failed to start"),
+	}
+
+	r := &phaseRunner{
+		phases: phases,
+		runCommand: func(username, command string) error {
+			ran = append(ran, command)
+			assert.Equal(t, command != "start-db", phases.Captured(time.Now()))
+			assert.NotEqual(t, "", phases.At(time.Now()))
+			return commandErrs[command]
+		},
+	}
+
+	results, err := r.run([]Phase{
+		{Name: "setup", Command: "start-db", Uncaptured: true},
+		{Name: "test", Command: "run-tests"},
+		{Name: "report", Command: "report"},
+		{Name: "teardown", Command: "cleanup", AlwaysRun: true},
+	})
+
+	assert.Equal(t, []string{"start-db", "run-tests", "cleanup"}, ran)
+	assert.True(t, phases.Captured(time.Now()))
+	assert.Equal(t, "", phases.At(time.Now()))
+
+	if assert.Error(t, err) {
+		exitErr, ok := err.(util.ExitError)
+		if assert.True(t, ok, "%T", err) {
+			assert.Equal(t, 3, exitErr.ExitCode)
+		}
+		assert.Contains(t, err.Error(), `phase "test" failed`)
+	}
+
+	if assert.Len(t, results, 4) {
+		assert.False(t, results[0].skipped)
+		assert.NoError(t, results[0].err)
+
+		assert.Equal(t, 3, results[1].exitCode)
+
+		assert.True(t, results[2].skipped)
+
+		assert.False(t, results[3].skipped)
+		assert.Error(t, results[3].err)
+		assert.Equal(t, -1, results[3].exitCode)
+	}
+}
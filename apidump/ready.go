@@ -0,0 +1,63 @@
+package apidump
+
+import (
+	"time"
+
+	"github.com/akitasoftware/akita-cli/pcap"
+	"github.com/akitasoftware/akita-cli/printer"
+)
+
+// How often to check whether packets are flowing, if sentinels can't be used.
+const captureReadyPollInterval = 50 * time.Millisecond
+
+// Waits until every capture has started, or until the timeout. A capture has
+// started once a sentinel injected into it has been parsed. If that can't be
+// confirmed, waits for the first packet instead. Returns false on timeout.
+//
+// Packets returns the number of packets captured so far.
+func waitForCapture(captures []*pcap.Sentinels, packets func() int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	if syncCaptures(captures, timeout) {
+		return true
+	}
+
+	ticker := time.NewTicker(captureReadyPollInterval)
+	defer ticker.Stop()
+	for packets() == 0 {
+		if !time.Now().Before(deadline) {
+			printer.Stderr.Debugf("Couldn't confirm that capture started within %s, continuing anyway\n", timeout)
+			return false
+		}
+		<-ticker.C
+	}
+	return true
+}
+
+// Waits until every capture has parsed the packets captured so far. If that
+// can't be confirmed, waits out the timeout instead.
+func drainCaptures(captures []*pcap.Sentinels, timeout time.Duration) {
+	start := time.Now()
+	if syncCaptures(captures, timeout) {
+		return
+	}
+	printer.Stderr.Debugf("Couldn't confirm that capture drained, waiting %s\n", timeout)
+	if remaining := timeout - time.Since(start); remaining > 0 {
+		time.Sleep(remaining)
+	}
+}
+
+// Syncs with every capture at once. Returns false unless all succeed within
+// the timeout.
+func syncCaptures(captures []*pcap.Sentinels, timeout time.Duration) bool {
+	results := make(chan bool, len(captures))
+	for _, s := range captures {
+		go func(s *pcap.Sentinels) {
+			results <- s.Sync(timeout)
+		}(s)
+	}
+	ok := true
+	for range captures {
+		ok = <-results && ok
+	}
+	return ok
+}
@@ -0,0 +1,40 @@
+package apidump
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/akitasoftware/akita-cli/pcap"
+)
+
+func TestWaitForCapture(t *testing.T) {
+	assert.True(t, waitForCapture(nil, func() int { return 0 }, time.Second))
+
+	// Without sentinels, waits for the first packet.
+	var packets int32
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		atomic.StoreInt32(&packets, 1)
+	}()
+	count := func() int { return int(atomic.LoadInt32(&packets)) }
+	assert.True(t, waitForCapture([]*pcap.Sentinels{nil}, count, 5*time.Second))
+
+	// Times out if capture never starts and no packets arrive.
+	start := time.Now()
+	assert.False(t, waitForCapture([]*pcap.Sentinels{pcap.NewSentinels()}, func() int { return 0 }, 100*time.Millisecond))
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestDrainCaptures(t *testing.T) {
+	start := time.Now()
+	drainCaptures(nil, time.Second)
+	assert.True(t, time.Since(start) < 100*time.Millisecond)
+
+	// Waits out the timeout if capture can't be synced with.
+	start = time.Now()
+	drainCaptures([]*pcap.Sentinels{pcap.NewSentinels(), nil}, 100*time.Millisecond)
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+}
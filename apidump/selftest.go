@@ -0,0 +1,311 @@
+package apidump
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-cli/endpoint_metrics"
+	"github.com/akitasoftware/akita-cli/har_loader"
+	"github.com/akitasoftware/akita-cli/har_writer"
+	"github.com/akitasoftware/akita-cli/location"
+	"github.com/akitasoftware/akita-cli/printer"
+	"github.com/akitasoftware/akita-cli/trace"
+)
+
+// Path requested by the self-test's traffic.
+const selfTestPath = "/akita-self-test"
+
+// Number of requests sent by the self-test. Each is sent on its own
+// connection.
+const selfTestRequests = 10
+
+// Tracks a self-test. Run sends the test traffic in place of waiting for a
+// signal, and records what each stage of capture saw once it stops.
+type selfTest struct {
+	iface  string
+	filter string
+	url    string
+
+	// Set if packets can't be captured on iface.
+	permErr error
+
+	// Requests answered by the test server.
+	sent int
+
+	// TCP packets captured matching and not matching the BPF filter, and HTTP
+	// requests and responses kept after sampling.
+	matched, unmatched trace.PacketCounters
+
+	// HTTP traffic parsed, before any filters.
+	parsed trace.PacketCounters
+
+	// Test requests that passed the direction, path, host and expression
+	// filters.
+	afterFilters int
+
+	// Test requests written to HAR files, or the error reading them.
+	written    int
+	harFiles   []string
+	readHARErr error
+}
+
+// Sends the test traffic. Capture should already have started.
+func (st *selfTest) generateTraffic() error {
+	printer.Stderr.Infof("Sending %d requests to %s%s...\n", selfTestRequests, st.url, selfTestPath)
+
+	client := &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: &http.Transport{DisableKeepAlives: true},
+	}
+	for i := 0; i < selfTestRequests; i++ {
+		resp, err := client.Get(st.url + selfTestPath)
+		if err != nil {
+			return errors.Wrap(err, "failed to send self-test request")
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		st.sent++
+	}
+	return nil
+}
+
+// Records the counts from each stage of a capture that has stopped.
+func (st *selfTest) record(matched, unmatched, prefilter *trace.PacketCountSummary, metrics *endpoint_metrics.Metrics, harCollectors []*trace.HARCollector) {
+	st.matched = matched.Total()
+	st.unmatched = unmatched.Total()
+	st.parsed = prefilter.Total()
+	for _, e := range metrics.Report().Endpoints {
+		if strings.Contains(e.Endpoint, selfTestPath) {
+			st.afterFilters += e.Requests
+		}
+	}
+	for _, hc := range harCollectors {
+		st.harFiles = append(st.harFiles, hc.Files()...)
+	}
+}
+
+func (st *selfTest) countWritten() {
+	for _, path := range st.harFiles {
+		h, err := har_loader.LoadCustomHARFromFile(path)
+		if err != nil {
+			st.readHARErr = errors.Wrapf(err, "failed to read %s", path)
+			return
+		}
+		for _, e := range h.Log.Entries {
+			if e.Request != nil && strings.Contains(e.Request.URL, selfTestPath) {
+				st.written++
+			}
+		}
+	}
+}
+
+type stageResult int
+
+const (
+	stagePassed stageResult = iota
+	stageWarned
+	stageFailed
+	stageSkipped
+)
+
+func (r stageResult) String() string {
+	switch r {
+	case stagePassed:
+		return printer.Color.Green("PASS").String()
+	case stageWarned:
+		return printer.Color.Yellow("WARN").String()
+	case stageFailed:
+		return printer.Color.Red("FAIL").String()
+	}
+	return "SKIP"
+}
+
+type selfTestStage struct {
+	name   string
+	result stageResult
+	detail string
+}
+
+// Diagnoses each stage of capture from the recorded counts. Stages after the
+// first failure are skipped.
+func (st *selfTest) diagnose() []selfTestStage {
+	stages := []selfTestStage{
+		{name: "Packet capture permissions"},
+		{name: "BPF filter"},
+		{name: "TCP reassembly and HTTP parsing"},
+		{name: "Filters"},
+		{name: "Sampling"},
+		{name: "Output"},
+	}
+	checks := []func() (stageResult, string){
+		func() (stageResult, string) {
+			if st.permErr != nil {
+				return stageFailed, fmt.Sprintf("%v (hint: try using sudo)", st.permErr)
+			}
+			return stagePassed, fmt.Sprintf("can capture packets on %s", st.iface)
+		},
+		func() (stageResult, string) {
+			if st.matched.TCPPackets > 0 {
+				return stagePassed, fmt.Sprintf("captured %d TCP packets", st.matched.TCPPackets)
+			} else if st.unmatched.TCPPackets > 0 {
+				return stageFailed, fmt.Sprintf("captured %d TCP packets on %s, but none matched --filter %q. Run the self-test without --filter to check the other stages", st.unmatched.TCPPackets, st.iface, st.filter)
+			}
+			return stageFailed, fmt.Sprintf("no TCP packets were captured on %s", st.iface)
+		},
+		func() (stageResult, string) {
+			requests, responses := st.parsed.HTTPRequests, st.parsed.HTTPResponses
+			if requests >= st.sent && responses >= st.sent {
+				return stagePassed, fmt.Sprintf("parsed %d HTTP requests and %d responses", requests, responses)
+			} else if requests > 0 || responses > 0 {
+				return stageWarned, fmt.Sprintf("parsed only %d HTTP requests and %d responses of the %d sent", requests, responses, st.sent)
+			} else if st.parsed.Unparsed > 0 {
+				return stageFailed, fmt.Sprintf("%d TCP segments could not be parsed as HTTP", st.parsed.Unparsed)
+			}
+			return stageFailed, "no HTTP traffic was reassembled from the captured packets"
+		},
+		func() (stageResult, string) {
+			if st.afterFilters >= st.sent {
+				return stagePassed, fmt.Sprintf("%d of %d requests passed the direction, path, host and expression filters", st.afterFilters, st.sent)
+			} else if st.afterFilters > 0 {
+				return stageWarned, fmt.Sprintf("only %d of %d requests passed the direction, path, host and expression filters", st.afterFilters, st.sent)
+			}
+			return stageFailed, fmt.Sprintf("none of the requests to %s passed the direction, path, host and expression filters", selfTestPath)
+		},
+		func() (stageResult, string) {
+			kept := st.matched.HTTPRequests
+			if kept > st.afterFilters {
+				kept = st.afterFilters
+			}
+			if kept == 0 {
+				return stageFailed, fmt.Sprintf("dropped all %d requests; check --sample-rate and --rate-limit", st.afterFilters)
+			}
+			return stagePassed, fmt.Sprintf("kept %d of %d requests", kept, st.afterFilters)
+		},
+		func() (stageResult, string) {
+			if st.readHARErr != nil {
+				return stageFailed, st.readHARErr.Error()
+			} else if st.written == 0 {
+				return stageFailed, "no requests were written to HAR files"
+			}
+			return stagePassed, fmt.Sprintf("wrote %d requests to HAR files", st.written)
+		},
+	}
+
+	failed := false
+	for i, check := range checks {
+		if failed {
+			stages[i].result = stageSkipped
+			continue
+		}
+		stages[i].result, stages[i].detail = check()
+		failed = stages[i].result == stageFailed
+	}
+	return stages
+}
+
+// Checks that capture works end to end: serves HTTP on the loopback
+// interface, captures test traffic to it with the given filters and sampling,
+// and reports which stage of capture, if any, dropped the traffic. Traffic is
+// written to a temporary directory rather than to args.Out.
+func RunSelfTest(args Args) error {
+	iface, err := loopbackInterface()
+	if err != nil {
+		return err
+	}
+	if len(args.Interfaces) > 0 {
+		printer.Stderr.Infof("The self-test captures on the loopback interface %s, ignoring --interfaces\n", iface)
+	}
+	st := &selfTest{
+		iface:  iface,
+		filter: args.Filter,
+	}
+
+	if errs := checkPcapPermissions(map[string]interfaceInfo{iface: nil}); len(errs) > 0 {
+		st.permErr = errs[iface]
+		return printSelfTestResults(st.diagnose())
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return errors.Wrap(err, "failed to start self-test server")
+	}
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"akita_self_test": true}`)
+		}),
+	}
+	go server.Serve(listener)
+	defer server.Close()
+	st.url = "http://" + listener.Addr().String()
+
+	outDir, err := ioutil.TempDir("", "akita-self-test")
+	if err != nil {
+		return errors.Wrap(err, "failed to create self-test output directory")
+	}
+	defer os.RemoveAll(outDir)
+
+	// Keep the filters and sampling, and replace everything that would
+	// outlive the test or wait for the user.
+	args.Interfaces = []string{iface}
+	args.Out = location.Location{LocalPath: &outDir}
+	args.LocalFormat = HARFormat
+	args.HAROptions = har_writer.Options{}
+	args.InferPathParams = false
+	args.EndpointMetrics = false
+	args.OTLP = nil
+	args.TUI = false
+	args.Duration, args.MaxRequests, args.MaxBytes = 0, 0, 0
+	args.ExecCommand = ""
+	args.MetricsAddr = ""
+	args.ControlSocket = ""
+	args.selfTest = st
+	if err := Run(args); err != nil {
+		printer.Stderr.Errorf("Self-test capture failed: %v\n", err)
+		return errors.New("self-test failed")
+	}
+
+	st.countWritten()
+	return printSelfTestResults(st.diagnose())
+}
+
+func printSelfTestResults(stages []selfTestStage) error {
+	printer.Stderr.Infof("Self-test results:\n")
+	var failed *selfTestStage
+	for i, s := range stages {
+		line := fmt.Sprintf("  %s  %-34s %s", s.result, s.name, s.detail)
+		printer.Stderr.Infof("%s\n", strings.TrimRight(line, " "))
+		if s.result == stageFailed {
+			failed = &stages[i]
+		}
+	}
+
+	if failed != nil {
+		return errors.Errorf("self-test failed at %s", strings.ToLower(failed.name))
+	}
+	printer.Stderr.Infof("%s 🎉\n", printer.Color.Green("Capture works on this machine."))
+	printer.Stderr.Infof("If apidump still captures no HTTP calls, check that --interfaces includes the interface your service uses, and that its traffic isn't encrypted with TLS.\n")
+	return nil
+}
+
+// Returns the name of the first loopback interface that is up.
+func loopbackInterface() (string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to list network interfaces")
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 && iface.Flags&net.FlagUp != 0 {
+			return iface.Name, nil
+		}
+	}
+	return "", errors.New("no loopback interface is up")
+}
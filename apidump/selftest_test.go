@@ -0,0 +1,66 @@
+package apidump
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/akitasoftware/akita-cli/trace"
+)
+
+func results(stages []selfTestStage) []stageResult {
+	var result []stageResult
+	for _, s := range stages {
+		result = append(result, s.result)
+	}
+	return result
+}
+
+func TestSelfTestDiagnosis(t *testing.T) {
+	passing := selfTest{
+		iface:        "lo",
+		sent:         10,
+		matched:      trace.PacketCounters{TCPPackets: 100, HTTPRequests: 10, HTTPResponses: 10},
+		parsed:       trace.PacketCounters{HTTPRequests: 10, HTTPResponses: 10},
+		afterFilters: 10,
+		written:      10,
+	}
+	assert.Equal(t,
+		[]stageResult{stagePassed, stagePassed, stagePassed, stagePassed, stagePassed, stagePassed},
+		results(passing.diagnose()))
+
+	noPermission := selfTest{iface: "lo", permErr: errors.New("operation not permitted")}
+	stages := noPermission.diagnose()
+	assert.Equal(t,
+		[]stageResult{stageFailed, stageSkipped, stageSkipped, stageSkipped, stageSkipped, stageSkipped},
+		results(stages))
+	assert.Contains(t, stages[0].detail, "sudo")
+
+	// The negation of the BPF filter shows that the filter excluded the
+	// traffic.
+	filtered := selfTest{iface: "lo", filter: "port 80", sent: 10, unmatched: trace.PacketCounters{TCPPackets: 100}}
+	stages = filtered.diagnose()
+	assert.Equal(t, stageFailed, stages[1].result)
+	assert.Contains(t, stages[1].detail, `none matched --filter "port 80"`)
+
+	unparsed := passing
+	unparsed.parsed = trace.PacketCounters{Unparsed: 20}
+	assert.Equal(t,
+		[]stageResult{stagePassed, stagePassed, stageFailed, stageSkipped, stageSkipped, stageSkipped},
+		results(unparsed.diagnose()))
+
+	excluded := passing
+	excluded.afterFilters = 0
+	assert.Equal(t, stageFailed, excluded.diagnose()[3].result)
+
+	sampled := passing
+	sampled.matched.HTTPRequests = 3
+	stages = sampled.diagnose()
+	assert.Equal(t, stagePassed, stages[4].result)
+	assert.Equal(t, "kept 3 of 10 requests", stages[4].detail)
+
+	notWritten := passing
+	notWritten.written = 0
+	assert.Equal(t, stageFailed, notWritten.diagnose()[5].result)
+}
@@ -0,0 +1,96 @@
+package apispec
+
+import (
+	"net"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"time"
+
+	pb "github.com/akitasoftware/akita-ir/go/api_spec"
+	. "github.com/akitasoftware/akita-libs/visitors"
+	vis "github.com/akitasoftware/akita-libs/visitors/http_rest"
+)
+
+var uuidRegexp = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// Detects the data formats of string values, using the names of the
+// corresponding OpenAPI 3 formats.
+func stringFormats(s string) map[string]bool {
+	formats := map[string]bool{}
+	if uuidRegexp.MatchString(s) {
+		formats["uuid"] = true
+	}
+	if _, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		formats["date-time"] = true
+	} else if _, err := time.Parse("2006-01-02", s); err == nil {
+		formats["date"] = true
+	}
+	if addr, err := mail.ParseAddress(s); err == nil && addr.Name == "" && addr.Address == s {
+		formats["email"] = true
+	}
+	if ip := net.ParseIP(s); ip != nil {
+		if ip.To4() != nil {
+			formats["ipv4"] = true
+		} else {
+			formats["ipv6"] = true
+		}
+	}
+	if u, err := url.Parse(s); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+		formats["uri"] = true
+	}
+	return formats
+}
+
+// Records the data formats of all primitive values in the method, then strips
+// the values, leaving only their types. Values need to be stripped before
+// witnesses are melded, since melding treats different values as conflicts.
+func inferDataFormats(m *pb.Method) {
+	var v dataFormatVisitor
+	vis.Apply(&v, m)
+}
+
+type dataFormatVisitor struct {
+	vis.DefaultSpecVisitorImpl
+}
+
+var _ vis.DefaultSpecVisitor = (*dataFormatVisitor)(nil)
+
+func (*dataFormatVisitor) EnterData(self interface{}, ctx vis.SpecVisitorContext, d *pb.Data) Cont {
+	dp, isPrimitive := d.GetValue().(*pb.Data_Primitive)
+	if !isPrimitive {
+		return Continue
+	}
+
+	if s := dp.Primitive.GetStringValue(); s != nil {
+		if formats := stringFormats(s.Value); len(formats) > 0 {
+			dp.Primitive.Formats = formats
+		}
+	}
+
+	clearPrimitiveValue(dp.Primitive)
+	return Continue
+}
+
+func clearPrimitiveValue(p *pb.Primitive) {
+	switch v := p.GetValue().(type) {
+	case *pb.Primitive_BoolValue:
+		v.BoolValue.Value = false
+	case *pb.Primitive_BytesValue:
+		v.BytesValue.Value = nil
+	case *pb.Primitive_StringValue:
+		v.StringValue.Value = ""
+	case *pb.Primitive_Int32Value:
+		v.Int32Value.Value = 0
+	case *pb.Primitive_Int64Value:
+		v.Int64Value.Value = 0
+	case *pb.Primitive_Uint32Value:
+		v.Uint32Value.Value = 0
+	case *pb.Primitive_Uint64Value:
+		v.Uint64Value.Value = 0
+	case *pb.Primitive_DoubleValue:
+		v.DoubleValue.Value = 0
+	case *pb.Primitive_FloatValue:
+		v.FloatValue.Value = 0
+	}
+}
@@ -28,18 +28,22 @@ func ProcessHAR(col trace.Collector, p string) (int, error) {
 	}
 
 	successCount, errs := parseFromHAR(col, harContent.Log)
-	if errs.TotalCount > 0 {
-		entriesCount := len(harContent.Log.Entries)
-		printer.Stderr.Warningf("Encountered errors with %d HAR file entries.\n", entriesCount-successCount)
-		printer.Stderr.Warningf("Akita will ignore entries with errors and generate a spec from the %d entries successfully processed.\n", successCount)
-
-		printer.Stderr.Warningf("Sample errors:\n")
-		for _, e := range errs.Samples {
-			printer.Stderr.Warningf("\t- %s\n", e)
-		}
+	warnEntryErrors(len(harContent.Log.Entries), successCount, errs)
+	return successCount, nil
+}
+
+func warnEntryErrors(entriesCount, successCount int, errs sampled_err.Errors) {
+	if errs.TotalCount == 0 {
+		return
 	}
 
-	return successCount, nil
+	printer.Stderr.Warningf("Encountered errors with %d trace entries.\n", entriesCount-successCount)
+	printer.Stderr.Warningf("Akita will ignore entries with errors and generate a spec from the %d entries successfully processed.\n", successCount)
+
+	printer.Stderr.Warningf("Sample errors:\n")
+	for _, e := range errs.Samples {
+		printer.Stderr.Warningf("\t- %s\n", e)
+	}
 }
 
 // ReconstructedTimestamps holds times we can use to fill in
@@ -0,0 +1,54 @@
+package apispec
+
+import (
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	hl "github.com/akitasoftware/akita-cli/har_loader"
+	"github.com/akitasoftware/akita-cli/ndjson"
+	"github.com/akitasoftware/akita-cli/trace"
+	"github.com/akitasoftware/akita-libs/sampled_err"
+)
+
+// Extract witnesses from a local trace, which may be either a HAR file or an
+// NDJSON file written by apidump, and send them to the collector. Returns the
+// number of entries extracted.
+func ProcessLocalTrace(col trace.Collector, p string) (int, error) {
+	if ndjson.IsNDJSONFile(p) {
+		return ProcessNDJSON(col, p)
+	}
+	return ProcessHAR(col, p)
+}
+
+// Extract witnesses from a local NDJSON file and send them to the collector.
+// Returns the number of exchanges extracted.
+func ProcessNDJSON(col trace.Collector, p string) (int, error) {
+	// Use the same UUID for all witnesses from the same file.
+	fileUUID := uuid.New()
+
+	entriesCount, successCount := 0, 0
+	errs := sampled_err.Errors{SampleCount: 3}
+	err := ndjson.ReadFile(p, func(e *ndjson.Exchange) error {
+		entry := hl.CustomHAREntry{
+			StartedDateTime: e.StartedDateTime,
+			Request:         e.Request,
+			Response:        e.Response,
+			Timings: &hl.CustomTimings{
+				Send:    &e.Timings.Send,
+				Wait:    &e.Timings.Wait,
+				Receive: &e.Timings.Receive,
+			},
+		}
+		if ProcessHAREntry(col, fileUUID, entriesCount, entry, &errs) {
+			successCount++
+		}
+		entriesCount++
+		return nil
+	})
+	if err != nil {
+		return successCount, errors.Wrapf(err, "failed to load NDJSON file %s", p)
+	}
+
+	warnEntryErrors(entriesCount, successCount, errs)
+	return successCount, nil
+}
@@ -0,0 +1,353 @@
+package apispec
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-cli/learn"
+	"github.com/akitasoftware/akita-cli/location"
+	"github.com/akitasoftware/akita-cli/ndjson"
+	"github.com/akitasoftware/akita-cli/path_inference"
+	"github.com/akitasoftware/akita-cli/plugin"
+	"github.com/akitasoftware/akita-cli/printer"
+	"github.com/akitasoftware/akita-cli/trace"
+	pb "github.com/akitasoftware/akita-ir/go/api_spec"
+	"github.com/akitasoftware/akita-libs/akid"
+	"github.com/akitasoftware/akita-libs/akinet"
+	pp "github.com/akitasoftware/akita-libs/path_pattern"
+	"github.com/akitasoftware/akita-libs/spec_util"
+	"github.com/akitasoftware/akita-libs/spec_util/ir_hash"
+)
+
+// Options for generating a spec from local traces without Akita Cloud.
+type OfflineOptions struct {
+	IncludeTrackers bool
+	PathPatterns    []pp.Pattern
+	PathExclusions  []*regexp.Regexp
+	Plugins         []plugin.AkitaPlugin
+}
+
+// Generates an OpenAPI 3 spec from local traces without contacting Akita
+// Cloud, and writes it to args.Out.
+func runOffline(args Args) error {
+	if args.Out.LocalPath == nil {
+		return errors.Errorf("--offline requires --out to be a local file")
+	}
+
+	tracePaths, err := localTracePaths(args.Traces)
+	if err != nil {
+		return err
+	}
+	if len(tracePaths) == 0 {
+		return errors.Errorf("no HAR or NDJSON files found in --traces")
+	}
+
+	pathExclusions, err := compilePathExclusions(args.PathExclusions)
+	if err != nil {
+		return err
+	}
+
+	printer.Infof("Generating API specification from %d local traces...\n", len(tracePaths))
+	doc, err := GenerateOfflineSpec(tracePaths, OfflineOptions{
+		IncludeTrackers: args.IncludeTrackers,
+		PathPatterns:    pathPatternsFromStrings(args.PathParams),
+		PathExclusions:  pathExclusions,
+		Plugins:         args.Plugins,
+	})
+	if err != nil {
+		return err
+	}
+
+	specContent, err := yaml.Marshal(doc)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal spec")
+	}
+	if args.Format == "json" {
+		specContent, err = yaml.YAMLToJSON(specContent)
+		if err != nil {
+			return errors.Wrap(err, "failed to convert spec from YAML to JSON")
+		}
+	}
+
+	var out io.Writer
+	if *args.Out.LocalPath == "-" {
+		out = os.Stdout
+	} else {
+		f, err := os.OpenFile(*args.Out.LocalPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return errors.Wrapf(err, "failed to open file at path %q", *args.Out.LocalPath)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if err := WriteSpec(out, string(specContent)); err != nil {
+		return errors.Wrap(err, "failed to write spec")
+	}
+
+	printer.Infof("%s 🎉\n\n", printer.Color.Green("Success!"))
+	return nil
+}
+
+// Expands the given local trace locations into a sorted list of files.
+// Directories are searched (non-recursively) for HAR and NDJSON files,
+// optionally gzipped.
+func localTracePaths(traces []location.Location) ([]string, error) {
+	var result []string
+	for _, loc := range traces {
+		if loc.LocalPath == nil {
+			return nil, errors.Errorf("%s is not a local trace; only local traces can be used with --offline", loc.String())
+		}
+		p := *loc.LocalPath
+
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to access %s", p)
+		}
+		if !fi.IsDir() {
+			result = append(result, p)
+			continue
+		}
+
+		entries, err := ioutil.ReadDir(p)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list %s", p)
+		}
+		for _, e := range entries {
+			if !e.IsDir() && isLocalTraceFileName(e.Name()) {
+				result = append(result, filepath.Join(p, e.Name()))
+			}
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func isLocalTraceFileName(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasSuffix(name, ".har") || strings.HasSuffix(name, ".har.gz") || ndjson.IsNDJSONFile(name)
+}
+
+func compilePathExclusions(exclusions []string) ([]*regexp.Regexp, error) {
+	result := make([]*regexp.Regexp, len(exclusions))
+	for i, s := range exclusions {
+		r, err := regexp.Compile(s)
+		if err != nil {
+			return nil, errors.Wrapf(err, "bad regular expression for path exclusion: %q", s)
+		}
+		result[i] = r
+	}
+	return result, nil
+}
+
+// Builds an OpenAPI 3 document from the given local traces. The output depends
+// only on the contents of the files, so it is suitable for golden-file tests.
+func GenerateOfflineSpec(tracePaths []string, opts OfflineOptions) (*OpenAPI3, error) {
+	witnesses := &localWitnessCollector{
+		pairCache: make(map[akid.WitnessID]*pb.Witness),
+	}
+	var col trace.Collector = witnesses
+	if !opts.IncludeTrackers {
+		col = trace.New3PTrackerFilterCollector(col)
+	}
+
+	for _, p := range tracePaths {
+		if _, err := ProcessLocalTrace(col, p); err != nil {
+			return nil, errors.Wrapf(err, "failed to process %s", p)
+		}
+	}
+	if err := col.Close(); err != nil {
+		return nil, err
+	}
+
+	// Drop excluded paths before inference so they don't affect clustering.
+	kept := make([]*pb.Witness, 0, len(witnesses.witnesses))
+	for _, w := range witnesses.witnesses {
+		meta := spec_util.HTTPMetaFromMethod(w.GetMethod())
+		if meta == nil || meta.Method == "" || isExcludedPath(meta.PathTemplate, opts.PathExclusions) {
+			continue
+		}
+		kept = append(kept, w)
+	}
+
+	inferrer := path_inference.NewInferrer(opts.PathPatterns, opts.PathExclusions)
+	for _, w := range kept {
+		inferrer.ObserveWitness(w)
+	}
+
+	methods := newMethodMelder()
+	for _, w := range kept {
+		inferrer.GeneralizeWitness(w)
+
+		for _, p := range opts.Plugins {
+			if err := p.Transform(w.Method); err != nil {
+				return nil, errors.Wrapf(err, "plugin %q failed", p.Name())
+			}
+		}
+
+		inferDataFormats(w.Method)
+		if err := methods.add(w.Method); err != nil {
+			return nil, err
+		}
+	}
+
+	return toOpenAPI3(methods.methods()), nil
+}
+
+func isExcludedPath(path string, exclusions []*regexp.Regexp) bool {
+	for _, r := range exclusions {
+		if r.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// Pairs up requests and responses into witnesses in memory. Witnesses are kept
+// in the order they are completed so that the result is deterministic.
+type localWitnessCollector struct {
+	pairCache map[akid.WitnessID]*pb.Witness
+
+	// Keys of pairCache in insertion order.
+	pending []akid.WitnessID
+
+	witnesses []*pb.Witness
+}
+
+var _ trace.Collector = (*localWitnessCollector)(nil)
+
+func (c *localWitnessCollector) Process(t akinet.ParsedNetworkTraffic) error {
+	switch t.Content.(type) {
+	case akinet.HTTPRequest, akinet.HTTPResponse:
+	default:
+		return nil
+	}
+
+	partial, err := learn.ParseHTTP(t.Content)
+	if err != nil {
+		printer.Debugf("Failed to parse HTTP, skipping: %v\n", err)
+		return nil
+	}
+
+	if pair, ok := c.pairCache[partial.PairKey]; ok {
+		learn.MergeWitness(pair, partial.Witness)
+		delete(c.pairCache, partial.PairKey)
+		c.witnesses = append(c.witnesses, pair)
+	} else {
+		c.pairCache[partial.PairKey] = partial.Witness
+		c.pending = append(c.pending, partial.PairKey)
+	}
+	return nil
+}
+
+// Keeps unpaired requests, since they still describe an endpoint. Unpaired
+// responses are dropped later on, since they have no method or path.
+func (c *localWitnessCollector) Close() error {
+	for _, k := range c.pending {
+		if w, ok := c.pairCache[k]; ok {
+			c.witnesses = append(c.witnesses, w)
+			delete(c.pairCache, k)
+		}
+	}
+	c.pending = nil
+	return nil
+}
+
+// Melds witnesses into one method per HTTP method and path template.
+type methodMelder struct {
+	byKey map[string]spec_util.MeldedMethod
+}
+
+func newMethodMelder() *methodMelder {
+	return &methodMelder{byKey: make(map[string]spec_util.MeldedMethod)}
+}
+
+func (m *methodMelder) add(method *pb.Method) error {
+	meta := spec_util.HTTPMetaFromMethod(method)
+	key := meta.Method + " " + meta.PathTemplate
+
+	if method.Args == nil {
+		method.Args = map[string]*pb.Data{}
+	}
+	if method.Responses == nil {
+		method.Responses = map[string]*pb.Data{}
+	}
+
+	melded := spec_util.NewMeldedMethod(method)
+	if existing, ok := m.byKey[key]; ok {
+		// Requests are only melded if both methods are 4xx-only or neither is;
+		// otherwise the args of one of them are discarded.
+		if existing.Has4xxOnly() == melded.Has4xxOnly() {
+			markMissingArgsOptional(existing.GetArgs(), melded.GetArgs())
+		}
+		if err := existing.Meld(melded); err != nil {
+			return errors.Wrapf(err, "failed to merge witnesses for %s", key)
+		}
+		return nil
+	}
+	m.byKey[key] = melded
+	return nil
+}
+
+// Returns the melded methods, sorted by path and HTTP method.
+func (m *methodMelder) methods() []*pb.Method {
+	keys := make([]string, 0, len(m.byKey))
+	for k := range m.byKey {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	result := make([]*pb.Method, 0, len(keys))
+	for _, k := range keys {
+		result = append(result, m.byKey[k].GetMethod())
+	}
+	return result
+}
+
+// Melding takes the union of the args in each method, so args that only some
+// requests have are marked optional beforehand.
+func markMissingArgsOptional(dst, src map[string]*pb.Data) {
+	dstByMeta := argsByMetaHash(dst)
+	srcByMeta := argsByMetaHash(src)
+	for h, d := range dstByMeta {
+		if _, ok := srcByMeta[h]; !ok {
+			makeOptional(d)
+		}
+	}
+	for h, d := range srcByMeta {
+		if _, ok := dstByMeta[h]; !ok {
+			makeOptional(d)
+		}
+	}
+}
+
+func argsByMetaHash(args map[string]*pb.Data) map[string]*pb.Data {
+	result := make(map[string]*pb.Data, len(args))
+	for _, d := range args {
+		if d.Meta != nil {
+			result[ir_hash.HashDataMetaToString(d.Meta)] = d
+		}
+	}
+	return result
+}
+
+func makeOptional(d *pb.Data) {
+	if _, ok := d.Value.(*pb.Data_Optional); ok {
+		return
+	}
+	d.Value = &pb.Data_Optional{
+		Optional: &pb.Optional{
+			Value: &pb.Optional_Data{
+				Data: &pb.Data{Value: d.Value},
+			},
+		},
+	}
+}
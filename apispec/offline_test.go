@@ -0,0 +1,100 @@
+package apispec
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/akitasoftware/akita-cli/har_writer"
+	"github.com/akitasoftware/akita-cli/location"
+	"github.com/akitasoftware/akita-cli/ndjson"
+	"github.com/akitasoftware/akita-cli/trace"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files")
+
+func TestGenerateOfflineSpec(t *testing.T) {
+	dir := "testdata/offline"
+	tracePaths, err := localTracePaths([]location.Location{{LocalPath: &dir}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"testdata/offline/a.har", "testdata/offline/b.har"}, tracePaths)
+
+	opts := OfflineOptions{
+		PathExclusions: []*regexp.Regexp{regexp.MustCompile(`^/static/`)},
+	}
+	doc, err := GenerateOfflineSpec(tracePaths, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := yaml.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	goldenPath := "testdata/offline_spec.golden.yaml"
+	if *updateGolden {
+		if err := ioutil.WriteFile(goldenPath, actual, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected, err := ioutil.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, string(expected), string(actual))
+
+	// Generating the spec again should give exactly the same output.
+	doc, err = GenerateOfflineSpec(tracePaths, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := yaml.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, string(actual), string(again))
+}
+
+// Specs generated from NDJSON files should match those generated from HAR
+// files written from the same traffic.
+func TestGenerateOfflineSpecFromNDJSON(t *testing.T) {
+	outDir := t.TempDir()
+	var harPaths, ndjsonPaths []string
+	for _, name := range []string{"a", "b"} {
+		harCol := trace.NewHARCollector(name, outDir, nil, har_writer.Options{})
+		ndjsonCol := ndjson.NewCollector(name, outDir, nil)
+		if _, err := ProcessHAR(trace.TeeCollector{Dst1: harCol, Dst2: ndjsonCol}, filepath.Join("testdata/offline", name+".har")); err != nil {
+			t.Fatal(err)
+		}
+		if err := harCol.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := ndjsonCol.Close(); err != nil {
+			t.Fatal(err)
+		}
+		harPaths = append(harPaths, harCol.Files()...)
+		ndjsonPaths = append(ndjsonPaths, ndjsonCol.Path())
+	}
+
+	specs := make([]string, 0, 2)
+	for _, paths := range [][]string{harPaths, ndjsonPaths} {
+		doc, err := GenerateOfflineSpec(paths, OfflineOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		spec, err := yaml.Marshal(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		specs = append(specs, string(spec))
+	}
+	assert.Contains(t, specs[1], "/users/{arg1}/orders/{arg2}")
+	assert.Equal(t, specs[0], specs[1])
+}
@@ -0,0 +1,393 @@
+package apispec
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	pb "github.com/akitasoftware/akita-ir/go/api_spec"
+	"github.com/akitasoftware/akita-libs/spec_util"
+)
+
+// A minimal OpenAPI 3 document, covering what can be learned from witnesses.
+// Fields are marshaled through JSON tags; maps keep the output deterministic
+// since their keys are sorted when marshaled.
+type OpenAPI3 struct {
+	OpenAPI    string                     `json:"openapi"`
+	Info       openAPIInfo                `json:"info"`
+	Paths      map[string]openAPIPathItem `json:"paths"`
+	Components *openAPIComponents         `json:"components,omitempty"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// Operations keyed by lowercase HTTP method.
+type openAPIPathItem map[string]*openAPIOperation
+
+type openAPIOperation struct {
+	Parameters  []*openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+	Security    []map[string][]string       `json:"security,omitempty"`
+}
+
+type openAPIParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required,omitempty"`
+	Schema   *openAPISchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Content  map[string]*openAPIMediaType `json:"content"`
+	Required bool                         `json:"required,omitempty"`
+}
+
+type openAPIResponse struct {
+	Description string                       `json:"description"`
+	Headers     map[string]*openAPIHeader    `json:"headers,omitempty"`
+	Content     map[string]*openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIHeader struct {
+	Required bool           `json:"required,omitempty"`
+	Schema   *openAPISchema `json:"schema"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+type openAPISchema struct {
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Nullable             bool                      `json:"nullable,omitempty"`
+	Properties           map[string]*openAPISchema `json:"properties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+	AdditionalProperties *openAPISchema            `json:"additionalProperties,omitempty"`
+	Items                *openAPISchema            `json:"items,omitempty"`
+	OneOf                []*openAPISchema          `json:"oneOf,omitempty"`
+}
+
+type openAPIComponents struct {
+	SecuritySchemes map[string]*openAPISecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type openAPISecurityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme"`
+}
+
+const (
+	openAPIVersion = "3.0.3"
+
+	basicAuthScheme  = "basicAuth"
+	bearerAuthScheme = "bearerAuth"
+)
+
+// OpenAPI 3 ignores header parameters with these names, since they are
+// described elsewhere in the document.
+var ignoredHeaderParams = map[string]bool{
+	"accept":        true,
+	"authorization": true,
+	"content-type":  true,
+}
+
+func toOpenAPI3(methods []*pb.Method) *OpenAPI3 {
+	doc := &OpenAPI3{
+		OpenAPI: openAPIVersion,
+		Info: openAPIInfo{
+			Title:   "API specification generated by Akita",
+			Version: "1.0.0",
+		},
+		Paths: map[string]openAPIPathItem{},
+	}
+
+	securitySchemes := map[string]*openAPISecurityScheme{}
+	for _, m := range methods {
+		meta := spec_util.HTTPMetaFromMethod(m)
+		if meta == nil {
+			continue
+		}
+
+		item, ok := doc.Paths[meta.PathTemplate]
+		if !ok {
+			item = openAPIPathItem{}
+			doc.Paths[meta.PathTemplate] = item
+		}
+		item[strings.ToLower(meta.Method)] = toOpenAPIOperation(m, securitySchemes)
+	}
+
+	if len(securitySchemes) > 0 {
+		doc.Components = &openAPIComponents{SecuritySchemes: securitySchemes}
+	}
+	return doc
+}
+
+func toOpenAPIOperation(m *pb.Method, securitySchemes map[string]*openAPISecurityScheme) *openAPIOperation {
+	op := &openAPIOperation{
+		Responses: map[string]*openAPIResponse{},
+	}
+
+	for _, k := range sortedDataKeys(m.Args) {
+		arg := m.Args[k]
+		schema, optional := toOpenAPISchema(arg)
+		meta := arg.GetMeta().GetHttp()
+
+		switch loc := meta.GetLocation().(type) {
+		case *pb.HTTPMeta_Path:
+			op.Parameters = append(op.Parameters, &openAPIParameter{
+				Name: loc.Path.Key, In: "path", Required: true, Schema: schema,
+			})
+		case *pb.HTTPMeta_Query:
+			op.Parameters = append(op.Parameters, &openAPIParameter{
+				Name: loc.Query.Key, In: "query", Required: !optional, Schema: schema,
+			})
+		case *pb.HTTPMeta_Header:
+			if ignoredHeaderParams[strings.ToLower(loc.Header.Key)] {
+				continue
+			}
+			op.Parameters = append(op.Parameters, &openAPIParameter{
+				Name: loc.Header.Key, In: "header", Required: !optional, Schema: schema,
+			})
+		case *pb.HTTPMeta_Cookie:
+			op.Parameters = append(op.Parameters, &openAPIParameter{
+				Name: loc.Cookie.Key, In: "cookie", Required: !optional, Schema: schema,
+			})
+		case *pb.HTTPMeta_Auth:
+			name := bearerAuthScheme
+			scheme := &openAPISecurityScheme{Type: "http", Scheme: "bearer"}
+			if loc.Auth.Type == pb.HTTPAuth_BASIC {
+				name = basicAuthScheme
+				scheme = &openAPISecurityScheme{Type: "http", Scheme: "basic"}
+			}
+			securitySchemes[name] = scheme
+			op.Security = append(op.Security, map[string][]string{name: {}})
+		case *pb.HTTPMeta_Body, *pb.HTTPMeta_Multipart:
+			if op.RequestBody == nil {
+				op.RequestBody = &openAPIRequestBody{
+					Content:  map[string]*openAPIMediaType{},
+					Required: !optional,
+				}
+			}
+			op.RequestBody.Content[mediaTypeOf(meta)] = &openAPIMediaType{Schema: schema}
+		}
+	}
+	sort.SliceStable(op.Parameters, func(i, j int) bool {
+		if op.Parameters[i].In != op.Parameters[j].In {
+			return op.Parameters[i].In < op.Parameters[j].In
+		}
+		return op.Parameters[i].Name < op.Parameters[j].Name
+	})
+	sort.SliceStable(op.Security, func(i, j int) bool {
+		return firstKey(op.Security[i]) < firstKey(op.Security[j])
+	})
+
+	for _, k := range sortedDataKeys(m.Responses) {
+		resp := m.Responses[k]
+		meta := resp.GetMeta().GetHttp()
+
+		code := "default"
+		description := "Response"
+		if c := int(meta.GetResponseCode()); c != 0 {
+			code = strconv.Itoa(c)
+			if text := http.StatusText(c); text != "" {
+				description = text
+			}
+		}
+		r, ok := op.Responses[code]
+		if !ok {
+			r = &openAPIResponse{Description: description}
+			op.Responses[code] = r
+		}
+
+		schema, optional := toOpenAPISchema(resp)
+		switch loc := meta.GetLocation().(type) {
+		case *pb.HTTPMeta_Header:
+			if strings.ToLower(loc.Header.Key) == "content-type" {
+				continue
+			}
+			if r.Headers == nil {
+				r.Headers = map[string]*openAPIHeader{}
+			}
+			r.Headers[loc.Header.Key] = &openAPIHeader{Required: !optional, Schema: schema}
+		case *pb.HTTPMeta_Body, *pb.HTTPMeta_Multipart:
+			if r.Content == nil {
+				r.Content = map[string]*openAPIMediaType{}
+			}
+			r.Content[mediaTypeOf(meta)] = &openAPIMediaType{Schema: schema}
+		}
+	}
+
+	// OpenAPI requires at least one response per operation.
+	if len(op.Responses) == 0 {
+		op.Responses["default"] = &openAPIResponse{Description: "No response was observed"}
+	}
+
+	return op
+}
+
+func firstKey(m map[string][]string) string {
+	for k := range m {
+		return k
+	}
+	return ""
+}
+
+func sortedDataKeys(m map[string]*pb.Data) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func mediaTypeOf(meta *pb.HTTPMeta) string {
+	if mp := meta.GetMultipart(); mp != nil {
+		return "multipart/" + mp.Type
+	}
+
+	body := meta.GetBody()
+	switch body.GetContentType() {
+	case pb.HTTPBody_JSON:
+		return "application/json"
+	case pb.HTTPBody_FORM_URL_ENCODED:
+		return "application/x-www-form-urlencoded"
+	case pb.HTTPBody_OCTET_STREAM:
+		return "application/octet-stream"
+	case pb.HTTPBody_PDF:
+		return "application/pdf"
+	case pb.HTTPBody_TEXT_PLAIN:
+		return "text/plain"
+	case pb.HTTPBody_YAML:
+		return "application/x-yaml"
+	case pb.HTTPBody_TEXT_HTML:
+		return "text/html"
+	case pb.HTTPBody_OTHER:
+		if body.OtherType != "" {
+			return body.OtherType
+		}
+	}
+	return "*/*"
+}
+
+// Converts the data to a schema. Also returns whether the data is optional.
+func toOpenAPISchema(d *pb.Data) (*openAPISchema, bool) {
+	schema := &openAPISchema{}
+	optional := false
+
+	switch v := d.GetValue().(type) {
+	case *pb.Data_Primitive:
+		schema = primitiveSchema(v.Primitive)
+	case *pb.Data_Struct:
+		schema = structSchema(v.Struct)
+	case *pb.Data_List:
+		schema.Type = "array"
+		schema.Items = listItemSchema(v.List)
+	case *pb.Data_Optional:
+		optional = true
+		if inner := v.Optional.GetData(); inner != nil {
+			schema, _ = toOpenAPISchema(inner)
+		}
+	case *pb.Data_Oneof:
+		options := v.Oneof.GetOptions()
+		for _, k := range sortedDataKeys(options) {
+			s, _ := toOpenAPISchema(options[k])
+			schema.OneOf = append(schema.OneOf, s)
+		}
+	}
+
+	if d.GetNullable() {
+		schema.Nullable = true
+	}
+	return schema, optional
+}
+
+func primitiveSchema(p *pb.Primitive) *openAPISchema {
+	schema := &openAPISchema{}
+	switch spec_util.TypeOfPrimitive(p) {
+	case "bool":
+		schema.Type = "boolean"
+	case "bytes":
+		schema.Type = "string"
+		schema.Format = "byte"
+	case "int32", "uint32":
+		schema.Type = "integer"
+		schema.Format = "int32"
+	case "int64", "uint64":
+		schema.Type = "integer"
+		schema.Format = "int64"
+	case "float":
+		schema.Type = "number"
+		schema.Format = "float"
+	case "double":
+		schema.Type = "number"
+		schema.Format = "double"
+	case "string":
+		schema.Type = "string"
+		// OpenAPI only allows a single format, so pick one deterministically if
+		// several were detected.
+		formats := make([]string, 0, len(p.Formats))
+		for f, ok := range p.Formats {
+			if ok {
+				formats = append(formats, f)
+			}
+		}
+		if len(formats) > 0 {
+			sort.Strings(formats)
+			schema.Format = formats[0]
+		}
+	}
+	return schema
+}
+
+func structSchema(s *pb.Struct) *openAPISchema {
+	schema := &openAPISchema{Type: "object"}
+	if mt := s.GetMapType(); mt != nil {
+		schema.AdditionalProperties, _ = toOpenAPISchema(mt.GetValue())
+		return schema
+	}
+
+	if len(s.Fields) > 0 {
+		schema.Properties = make(map[string]*openAPISchema, len(s.Fields))
+	}
+	for name, field := range s.Fields {
+		fieldSchema, optional := toOpenAPISchema(field)
+		schema.Properties[name] = fieldSchema
+		if !optional {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	sort.Strings(schema.Required)
+	return schema
+}
+
+func listItemSchema(l *pb.List) *openAPISchema {
+	if len(l.Elems) == 1 {
+		s, _ := toOpenAPISchema(l.Elems[0])
+		return s
+	}
+
+	// Lists are usually collapsed to a single element when witnesses are
+	// melded. Otherwise, describe each distinct element type.
+	schema := &openAPISchema{}
+	seen := map[string]bool{}
+	for _, e := range l.Elems {
+		s, _ := toOpenAPISchema(e)
+		key, _ := json.Marshal(s)
+		if !seen[string(key)] {
+			seen[string(key)] = true
+			schema.OneOf = append(schema.OneOf, s)
+		}
+	}
+	if len(schema.OneOf) == 1 {
+		return schema.OneOf[0]
+	}
+	return schema
+}
@@ -9,7 +9,6 @@ import (
 	"net/url"
 	"os"
 	"path"
-	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -60,6 +59,10 @@ type Args struct {
 	Timeout                    *time.Duration
 	TimeRange                  *time_span.TimeSpan
 
+	// If set, the spec is generated locally from local traces, without
+	// contacting Akita Cloud.
+	Offline bool
+
 	GitHubBranch string
 	GitHubCommit string
 	GitHubRepo   string
@@ -149,6 +152,10 @@ func collectSpecTags(args *Args) (map[tags.Key]string, *github.PRInfo, error) {
 }
 
 func Run(args Args) error {
+	if args.Offline {
+		return runOffline(args)
+	}
+
 	var serviceName string
 	if uri := args.Out.AkitaURI; uri != nil {
 		serviceName = uri.ServiceName
@@ -191,13 +198,9 @@ func Run(args Args) error {
 		}
 	}
 
-	pathExclusions := make([]*regexp.Regexp, len(args.PathExclusions))
-	for i, s := range args.PathExclusions {
-		if r, err := regexp.Compile(s); err == nil {
-			pathExclusions[i] = r
-		} else {
-			return errors.Wrapf(err, "bad regular expression for path exclusion: %q", s)
-		}
+	pathExclusions, err := compilePathExclusions(args.PathExclusions)
+	if err != nil {
+		return err
 	}
 
 	// Build tag set, extract CI or source-control information
@@ -463,9 +466,9 @@ func uploadLocalTraces(domain string, clientID akid.ClientID, svc akid.ServiceID
 		}
 		defer collector.Close()
 
-		witnessCount, err := ProcessHAR(collector, p)
+		witnessCount, err := ProcessLocalTrace(collector, p)
 		if err != nil {
-			return nil, errors.Wrapf(err, "failed to process HAR file %s", p)
+			return nil, errors.Wrapf(err, "failed to process %s", p)
 		}
 		lrns = append(lrns, lrn)
 		numWitnesses = append(numWitnesses, witnessCount)
@@ -37,3 +37,9 @@ func initCfgDir() {
 		os.Exit(1)
 	}
 }
+
+// Returns the directory in which upload batches that could not be sent to
+// Akita Cloud are kept for retrying.
+func GetUploadSpoolDir() string {
+	return filepath.Join(cfgDir, "upload_spool")
+}
@@ -1,6 +1,9 @@
 package apidump
 
 import (
+	"strings"
+	"time"
+
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 
@@ -8,28 +11,83 @@ import (
 	"github.com/akitasoftware/akita-cli/cmd/internal/akiflag"
 	"github.com/akitasoftware/akita-cli/cmd/internal/cmderr"
 	"github.com/akitasoftware/akita-cli/cmd/internal/pluginloader"
+	"github.com/akitasoftware/akita-cli/dashboard"
+	"github.com/akitasoftware/akita-cli/dedup"
+	"github.com/akitasoftware/akita-cli/filter_expr"
+	"github.com/akitasoftware/akita-cli/har_writer"
+	"github.com/akitasoftware/akita-cli/listen_ports"
 	"github.com/akitasoftware/akita-cli/location"
+	"github.com/akitasoftware/akita-cli/otlp_export"
+	"github.com/akitasoftware/akita-cli/pair_cache"
+	"github.com/akitasoftware/akita-cli/sampling"
+	"github.com/akitasoftware/akita-cli/traffic_direction"
 	"github.com/akitasoftware/akita-cli/util"
 	"github.com/akitasoftware/akita-libs/akiuri"
 )
 
 var (
 	// Optional flags
-	outFlag             location.Location
-	serviceFlag         string
-	interfacesFlag      []string
-	filterFlag          string
-	sampleRateFlag      float64
-	rateLimitFlag       float64
-	tagsFlag            []string
-	appendByTagFlag     bool
-	pathExclusionsFlag  []string
-	hostExclusionsFlag  []string
-	pathAllowlistFlag   []string
-	hostAllowlistFlag   []string
-	execCommandFlag     string
-	execCommandUserFlag string
-	pluginsFlag         []string
+	outFlag               location.Location
+	serviceFlag           string
+	interfacesFlag        []string
+	filterFlag            string
+	autoFilterFlag        bool
+	autoFilterPIDFlag     int
+	autoFilterCtrFlag     string
+	sampleRateFlag        float64
+	rateLimitFlag         float64
+	samplePolicyFlag      string
+	slowRequestFlag       time.Duration
+	perEndpointLimitFlag  bool
+	endpointMinRateFlag   int
+	endpointMaxShareFlag  float64
+	endpointReportFlag    string
+	endpointMetricsFlag   bool
+	metricsFileFlag       string
+	metricsAddrFlag       string
+	controlSocketFlag     string
+	selfTestFlag          bool
+	otlpEndpointFlag      string
+	otlpFileFlag          string
+	otlpHeadersFlag       []string
+	otlpServiceFlag       string
+	tuiFlag               bool
+	durationFlag          time.Duration
+	maxRequestsFlag       int
+	maxBytesFlag          string
+	tagsFlag              []string
+	appendByTagFlag       bool
+	pathExclusionsFlag    []string
+	hostExclusionsFlag    []string
+	pathAllowlistFlag     []string
+	hostAllowlistFlag     []string
+	allowExprFlag         []string
+	excludeExprFlag       []string
+	filterRulesFlag       string
+	attributeProcsFlag    bool
+	inferPathParamsFlag   bool
+	pathParamsFlag        []string
+	detectPIIFlag         bool
+	piiDetectorsFlag      string
+	piiReportFlag         string
+	redactionRulesFlag    string
+	harRotateSizeFlag     int64
+	harRotateEntriesFlag  int
+	harRotateIntervalFlag time.Duration
+	harGzipFlag           bool
+	formatFlag            string
+	pairCacheSizeFlag     int
+	pairCacheTTLFlag      time.Duration
+	dedupFlag             bool
+	dedupWindowFlag       time.Duration
+	dedupExemplarsFlag    int
+	directionFlag         string
+	execCommandFlag       string
+	execCommandUserFlag   string
+	phaseFlag             []string
+	uncapturedPhasesFlag  []string
+	alwaysRunPhasesFlag   []string
+	pluginsFlag           []string
 )
 
 var Cmd = &cobra.Command{
@@ -49,8 +107,16 @@ var Cmd = &cobra.Command{
 			return errors.Wrap(err, "failed to load plugins")
 		}
 
-		// Check that exactly one of --out or --service is specified.
-		if outFlag.IsSet() == (serviceFlag != "") {
+		// Check that exactly one of --out or --service is specified. The
+		// self-test writes to a temporary directory, so needs neither.
+		if selfTestFlag {
+			if outFlag.IsSet() || serviceFlag != "" {
+				return errors.New("\"self-test\" can't be used together with --out or --service")
+			}
+			if execCommandFlag != "" || len(phaseFlag) > 0 || tuiFlag {
+				return errors.New("\"self-test\" can't be used together with \"command\", \"phase\" or \"tui\"")
+			}
+		} else if outFlag.IsSet() == (serviceFlag != "") {
 			return errors.New("exactly one of --out or --service must be specified")
 		}
 
@@ -87,24 +153,245 @@ var Cmd = &cobra.Command{
 			}
 		}
 
+		if len(pathParamsFlag) > 0 && !inferPathParamsFlag {
+			return errors.New("\"path-parameters\" can only be used together with \"infer-path-parameters\"")
+		}
+		if piiDetectorsFlag != "" && !detectPIIFlag && redactionRulesFlag == "" {
+			return errors.New("\"pii-detectors\" can only be used together with \"detect-pii\" or \"redaction-rules\"")
+		}
+		if piiReportFlag != "" && !detectPIIFlag {
+			return errors.New("\"pii-report\" can only be used together with \"detect-pii\"")
+		}
+		switch formatFlag {
+		case apidump.HARFormat:
+		case apidump.NDJSONFormat:
+			if outFlag.LocalPath == nil {
+				return errors.New("\"format\" can only be used with a local --out directory")
+			}
+			if inferPathParamsFlag {
+				return errors.New("\"infer-path-parameters\" can only be used with HAR output")
+			}
+		default:
+			return errors.Errorf("unknown format %q; must be %q or %q", formatFlag, apidump.HARFormat, apidump.NDJSONFormat)
+		}
+		harOpts := har_writer.Options{
+			MaxFileBytes:   harRotateSizeFlag * 1024 * 1024,
+			MaxFileEntries: harRotateEntriesFlag,
+			RotateInterval: harRotateIntervalFlag,
+			Gzip:           harGzipFlag,
+		}
+		if harOpts != (har_writer.Options{}) && (outFlag.LocalPath == nil || formatFlag != apidump.HARFormat) {
+			return errors.New("HAR rotation and compression flags can only be used with HAR output to a local --out directory")
+		}
+		if harRotateSizeFlag < 0 || harRotateEntriesFlag < 0 || harRotateIntervalFlag < 0 {
+			return errors.New("HAR rotation limits must not be negative")
+		}
+
+		filterRules := filter_expr.Rules{
+			Allow:   allowExprFlag,
+			Exclude: excludeExprFlag,
+		}
+		if filterRulesFlag != "" {
+			fileRules, err := filter_expr.LoadRules(filterRulesFlag)
+			if err != nil {
+				return err
+			}
+			filterRules.Allow = append(filterRules.Allow, fileRules.Allow...)
+			filterRules.Exclude = append(filterRules.Exclude, fileRules.Exclude...)
+		}
+		if e, err := filterRules.Compile(); err != nil {
+			return err
+		} else if e != nil && e.NeedsOwners() && !attributeProcsFlag {
+			return errors.New("filter expressions on pid, process, container or pod fields can only be used together with \"attribute-processes\"")
+		}
+
+		switch samplePolicyFlag {
+		case apidump.UniformSampling:
+			if slowRequestFlag != 0 {
+				return errors.New("\"slow-request-threshold\" can only be used with \"--sample-policy outcome\"")
+			}
+		case apidump.OutcomeSampling:
+			if slowRequestFlag < 0 {
+				return errors.New("\"slow-request-threshold\" must not be negative")
+			}
+		default:
+			return errors.Errorf("unknown sample policy %q; must be %q or %q", samplePolicyFlag, apidump.UniformSampling, apidump.OutcomeSampling)
+		}
+
+		var endpointLimit *sampling.EndpointLimitOptions
+		if perEndpointLimitFlag {
+			if rateLimitFlag <= 0 {
+				return errors.New("\"rate-limit-per-endpoint\" can only be used together with \"rate-limit\"")
+			}
+			if endpointMinRateFlag < 1 {
+				return errors.New("\"endpoint-min-rate\" must be at least 1")
+			}
+			if endpointMaxShareFlag <= 0 || endpointMaxShareFlag > 1 {
+				return errors.New("\"endpoint-max-share\" must be greater than 0 and at most 1")
+			}
+			endpointLimit = &sampling.EndpointLimitOptions{
+				MinPerMinute: endpointMinRateFlag,
+				MaxShare:     endpointMaxShareFlag,
+			}
+		} else if endpointReportFlag != "" {
+			return errors.New("\"endpoint-report\" can only be used together with \"rate-limit-per-endpoint\"")
+		}
+
+		if metricsFileFlag != "" && !endpointMetricsFlag {
+			return errors.New("\"endpoint-metrics-file\" can only be used together with \"endpoint-metrics\"")
+		}
+
+		if len(otlpHeadersFlag) > 0 && otlpEndpointFlag == "" {
+			return errors.New("\"otlp-header\" can only be used together with \"otlp-endpoint\"")
+		}
+		var otlpOpts *otlp_export.Options
+		if otlpEndpointFlag != "" || otlpFileFlag != "" {
+			otlpOpts = &otlp_export.Options{
+				Endpoint:    otlpEndpointFlag,
+				File:        otlpFileFlag,
+				ServiceName: otlpServiceFlag,
+				Headers:     make(map[string]string, len(otlpHeadersFlag)),
+			}
+			for _, h := range otlpHeadersFlag {
+				parts := strings.SplitN(h, "=", 2)
+				if len(parts) != 2 || parts[0] == "" {
+					return errors.Errorf("bad OTLP header %q; must be of the form \"name=value\"", h)
+				}
+				otlpOpts.Headers[parts[0]] = parts[1]
+			}
+		} else if otlpServiceFlag != "" {
+			return errors.New("\"otlp-service-name\" can only be used together with \"otlp-endpoint\" or \"otlp-file\"")
+		}
+
+		if tuiFlag {
+			if execCommandFlag != "" || len(phaseFlag) > 0 {
+				return errors.New("\"tui\" can't be used together with \"command\" or \"phase\"")
+			}
+			if !dashboard.IsTerminal() {
+				return errors.New("\"tui\" can only be used in a terminal")
+			}
+		}
+
+		if durationFlag < 0 || maxRequestsFlag < 0 {
+			return errors.New("\"duration\" and \"max-requests\" must not be negative")
+		}
+		var maxBytes int64
+		if maxBytesFlag != "" {
+			if maxBytes, err = util.ParseSize(maxBytesFlag); err != nil {
+				return errors.Wrap(err, "bad value for \"max-bytes\"")
+			}
+		}
+		if (durationFlag != 0 || maxRequestsFlag != 0 || maxBytes != 0) && execCommandFlag != "" {
+			return errors.New("\"duration\", \"max-requests\" and \"max-bytes\" can't be used together with \"command\", which stops the capture when the command finishes")
+		}
+		if (durationFlag != 0 || maxRequestsFlag != 0 || maxBytes != 0) && len(phaseFlag) > 0 {
+			return errors.New("\"duration\", \"max-requests\" and \"max-bytes\" can't be used together with \"phase\", which stops the capture when the last phase finishes")
+		}
+
+		if execCommandFlag != "" && len(phaseFlag) > 0 {
+			return errors.New("\"command\" can't be used together with \"phase\"")
+		}
+		phases, err := parsePhases(phaseFlag, uncapturedPhasesFlag, alwaysRunPhasesFlag)
+		if err != nil {
+			return err
+		}
+
+		if autoFilterFlag {
+			if filterFlag != "" {
+				return errors.New("\"auto-filter\" can't be used together with \"filter\"")
+			}
+			if autoFilterPIDFlag != 0 && autoFilterCtrFlag != "" {
+				return errors.New("\"auto-filter-pid\" can't be used together with \"auto-filter-container\"")
+			}
+			if autoFilterPIDFlag < 0 {
+				return errors.New("\"auto-filter-pid\" must be positive")
+			}
+			if autoFilterCtrFlag != "" && len(autoFilterCtrFlag) < 12 {
+				return errors.New("\"auto-filter-container\" must be at least 12 characters of the container ID")
+			}
+		} else if autoFilterPIDFlag != 0 || autoFilterCtrFlag != "" {
+			return errors.New("\"auto-filter-pid\" and \"auto-filter-container\" can only be used together with \"auto-filter\"")
+		}
+
+		direction, err := traffic_direction.ParseFilter(directionFlag)
+		if err != nil {
+			return err
+		}
+		if pairCacheSizeFlag < 0 || pairCacheTTLFlag < 0 {
+			return errors.New("pair cache limits must not be negative")
+		}
+
+		var dedupOpts *dedup.Options
+		if dedupFlag {
+			if dedupWindowFlag <= 0 {
+				return errors.New("\"dedup-window\" must be positive")
+			}
+			if dedupExemplarsFlag < 1 {
+				return errors.New("\"dedup-exemplars\" must be at least 1")
+			}
+			dedupOpts = &dedup.Options{
+				Window:    dedupWindowFlag,
+				Exemplars: dedupExemplarsFlag,
+			}
+		}
+
 		args := apidump.Args{
-			ClientID:           akiflag.GetClientID(),
-			Domain:             akiflag.Domain,
-			Out:                outFlag,
-			Tags:               traceTags,
-			SampleRate:         sampleRateFlag,
-			WitnessesPerMinute: rateLimitFlag,
-			Interfaces:         interfacesFlag,
-			Filter:             filterFlag,
-			PathExclusions:     pathExclusionsFlag,
-			HostExclusions:     hostExclusionsFlag,
-			PathAllowlist:      pathAllowlistFlag,
-			HostAllowlist:      hostAllowlistFlag,
-			ExecCommand:        execCommandFlag,
-			ExecCommandUser:    execCommandUserFlag,
-			Plugins:            plugins,
-		}
-		if err := apidump.Run(args); err != nil {
+			ClientID:             akiflag.GetClientID(),
+			Domain:               akiflag.Domain,
+			Out:                  outFlag,
+			Tags:                 traceTags,
+			SampleRate:           sampleRateFlag,
+			WitnessesPerMinute:   rateLimitFlag,
+			SamplePolicy:         samplePolicyFlag,
+			SlowRequestThreshold: slowRequestFlag,
+			EndpointLimit:        endpointLimit,
+			EndpointReport:       endpointReportFlag,
+			EndpointMetrics:      endpointMetricsFlag,
+			EndpointMetricsFile:  metricsFileFlag,
+			OTLP:                 otlpOpts,
+			TUI:                  tuiFlag,
+			Duration:             durationFlag,
+			MaxRequests:          maxRequestsFlag,
+			MaxBytes:             maxBytes,
+			Interfaces:           interfacesFlag,
+			Filter:               filterFlag,
+			PathExclusions:       pathExclusionsFlag,
+			HostExclusions:       hostExclusionsFlag,
+			PathAllowlist:        pathAllowlistFlag,
+			HostAllowlist:        hostAllowlistFlag,
+			FilterRules:          filterRules,
+			AttributeProcesses:   attributeProcsFlag,
+			InferPathParams:      inferPathParamsFlag,
+			PathParams:           pathParamsFlag,
+			DetectPII:            detectPIIFlag,
+			PIIDetectorConfig:    piiDetectorsFlag,
+			PIIReport:            piiReportFlag,
+			RedactionRules:       redactionRulesFlag,
+			LocalFormat:          formatFlag,
+			HAROptions:           harOpts,
+			PairCache: pair_cache.Options{
+				MaxEntries: pairCacheSizeFlag,
+				TTL:        pairCacheTTLFlag,
+			},
+			Direction:       direction,
+			Dedup:           dedupOpts,
+			ExecCommand:     execCommandFlag,
+			ExecCommandUser: execCommandUserFlag,
+			Phases:          phases,
+			MetricsAddr:     metricsAddrFlag,
+			ControlSocket:   controlSocketFlag,
+			Plugins:         plugins,
+			AutoFilter:      autoFilterFlag,
+			AutoFilterScope: listen_ports.Scope{
+				PID:       autoFilterPIDFlag,
+				Container: autoFilterCtrFlag,
+			},
+		}
+		run := apidump.Run
+		if selfTestFlag {
+			run = apidump.RunSelfTest
+		}
+		if err := run(args); err != nil {
 			return cmderr.AkitaErr{Err: err}
 		}
 		return nil
@@ -135,6 +422,27 @@ func init() {
 		"",
 		"Used to match packets going to and coming from your API service.")
 
+	Cmd.Flags().BoolVar(
+		&autoFilterFlag,
+		"auto-filter",
+		false,
+		"If set, builds --filter from the TCP ports with listening sockets on this host, and extends it as new listeners appear. Linux only.",
+	)
+
+	Cmd.Flags().IntVar(
+		&autoFilterPIDFlag,
+		"auto-filter-pid",
+		0,
+		"Only use ports listened on by this process for --auto-filter.",
+	)
+
+	Cmd.Flags().StringVar(
+		&autoFilterCtrFlag,
+		"auto-filter-container",
+		"",
+		"Only use ports listened on in this container for --auto-filter. Takes a container ID or a prefix of one.",
+	)
+
 	Cmd.Flags().StringSliceVar(
 		&interfacesFlag,
 		"interfaces",
@@ -155,6 +463,139 @@ func init() {
 		"Number of requests per minute to capture. Defaults to unlimited.",
 	)
 
+	Cmd.Flags().BoolVar(
+		&perEndpointLimitFlag,
+		"rate-limit-per-endpoint",
+		false,
+		"If set, divides --rate-limit among endpoints, so that busy endpoints can't use up the whole budget.",
+	)
+
+	Cmd.Flags().IntVar(
+		&endpointMinRateFlag,
+		"endpoint-min-rate",
+		sampling.DefaultEndpointMinPerMinute,
+		"With --rate-limit-per-endpoint, the number of requests per minute guaranteed to each endpoint.",
+	)
+
+	Cmd.Flags().Float64Var(
+		&endpointMaxShareFlag,
+		"endpoint-max-share",
+		sampling.DefaultEndpointMaxShare,
+		"With --rate-limit-per-endpoint, the largest share of --rate-limit that one endpoint may use, between 0 and 1.",
+	)
+
+	Cmd.Flags().StringVar(
+		&endpointReportFlag,
+		"endpoint-report",
+		"",
+		"With --rate-limit-per-endpoint, path to write a report of requests observed and kept per endpoint. Defaults to akita_endpoint_report.json in a local --out directory.",
+	)
+
+	Cmd.Flags().BoolVar(
+		&endpointMetricsFlag,
+		"endpoint-metrics",
+		false,
+		"If set, collects request counts, status codes and latency percentiles for each endpoint, and prints them when the capture ends.",
+	)
+
+	Cmd.Flags().StringVar(
+		&metricsFileFlag,
+		"endpoint-metrics-file",
+		"",
+		"With --endpoint-metrics, path to write the metrics to as JSON. Defaults to akita_endpoint_metrics.json in a local --out directory.",
+	)
+
+	Cmd.Flags().StringVar(
+		&metricsAddrFlag,
+		"metrics-addr",
+		"",
+		"Address on which to serve Prometheus metrics, e.g. :9090. Metrics are served at /metrics. Disabled by default.",
+	)
+
+	Cmd.Flags().BoolVar(
+		&selfTestFlag,
+		"self-test",
+		false,
+		"If set, checks that capture works on this machine by capturing test traffic on the loopback interface with the given filters and sampling, and reports where it was dropped.",
+	)
+
+	Cmd.Flags().StringVar(
+		&controlSocketFlag,
+		"control-socket",
+		"",
+		"Path of a Unix socket on which to accept commands from \"akita apidump ctl\" while capturing. Disabled by default.",
+	)
+
+	Cmd.Flags().DurationVar(
+		&durationFlag,
+		"duration",
+		0,
+		"If set, stops capturing after this long, e.g. 10m.",
+	)
+
+	Cmd.Flags().IntVar(
+		&maxRequestsFlag,
+		"max-requests",
+		0,
+		"If set, stops capturing once this many HTTP requests have passed the filters.",
+	)
+
+	Cmd.Flags().StringVar(
+		&maxBytesFlag,
+		"max-bytes",
+		"",
+		"If set, stops capturing once this much TCP traffic has been captured, e.g. 2GB.",
+	)
+
+	Cmd.Flags().BoolVar(
+		&tuiFlag,
+		"tui",
+		false,
+		"If set, shows a live dashboard of packet counts, endpoints and upload progress until you quit with q.",
+	)
+
+	Cmd.Flags().StringVar(
+		&otlpEndpointFlag,
+		"otlp-endpoint",
+		"",
+		"URL of an OTLP/HTTP receiver, e.g. http://localhost:4318, to which a span is exported for each captured HTTP request.",
+	)
+
+	Cmd.Flags().StringVar(
+		&otlpFileFlag,
+		"otlp-file",
+		"",
+		"Path of a file to which a span is appended as OTLP JSON for each captured HTTP request.",
+	)
+
+	Cmd.Flags().StringArrayVar(
+		&otlpHeadersFlag,
+		"otlp-header",
+		nil,
+		`With --otlp-endpoint, an HTTP header to send with each export request, as "name=value". May be repeated.`,
+	)
+
+	Cmd.Flags().StringVar(
+		&otlpServiceFlag,
+		"otlp-service-name",
+		"",
+		"The service.name reported for exported spans. Defaults to the host each request was sent to.",
+	)
+
+	Cmd.Flags().StringVar(
+		&samplePolicyFlag,
+		"sample-policy",
+		apidump.UniformSampling,
+		"How requests are sampled: uniform, or outcome to always keep errors, slow requests and new endpoints.",
+	)
+
+	Cmd.Flags().DurationVar(
+		&slowRequestFlag,
+		"slow-request-threshold",
+		0,
+		"With --sample-policy outcome, always keeps requests that take at least this long, e.g. 500ms.",
+	)
+
 	Cmd.Flags().StringSliceVar(
 		&tagsFlag,
 		"tags",
@@ -196,6 +637,153 @@ func init() {
 		"Allows only HTTP hosts matching regular expressions.",
 	)
 
+	Cmd.Flags().StringArrayVar(
+		&allowExprFlag,
+		"allow-expr",
+		nil,
+		`Allows only HTTP requests matching a filter expression, e.g. "method = POST and status >= 500". May be repeated; requests matching any are allowed.`,
+	)
+
+	Cmd.Flags().StringArrayVar(
+		&excludeExprFlag,
+		"exclude-expr",
+		nil,
+		`Removes HTTP requests matching a filter expression, e.g. "header[X-Debug] present". May be repeated.`,
+	)
+
+	Cmd.Flags().StringVar(
+		&filterRulesFlag,
+		"filter-rules",
+		"",
+		"Path to a YAML file of allow and exclude filter expressions, applied together with --allow-expr and --exclude-expr.",
+	)
+
+	Cmd.Flags().BoolVar(
+		&attributeProcsFlag,
+		"attribute-processes",
+		false,
+		"If set, tags each captured request with the process, container and Kubernetes pod that handled it. Linux only.",
+	)
+
+	Cmd.Flags().BoolVar(
+		&inferPathParamsFlag,
+		"infer-path-parameters",
+		false,
+		"If set, infers path parameters locally and replaces paths in uploaded witnesses and local HAR files with path templates.",
+	)
+
+	Cmd.Flags().StringSliceVar(
+		&pathParamsFlag,
+		"path-parameters",
+		nil,
+		"List of patterns used to override locally inferred path parameters. Only used with --infer-path-parameters. See akita man apispec for the format.",
+	)
+
+	Cmd.Flags().BoolVar(
+		&detectPIIFlag,
+		"detect-pii",
+		false,
+		"If set, detects sensitive data such as emails, phone numbers and credit card numbers, annotates it in witnesses, and writes a summary report.",
+	)
+
+	Cmd.Flags().StringVar(
+		&piiDetectorsFlag,
+		"pii-detectors",
+		"",
+		"Path to a YAML file defining additional regular-expression PII detectors. Only used with --detect-pii or --redaction-rules.",
+	)
+
+	Cmd.Flags().StringVar(
+		&piiReportFlag,
+		"pii-report",
+		"",
+		"Path to write the PII report to. Defaults to akita_pii_report.json in the --out directory, if local. Only used with --detect-pii.",
+	)
+
+	Cmd.Flags().StringVar(
+		&redactionRulesFlag,
+		"redaction-rules",
+		"",
+		"Path to a YAML file of rules for dropping, hashing, masking or truncating values before they are written to HAR files or sent to Akita Cloud.",
+	)
+
+	Cmd.Flags().StringVar(
+		&formatFlag,
+		"format",
+		apidump.HARFormat,
+		"Format of traces written to a local --out directory: har, or ndjson for one JSON object per request/response pair.",
+	)
+
+	Cmd.Flags().Int64Var(
+		&harRotateSizeFlag,
+		"har-rotate-size",
+		0,
+		"Starts a new local HAR file once the current one reaches this many megabytes. Defaults to no limit.",
+	)
+
+	Cmd.Flags().IntVar(
+		&harRotateEntriesFlag,
+		"har-rotate-entries",
+		0,
+		"Starts a new local HAR file once the current one has this many entries. Defaults to no limit.",
+	)
+
+	Cmd.Flags().DurationVar(
+		&harRotateIntervalFlag,
+		"har-rotate-interval",
+		0,
+		"Starts a new local HAR file after this much time, e.g. 10m. Defaults to no limit.",
+	)
+
+	Cmd.Flags().BoolVar(
+		&harGzipFlag,
+		"har-gzip",
+		false,
+		"If set, gzips local HAR files.",
+	)
+
+	Cmd.Flags().StringVar(
+		&directionFlag,
+		"direction",
+		string(traffic_direction.CaptureBoth),
+		"Which traffic to capture: inbound for requests served by this host, outbound for requests it makes to other services, or both.",
+	)
+
+	Cmd.Flags().IntVar(
+		&pairCacheSizeFlag,
+		"pair-cache-size",
+		pair_cache.DefaultMaxEntries,
+		"Maximum number of requests and responses held while waiting for their other half. When full, the oldest are uploaded unpaired.",
+	)
+
+	Cmd.Flags().DurationVar(
+		&pairCacheTTLFlag,
+		"pair-cache-ttl",
+		pair_cache.DefaultTTL,
+		"How long to wait for the other half of a request or response before uploading it unpaired.",
+	)
+
+	Cmd.Flags().BoolVar(
+		&dedupFlag,
+		"dedup",
+		false,
+		"If set, uploads only a few requests of each shape per window to Akita Cloud, and counts the rest.",
+	)
+
+	Cmd.Flags().DurationVar(
+		&dedupWindowFlag,
+		"dedup-window",
+		dedup.DefaultWindow,
+		"With --dedup, how long each window of a request shape lasts.",
+	)
+
+	Cmd.Flags().IntVar(
+		&dedupExemplarsFlag,
+		"dedup-exemplars",
+		dedup.DefaultExemplars,
+		"With --dedup, the number of requests of each shape uploaded per window.",
+	)
+
 	Cmd.Flags().StringVarP(
 		&execCommandFlag,
 		"command",
@@ -209,7 +797,28 @@ func init() {
 		"user",
 		"u",
 		"",
-		"User to use when running command specified by -c. Defaults to current user.",
+		"User to use when running the command specified by -c or --phase. Defaults to current user.",
+	)
+
+	Cmd.Flags().StringArrayVar(
+		&phaseFlag,
+		"phase",
+		nil,
+		"A named command to generate API traffic, given as NAME=COMMAND. May be repeated; phases run in the order given.",
+	)
+
+	Cmd.Flags().StringSliceVar(
+		&uncapturedPhasesFlag,
+		"uncaptured-phases",
+		nil,
+		"Names of phases whose HTTP traffic is not captured.",
+	)
+
+	Cmd.Flags().StringSliceVar(
+		&alwaysRunPhasesFlag,
+		"always-run-phases",
+		nil,
+		"Names of phases that run even if an earlier phase failed.",
 	)
 
 	Cmd.Flags().StringSliceVar(
@@ -220,3 +829,44 @@ func init() {
 	)
 	Cmd.Flags().MarkHidden("plugins")
 }
+
+// Parses phases given as NAME=COMMAND, marking those named in uncaptured and
+// alwaysRun.
+func parsePhases(specs, uncaptured, alwaysRun []string) ([]apidump.Phase, error) {
+	if len(specs) == 0 {
+		if len(uncaptured) > 0 || len(alwaysRun) > 0 {
+			return nil, errors.New("\"uncaptured-phases\" and \"always-run-phases\" can only be used together with \"phase\"")
+		}
+		return nil, nil
+	}
+
+	phases := make([]apidump.Phase, 0, len(specs))
+	indices := make(map[string]int, len(specs))
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, errors.Errorf("bad phase %q; must be of the form \"name=command\"", spec)
+		}
+		if _, ok := indices[parts[0]]; ok {
+			return nil, errors.Errorf("phase %q is given more than once", parts[0])
+		}
+		indices[parts[0]] = len(phases)
+		phases = append(phases, apidump.Phase{Name: parts[0], Command: parts[1]})
+	}
+
+	for _, name := range uncaptured {
+		i, ok := indices[name]
+		if !ok {
+			return nil, errors.Errorf("bad value for \"uncaptured-phases\": no phase named %q", name)
+		}
+		phases[i].Uncaptured = true
+	}
+	for _, name := range alwaysRun {
+		i, ok := indices[name]
+		if !ok {
+			return nil, errors.Errorf("bad value for \"always-run-phases\": no phase named %q", name)
+		}
+		phases[i].AlwaysRun = true
+	}
+	return phases, nil
+}
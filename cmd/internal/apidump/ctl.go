@@ -0,0 +1,107 @@
+package apidump
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/akitasoftware/akita-cli/cmd/internal/cmderr"
+	"github.com/akitasoftware/akita-cli/control"
+	"github.com/akitasoftware/akita-cli/printer"
+)
+
+var ctlSocketFlag string
+
+var CtlCmd = &cobra.Command{
+	Use:   "ctl COMMAND [ARG]",
+	Short: "Send a command to a running apidump.",
+	Long: `Send a command to an apidump started with --control-socket. Commands are:
+
+  stats             Print live stats about the capture as JSON.
+  flush             Write buffered HAR entries and upload batched witnesses now.
+  rotate            Complete the current HAR files and start new ones.
+  pause             Stop recording traffic until resumed.
+  resume            Resume recording traffic.
+  sample-rate RATE  Change the sample rate to a number between 0.0 and 1.0.
+  stop [EXIT_CODE]  Stop the capture gracefully, exiting with EXIT_CODE if given.`,
+	SilenceUsage: true,
+	Args:         cobra.RangeArgs(1, 2),
+	RunE:         runCtl,
+}
+
+func init() {
+	Cmd.AddCommand(CtlCmd)
+
+	CtlCmd.Flags().StringVar(
+		&ctlSocketFlag,
+		"socket",
+		"",
+		"Path of the control socket given to apidump with --control-socket.",
+	)
+	CtlCmd.MarkFlagRequired("socket")
+}
+
+func runCtl(cmd *cobra.Command, args []string) error {
+	req := control.Request{Command: args[0]}
+	switch req.Command {
+	case control.SampleRateCommand:
+		if len(args) != 2 {
+			return errors.New("sample-rate takes the new rate, e.g. \"sample-rate 0.5\"")
+		}
+		rate, err := strconv.ParseFloat(args[1], 64)
+		if err != nil {
+			return errors.Wrap(err, "bad sample rate")
+		}
+		req.SampleRate = rate
+	case control.StopCommand:
+		if len(args) == 2 {
+			code, err := strconv.Atoi(args[1])
+			if err != nil {
+				return errors.Wrap(err, "bad exit code")
+			}
+			req.ExitCode = code
+		}
+	default:
+		if len(args) != 1 {
+			return errors.Errorf("%s takes no arguments", req.Command)
+		}
+	}
+
+	result, err := control.Send(ctlSocketFlag, req)
+	if err != nil {
+		return cmderr.AkitaErr{Err: err}
+	}
+
+	switch req.Command {
+	case control.StatsCommand:
+		var out bytes.Buffer
+		if err := json.Indent(&out, result, "", "  "); err != nil {
+			return cmderr.AkitaErr{Err: errors.Wrap(err, "bad stats")}
+		}
+		fmt.Println(out.String())
+	case control.FlushCommand:
+		printer.Infof("Flushed HAR files and uploads\n")
+	case control.RotateCommand:
+		var files []string
+		if err := json.Unmarshal(result, &files); err != nil {
+			return cmderr.AkitaErr{Err: errors.Wrap(err, "bad list of files")}
+		}
+		printer.Infof("Completed %d HAR files\n", len(files))
+		for _, f := range files {
+			fmt.Println(f)
+		}
+	case control.PauseCommand:
+		printer.Infof("Capture paused\n")
+	case control.ResumeCommand:
+		printer.Infof("Capture resumed\n")
+	case control.SampleRateCommand:
+		printer.Infof("Sample rate set to %v\n", req.SampleRate)
+	case control.StopCommand:
+		printer.Infof("Capture is stopping\n")
+	}
+	return nil
+}
@@ -78,6 +78,15 @@ var Cmd = &cobra.Command{
 			return errors.New("Must specify at least one input via \"traces\" or \"trace-tag\"")
 		}
 
+		if offlineFlag {
+			if len(traceTags) > 0 {
+				return errors.New("\"trace-tag\" cannot be used with \"offline\"")
+			}
+			if outFlag.LocalPath == nil {
+				return errors.New("\"offline\" requires \"out\" to be a local file")
+			}
+		}
+
 		if len(traceTags) > 0 {
 			var serviceName string
 			if serviceFlag != "" {
@@ -141,6 +150,8 @@ var Cmd = &cobra.Command{
 			IncludeTrackers:            includeTrackersFlag,
 
 			Plugins: plugins,
+
+			Offline: offlineFlag,
 		}
 		if err := apispec.Run(args); err != nil {
 			return cmderr.AkitaErr{Err: err}
@@ -39,6 +39,8 @@ var (
 	pathExclusionsFlag []string
 
 	pluginsFlag []string
+
+	offlineFlag bool
 )
 
 func init() {
@@ -192,6 +194,13 @@ func init() {
 		"SHA of gitlab commit that this spec belongs to.",
 	)
 
+	Cmd.Flags().BoolVar(
+		&offlineFlag,
+		"offline",
+		false,
+		"If set, generates an OpenAPI3 spec from local HAR or NDJSON files without contacting Akita Cloud. Requires --out to be a local file.",
+	)
+
 	Cmd.Flags().StringSliceVar(
 		&pluginsFlag,
 		"plugins",
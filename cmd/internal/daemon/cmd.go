@@ -17,7 +17,8 @@ var (
 	nameFlag string
 
 	// Optional flags
-	portNumberFlag uint16
+	portNumberFlag  uint16
+	metricsAddrFlag string
 
 	pluginsFlag []string
 )
@@ -34,10 +35,11 @@ var Cmd = &cobra.Command{
 		}
 
 		args := daemon.Args{
-			ClientID:   akiflag.GetClientID(),
-			Domain:     akiflag.Domain,
-			DaemonName: nameFlag,
-			PortNumber: portNumberFlag,
+			ClientID:    akiflag.GetClientID(),
+			Domain:      akiflag.Domain,
+			DaemonName:  nameFlag,
+			PortNumber:  portNumberFlag,
+			MetricsAddr: metricsAddrFlag,
 
 			Plugins: plugins,
 		}
@@ -71,4 +73,11 @@ func init() {
 		50_080,
 		"The port number on which to listen for connections.",
 	)
+
+	Cmd.Flags().StringVar(
+		&metricsAddrFlag,
+		"metrics-addr",
+		"",
+		"Address on which to serve Prometheus metrics, e.g. :9090. Metrics are served at /metrics. Disabled by default.",
+	)
 }
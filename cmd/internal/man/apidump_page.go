@@ -34,7 +34,7 @@ The location to store the trace. Can be an AkitaURI or a local directory.
 
 If not specified, defaults to a trace on Akita Cloud. Note that you must supply <bt>--service<bt> in this case.
 
-When specifying a local directory, Akita writes HAR files to the directory.
+When specifying a local directory, Akita writes HAR files to the directory, or NDJSON files if <bt>--format ndjson<bt> is given.
 
 When specifying an AkitaURI, the format is "akita://{SERVICE}:trace" or "akita://{SERVICE}:trace:{NAME}", where "SERVICE" is the name of your service and "NAME" is the name of the trace on Akita Cloud where the collected data is stored. A trace name will be generated if "NAME" is not provided.
 
@@ -54,6 +54,24 @@ The syntax follows BPF syntax (man 7 pcap-filter).
 
 This filter is applied uniformly across all network interfaces, as set by <bt>--interfaces<bt> flag.
 
+## --auto-filter bool
+
+Builds <bt>--filter<bt> for you from the TCP ports with listening sockets, read from <bt>/proc/net/tcp<bt> and <bt>/proc/net/tcp6<bt>. For example, if services are listening on ports 80 and 8080, Akita captures with <bt>--filter "tcp port 80 or tcp port 8080"<bt>:
+
+    akita apidump --auto-filter --service my-service
+
+The proposed filter is printed when capture starts. Akita checks for new listening sockets every 10 seconds, and adds their ports to the filter of the running capture. Ports are never removed, so a service that restarts stays captured. Start your service before apidump, since the capture fails if no listening sockets are found.
+
+Only available on Linux. Can't be used together with <bt>--filter<bt>.
+
+## --auto-filter-pid int
+
+Only uses ports that this process is listening on for <bt>--auto-filter<bt>. Reading another user's sockets requires root.
+
+## --auto-filter-container string
+
+Only uses ports listened on inside this container for <bt>--auto-filter<bt>. Takes a Docker or containerd container ID, or a prefix of at least 12 characters. Traffic forwarded to a container from another port on the host is captured on the container's port, so use <bt>--interfaces<bt> to capture on the container's network interface.
+
 ## --interfaces []string
 
 List of network interfaces to listen on (e.g. "lo" or "eth0").
@@ -66,6 +84,115 @@ If not set, defaults to all interfaces on the host.
 
 A number between [0.0, 1.0] to control sampling.
 
+## --rate-limit-per-endpoint bool
+
+Divides <bt>--rate-limit<bt> among endpoints, so that a busy endpoint, such as a health check, can't use up the budget meant for rarely called ones. Endpoints are distinguished by host, method and path, with path parameters generalized as they are seen.
+
+Each minute, every endpoint seen so far is guaranteed <bt>--endpoint-min-rate<bt> requests, and no endpoint may use more than <bt>--endpoint-max-share<bt> of the limit.
+
+When the capture ends, a report of the requests observed and kept for each endpoint is written to <bt>--endpoint-report<bt>. For example:
+
+    {
+      "endpoints": [
+        {"endpoint": "GET api.example.com/health", "observed": 12000, "kept": 50},
+        {"endpoint": "POST api.example.com/orders/{arg1}/refund", "observed": 3, "kept": 3}
+      ]
+    }
+
+## --endpoint-min-rate int
+
+With <bt>--rate-limit-per-endpoint<bt>, the number of requests per minute guaranteed to each endpoint, as long as <bt>--rate-limit<bt> allows. Defaults to 1.
+
+## --endpoint-max-share number
+
+With <bt>--rate-limit-per-endpoint<bt>, the largest share of <bt>--rate-limit<bt> that a single endpoint may use, between 0 and 1. Defaults to 0.25.
+
+## --endpoint-report string
+
+Path to write the per-endpoint report to. Defaults to <bt>akita_endpoint_report.json<bt> in the <bt>--out<bt> directory, if it is local.
+
+## --endpoint-metrics bool
+
+Collects request counts, status codes and latency percentiles for each endpoint -- each distinct method, host and path template -- and prints the busiest endpoints as a table when the capture ends. Metrics cover all requests that pass the filters, before sampling and rate limiting. Latency runs from the end of the request to the start of the response.
+
+The metrics are also written as JSON to <bt>--endpoint-metrics-file<bt>. For example:
+
+    {
+      "endpoints": [
+        {
+          "endpoint": "GET api.example.com/users/{arg1}",
+          "requests": 1200,
+          "responses": 1198,
+          "status_codes": {"200": 1150, "404": 48},
+          "latency_ms": {"count": 1198, "min": 1.2, "mean": 8.4, "p50": 6.1, "p90": 15.2, "p95": 21, "p99": 48.5, "max": 210.3}
+        }
+      ]
+    }
+
+## --endpoint-metrics-file string
+
+Path to write the endpoint metrics to. Defaults to <bt>akita_endpoint_metrics.json<bt> in the <bt>--out<bt> directory, if it is local.
+
+## --metrics-addr string
+
+Serves Prometheus metrics over HTTP on the given address, e.g. <bt>:9090<bt>, at <bt>/metrics<bt>. This is useful for alerting when a long-running capture, such as one in a DaemonSet, stops seeing traffic. The same flag is accepted by <bt>akita daemon<bt>. Metrics include:
+
+- <bt>akita_tcp_packets_total<bt>, <bt>akita_http_requests_total<bt>, <bt>akita_http_responses_total<bt> and <bt>akita_unparsed_segments_total<bt>, by interface.
+- <bt>akita_assembler_context_errors_total<bt>, by kind of packet assembly problem.
+- <bt>akita_pair_cache_entries<bt> and <bt>akita_pair_cache_events_total<bt>, by cache. The <bt>witnesses<bt> cache holds requests and responses waiting for their pair before upload; the others pair traffic for sampling, metrics, spans and PII detection.
+- <bt>akita_upload_batches_total<bt>, by result, <bt>akita_upload_duration_seconds<bt> and <bt>akita_uploaded_witnesses_total<bt>.
+- The state of the rate limiter or sampling policy in use, such as <bt>akita_rate_limit_interval_active<bt> or <bt>akita_sampling_decisions_total<bt>.
+- For <bt>akita daemon<bt>, <bt>akita_daemon_trace_queue_depth<bt>, <bt>akita_daemon_trace_events_total<bt> and <bt>akita_daemon_trace_events_dropped_total<bt>, by trace.
+
+## --control-socket string
+
+Accepts commands on a Unix socket at the given path while capturing, so that a long-running capture can be inspected and steered without signals. Only the user running apidump may connect to the socket. Commands are sent with <bt>akita apidump ctl<bt>:
+
+    akita apidump --service my-service --out mytracedir --control-socket /tmp/akita.sock
+    akita apidump ctl --socket /tmp/akita.sock stats
+
+The commands are:
+
+- <bt>stats<bt> prints packet counts, endpoints, upload progress and the current sample rate as JSON.
+- <bt>flush<bt> writes buffered HAR entries to disk and uploads batched witnesses right away.
+- <bt>rotate<bt> completes the current HAR files, as <bt>--har-rotate-interval<bt> would, and prints their paths.
+- <bt>pause<bt> and <bt>resume<bt> stop and restart recording. HTTP traffic seen while paused is dropped.
+- <bt>sample-rate RATE<bt> changes the sample rate given by <bt>--sample-rate<bt>, or the rate of requests kept by sampling with <bt>--sample-policy outcome<bt>.
+- <bt>stop [EXIT_CODE]<bt> stops the capture as SIGINT would. If a non-zero exit code is given, apidump exits with it, as it does when a <bt>--command<bt> fails. Not available together with <bt>--command<bt> or <bt>--phase<bt>.
+
+## --otlp-endpoint string
+
+Exports an OpenTelemetry span for each HTTP request and its response to an OTLP/HTTP receiver, such as an OpenTelemetry collector, so that services without instrumentation show up in your tracing backend. Spans are posted as JSON to <bt>/v1/traces<bt> unless the URL has a path:
+
+    akita apidump --service my-service --otlp-endpoint http://localhost:4318
+
+Each span runs from the first packet of the request to the last packet of the response, and has the HTTP semantic-convention attributes, such as <bt>http.method<bt>, <bt>http.route<bt> and <bt>http.status_code<bt>. Path parameters in <bt>http.route<bt> and the span name are inferred. Query strings, headers other than <bt>User-Agent<bt>, and bodies aren't exported. Responses with a 5xx status, or a 4xx status for requests made by the host, mark the span as an error. Requests that carry a W3C <bt>traceparent<bt> header are added to the caller's trace.
+
+Spans are exported for all requests that pass the filters, before sampling and rate limiting.
+
+## --otlp-file string
+
+Appends the spans to the given file in the OTLP JSON file format, one export request per line, instead of or in addition to <bt>--otlp-endpoint<bt>.
+
+## --otlp-header string
+
+With <bt>--otlp-endpoint<bt>, an HTTP header to send with each export request, e.g. <bt>--otlp-header "Authorization=Bearer $TOKEN"<bt>. May be repeated.
+
+## --otlp-service-name string
+
+The <bt>service.name<bt> reported for exported spans. By default, each span belongs to the service named by the <bt>Host<bt> header of its request.
+
+## --sample-policy string
+
+How requests are chosen when sampling or rate limiting:
+
+- <bt>uniform<bt> (the default) samples requests at random, before their responses are seen.
+- <bt>outcome<bt> waits for each response before deciding. Requests that got a 5xx response, took much longer than usual for their endpoint, or are the first seen for their endpoint are always kept; the rest are sampled with <bt>--sample-rate<bt>. A request and its response are always kept or dropped together. With <bt>--rate-limit<bt>, the limit applies to all requests, but part of it is reserved for requests that are always kept.
+
+## --slow-request-threshold duration
+
+With <bt>--sample-policy outcome<bt>, always keeps requests that take at least this long, e.g. <bt>500ms<bt>.
+
 ## --tags []string
 
 Adds tags to the dump.
@@ -78,9 +205,78 @@ A command that generates requests and responses for Akita to observe. Akita will
 
 By default, the command runs as the current user. As a safety precaution, if the current user is <bt>root<bt>, you must use the <bt>-u<bt> flag to explicitly indicate that you want to run as <bt>root<bt>.
 
+The command starts once capture is running on every interface, or after 5 seconds at most, and capture stops once the command's last packets have been processed. To tell, apidump sends a marker UDP packet from 192.0.2.1 to 192.0.2.2, port 9, on each interface. These addresses are reserved for documentation, so the packets go nowhere.
+
 ## --user, -u string
 
-Username of the user to use when running the command specified in <bt>-c<bt>
+Username of the user to use when running the command specified in <bt>-c<bt> or <bt>--phase<bt>
+
+## --phase string
+
+A named command that generates requests and responses, given as <bt>NAME=COMMAND<bt>. Repeat the flag to run several phases in the order given, e.g. the setup, test and teardown steps of an integration test suite:
+
+    akita apidump --out mytracedir --phase setup=./setup.sh --phase test=./run_tests.sh --phase teardown=./teardown.sh --uncaptured-phases setup --always-run-phases teardown
+
+Each command runs like one given with <bt>--command<bt>, and capture stops once the last phase finishes. Requests and responses are tagged with <bt>x-akita-phase<bt>, the name of the phase they were observed in. At the end, apidump reports each phase's status, exit code, duration and the number of requests observed while it ran.
+
+If a phase fails, later phases are skipped unless they are named in <bt>--always-run-phases<bt>, and apidump exits with the exit code of the first phase that failed. Can't be used together with <bt>--command<bt>.
+
+## --uncaptured-phases []string
+
+Names of phases whose HTTP traffic is dropped, e.g. to leave setup traffic out of the trace. Traffic is matched to phases by when it was observed, not by when it was processed.
+
+## --always-run-phases []string
+
+Names of phases that run even if an earlier phase failed, e.g. to tear down what the earlier phases set up.
+
+## --duration duration
+
+Stops capturing after the given time, e.g. <bt>10m<bt>. Together with <bt>--max-requests<bt> and <bt>--max-bytes<bt>, this bounds captures run from CI or cron jobs, without a <bt>--command<bt> to stop them:
+
+    akita apidump --service my-service --duration 10m --max-requests 5000 --max-bytes 2GB
+
+Capture stops at whichever limit is reached first, in the same way as on SIGINT: the last packets are processed and everything captured is written or uploaded. The limit that stopped the capture is reported. Can't be used together with <bt>--command<bt> or <bt>--phase<bt>.
+
+## --max-requests int
+
+Stops capturing once this many HTTP requests have passed the filters. Limits are checked once a second, so a few more requests may be captured.
+
+## --max-bytes string
+
+Stops capturing once this much TCP traffic, including headers, has been captured on the filtered interfaces, e.g. <bt>500MB<bt> or <bt>2GB<bt>. Sizes may be given in B, KB, MB or GB, which are powers of 1024.
+
+## --tui bool
+
+Shows a live dashboard in the terminal while capturing, so that you can check that your filters work without waiting for the capture to end. The dashboard shows:
+
+- Packet counts for each interface, after filtering.
+- The endpoints seen so far, with request counts, status codes and latencies.
+- The number of partial witnesses waiting in the pair cache.
+- How many witnesses have been uploaded to Akita Cloud.
+- Warnings and other messages, which are also printed when the dashboard closes.
+
+Keyboard shortcuts:
+
+- <bt>q<bt> stops the capture, like SIGINT (Ctrl-C).
+- <bt>p<bt> pauses uploads to Akita Cloud, or resumes them. While paused, witnesses are saved locally, and they are uploaded once uploads resume or the capture stops.
+- <bt>d<bt> writes the current stats as JSON to <bt>akita_stats_<time>.json<bt>, in a local <bt>--out<bt> directory or the current directory.
+
+Can't be used together with <bt>--command<bt> or <bt>--phase<bt>.
+
+## --self-test bool
+
+Checks that capture works on this machine, to tell apart the usual reasons for "No HTTP calls captured!". Akita starts an HTTP server on the loopback interface, sends it a few requests, and captures them with the given <bt>--filter<bt>, path and host filters, filter expressions, <bt>--direction<bt> and sampling flags. The traffic is written to a temporary directory. A result is printed for each stage of capture:
+
+    akita apidump --self-test --filter "tcp"
+
+- Packet capture permissions: whether packets can be captured at all. If not, try running with sudo.
+- BPF filter: whether <bt>--filter<bt> matched the test traffic. Traffic not matching the filter is captured separately, to tell a filter that excludes the traffic apart from a capture that sees nothing. A filter on your service's port will exclude the test traffic, so run the self-test without it to check the other stages.
+- TCP reassembly and HTTP parsing: whether the captured packets were parsed as HTTP.
+- Filters: whether the requests passed the direction, path, host and expression filters.
+- Sampling: how many requests were kept by <bt>--sample-rate<bt>, <bt>--rate-limit<bt> and <bt>--sample-policy<bt>.
+- Output: whether the requests were written to HAR files.
+
+Stages after the first failure are skipped. <bt>--interfaces<bt> is ignored, and <bt>--out<bt>, <bt>--service<bt>, <bt>--command<bt>, <bt>--phase<bt> and <bt>--tui<bt> can't be used. If every stage passes but apidump captures nothing on your service, its traffic is probably on another interface, or encrypted with TLS.
 
 ## --path-exclusions []string
 
@@ -88,9 +284,166 @@ Removes HTTP paths matching regular expressions.
 
 For example, to filter out requests fetching files with png or jpg extensions, you can specify <bt>--path-exclusions ".*\.png" --path-exclusions ".*\.jpg"<bt>
 
+## --infer-path-parameters
+
+Infers path parameters locally and replaces concrete paths with path templates. For example, <bt>/users/8f3a5c2e-1b7d-4c3e-9a2f-6d1e0b9c4a7f/orders/12<bt> becomes <bt>/users/{arg1}/orders/{arg2}<bt>.
+
+Witnesses sent to Akita Cloud are generalized as they are uploaded, using the traffic seen so far, and carry the values of path parameters as obfuscated arguments. HAR files written to a local <bt>--out<bt> directory are rewritten once capture stops, using all of the traffic captured. Not supported with <bt>--format ndjson<bt>.
+
+Segments are treated as path parameters if they look like IDs (UUIDs, integers, hashes, or dates), or if many distinct values are seen in the same position. Paths matching <bt>--path-exclusions<bt> are left unchanged.
+
+## --path-parameters []path-prefix
+
+Overrides locally inferred path parameters. Only used together with <bt>--infer-path-parameters<bt>. The format is the same as <bt>--path-parameters<bt> for <bt>akita apispec<bt>; see <bt>akita man apispec<bt> for details. The same patterns are used to name endpoints in endpoint metrics, the PII and endpoint reports, deduplication, sampling, and exported OTLP spans.
+
+## --detect-pii bool
+
+Detects sensitive data in captured traffic. Matching values are marked as sensitive in the witnesses sent to Akita Cloud, and a summary of the endpoints and fields in which they were found is written to a local JSON report.
+
+The built-in detectors recognize email addresses, phone numbers, US social security numbers, IBANs, IP addresses, AWS access key IDs, and credit card numbers. Phone numbers must have a country code, such as <bt>+1<bt>, or separators or parentheses, so that bare 10-digit IDs and timestamps aren't mistaken for them.
+
+## --pii-detectors string
+
+Path to a YAML file with additional detectors, each of which matches values against a regular expression. Only used together with <bt>--detect-pii<bt> or <bt>--redaction-rules<bt>. For example:
+
+    detectors:
+      - name: employee_id
+        pattern: '^E\d{6}$'
+
+## --pii-report string
+
+Path to write the PII report to. Defaults to <bt>akita_pii_report.json<bt> in the <bt>--out<bt> directory, if it is local. Only used together with <bt>--detect-pii<bt>.
+
+## --redaction-rules string
+
+Path to a YAML file of rules for redacting values before traffic is written to local HAR files or sent to Akita Cloud. Each rule selects values by location (<bt>header<bt>, <bt>cookie<bt>, <bt>query<bt>, <bt>body<bt>, <bt>form<bt>, or <bt>any<bt>) and field name, by PII detector, or both, and applies one of these actions:
+
+- <bt>drop<bt> removes the field.
+- <bt>hash<bt> replaces the value with a keyed HMAC-SHA256 of it. The key is set with <bt>hash_key<bt>, or the AKITA_REDACTION_KEY environment variable.
+- <bt>mask<bt> replaces all but the last <bt>keep<bt> characters with <bt>*<bt>.
+- <bt>truncate<bt> keeps only the first <bt>keep<bt> characters.
+
+For JSON bodies, the name is a path such as <bt>user.cards[].number<bt>, where <bt>[]<bt> selects all elements of an array and <bt>*<bt> matches any key. The first matching rule applies. For example:
+
+    rules:
+      - location: header
+        name: Authorization
+        action: drop
+      - location: body
+        name: user.cards[].number
+        action: mask
+        keep: 4
+      - detector: email
+        action: hash
+
+## --format string
+
+Format of the traces written to a local <bt>--out<bt> directory. One of:
+
+- <bt>har<bt> (the default) writes a HAR file for each interface.
+- <bt>ndjson<bt> writes a file named <bt>akita_<interface>.ndjson<bt> for each interface, with one JSON object per line for each request/response pair. Each object has the witness ID, interface, tags, connection (protocol, source and destination address and port), start time, request, response, and timings. Requests and responses use the same representation as in HAR files. These files are convenient for stream processing with tools such as <bt>jq<bt>, and can be given to <bt>akita apispec<bt> and <bt>akita upload<bt> in place of HAR files.
+
+## --har-rotate-size int
+
+Starts a new HAR file once the current one reaches this many megabytes, before compression. Only used with a local <bt>--out<bt> directory.
+
+HAR files are written as requests and responses are paired, rather than when capture stops. The first file for each interface is named <bt>akita_<interface>.har<bt>, and later ones <bt>akita_<interface>.1.har<bt>, <bt>akita_<interface>.2.har<bt>, and so on. A file ending in <bt>.partial<bt> is still being written; every other file is a complete HAR file. Requests without a response after one minute are written without one.
+
+## --har-rotate-entries int
+
+Starts a new HAR file once the current one has this many entries. Only used with a local <bt>--out<bt> directory.
+
+## --har-rotate-interval duration
+
+Starts a new HAR file once the current one has been open this long, for example <bt>10m<bt>. Only used with a local <bt>--out<bt> directory.
+
+## --har-gzip bool
+
+Compresses HAR files with gzip and adds a <bt>.gz<bt> suffix to their names. <bt>akita apispec --offline<bt> and <bt>akita upload<bt> read compressed HAR files directly.
+
+## --direction string
+
+Selects traffic by its direction relative to this host: <bt>inbound<bt> for requests served by the host, <bt>outbound<bt> for requests the host makes to other services, or <bt>both<bt> (the default).
+
+Direction is inferred from the addresses of each interface. Requests between two services on the same host are treated as inbound. If neither address belongs to the host, as behind NAT, direction is inferred from the ports the host has been seen accepting connections on. Traffic whose direction can't be determined is treated as inbound. Witnesses of outbound traffic sent to Akita Cloud are tagged with <bt>x-akita-direction: outbound<bt>.
+
+## --pair-cache-size int
+
+Requests and responses sent to Akita Cloud are held in memory until their other half arrives. This limits how many are held at once; when the limit is reached, the oldest are uploaded without their pair. Defaults to 10000.
+
+## --pair-cache-ttl duration
+
+How long to wait for the other half of a request or response before uploading it without its pair, e.g. <bt>30s<bt>. Defaults to 1m.
+
+Witnesses uploaded without their pair are tagged <bt>x-akita-unpaired<bt>.
+
+## --dedup bool
+
+Suppresses repeated requests before they are sent to Akita Cloud. Two requests have the same shape if they are to the same endpoint and their requests and responses have the same fields, with values of the same types and formats; paths are generalized, so <bt>/users/1<bt> and <bt>/users/2<bt> have the same shape.
+
+For each shape, the first <bt>--dedup-exemplars<bt> requests are uploaded as usual, so that latency statistics remain meaningful. The rest are counted, and when <bt>--dedup-window<bt> ends or capture stops, one of them is uploaded in their place, tagged <bt>x-akita-duplicates<bt> with their number. Local traces are not affected.
+
+## --dedup-window duration
+
+With <bt>--dedup<bt>, how long each window lasts. A shape's first window starts when it is first seen; once a window ends, the next requests of that shape are uploaded again as exemplars. Shapes not seen for a whole window are forgotten. Defaults to 10m.
+
+## --dedup-exemplars int
+
+With <bt>--dedup<bt>, the number of requests of each shape uploaded per window. Defaults to 5.
+
 ## --host-exclusions []string
 
 Removes HTTP hosts matching regular expressions.
 
 For example, to filter out requests to all subdomains of <bt>example.com<bt>, you can specify <bt>--host-exclusions ".*example.com"<bt>
+
+## --allow-expr []string
+
+Allows only HTTP requests matching a filter expression, along with their responses. May be repeated, in which case requests matching any of the expressions are allowed. For example:
+
+    --allow-expr 'method = POST and status >= 500'
+    --allow-expr 'client_ip in 10.0.0.0/8,192.168.0.0/16'
+    --allow-expr 'response_content_type ~ json and response_size > 1MB'
+
+An expression compares fields with values, and combines comparisons with <bt>and<bt>, <bt>or<bt>, <bt>not<bt> and parentheses. The fields are:
+
+- <bt>method<bt>, <bt>host<bt> and <bt>path<bt> of the request.
+- <bt>status<bt> of the response.
+- <bt>client_ip<bt>, <bt>server_ip<bt> and <bt>server_port<bt>.
+- <bt>request_size<bt> and <bt>response_size<bt>, the sizes of the bodies. Sizes may be given in B, KB, MB or GB.
+- <bt>request_content_type<bt> and <bt>response_content_type<bt>, without parameters such as the charset.
+- <bt>request_header[Name]<bt> and <bt>response_header[Name]<bt>. <bt>header[Name]<bt> is short for <bt>request_header[Name]<bt>.
+- <bt>pid<bt>, <bt>process<bt>, <bt>container<bt>, <bt>pod<bt>, <bt>pod_namespace<bt> and <bt>pod_label[name]<bt>, describing the process that handled the request. These can only be used together with <bt>--attribute-processes<bt>.
+
+The operators are <bt>=<bt>, <bt>!=<bt>, <bt><<bt>, <bt><=<bt>, <bt>><bt> and <bt>>=<bt>; <bt>~<bt> and <bt>!~<bt>, which match strings against a regular expression; <bt>in<bt>, which takes a comma-separated list of values, or of CIDR blocks for IP addresses; and <bt>present<bt>, which takes no value and checks that the field is known, e.g. <bt>header[X-Debug] present<bt>. Values containing spaces or operators must be quoted with double quotes. A comparison involving a field that isn't known, such as a missing header, is false.
+
+If an expression refers to the response, each request is held until its response arrives, for up to a minute, after which it is matched on its own. At most 10000 requests are held at once; when the limit is reached, the oldest are matched on their own. Responses to requests matched on their own follow them if they arrive within a minute.
+
+## --exclude-expr []string
+
+Removes HTTP requests matching a filter expression, along with their responses. May be repeated. See <bt>--allow-expr<bt> for the syntax.
+
+## --filter-rules string
+
+Path to a YAML file of filter expressions, applied together with <bt>--allow-expr<bt> and <bt>--exclude-expr<bt>. For example:
+
+    allow:
+      - host = api.example.com
+    exclude:
+      - path ~ ^/health
+      - method = OPTIONS
+
+Requests are kept if they match any allow expression, or there are none, and match no exclude expression.
+
+## --attribute-processes bool
+
+Finds the process on this host that handled each request, by matching the local end of its connection against the sockets each process has open in <bt>/proc<bt>. Witnesses, HAR entries and NDJSON exchanges are tagged with:
+
+- <bt>x-akita-pid<bt> and <bt>x-akita-process<bt>, the process ID and command.
+- <bt>x-akita-container-id<bt>, if the process runs in a Docker, containerd or CRI-O container.
+- <bt>x-akita-pod-uid<bt>, if the process runs in a Kubernetes pod. The pod's name and namespace are added as <bt>x-akita-pod-name<bt> and <bt>x-akita-pod-namespace<bt> when the kubelet's log directories are visible in <bt>/var/log/pods<bt>, and its labels as <bt>x-akita-pod-label-<name><bt> when it runs under Docker.
+
+In HAR files, the tags of each entry are in its <bt>_akita_tags<bt> field. The same fields can be used in filter expressions, e.g. <bt>--allow-expr 'pod_label[app] = checkout'<bt>.
+
+Sockets are checked once before capture starts, then again in the background when a request can't be attributed, at most every 5 seconds, so that capture never waits for them. Requests on connections opened since the last check aren't attributed unless the process was already listening. Reading the sockets of other users' processes requires root. Only available on Linux.
 `
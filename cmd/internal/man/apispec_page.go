@@ -17,13 +17,17 @@ Generates a spec from a local trace file and outputs it to stdout.
 
 Generates a spec from a combination of local trace file and trace file on Akita cloud.
 
+## akita apispec --offline --traces ./har --out spec.yaml
+
+Generates a spec from all the HAR and NDJSON files in the <bt>./har<bt> directory without contacting Akita Cloud, and writes it to <bt>spec.yaml<bt>.
+
 # Required Flags
 
 ## --traces []location
 
 The locations to read traces from. Can be a mix of AkitaURI and local file paths.
 
-When specifying a local file, Akita reads the HAR file and uploads it to the Akita cloud.
+When specifying a local file, Akita reads the HAR or NDJSON file and uploads it to the Akita cloud.
 
 When specifying an AkitaURI, the format is "akita://{SERVICE}:trace:{NAME}", where "SERVICE" is the name of your service and "NAME" is the name of the trace on Akita Cloud.
 
@@ -128,6 +132,14 @@ Removes HTTP paths matching regular expressions.
 
 For example, to filter out requests fetching files with png or jpg extensions, you can specify <bt>--path-exclusions ".*\.png" --path-exclusions ".*\.jpg"<bt>
 
+## --offline bool
+
+If true, generates the spec locally, without contacting Akita Cloud. Witnesses are merged, and path parameters and data formats are inferred, on this machine.
+
+Only local traces are supported. A directory given to <bt>--traces<bt> is searched for HAR files (ending in <bt>.har<bt> or <bt>.har.gz<bt>) and NDJSON files written by <bt>akita apidump --format ndjson<bt> (ending in <bt>.ndjson<bt> or <bt>.ndjson.gz<bt>). <bt>--out<bt> must be a local file, and <bt>--service<bt> is not needed.
+
+<bt>--path-parameters<bt> and <bt>--path-exclusions<bt> are honored. Flags that only apply to specs on Akita Cloud, such as <bt>--tags<bt>, <bt>--versions<bt>, and the GitHub and GitLab integration flags, are ignored.
+
 ## --infer-field-relations bool
 
 If true, enables analysis to determine related fields in your API.
@@ -7,7 +7,7 @@ var uploadPage = `
 
 # Description
 
-Uploads an OpenAPI 3 spec or a HAR file to Akita Cloud. When the upload completes, the command prints the uploaded object's Akita URI to stdout.
+Uploads an OpenAPI 3 spec or a trace to Akita Cloud. Traces may be HAR files or NDJSON files written by <bt>akita apidump --format ndjson<bt>. When the upload completes, the command prints the uploaded object's Akita URI to stdout.
 
 # Examples
 
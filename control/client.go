@@ -0,0 +1,38 @@
+package control
+
+import (
+	"encoding/json"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Time allowed for a command to complete. Flushing may involve uploading a
+// batch of witnesses.
+const requestTimeout = time.Minute
+
+// Sends a request to the capture listening on the socket at the given path,
+// and returns the command's result, which is empty for commands that have
+// none.
+func Send(path string, req Request) (json.RawMessage, error) {
+	conn, err := net.DialTimeout("unix", path, ioTimeout)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to control socket %s", path)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, errors.Wrap(err, "failed to send request")
+	}
+
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, errors.Wrap(err, "failed to read response")
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return resp.Result, nil
+}
@@ -0,0 +1,94 @@
+// Package control lets a running capture be inspected and steered over a
+// local Unix socket. Each connection carries one JSON request and one JSON
+// response.
+package control
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// Commands understood by the server.
+const (
+	// Returns live stats about the capture.
+	StatsCommand = "stats"
+
+	// Writes buffered HAR entries to disk and uploads batched witnesses now.
+	FlushCommand = "flush"
+
+	// Completes the current HAR files and starts new ones. Returns the paths
+	// of the completed files.
+	RotateCommand = "rotate"
+
+	// Pauses and resumes capture. Traffic seen while paused is dropped.
+	PauseCommand  = "pause"
+	ResumeCommand = "resume"
+
+	// Changes the sample rate to Request.SampleRate.
+	SampleRateCommand = "sample-rate"
+
+	// Stops the capture gracefully. If Request.ExitCode is non-zero, the
+	// capture exits with that code.
+	StopCommand = "stop"
+)
+
+type Request struct {
+	Command string `json:"command"`
+
+	// Argument to SampleRateCommand, between 0.0 and 1.0.
+	SampleRate float64 `json:"sample_rate,omitempty"`
+
+	// Argument to StopCommand.
+	ExitCode int `json:"exit_code,omitempty"`
+}
+
+type Response struct {
+	// Set if the command failed.
+	Error string `json:"error,omitempty"`
+
+	// The command's result, if it has one.
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// Carries out commands for the server. Methods may be called concurrently.
+type Handler interface {
+	// Returns a snapshot of the capture that can be marshaled to JSON.
+	Stats() (interface{}, error)
+
+	Flush() error
+
+	// Returns the paths of the files completed.
+	Rotate() ([]string, error)
+
+	Pause() error
+	Resume() error
+	SetSampleRate(rate float64) error
+	Stop(exitCode int) error
+}
+
+func handle(h Handler, req Request) (interface{}, error) {
+	switch req.Command {
+	case StatsCommand:
+		return h.Stats()
+	case FlushCommand:
+		return nil, h.Flush()
+	case RotateCommand:
+		return h.Rotate()
+	case PauseCommand:
+		return nil, h.Pause()
+	case ResumeCommand:
+		return nil, h.Resume()
+	case SampleRateCommand:
+		if req.SampleRate < 0 || req.SampleRate > 1 {
+			return nil, errors.Errorf("sample rate must be between 0.0 and 1.0, got %v", req.SampleRate)
+		}
+		return nil, h.SetSampleRate(req.SampleRate)
+	case StopCommand:
+		if req.ExitCode < 0 || req.ExitCode > 255 {
+			return nil, errors.Errorf("exit code must be between 0 and 255, got %d", req.ExitCode)
+		}
+		return nil, h.Stop(req.ExitCode)
+	}
+	return nil, errors.Errorf("unknown command %q", req.Command)
+}
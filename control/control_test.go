@@ -0,0 +1,141 @@
+package control
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeHandler struct {
+	mutex      sync.Mutex
+	calls      []string
+	sampleRate float64
+	exitCode   int
+}
+
+func (h *fakeHandler) record(call string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.calls = append(h.calls, call)
+}
+
+func (h *fakeHandler) Stats() (interface{}, error) {
+	h.record("stats")
+	return map[string]int{"requests": 3}, nil
+}
+
+func (h *fakeHandler) Flush() error {
+	h.record("flush")
+	return nil
+}
+
+func (h *fakeHandler) Rotate() ([]string, error) {
+	h.record("rotate")
+	return nil, errors.New("no HAR files are being written")
+}
+
+func (h *fakeHandler) Pause() error {
+	h.record("pause")
+	return nil
+}
+
+func (h *fakeHandler) Resume() error {
+	h.record("resume")
+	return nil
+}
+
+func (h *fakeHandler) SetSampleRate(rate float64) error {
+	h.record("sample-rate")
+	h.sampleRate = rate
+	return nil
+}
+
+func (h *fakeHandler) Stop(exitCode int) error {
+	h.record("stop")
+	h.exitCode = exitCode
+	return nil
+}
+
+func TestServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apidump.sock")
+	h := &fakeHandler{}
+	s, err := Listen(path, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(path)
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	}
+
+	result, err := Send(path, Request{Command: StatsCommand})
+	if assert.NoError(t, err) {
+		var stats map[string]int
+		assert.NoError(t, json.Unmarshal(result, &stats))
+		assert.Equal(t, 3, stats["requests"])
+	}
+
+	for _, cmd := range []string{FlushCommand, PauseCommand, ResumeCommand} {
+		result, err := Send(path, Request{Command: cmd})
+		assert.NoError(t, err)
+		assert.Empty(t, result)
+	}
+
+	_, err = Send(path, Request{Command: RotateCommand})
+	assert.EqualError(t, err, "no HAR files are being written")
+
+	_, err = Send(path, Request{Command: SampleRateCommand, SampleRate: 1.5})
+	assert.Error(t, err)
+	_, err = Send(path, Request{Command: SampleRateCommand, SampleRate: 0.25})
+	assert.NoError(t, err)
+	assert.Equal(t, 0.25, h.sampleRate)
+
+	_, err = Send(path, Request{Command: "reboot"})
+	assert.EqualError(t, err, `unknown command "reboot"`)
+
+	_, err = Send(path, Request{Command: StopCommand, ExitCode: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, h.exitCode)
+
+	assert.Equal(t, []string{"stats", "flush", "pause", "resume", "rotate", "sample-rate", "stop"}, h.calls)
+
+	// A second capture can't take over the socket while the first is running,
+	// but can once it has stopped.
+	_, err = Listen(path, h)
+	assert.Error(t, err)
+	assert.NoError(t, s.Close())
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = Send(path, Request{Command: StatsCommand})
+	assert.Error(t, err)
+}
+
+// Sockets left behind by captures that didn't exit cleanly are replaced.
+func TestStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apidump.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+
+	s, err := Listen(path, &fakeHandler{})
+	if assert.NoError(t, err) {
+		assert.NoError(t, s.Close())
+	}
+
+	notSocket := filepath.Join(t.TempDir(), "file")
+	assert.NoError(t, ioutil.WriteFile(notSocket, nil, 0644))
+	_, err = Listen(notSocket, &fakeHandler{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,131 @@
+package control
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-cli/printer"
+)
+
+// Time allowed for a client to send its request, and for the server to send
+// its response once the command is done.
+const ioTimeout = 10 * time.Second
+
+// Serves commands on a Unix socket.
+type Server struct {
+	path     string
+	listener net.Listener
+	handler  Handler
+	wg       sync.WaitGroup
+}
+
+// Listens for commands on a Unix socket at the given path, which only the
+// current user may connect to. A socket left behind by an earlier capture is
+// replaced, but one that another capture is still listening on is not.
+func Listen(path string, h Handler) (*Server, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to listen on control socket %s", path)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, errors.Wrapf(err, "failed to restrict access to control socket %s", path)
+	}
+
+	s := &Server{
+		path:     path,
+		listener: listener,
+		handler:  h,
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.Wrapf(err, "failed to check control socket %s", path)
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return errors.Errorf("%s already exists and is not a socket", path)
+	}
+
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return errors.Errorf("another capture is already listening on control socket %s", path)
+	}
+	if err := os.Remove(path); err != nil {
+		return errors.Wrapf(err, "failed to remove stale control socket %s", path)
+	}
+	return nil
+}
+
+func (s *Server) Path() string {
+	return s.path
+}
+
+// Stops accepting commands, waits for those in progress to finish, and
+// removes the socket.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.serveConn(conn)
+		}()
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	var req Request
+	conn.SetReadDeadline(time.Now().Add(ioTimeout))
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		printer.Debugf("Bad request on control socket: %v\n", err)
+		writeResponse(conn, nil, errors.Wrap(err, "bad request"))
+		return
+	}
+
+	printer.Debugf("Received %q on control socket\n", req.Command)
+	result, err := handle(s.handler, req)
+	writeResponse(conn, result, err)
+}
+
+func writeResponse(conn net.Conn, result interface{}, err error) {
+	var resp Response
+	if err != nil {
+		resp.Error = err.Error()
+	} else if result != nil {
+		if resp.Result, err = json.Marshal(result); err != nil {
+			resp.Error = errors.Wrap(err, "failed to encode result").Error()
+		}
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(ioTimeout))
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		printer.Debugf("Failed to send response on control socket: %v\n", err)
+	}
+}
@@ -145,6 +145,7 @@ func (client *cloudClient) startTraceEventCollector(serviceID akid.ServiceID, lo
 	// Start a collector goroutine.
 	traceEventChannel := make(chan *TraceEvent, TRACE_BUFFER_SIZE)
 	go collectTraces(traceEventChannel, serviceInfo.learnClient, serviceID, loggingOptions, client.plugins)
+	trackTraceQueue(loggingOptions.TraceID, traceEventChannel)
 
 	// Register the newly discovered trace.
 	serviceInfo.traces[loggingOptions.TraceID] = newTraceInfo(loggingOptions, traceEventChannel)
@@ -220,4 +221,5 @@ func (client *cloudClient) unregisterTrace(serviceID akid.ServiceID, traceID aki
 	// Flush the trace event channel and unregister the trace.
 	defer close(traceInfo.traceEventChannel)
 	delete(serviceInfo.traces, traceID)
+	untrackTrace(traceID)
 }
@@ -0,0 +1,60 @@
+package cloud_client
+
+import (
+	"sync"
+
+	"github.com/akitasoftware/akita-cli/prom_metrics"
+	"github.com/akitasoftware/akita-libs/akid"
+)
+
+var (
+	traceEventsReceived = prom_metrics.Default.NewCounter(
+		"akita_daemon_trace_events_total",
+		"Trace events received from clients, by trace.",
+		"trace",
+	)
+
+	traceEventsDropped = prom_metrics.Default.NewCounter(
+		"akita_daemon_trace_events_dropped_total",
+		"Trace events dropped because the trace's queue was full, by trace.",
+		"trace",
+	)
+)
+
+// Event queues of registered traces, so that their depth can be reported
+// outside the main goroutine.
+var traceQueues = struct {
+	sync.Mutex
+	byID map[akid.LearnSessionID]chan *TraceEvent
+}{byID: map[akid.LearnSessionID]chan *TraceEvent{}}
+
+func init() {
+	prom_metrics.Default.NewGaugeFunc(
+		"akita_daemon_trace_queue_depth",
+		"Trace events waiting to be processed, by trace. Events are dropped once a queue holds TRACE_BUFFER_SIZE events.",
+		[]string{"trace"},
+		func(emit func(float64, ...string)) {
+			traceQueues.Lock()
+			defer traceQueues.Unlock()
+			for traceID, queue := range traceQueues.byID {
+				emit(float64(len(queue)), akid.String(traceID))
+			}
+		},
+	)
+}
+
+func trackTraceQueue(traceID akid.LearnSessionID, queue chan *TraceEvent) {
+	traceQueues.Lock()
+	defer traceQueues.Unlock()
+	traceQueues.byID[traceID] = queue
+}
+
+// Stops reporting metrics for the trace.
+func untrackTrace(traceID akid.LearnSessionID) {
+	traceQueues.Lock()
+	delete(traceQueues.byID, traceID)
+	traceQueues.Unlock()
+
+	traceEventsReceived.Delete(akid.String(traceID))
+	traceEventsDropped.Delete(akid.String(traceID))
+}
@@ -127,6 +127,11 @@ func uploadTraceEvents(client *cloudClient, req traceEventRequest, traceEventCha
 		}
 	}
 
+	traceEventsReceived.With(akid.String(req.traceID)).Add(float64(len(req.traceEvents)))
+	if numTraceEventsDropped > 0 {
+		traceEventsDropped.With(akid.String(req.traceID)).Add(float64(numTraceEventsDropped))
+	}
+
 	// Log any errors that we encountered while processing the trace events.
 	eventDetails := TraceEventDetails{
 		Drops: numTraceEventsDropped,
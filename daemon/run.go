@@ -12,6 +12,7 @@ import (
 	"github.com/akitasoftware/akita-cli/har_loader"
 	"github.com/akitasoftware/akita-cli/plugin"
 	"github.com/akitasoftware/akita-cli/printer"
+	"github.com/akitasoftware/akita-cli/prom_metrics"
 	"github.com/akitasoftware/akita-cli/rest"
 	"github.com/akitasoftware/akita-cli/util"
 	"github.com/akitasoftware/akita-libs/akid"
@@ -31,6 +32,10 @@ type Args struct {
 	// Optional args.
 	PortNumber uint16
 
+	// If set, Prometheus metrics are served over HTTP on this address, e.g.
+	// ":9090".
+	MetricsAddr string
+
 	Plugins []plugin.AkitaPlugin
 }
 
@@ -50,6 +55,13 @@ func httpHandler(requestHandler httpRequestHandler) http.Handler {
 
 func Run(args Args) error {
 	cmdArgs = args
+	if args.MetricsAddr != "" {
+		server, err := prom_metrics.Serve(args.MetricsAddr, prom_metrics.Default)
+		if err != nil {
+			return err
+		}
+		defer server.Close()
+	}
 	eventChannel = cloud_client.Run(args.DaemonName, args.Domain, args.ClientID, args.Plugins)
 
 	router := mux.NewRouter().StrictSlash(true)
@@ -0,0 +1,264 @@
+// Package dashboard shows a live view of a running capture in the terminal.
+package dashboard
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+
+	"github.com/akitasoftware/akita-cli/endpoint_metrics"
+	"github.com/akitasoftware/akita-cli/printer"
+)
+
+const (
+	refreshInterval = time.Second
+
+	// Lines kept in the log pane, and printed once the dashboard closes.
+	maxLogLines = 500
+
+	// Busiest endpoints shown.
+	maxEndpointRows = 200
+
+	helpText = "q stop capture   p pause/resume upload   d dump stats"
+)
+
+// A full-screen view of packet counts, endpoints, the pair cache and upload
+// progress, refreshed every second, with a pane for warnings and other
+// messages.
+type Dashboard struct {
+	src   Sources
+	start time.Time
+
+	app        *tview.Application
+	status     *tview.TextView
+	interfaces *tview.Table
+	endpoints  *tview.Table
+	log        *tview.TextView
+
+	stopOnce sync.Once
+	stop     chan struct{}
+
+	// Closed once Run has redirected printer output to the log pane.
+	running chan struct{}
+}
+
+func New(src Sources) *Dashboard {
+	d := &Dashboard{
+		src:        src,
+		start:      time.Now(),
+		app:        tview.NewApplication(),
+		status:     tview.NewTextView().SetDynamicColors(true),
+		interfaces: tview.NewTable().SetFixed(1, 0),
+		endpoints:  tview.NewTable().SetFixed(1, 0),
+		log:        tview.NewTextView().SetDynamicColors(true).SetMaxLines(maxLogLines),
+		stop:       make(chan struct{}),
+		running:    make(chan struct{}),
+	}
+
+	for _, box := range []struct {
+		*tview.Box
+		title string
+	}{
+		{d.status.Box, " akita apidump "},
+		{d.interfaces.Box, " Interfaces "},
+		{d.endpoints.Box, " Endpoints "},
+		{d.log.Box, " Log "},
+	} {
+		box.SetBorder(true).SetTitle(box.title).SetTitleAlign(tview.AlignLeft)
+		box.SetBackgroundColor(tcell.ColorDefault)
+	}
+
+	layout := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(d.status, 3, 0, false).
+		AddItem(d.interfaces, len(src.Interfaces)+3, 0, false).
+		AddItem(d.endpoints, 0, 2, false).
+		AddItem(d.log, 0, 1, false)
+	frame := tview.NewFrame(layout).
+		SetBorders(0, 0, 0, 0, 0, 0).
+		AddText(helpText, false, tview.AlignLeft, tcell.ColorYellow)
+	frame.SetBackgroundColor(tcell.ColorDefault)
+
+	d.app.SetRoot(frame, true)
+	d.app.SetInputCapture(d.handleKey)
+	d.refresh()
+	return d
+}
+
+// Shows the dashboard until the user quits or Stop is called. While it is
+// shown, messages printed to printer.Stderr appear in the log pane instead,
+// and they are printed to stderr once the dashboard closes.
+func (d *Dashboard) Run() error {
+	restore := printer.RedirectStderr(&logWriter{ansi: tview.ANSIWriter(d.log)})
+	defer func() {
+		restore()
+		if text := strings.TrimRight(d.log.GetText(true), "\n"); text != "" {
+			printer.Stderr.RawOutput(text)
+		}
+	}()
+	close(d.running)
+
+	done := make(chan struct{})
+	defer close(done)
+	go d.refreshPeriodically(done)
+
+	return d.app.Run()
+}
+
+// Closes the dashboard. May be called before Run.
+func (d *Dashboard) Stop() {
+	d.stopOnce.Do(func() { close(d.stop) })
+}
+
+func (d *Dashboard) refreshPeriodically(done <-chan struct{}) {
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-d.stop:
+			// Queued, so that it takes effect even if the application hasn't
+			// started yet.
+			d.app.QueueUpdate(d.app.Stop)
+			return
+		case <-ticker.C:
+			d.app.QueueUpdateDraw(d.refresh)
+		}
+	}
+}
+
+func (d *Dashboard) handleKey(event *tcell.EventKey) *tcell.EventKey {
+	switch event.Rune() {
+	case 'q', 'Q':
+		d.app.Stop()
+		return nil
+	case 'p', 'P':
+		if d.src.Uploads == nil {
+			d.logf("Not uploading to Akita Cloud, nothing to pause\n")
+		} else if d.src.Uploads.Toggle() {
+			d.logf("Uploads paused. Witnesses are saved and will be uploaded once uploads resume.\n")
+		} else {
+			d.logf("Uploads resumed\n")
+		}
+		d.refresh()
+		return nil
+	case 'd', 'D':
+		d.dumpStats()
+		return nil
+	}
+	return event
+}
+
+// Escapes square brackets in printer output, such as "[INFO]", that tview
+// would take for color tags, and translates its ANSI colors. Safe for
+// concurrent use.
+type logWriter struct {
+	mutex sync.Mutex
+	ansi  io.Writer
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if _, err := io.WriteString(w.ansi, tview.Escape(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (d *Dashboard) logf(format string, args ...interface{}) {
+	fmt.Fprintf(d.log, "%s", tview.Escape(fmt.Sprintf(format, args...)))
+}
+
+// Writes the current stats to a timestamped file in the dump directory.
+func (d *Dashboard) dumpStats() {
+	now := time.Now()
+	path := filepath.Join(d.src.DumpDir, "akita_stats_"+now.Format("20060102T150405")+".json")
+	if err := d.src.Snapshot(d.start, now).WriteFile(path); err != nil {
+		d.logf("%v\n", err)
+		return
+	}
+	d.logf("Wrote stats to %s\n", path)
+}
+
+func (d *Dashboard) refresh() {
+	stats := d.src.Snapshot(d.start, time.Now())
+
+	var status strings.Builder
+	fmt.Fprintf(&status, "Capturing for %s   Pair cache: %d entries   ", stats.Elapsed, stats.PairCacheEntries)
+	if u := stats.Uploads; u == nil {
+		status.WriteString("Not uploading")
+	} else {
+		fmt.Fprintf(&status, "Uploaded: %d witnesses in %d batches", u.Witnesses, u.Batches)
+		if u.FailedBatches > 0 {
+			fmt.Fprintf(&status, ", [red]%d failed[-]", u.FailedBatches)
+		}
+		if u.Spooled > u.Retried {
+			fmt.Fprintf(&status, ", %d waiting", u.Spooled-u.Retried)
+		}
+		if u.Paused {
+			status.WriteString("   [yellow]PAUSED[-]")
+		}
+	}
+	d.status.SetText(status.String())
+
+	d.interfaces.Clear()
+	setRow(d.interfaces, 0, true, "Interface", "TCP packets", "HTTP requests", "HTTP responses", "Unparsed")
+	for i, s := range stats.Interfaces {
+		setRow(d.interfaces, i+1, false, s.Interface, itoa(s.TCPPackets), itoa(s.HTTPRequests), itoa(s.HTTPResponses), itoa(s.Unparsed))
+	}
+
+	d.endpoints.Clear()
+	setRow(d.endpoints, 0, true, "Endpoint", "Requests", "2xx", "3xx", "4xx", "5xx", "p50 ms", "p99 ms")
+	for i, e := range stats.Endpoints {
+		if i == maxEndpointRows {
+			d.endpoints.SetCell(i+1, 0, tview.NewTableCell(fmt.Sprintf("... and %d more", len(stats.Endpoints)-maxEndpointRows)))
+			break
+		}
+		setRow(d.endpoints, i+1, false, endpointRow(e)...)
+	}
+}
+
+func endpointRow(e endpoint_metrics.EndpointReport) []string {
+	classes := e.StatusClasses()
+	p50, p99 := "-", "-"
+	if e.Latency != nil {
+		p50 = fmt.Sprintf("%.1f", e.Latency.P50)
+		p99 = fmt.Sprintf("%.1f", e.Latency.P99)
+	}
+	return []string{e.Endpoint, itoa(e.Requests), itoa(classes[2]), itoa(classes[3]), itoa(classes[4]), itoa(classes[5]), p50, p99}
+}
+
+func setRow(table *tview.Table, row int, header bool, values ...string) {
+	for col, v := range values {
+		cell := tview.NewTableCell(tview.Escape(v))
+		if col == 0 {
+			cell.SetExpansion(1)
+		} else {
+			cell.SetAlign(tview.AlignRight)
+		}
+		if header {
+			cell.SetTextColor(tcell.ColorYellow).SetSelectable(false)
+		}
+		table.SetCell(row, col, cell)
+	}
+}
+
+func itoa(n int) string {
+	return strconv.Itoa(n)
+}
+
+// Returns true if the process is attached to a terminal the dashboard can be
+// shown on.
+func IsTerminal() bool {
+	fi, err := os.Stdout.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
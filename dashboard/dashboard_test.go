@@ -0,0 +1,117 @@
+package dashboard
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/akitasoftware/akita-cli/endpoint_metrics"
+	"github.com/akitasoftware/akita-cli/printer"
+	"github.com/akitasoftware/akita-cli/trace"
+)
+
+func newTestDashboard(t *testing.T) (*Dashboard, Sources) {
+	packets := trace.NewPacketCountSummary()
+	packets.Update(trace.PacketCounters{Interface: "eth0", TCPPackets: 10, HTTPRequests: 2, HTTPResponses: 1})
+
+	metrics := endpoint_metrics.NewMetrics(nil)
+	endpoint := metrics.RecordRequest("GET", "example.com", "/users")
+	metrics.RecordResponse(endpoint, 404, 10*time.Millisecond)
+	metrics.RecordRequest("GET", "example.com", "/users")
+
+	src := Sources{
+		Interfaces: []string{"eth0", "lo"},
+		Packets:    packets,
+		Endpoints:  metrics,
+		Uploads:    &trace.UploadSwitch{},
+		DumpDir:    t.TempDir(),
+	}
+	return New(src), src
+}
+
+func TestRefresh(t *testing.T) {
+	d, _ := newTestDashboard(t)
+
+	assert.Equal(t, 3, d.interfaces.GetRowCount())
+	assert.Equal(t, "eth0", d.interfaces.GetCell(1, 0).Text)
+	assert.Equal(t, "10", d.interfaces.GetCell(1, 1).Text)
+	assert.Equal(t, "lo", d.interfaces.GetCell(2, 0).Text)
+	assert.Equal(t, "0", d.interfaces.GetCell(2, 1).Text)
+
+	assert.Equal(t, 2, d.endpoints.GetRowCount())
+	assert.Equal(t, "GET example.com/users", d.endpoints.GetCell(1, 0).Text)
+	assert.Equal(t, "2", d.endpoints.GetCell(1, 1).Text)
+	assert.Equal(t, "1", d.endpoints.GetCell(1, 4).Text)
+
+	assert.Contains(t, d.status.GetText(true), "Uploaded: ")
+	assert.NotContains(t, d.status.GetText(true), "PAUSED")
+}
+
+func TestKeys(t *testing.T) {
+	d, src := newTestDashboard(t)
+
+	assert.Nil(t, d.handleKey(tcell.NewEventKey(tcell.KeyRune, 'p', tcell.ModNone)))
+	assert.True(t, src.Uploads.Paused())
+	assert.Contains(t, d.status.GetText(true), "PAUSED")
+	assert.Nil(t, d.handleKey(tcell.NewEventKey(tcell.KeyRune, 'p', tcell.ModNone)))
+	assert.False(t, src.Uploads.Paused())
+
+	assert.Nil(t, d.handleKey(tcell.NewEventKey(tcell.KeyRune, 'd', tcell.ModNone)))
+	matches, _ := filepath.Glob(filepath.Join(src.DumpDir, "akita_stats_*.json"))
+	if assert.Len(t, matches, 1) {
+		contents, err := ioutil.ReadFile(matches[0])
+		assert.NoError(t, err)
+		var stats Stats
+		assert.NoError(t, json.Unmarshal(contents, &stats))
+		assert.Len(t, stats.Interfaces, 2)
+		assert.Len(t, stats.Endpoints, 1)
+		if assert.NotNil(t, stats.Uploads) {
+			assert.False(t, stats.Uploads.Paused)
+		}
+	}
+	assert.Contains(t, d.log.GetText(true), "Wrote stats to ")
+
+	other := tcell.NewEventKey(tcell.KeyRune, 'x', tcell.ModNone)
+	assert.Equal(t, other, d.handleKey(other))
+}
+
+// Printer output is shown in the log pane while the dashboard runs, and
+// printed once it stops.
+func TestRun(t *testing.T) {
+	d, _ := newTestDashboard(t)
+	screen := tcell.NewSimulationScreen("")
+	if err := screen.Init(); err != nil {
+		t.Fatal(err)
+	}
+	d.app.SetScreen(screen)
+
+	var out bytes.Buffer
+	defer printer.RedirectStderr(&out)()
+
+	done := make(chan error)
+	go func() { done <- d.Run() }()
+	select {
+	case <-d.running:
+	case <-time.After(5 * time.Second):
+		t.Fatal("dashboard did not start")
+	}
+
+	printer.Warningf("[filter] matched nothing\n")
+	assert.Contains(t, d.log.GetText(true), "[WARNING] [filter] matched nothing")
+	assert.Empty(t, out.String())
+	d.Stop()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("dashboard did not stop")
+	}
+
+	assert.Contains(t, out.String(), "[WARNING] [filter] matched nothing")
+}
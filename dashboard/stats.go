@@ -0,0 +1,133 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-cli/endpoint_metrics"
+	"github.com/akitasoftware/akita-cli/pair_cache"
+	"github.com/akitasoftware/akita-cli/trace"
+	"github.com/akitasoftware/akita-cli/upload_spool"
+)
+
+// Where the dashboard gets its numbers from.
+type Sources struct {
+	// Interfaces being captured on. Shown even before any packets are seen.
+	Interfaces []string
+
+	// Packets that passed the filters.
+	Packets *trace.PacketCountSummary
+
+	// Per-endpoint request counts and status codes. May be nil.
+	Endpoints *endpoint_metrics.Metrics
+
+	// Pauses and resumes uploads to Akita Cloud. Nil if traffic isn't being
+	// uploaded.
+	Uploads *trace.UploadSwitch
+
+	// Directory to which stats are written when the user asks for them.
+	// Defaults to the current directory.
+	DumpDir string
+}
+
+// A snapshot of everything shown on the dashboard.
+type Stats struct {
+	Time    time.Time `json:"time"`
+	Elapsed string    `json:"elapsed"`
+
+	Interfaces []InterfaceStats                  `json:"interfaces"`
+	Endpoints  []endpoint_metrics.EndpointReport `json:"endpoints"`
+
+	PairCacheEntries int `json:"pair_cache_entries"`
+
+	// Omitted if traffic isn't being uploaded.
+	Uploads *UploadStats `json:"uploads,omitempty"`
+}
+
+type InterfaceStats struct {
+	Interface     string `json:"interface"`
+	TCPPackets    int    `json:"tcp_packets"`
+	HTTPRequests  int    `json:"http_requests"`
+	HTTPResponses int    `json:"http_responses"`
+	Unparsed      int    `json:"unparsed"`
+}
+
+type UploadStats struct {
+	Paused        bool `json:"paused"`
+	Batches       int  `json:"batches"`
+	FailedBatches int  `json:"failed_batches"`
+	Witnesses     int  `json:"witnesses"`
+
+	// Batches spooled because an upload failed or uploads were paused, and
+	// spooled batches uploaded since.
+	Spooled int `json:"spooled"`
+	Retried int `json:"retried"`
+}
+
+// Returns the current stats of a capture that started at the given time.
+func (src Sources) Snapshot(start, now time.Time) Stats {
+	stats := Stats{
+		Time:             now,
+		Elapsed:          now.Sub(start).Round(time.Second).String(),
+		PairCacheEntries: pair_cache.TotalEntries(),
+	}
+
+	byName := make(map[string]InterfaceStats, len(src.Interfaces))
+	for _, name := range src.Interfaces {
+		byName[name] = InterfaceStats{Interface: name}
+	}
+	if src.Packets != nil {
+		for _, c := range src.Packets.AllInterfaces() {
+			byName[c.Interface] = InterfaceStats{
+				Interface:     c.Interface,
+				TCPPackets:    c.TCPPackets,
+				HTTPRequests:  c.HTTPRequests,
+				HTTPResponses: c.HTTPResponses,
+				Unparsed:      c.Unparsed,
+			}
+		}
+	}
+	for _, s := range byName {
+		stats.Interfaces = append(stats.Interfaces, s)
+	}
+	sort.Slice(stats.Interfaces, func(i, j int) bool {
+		return stats.Interfaces[i].Interface < stats.Interfaces[j].Interface
+	})
+
+	if src.Endpoints != nil {
+		stats.Endpoints = src.Endpoints.Report().Endpoints
+	}
+
+	if src.Uploads != nil {
+		uploads := trace.GetUploadCounts()
+		spooled := upload_spool.GetCounts()
+		stats.Uploads = &UploadStats{
+			Paused:        src.Uploads.Paused(),
+			Batches:       uploads.Batches,
+			FailedBatches: uploads.FailedBatches,
+			Witnesses:     uploads.Witnesses,
+			Spooled:       spooled.Spooled,
+			Retried:       spooled.Retried,
+		}
+	}
+	return stats
+}
+
+func (s Stats) WriteFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open stats file %s", path)
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s); err != nil {
+		return errors.Wrap(err, "failed to write stats")
+	}
+	return nil
+}
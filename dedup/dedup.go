@@ -0,0 +1,211 @@
+package dedup
+
+import (
+	"sync"
+	"time"
+
+	"github.com/akitasoftware/akita-cli/path_inference"
+	pb "github.com/akitasoftware/akita-ir/go/api_spec"
+	"github.com/akitasoftware/akita-libs/spec_util"
+	"github.com/akitasoftware/akita-libs/tags"
+)
+
+const (
+	// By default, each window of a shape lasts this long.
+	DefaultWindow = 10 * time.Minute
+
+	// By default, this many witnesses of each shape are forwarded per window.
+	DefaultExemplars = 5
+
+	// Limit on the number of shapes tracked at once. Witnesses of new shapes
+	// beyond this are always forwarded.
+	maxShapes = 10000
+
+	// A witness forwarded at the end of a window in place of the duplicates
+	// suppressed during it carries this tag. Its value is the number of
+	// witnesses it stands for, including itself.
+	DuplicatesTag tags.Key = "x-akita-duplicates"
+)
+
+type Options struct {
+	// How long each window of a shape lasts. The first window starts when the
+	// shape is first seen. When a window ends, the duplicates suppressed
+	// during it are summarized, and the next witnesses of the shape are
+	// forwarded as new exemplars. Shapes not seen for a whole window are
+	// forgotten. Defaults to DefaultWindow.
+	Window time.Duration
+
+	// Number of witnesses of each shape forwarded per window. Defaults to
+	// DefaultExemplars.
+	Exemplars int
+}
+
+// Suppresses witnesses whose structure -- endpoint, field names and types --
+// has already been seen. Within a window, the first few witnesses of each
+// shape are forwarded as exemplars, so that latency statistics remain
+// meaningful; the rest are suppressed. When the window ends, the last
+// suppressed witness is forwarded in place of all of them, along with their
+// count.
+//
+// Safe for concurrent use. When shared, each user should call Retain when it
+// starts and Release when it stops, so that the shapes are only drained once
+// all of them have stopped.
+type Deduplicator struct {
+	opts     Options
+	inferrer *path_inference.Inferrer
+
+	mutex  sync.Mutex
+	shapes map[string]*shape
+	users  int
+
+	forwarded  int
+	suppressed int
+}
+
+type shape struct {
+	windowStart time.Time
+	lastSeen    time.Time
+	forwarded   int
+
+	// The most recently suppressed witness, and the number suppressed.
+	last       interface{}
+	suppressed int
+}
+
+// A witness forwarded in place of the duplicates suppressed during a window.
+type Summary struct {
+	Value interface{}
+
+	// The number of witnesses the value stands for, including itself.
+	Count int
+}
+
+// Shapes are keyed by path templates from the given inferrer, which may be
+// shared with other consumers. If it is nil, a private one is used.
+func New(opts Options, inferrer *path_inference.Inferrer) *Deduplicator {
+	if inferrer == nil {
+		inferrer = path_inference.NewInferrer(nil, nil)
+	}
+	if opts.Window <= 0 {
+		opts.Window = DefaultWindow
+	}
+	if opts.Exemplars <= 0 {
+		opts.Exemplars = DefaultExemplars
+	}
+	return &Deduplicator{
+		opts:     opts,
+		inferrer: inferrer,
+		shapes:   make(map[string]*shape),
+	}
+}
+
+// Returns true if the witness should be forwarded. Otherwise, it is a
+// duplicate, and value may be returned later by Expire or Drain as a summary.
+func (d *Deduplicator) Add(w *pb.Witness, value interface{}, now time.Time) bool {
+	template := ""
+	if meta := spec_util.HTTPMetaFromMethod(w.GetMethod()); meta != nil {
+		template = d.inferrer.ObserveEndpoint(meta.Method, meta.Host, meta.PathTemplate).Template
+	}
+	key := shapeHash(w.GetMethod(), template)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	s, ok := d.shapes[key]
+	if !ok {
+		if len(d.shapes) >= maxShapes {
+			d.forwarded++
+			return true
+		}
+		s = &shape{windowStart: now}
+		d.shapes[key] = s
+	}
+	s.lastSeen = now
+
+	if s.forwarded < d.opts.Exemplars {
+		s.forwarded++
+		d.forwarded++
+		return true
+	}
+	s.last = value
+	s.suppressed++
+	d.suppressed++
+	return false
+}
+
+// Ends the windows that have passed as of now, and returns summaries of the
+// duplicates suppressed during them. Shapes that haven't been seen for a
+// whole window are forgotten; the others start a new window.
+func (d *Deduplicator) Expire(now time.Time) []Summary {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	cutoff := now.Add(-d.opts.Window)
+	var result []Summary
+	for k, s := range d.shapes {
+		if !s.windowStart.Before(cutoff) {
+			continue
+		}
+		if summary, ok := s.summary(); ok {
+			result = append(result, summary)
+		}
+		if s.lastSeen.Before(cutoff) {
+			delete(d.shapes, k)
+			continue
+		}
+		*s = shape{windowStart: now, lastSeen: s.lastSeen}
+	}
+	return result
+}
+
+// Forgets all shapes, and returns summaries of the duplicates suppressed.
+func (d *Deduplicator) Drain() []Summary {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var result []Summary
+	for _, s := range d.shapes {
+		if summary, ok := s.summary(); ok {
+			result = append(result, summary)
+		}
+	}
+	d.shapes = make(map[string]*shape)
+	return result
+}
+
+// Summarizes the duplicates suppressed in the current window, if any.
+func (s *shape) summary() (Summary, bool) {
+	if s.suppressed == 0 {
+		return Summary{}, false
+	}
+	return Summary{Value: s.last, Count: s.suppressed}, true
+}
+
+// Registers a user of the deduplicator.
+func (d *Deduplicator) Retain() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.users++
+}
+
+// Unregisters a user of the deduplicator. The last user to be released drains
+// it, and gets the summaries; the others get nil.
+func (d *Deduplicator) Release() []Summary {
+	d.mutex.Lock()
+	d.users--
+	last := d.users <= 0
+	d.mutex.Unlock()
+
+	if !last {
+		return nil
+	}
+	return d.Drain()
+}
+
+// Returns the number of witnesses forwarded as exemplars and suppressed as
+// duplicates so far.
+func (d *Deduplicator) Counts() (forwarded, suppressed int) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.forwarded, d.suppressed
+}
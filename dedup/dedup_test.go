@@ -0,0 +1,121 @@
+package dedup
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	pb "github.com/akitasoftware/akita-ir/go/api_spec"
+	"github.com/akitasoftware/akita-libs/spec_util"
+	"github.com/akitasoftware/akita-libs/spec_util/ir_hash"
+)
+
+// Returns a witness for a GET request whose response body has a single field.
+func newWitness(path string, field *pb.Primitive, latency float32) *pb.Witness {
+	body := &pb.Data{
+		Value: &pb.Data_Struct{
+			Struct: &pb.Struct{
+				Fields: map[string]*pb.Data{
+					"value": {Value: &pb.Data_Primitive{Primitive: field}},
+				},
+			},
+		},
+	}
+	return &pb.Witness{
+		Method: &pb.Method{
+			Responses: map[string]*pb.Data{
+				ir_hash.HashDataToString(body): body,
+			},
+			Meta: &pb.MethodMeta{
+				Meta: &pb.MethodMeta_Http{
+					Http: &pb.HTTPMethodMeta{
+						Method:            "GET",
+						PathTemplate:      path,
+						Host:              "example.com",
+						ProcessingLatency: latency,
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestExemplars(t *testing.T) {
+	d := New(Options{Exemplars: 2}, nil)
+	now := time.Now()
+
+	// Values, path parameters and latency don't change the shape.
+	assert.True(t, d.Add(newWitness("/users/1", spec_util.NewPrimitiveString("a"), 1), "1", now))
+	assert.True(t, d.Add(newWitness("/users/2", spec_util.NewPrimitiveString("b"), 2), "2", now))
+	assert.False(t, d.Add(newWitness("/users/3", spec_util.NewPrimitiveString("c"), 3), "3", now))
+	assert.False(t, d.Add(newWitness("/users/4", spec_util.NewPrimitiveString("d"), 4), "4", now))
+
+	// A field of a different type is a new shape.
+	assert.True(t, d.Add(newWitness("/users/5", spec_util.NewPrimitiveInt64(5), 5), "5", now))
+
+	forwarded, suppressed := d.Counts()
+	assert.Equal(t, 3, forwarded)
+	assert.Equal(t, 2, suppressed)
+}
+
+func TestExpire(t *testing.T) {
+	d := New(Options{Window: time.Minute, Exemplars: 1}, nil)
+	start := time.Now()
+
+	d.Add(newWitness("/users/1", spec_util.NewPrimitiveString("a"), 1), "1", start)
+	d.Add(newWitness("/users/2", spec_util.NewPrimitiveString("b"), 1), "2", start)
+	d.Add(newWitness("/users/3", spec_util.NewPrimitiveString("c"), 1), "3", start)
+	d.Add(newWitness("/orders", spec_util.NewPrimitiveString("d"), 1), "4", start.Add(30*time.Second))
+	d.Add(newWitness("/orders", spec_util.NewPrimitiveString("e"), 1), "5", start.Add(30*time.Second))
+
+	assert.Empty(t, d.Expire(start.Add(30*time.Second)))
+	assert.Equal(t, []Summary{{Value: "3", Count: 2}}, d.Expire(start.Add(time.Minute+time.Second)))
+
+	// The shape has been forgotten, so the next witness is a new exemplar.
+	assert.True(t, d.Add(newWitness("/users/4", spec_util.NewPrimitiveString("f"), 1), "6", start.Add(time.Minute+time.Second)))
+
+	assert.Equal(t, []Summary{{Value: "5", Count: 1}}, d.Drain())
+	assert.Empty(t, d.Drain())
+}
+
+func TestSteadyTraffic(t *testing.T) {
+	d := New(Options{Window: time.Minute, Exemplars: 2}, nil)
+	start := time.Now()
+
+	// A witness every 10 seconds for three and a half minutes.
+	var forwarded []string
+	var summaries []Summary
+	for i := 0; i < 21; i++ {
+		now := start.Add(time.Duration(i) * 10 * time.Second)
+		summaries = append(summaries, d.Expire(now)...)
+		value := strconv.Itoa(i)
+		if d.Add(newWitness("/users/"+value, spec_util.NewPrimitiveString(value), 1), value, now) {
+			forwarded = append(forwarded, value)
+		}
+	}
+
+	// Each window forwards its own exemplars and ends with a summary of the
+	// rest, even though the shape is never idle.
+	assert.Equal(t, []string{"0", "1", "7", "8", "14", "15"}, forwarded)
+	assert.Equal(t, []Summary{
+		{Value: "6", Count: 5},
+		{Value: "13", Count: 5},
+	}, summaries)
+	assert.Equal(t, []Summary{{Value: "20", Count: 5}}, d.Drain())
+}
+
+func TestRelease(t *testing.T) {
+	d := New(Options{Exemplars: 1}, nil)
+	now := time.Now()
+	d.Retain()
+	d.Retain()
+
+	d.Add(newWitness("/users/1", spec_util.NewPrimitiveString("a"), 1), "1", now)
+	d.Add(newWitness("/users/2", spec_util.NewPrimitiveString("b"), 1), "2", now)
+
+	// Only the last user to be released drains the deduplicator.
+	assert.Nil(t, d.Release())
+	assert.Equal(t, []Summary{{Value: "2", Count: 1}}, d.Release())
+}
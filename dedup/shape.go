@@ -0,0 +1,79 @@
+package dedup
+
+import (
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/akitasoftware/akita-ir/go/api_spec"
+	"github.com/akitasoftware/akita-libs/spec_util"
+	"github.com/akitasoftware/akita-libs/spec_util/ir_hash"
+)
+
+// Returns a hash of the structure of a witness's method: its endpoint, the
+// names and types of its arguments and responses, and the formats of their
+// values, but not the values themselves. The path of HTTP methods is replaced
+// by the given template, and the processing latency is ignored.
+//
+// Lists are reduced to their distinct element shapes, so that lists of
+// different lengths with the same kind of elements have the same shape.
+func shapeHash(method *pb.Method, pathTemplate string) string {
+	m := proto.Clone(method).(*pb.Method)
+	if meta := spec_util.HTTPMetaFromMethod(m); meta != nil {
+		meta.PathTemplate = pathTemplate
+		meta.ProcessingLatency = 0
+	}
+	m.Args = shapeOfMap(m.Args)
+	m.Responses = shapeOfMap(m.Responses)
+	return ir_hash.HashWitnessToString(&pb.Witness{Method: m})
+}
+
+// Re-keys a map of data by the hash of each value's shape. The keys of
+// argument and response maps are hashes of the values, so they must be
+// recomputed.
+func shapeOfMap(in map[string]*pb.Data) map[string]*pb.Data {
+	if len(in) == 0 {
+		return in
+	}
+	out := make(map[string]*pb.Data, len(in))
+	for _, d := range in {
+		shapeOf(d)
+		out[ir_hash.HashDataToString(d)] = d
+	}
+	return out
+}
+
+// Replaces primitive values with the zero value of their type, in place.
+func shapeOf(d *pb.Data) {
+	if d == nil {
+		return
+	}
+
+	switch v := d.Value.(type) {
+	case *pb.Data_Primitive:
+		pv, err := spec_util.PrimitiveValueFromProto(v.Primitive)
+		if err != nil {
+			v.Primitive.Value = nil
+			return
+		}
+		v.Primitive.Value = pv.Zero().ToProto().Value
+	case *pb.Data_Struct:
+		for _, f := range v.Struct.GetFields() {
+			shapeOf(f)
+		}
+	case *pb.Data_List:
+		seen := make(map[string]struct{}, len(v.List.GetElems()))
+		elems := v.List.GetElems()[:0]
+		for _, e := range v.List.GetElems() {
+			shapeOf(e)
+			h := ir_hash.HashDataToString(e)
+			if _, ok := seen[h]; !ok {
+				seen[h] = struct{}{}
+				elems = append(elems, e)
+			}
+		}
+		if v.List != nil {
+			v.List.Elems = elems
+		}
+	case *pb.Data_Optional:
+		shapeOf(v.Optional.GetData())
+	}
+}
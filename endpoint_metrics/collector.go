@@ -0,0 +1,97 @@
+package endpoint_metrics
+
+import (
+	"time"
+
+	"github.com/akitasoftware/akita-cli/learn"
+	"github.com/akitasoftware/akita-cli/pair_cache"
+	"github.com/akitasoftware/akita-cli/trace"
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+// Records HTTP requests and responses in the metrics, and passes on all
+// traffic as is.
+type collector struct {
+	metrics *Metrics
+	next    trace.Collector
+
+	// Requests and responses waiting for their other half. Those that wait
+	// too long are forgotten, and their responses aren't counted.
+	pending *pair_cache.Cache
+}
+
+// Whichever half of a pair has been seen.
+type pendingPair struct {
+	// Set once the request is seen.
+	endpoint   string
+	requestEnd time.Time
+
+	// Set once the response is seen.
+	hasResponse   bool
+	status        int
+	responseStart time.Time
+}
+
+var _ trace.Collector = (*collector)(nil)
+
+func NewCollector(m *Metrics, next trace.Collector) trace.Collector {
+	return &collector{
+		metrics: m,
+		next:    next,
+		pending: pair_cache.New(pair_cache.Options{Name: "endpoint_metrics"}),
+	}
+}
+
+func (c *collector) Process(t akinet.ParsedNetworkTraffic) error {
+	c.pending.Expire(time.Now())
+
+	switch content := t.Content.(type) {
+	case akinet.HTTPRequest:
+		path := ""
+		if content.URL != nil {
+			path = content.URL.Path
+		}
+		endpoint := c.metrics.RecordRequest(content.Method, content.Host, path)
+		requestEnd := t.FinalPacketTime
+		if requestEnd.IsZero() {
+			requestEnd = t.ObservationTime
+		}
+
+		key := learn.ToWitnessID(content.StreamID, content.Seq)
+		if v, ok := c.pending.Take(key); ok && v.(*pendingPair).hasResponse {
+			p := v.(*pendingPair)
+			c.metrics.RecordResponse(endpoint, p.status, latency(requestEnd, p.responseStart))
+		} else {
+			c.pending.Add(key, &pendingPair{endpoint: endpoint, requestEnd: requestEnd})
+		}
+	case akinet.HTTPResponse:
+		key := learn.ToWitnessID(content.StreamID, content.Seq)
+		if v, ok := c.pending.Take(key); ok && v.(*pendingPair).endpoint != "" {
+			p := v.(*pendingPair)
+			c.metrics.RecordResponse(p.endpoint, content.StatusCode, latency(p.requestEnd, t.ObservationTime))
+		} else {
+			c.pending.Add(key, &pendingPair{
+				hasResponse:   true,
+				status:        content.StatusCode,
+				responseStart: t.ObservationTime,
+			})
+		}
+	}
+	return c.next.Process(t)
+}
+
+func (c *collector) Close() error {
+	return c.next.Close()
+}
+
+// Processing latency runs from the end of the request to the start of the
+// response. Returns zero if it can't be determined.
+func latency(requestEnd, responseStart time.Time) time.Duration {
+	if requestEnd.IsZero() || responseStart.IsZero() {
+		return 0
+	}
+	if d := responseStart.Sub(requestEnd); d > 0 {
+		return d
+	}
+	return 0
+}
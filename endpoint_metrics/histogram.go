@@ -0,0 +1,113 @@
+package endpoint_metrics
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// Number of bits of precision kept for each value. Values below
+// 2^subBucketBits are counted exactly; larger ones are counted in buckets
+// no wider than 1/2^subBucketBits of their value, so that percentiles are
+// within about 3%.
+const subBucketBits = 5
+
+const subBuckets = 1 << subBucketBits
+
+// A log-linear histogram of latencies, in the style of HDR histograms.
+// Latencies are recorded in microseconds. Not safe for concurrent use.
+type Histogram struct {
+	counts []uint64
+	total  uint64
+	sum    time.Duration
+	min    time.Duration
+	max    time.Duration
+}
+
+func (h *Histogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	i := bucketIndex(uint64(d / time.Microsecond))
+	if i >= len(h.counts) {
+		grown := make([]uint64, i+1)
+		copy(grown, h.counts)
+		h.counts = grown
+	}
+	h.counts[i]++
+
+	if h.total == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.total++
+	h.sum += d
+}
+
+func (h *Histogram) Count() uint64 {
+	return h.total
+}
+
+func (h *Histogram) Min() time.Duration {
+	return h.min
+}
+
+func (h *Histogram) Max() time.Duration {
+	return h.max
+}
+
+func (h *Histogram) Mean() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.total)
+}
+
+// Returns the latency below which the given fraction of latencies fall, for
+// q between 0 and 1.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(h.total)))
+	if rank < 1 {
+		rank = 1
+	}
+
+	var seen uint64
+	for i, n := range h.counts {
+		seen += n
+		if seen >= rank {
+			d := time.Duration(bucketMidpoint(i)) * time.Microsecond
+			if d < h.min {
+				return h.min
+			}
+			if d > h.max {
+				return h.max
+			}
+			return d
+		}
+	}
+	return h.max
+}
+
+func bucketIndex(v uint64) int {
+	if v < subBuckets {
+		return int(v)
+	}
+	shift := bits.Len64(v) - 1 - subBucketBits
+	return subBuckets + shift*subBuckets + int(v>>uint(shift)) - subBuckets
+}
+
+// Returns the middle of the range of values counted by the bucket.
+func bucketMidpoint(i int) uint64 {
+	if i < subBuckets {
+		return uint64(i)
+	}
+	shift := uint((i - subBuckets) / subBuckets)
+	sub := uint64((i - subBuckets) % subBuckets)
+	lower := (subBuckets + sub) << shift
+	return lower + (uint64(1)<<shift)/2
+}
@@ -0,0 +1,235 @@
+package endpoint_metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-cli/path_inference"
+	"github.com/akitasoftware/akita-cli/printer"
+)
+
+const (
+	// Limit on the number of endpoints tracked. Requests to endpoints beyond
+	// this are counted under otherEndpoints.
+	maxEndpoints = 10000
+
+	otherEndpoints = "(other)"
+
+	// Number of endpoints shown in the table printed by PrintTable.
+	tableRows = 20
+)
+
+// Per-endpoint request counts, status codes and latencies, where endpoints
+// are distinct (method, host, path template) triples.
+//
+// Safe for concurrent use, and meant to be shared by all collectors.
+type Metrics struct {
+	mutex     sync.Mutex
+	inferrer  *path_inference.Inferrer
+	endpoints map[string]*endpointMetrics
+}
+
+type endpointMetrics struct {
+	requests  int
+	responses int
+	statuses  map[int]int
+	latency   Histogram
+}
+
+// Endpoints are named using the given inferrer, which may be shared with other
+// consumers so that they agree on path templates. If it is nil, a private one
+// with no user-supplied patterns is used.
+func NewMetrics(inferrer *path_inference.Inferrer) *Metrics {
+	if inferrer == nil {
+		inferrer = path_inference.NewInferrer(nil, nil)
+	}
+	return &Metrics{
+		inferrer:  inferrer,
+		endpoints: make(map[string]*endpointMetrics),
+	}
+}
+
+// Counts a request, and returns the name of its endpoint, to be passed to
+// RecordResponse.
+func (m *Metrics) RecordRequest(method, host, path string) string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	e, endpoint := m.endpointFor(m.inferrer.ObserveEndpoint(method, host, path).String())
+	e.requests++
+	return endpoint
+}
+
+// Counts a response to a request to the given endpoint. The latency is
+// ignored if it is zero.
+func (m *Metrics) RecordResponse(endpoint string, status int, latency time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	e, _ := m.endpointFor(endpoint)
+	e.responses++
+	e.statuses[status]++
+	if latency > 0 {
+		e.latency.Record(latency)
+	}
+}
+
+// Caller must hold m.mutex.
+func (m *Metrics) endpointFor(endpoint string) (*endpointMetrics, string) {
+	if e, ok := m.endpoints[endpoint]; ok {
+		return e, endpoint
+	}
+	if len(m.endpoints) >= maxEndpoints {
+		endpoint = otherEndpoints
+		if e, ok := m.endpoints[endpoint]; ok {
+			return e, endpoint
+		}
+	}
+	e := &endpointMetrics{statuses: make(map[int]int)}
+	m.endpoints[endpoint] = e
+	return e, endpoint
+}
+
+type Report struct {
+	Endpoints []EndpointReport `json:"endpoints"`
+}
+
+type EndpointReport struct {
+	Endpoint  string `json:"endpoint"`
+	Requests  int    `json:"requests"`
+	Responses int    `json:"responses"`
+
+	// Number of responses with each status code.
+	StatusCodes map[string]int `json:"status_codes"`
+
+	// Processing latency, from the end of the request to the start of the
+	// response. Omitted if no latencies were measured.
+	Latency *LatencyReport `json:"latency_ms,omitempty"`
+}
+
+// Latency percentiles, in milliseconds.
+type LatencyReport struct {
+	Count uint64  `json:"count"`
+	Min   float64 `json:"min"`
+	Mean  float64 `json:"mean"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P95   float64 `json:"p95"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+}
+
+// Returns the metrics so far, busiest endpoints first.
+func (m *Metrics) Report() Report {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	rows := make([]EndpointReport, 0, len(m.endpoints))
+	for name, e := range m.endpoints {
+		row := EndpointReport{
+			Endpoint:    name,
+			Requests:    e.requests,
+			Responses:   e.responses,
+			StatusCodes: make(map[string]int, len(e.statuses)),
+		}
+		for status, n := range e.statuses {
+			row.StatusCodes[strconv.Itoa(status)] = n
+		}
+		if h := &e.latency; h.Count() > 0 {
+			row.Latency = &LatencyReport{
+				Count: h.Count(),
+				Min:   millis(h.Min()),
+				Mean:  millis(h.Mean()),
+				P50:   millis(h.Quantile(0.5)),
+				P90:   millis(h.Quantile(0.9)),
+				P95:   millis(h.Quantile(0.95)),
+				P99:   millis(h.Quantile(0.99)),
+				Max:   millis(h.Max()),
+			}
+		}
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Requests != rows[j].Requests {
+			return rows[i].Requests > rows[j].Requests
+		}
+		return rows[i].Endpoint < rows[j].Endpoint
+	})
+	return Report{Endpoints: rows}
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000.0
+}
+
+func (r Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func (r Report) WriteFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open endpoint metrics file %s", path)
+	}
+	defer f.Close()
+
+	if err := r.WriteJSON(f); err != nil {
+		return errors.Wrap(err, "failed to write endpoint metrics")
+	}
+	return nil
+}
+
+// Prints the busiest endpoints as a table to stderr.
+func (r Report) PrintTable() {
+	if len(r.Endpoints) == 0 {
+		printer.Stderr.Infof("No HTTP requests to report metrics for\n")
+		return
+	}
+
+	printer.Stderr.Infof("Endpoint metrics (latencies in ms):\n")
+	printer.Stderr.Infof("%-50s %8s %6s %6s %6s %6s %8s %8s %8s\n", "endpoint", "requests", "2xx", "3xx", "4xx", "5xx", "p50", "p90", "p99")
+	for i, e := range r.Endpoints {
+		if i == tableRows {
+			printer.Stderr.Infof("... and %d more endpoints\n", len(r.Endpoints)-tableRows)
+			break
+		}
+		classes := e.StatusClasses()
+		p50, p90, p99 := "-", "-", "-"
+		if e.Latency != nil {
+			p50 = fmt.Sprintf("%.1f", e.Latency.P50)
+			p90 = fmt.Sprintf("%.1f", e.Latency.P90)
+			p99 = fmt.Sprintf("%.1f", e.Latency.P99)
+		}
+		printer.Stderr.Infof("%-50s %8d %6d %6d %6d %6d %8s %8s %8s\n",
+			truncate(e.Endpoint, 50), e.Requests, classes[2], classes[3], classes[4], classes[5], p50, p90, p99)
+	}
+}
+
+// Returns the number of responses in each class of status codes, indexed by
+// the first digit.
+func (e EndpointReport) StatusClasses() [6]int {
+	var result [6]int
+	for s, n := range e.StatusCodes {
+		if status, err := strconv.Atoi(s); err == nil && status >= 100 && status < 600 {
+			result[status/100] += n
+		}
+	}
+	return result
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
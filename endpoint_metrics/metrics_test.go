@@ -0,0 +1,89 @@
+package endpoint_metrics
+
+import (
+	"bytes"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/akitasoftware/akita-cli/trace"
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+func TestHistogram(t *testing.T) {
+	var h Histogram
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+
+	assert.Equal(t, uint64(1000), h.Count())
+	assert.Equal(t, time.Millisecond, h.Min())
+	assert.Equal(t, time.Second, h.Max())
+	assert.InEpsilon(t, float64(500500*time.Microsecond), float64(h.Mean()), 0.001)
+	for _, q := range []float64{0.5, 0.9, 0.99} {
+		assert.InEpsilon(t, q*float64(time.Second), float64(h.Quantile(q)), 0.03, "quantile %v", q)
+	}
+	assert.Equal(t, time.Second, h.Quantile(1))
+
+	var empty Histogram
+	assert.Equal(t, time.Duration(0), empty.Quantile(0.5))
+}
+
+func TestCollector(t *testing.T) {
+	m := NewMetrics(nil)
+	col := NewCollector(m, trace.NewDummyCollector())
+
+	start := time.Now()
+	stream := uuid.New()
+	send := func(seq int, path string, status int, latency time.Duration, responseFirst bool) {
+		u, _ := url.Parse(path)
+		req := akinet.ParsedNetworkTraffic{
+			Content:         akinet.HTTPRequest{StreamID: stream, Seq: seq, Method: "GET", Host: "example.com", URL: u},
+			ObservationTime: start,
+			FinalPacketTime: start,
+		}
+		resp := akinet.ParsedNetworkTraffic{
+			Content:         akinet.HTTPResponse{StreamID: stream, Seq: seq, StatusCode: status},
+			ObservationTime: start.Add(latency),
+		}
+		if responseFirst {
+			req, resp = resp, req
+		}
+		assert.NoError(t, col.Process(req))
+		if status != 0 {
+			assert.NoError(t, col.Process(resp))
+		}
+	}
+
+	send(1, "/users/1", 200, 10*time.Millisecond, false)
+	send(2, "/users/2", 200, 20*time.Millisecond, true)
+	send(3, "/users/3", 404, 30*time.Millisecond, false)
+	send(4, "/users/4", 0, 0, false)
+	send(5, "/health", 503, time.Millisecond, false)
+	assert.NoError(t, col.Close())
+
+	report := m.Report()
+	if assert.Len(t, report.Endpoints, 2) {
+		users := report.Endpoints[0]
+		assert.Equal(t, "GET example.com/users/{arg1}", users.Endpoint)
+		assert.Equal(t, 4, users.Requests)
+		assert.Equal(t, 3, users.Responses)
+		assert.Equal(t, map[string]int{"200": 2, "404": 1}, users.StatusCodes)
+		if assert.NotNil(t, users.Latency) {
+			assert.Equal(t, uint64(3), users.Latency.Count)
+			assert.Equal(t, 10.0, users.Latency.Min)
+			assert.Equal(t, 30.0, users.Latency.Max)
+		}
+		assert.Equal(t, [6]int{0, 0, 2, 0, 1, 0}, users.StatusClasses())
+
+		assert.Equal(t, "GET example.com/health", report.Endpoints[1].Endpoint)
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, report.WriteJSON(&buf))
+	assert.Contains(t, buf.String(), `"status_codes": {`)
+	assert.Contains(t, buf.String(), `"latency_ms": {`)
+}
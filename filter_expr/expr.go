@@ -0,0 +1,442 @@
+package filter_expr
+
+import (
+	"mime"
+	"net"
+	"net/textproto"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-cli/process_info"
+	"github.com/akitasoftware/akita-cli/util"
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+// A compiled filter expression, such as
+//
+//	method = POST and status >= 500
+//	header[X-Debug] present
+//	client_ip in 10.0.0.0/8
+//	response_content_type ~ json and response_size > 1MB
+//	process = nginx or pod_label[app] = checkout
+//
+// Expressions combine comparisons with and, or, not and parentheses. A
+// comparison involving a field that isn't known -- a missing header, or the
+// status of a request whose response was never seen -- is false.
+type Expr struct {
+	src           string
+	pred          predicate
+	needsResponse bool
+	needsOwners   bool
+
+	// Finds the processes that handled the traffic, for the process, container
+	// and pod fields.
+	owners OwnerResolver
+}
+
+// Finds the process that handled traffic. Implemented by
+// process_info.Resolver.
+type OwnerResolver interface {
+	ForTraffic(akinet.ParsedNetworkTraffic) *process_info.Owner
+}
+
+// Returns true if the exchange should be matched.
+type predicate func(*exchange) bool
+
+func Compile(src string) (*Expr, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid filter expression %q", src)
+	}
+	p := &parser{tokens: tokens}
+	pred, err := p.parseExpr()
+	if err == nil && p.peek().kind != eofToken {
+		err = errors.Errorf("unexpected %q at position %d", p.peek().text, p.peek().pos)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid filter expression %q", src)
+	}
+	return &Expr{src: src, pred: pred, needsResponse: p.needsResponse, needsOwners: p.needsOwners}, nil
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Returns true if the expression refers to the response, so that requests
+// can't be matched until their response is seen.
+func (e *Expr) NeedsResponse() bool {
+	return e.needsResponse
+}
+
+// Returns true if the expression refers to the process, container or pod that
+// handled the traffic, which are only known once SetOwners is called.
+func (e *Expr) NeedsOwners() bool {
+	return e.needsOwners
+}
+
+// Sets the resolver used to find the process, container and pod that handled
+// the traffic. Should be called before Match.
+func (e *Expr) SetOwners(r OwnerResolver) {
+	e.owners = r
+}
+
+// Matches an HTTP request and its response. Either may be nil if it wasn't
+// seen.
+func (e *Expr) Match(req, resp *akinet.ParsedNetworkTraffic) bool {
+	return e.pred(newExchange(req, resp, e.owners))
+}
+
+func and(l, r predicate) predicate {
+	return func(x *exchange) bool { return l(x) && r(x) }
+}
+
+func or(l, r predicate) predicate {
+	return func(x *exchange) bool { return l(x) || r(x) }
+}
+
+func not(p predicate) predicate {
+	return func(x *exchange) bool { return !p(x) }
+}
+
+func present(f *field) predicate {
+	return func(x *exchange) bool {
+		switch f.kind {
+		case stringKind:
+			_, ok := f.str(x)
+			return ok
+		case numberKind:
+			_, ok := f.num(x)
+			return ok
+		default:
+			_, ok := f.ip(x)
+			return ok
+		}
+	}
+}
+
+// A request and its response, as seen by predicates.
+type exchange struct {
+	req  *akinet.HTTPRequest
+	resp *akinet.HTTPResponse
+
+	// Addresses of the client and server, from the request if it was seen,
+	// otherwise from the response.
+	hasAddrs   bool
+	clientIP   net.IP
+	serverIP   net.IP
+	serverPort int
+
+	// The traffic whose owner is looked up, and the owner once it has been.
+	traffic     *akinet.ParsedNetworkTraffic
+	owners      OwnerResolver
+	owner       *process_info.Owner
+	ownerLooked bool
+}
+
+func newExchange(req, resp *akinet.ParsedNetworkTraffic, owners OwnerResolver) *exchange {
+	x := &exchange{owners: owners}
+	if resp != nil {
+		if c, ok := resp.Content.(akinet.HTTPResponse); ok {
+			x.resp = &c
+			x.hasAddrs = true
+			x.clientIP, x.serverIP, x.serverPort = resp.DstIP, resp.SrcIP, resp.SrcPort
+			x.traffic = resp
+		}
+	}
+	if req != nil {
+		if c, ok := req.Content.(akinet.HTTPRequest); ok {
+			x.req = &c
+			x.hasAddrs = true
+			x.clientIP, x.serverIP, x.serverPort = req.SrcIP, req.DstIP, req.DstPort
+			x.traffic = req
+		}
+	}
+	return x
+}
+
+// Returns the process that handled the exchange, or nil if it isn't known.
+func (x *exchange) getOwner() *process_info.Owner {
+	if !x.ownerLooked {
+		x.ownerLooked = true
+		if x.owners != nil && x.traffic != nil {
+			x.owner = x.owners.ForTraffic(*x.traffic)
+		}
+	}
+	return x.owner
+}
+
+type fieldKind int
+
+const (
+	stringKind fieldKind = iota
+	numberKind
+	ipKind
+)
+
+type field struct {
+	name string
+	kind fieldKind
+
+	// Set if the field is only known once the response is seen.
+	response bool
+
+	// Set if the field describes the process that handled the exchange.
+	owner bool
+
+	// Set if string comparisons ignore case.
+	foldCase bool
+
+	// Getters; only the one for the field's kind is set. They return false if
+	// the field isn't known.
+	str func(*exchange) (string, bool)
+	num func(*exchange) (int64, bool)
+	ip  func(*exchange) (net.IP, bool)
+
+	// Parses literal values of number fields.
+	parse func(string) (int64, error)
+}
+
+var fields = map[string]*field{
+	"method": {
+		kind:     stringKind,
+		foldCase: true,
+		str: func(x *exchange) (string, bool) {
+			if x.req == nil {
+				return "", false
+			}
+			return x.req.Method, true
+		},
+	},
+	"host": {
+		kind:     stringKind,
+		foldCase: true,
+		str: func(x *exchange) (string, bool) {
+			if x.req == nil {
+				return "", false
+			}
+			if x.req.Host == "" && x.req.URL != nil {
+				return x.req.URL.Host, true
+			}
+			return x.req.Host, true
+		},
+	},
+	"path": {
+		kind: stringKind,
+		str: func(x *exchange) (string, bool) {
+			if x.req == nil || x.req.URL == nil {
+				return "", false
+			}
+			return x.req.URL.Path, true
+		},
+	},
+	"status": {
+		kind:     numberKind,
+		response: true,
+		parse:    parseInt,
+		num: func(x *exchange) (int64, bool) {
+			if x.resp == nil {
+				return 0, false
+			}
+			return int64(x.resp.StatusCode), true
+		},
+	},
+	"client_ip": {
+		kind: ipKind,
+		ip: func(x *exchange) (net.IP, bool) {
+			return x.clientIP, x.hasAddrs
+		},
+	},
+	"server_ip": {
+		kind: ipKind,
+		ip: func(x *exchange) (net.IP, bool) {
+			return x.serverIP, x.hasAddrs
+		},
+	},
+	"server_port": {
+		kind:  numberKind,
+		parse: parseInt,
+		num: func(x *exchange) (int64, bool) {
+			return int64(x.serverPort), x.hasAddrs
+		},
+	},
+	"request_size": {
+		kind:  numberKind,
+		parse: util.ParseSize,
+		num: func(x *exchange) (int64, bool) {
+			if x.req == nil {
+				return 0, false
+			}
+			return int64(len(x.req.Body)), true
+		},
+	},
+	"response_size": {
+		kind:     numberKind,
+		response: true,
+		parse:    util.ParseSize,
+		num: func(x *exchange) (int64, bool) {
+			if x.resp == nil {
+				return 0, false
+			}
+			return int64(len(x.resp.Body)), true
+		},
+	},
+	"pid": {
+		kind:  numberKind,
+		owner: true,
+		parse: parseInt,
+		num: func(x *exchange) (int64, bool) {
+			if o := x.getOwner(); o != nil {
+				return int64(o.PID), true
+			}
+			return 0, false
+		},
+	},
+	"process": {
+		kind:  stringKind,
+		owner: true,
+		str: func(x *exchange) (string, bool) {
+			if o := x.getOwner(); o != nil {
+				return o.Command, true
+			}
+			return "", false
+		},
+	},
+	"container": {
+		kind:     stringKind,
+		owner:    true,
+		foldCase: true,
+		str: func(x *exchange) (string, bool) {
+			if o := x.getOwner(); o != nil && o.ContainerID != "" {
+				return o.ContainerID, true
+			}
+			return "", false
+		},
+	},
+	"pod": {
+		kind:  stringKind,
+		owner: true,
+		str: func(x *exchange) (string, bool) {
+			if o := x.getOwner(); o != nil && o.Pod != nil && o.Pod.Name != "" {
+				return o.Pod.Name, true
+			}
+			return "", false
+		},
+	},
+	"pod_namespace": {
+		kind:  stringKind,
+		owner: true,
+		str: func(x *exchange) (string, bool) {
+			if o := x.getOwner(); o != nil && o.Pod != nil && o.Pod.Namespace != "" {
+				return o.Pod.Namespace, true
+			}
+			return "", false
+		},
+	},
+	"request_content_type": {
+		kind:     stringKind,
+		foldCase: true,
+		str: func(x *exchange) (string, bool) {
+			if x.req == nil {
+				return "", false
+			}
+			return mediaType(x.req.Header.Get("Content-Type"))
+		},
+	},
+	"response_content_type": {
+		kind:     stringKind,
+		response: true,
+		foldCase: true,
+		str: func(x *exchange) (string, bool) {
+			if x.resp == nil {
+				return "", false
+			}
+			return mediaType(x.resp.Header.Get("Content-Type"))
+		},
+	},
+}
+
+func init() {
+	for name, f := range fields {
+		f.name = name
+	}
+}
+
+// Returns the field with the given name. Headers are named
+// request_header[Name] or response_header[Name]; header[Name] is short for
+// request_header[Name]. Pod labels are named pod_label[name].
+func lookupField(name string) (*field, error) {
+	if f, ok := fields[strings.ToLower(name)]; ok {
+		return f, nil
+	}
+
+	open := strings.Index(name, "[")
+	if open < 0 || !strings.HasSuffix(name, "]") || open+2 > len(name)-1 {
+		return nil, errors.Errorf("unknown field %q", name)
+	}
+	key := name[open+1 : len(name)-1]
+	header := textproto.CanonicalMIMEHeaderKey(key)
+
+	switch strings.ToLower(name[:open]) {
+	case "pod_label":
+		return &field{
+			name:  name,
+			kind:  stringKind,
+			owner: true,
+			str: func(x *exchange) (string, bool) {
+				o := x.getOwner()
+				if o == nil || o.Pod == nil {
+					return "", false
+				}
+				v, ok := o.Pod.Labels[key]
+				return v, ok
+			},
+		}, nil
+	case "header", "request_header":
+		return &field{
+			name: name,
+			kind: stringKind,
+			str: func(x *exchange) (string, bool) {
+				if x.req == nil {
+					return "", false
+				}
+				return headerValue(x.req.Header, header)
+			},
+		}, nil
+	case "response_header":
+		return &field{
+			name:     name,
+			kind:     stringKind,
+			response: true,
+			str: func(x *exchange) (string, bool) {
+				if x.resp == nil {
+					return "", false
+				}
+				return headerValue(x.resp.Header, header)
+			},
+		}, nil
+	}
+	return nil, errors.Errorf("unknown field %q", name)
+}
+
+// Returns the values of a header joined by commas, and whether it is present.
+func headerValue(h map[string][]string, name string) (string, bool) {
+	values, ok := h[name]
+	if !ok {
+		return "", false
+	}
+	return strings.Join(values, ","), true
+}
+
+// Returns a content type without its parameters, e.g. "application/json"
+// for "application/json; charset=utf-8".
+func mediaType(contentType string) (string, bool) {
+	if contentType == "" {
+		return "", false
+	}
+	if mt, _, err := mime.ParseMediaType(contentType); err == nil {
+		return mt, true
+	}
+	return contentType, true
+}
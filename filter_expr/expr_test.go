@@ -0,0 +1,202 @@
+package filter_expr
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/akitasoftware/akita-cli/process_info"
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+func newRequest(method, path string, header http.Header, body string) *akinet.ParsedNetworkTraffic {
+	u, _ := url.Parse(path)
+	return &akinet.ParsedNetworkTraffic{
+		SrcIP:   net.ParseIP("10.1.2.3"),
+		SrcPort: 51234,
+		DstIP:   net.ParseIP("192.168.0.1"),
+		DstPort: 8080,
+		Content: akinet.HTTPRequest{
+			Method: method,
+			URL:    u,
+			Host:   "api.example.com",
+			Header: header,
+			Body:   []byte(body),
+		},
+	}
+}
+
+func newResponse(status int, header http.Header, body string) *akinet.ParsedNetworkTraffic {
+	return &akinet.ParsedNetworkTraffic{
+		SrcIP:   net.ParseIP("192.168.0.1"),
+		SrcPort: 8080,
+		DstIP:   net.ParseIP("10.1.2.3"),
+		DstPort: 51234,
+		Content: akinet.HTTPResponse{
+			StatusCode: status,
+			Header:     header,
+			Body:       []byte(body),
+		},
+	}
+}
+
+func TestMatch(t *testing.T) {
+	req := newRequest("POST", "/v1/orders", http.Header{"X-Debug": {"1"}}, "{}")
+	resp := newResponse(503, http.Header{"Content-Type": {"application/json; charset=utf-8"}}, strings.Repeat("x", 2<<20))
+
+	testCases := []struct {
+		expr     string
+		expected bool
+	}{
+		{"method = POST and status >= 500", true},
+		{"method = post and status < 500", false},
+		{"method in GET,POST", true},
+		{"header[X-Debug] present", true},
+		{"header[x-debug] = 1", true},
+		{"response_header[X-Debug] present", false},
+		{"not request_header[Authorization] present", true},
+		{"client_ip in 10.0.0.0/8", true},
+		{"server_ip = 192.168.0.1 and server_port = 8080", true},
+		{"client_ip in 172.16.0.0/12", false},
+		{"response_content_type ~ json and response_size > 1MB", true},
+		{"response_content_type = application/json", true},
+		{"request_content_type present", false},
+		{"request_size <= 2B", true},
+		{`path ~ "^/v1/" and host = API.example.com`, true},
+		{"path !~ orders or (status = 503 and not method = GET)", true},
+		{"status != 503", false},
+	}
+	for _, tc := range testCases {
+		e, err := Compile(tc.expr)
+		if assert.NoError(t, err, tc.expr) {
+			assert.Equal(t, tc.expected, e.Match(req, resp), tc.expr)
+		}
+	}
+}
+
+func TestMatchPartial(t *testing.T) {
+	status, err := Compile("status >= 500")
+	assert.NoError(t, err)
+	assert.True(t, status.NeedsResponse())
+
+	// Response fields are unknown without the response, and request fields
+	// without the request.
+	req := newRequest("GET", "/", nil, "")
+	assert.False(t, status.Match(req, nil))
+	notStatus, _ := Compile("not status >= 500")
+	assert.True(t, notStatus.Match(req, nil))
+
+	method, err := Compile("method = GET and client_ip in 10.0.0.0/8")
+	assert.NoError(t, err)
+	assert.False(t, method.NeedsResponse())
+	assert.False(t, method.Match(nil, newResponse(200, nil, "")))
+
+	// Addresses come from the response when the request wasn't seen.
+	client, _ := Compile("client_ip = 10.1.2.3")
+	assert.True(t, client.Match(nil, newResponse(200, nil, "")))
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, src := range []string{
+		"",
+		"method",
+		"method =",
+		"nonsense = 1",
+		"status > abc",
+		"path < 3",
+		"client_ip = 10.0.0.0/8",
+		"client_ip in 10.0.0.0/33",
+		"(method = GET",
+		"method = GET extra",
+		`path = "unterminated`,
+		"path ~ (",
+		"status & 1",
+	} {
+		_, err := Compile(src)
+		assert.Error(t, err, src)
+	}
+}
+
+func TestRules(t *testing.T) {
+	rules := Rules{
+		Allow:   []string{"method = GET", "status >= 500"},
+		Exclude: []string{"path ~ ^/health"},
+	}
+	e, err := rules.Compile()
+	assert.NoError(t, err)
+	assert.True(t, e.NeedsResponse())
+	assert.Equal(t, "((method = GET) or (status >= 500)) and not (path ~ ^/health)", e.String())
+
+	assert.True(t, e.Match(newRequest("GET", "/users", nil, ""), nil))
+	assert.False(t, e.Match(newRequest("GET", "/healthz", nil, ""), nil))
+	assert.False(t, e.Match(newRequest("POST", "/users", nil, ""), newResponse(201, nil, "")))
+	assert.True(t, e.Match(newRequest("POST", "/users", nil, ""), newResponse(502, nil, "")))
+
+	e, err = Rules{}.Compile()
+	assert.NoError(t, err)
+	assert.Nil(t, e)
+
+	_, err = Rules{Exclude: []string{"bogus"}}.Compile()
+	assert.Error(t, err)
+}
+
+// Attributes traffic to the server at 192.168.0.1:8080.
+type fakeOwners struct{}
+
+func (fakeOwners) ForTraffic(t akinet.ParsedNetworkTraffic) *process_info.Owner {
+	if (t.DstIP.Equal(net.ParseIP("192.168.0.1")) && t.DstPort == 8080) || (t.SrcIP.Equal(net.ParseIP("192.168.0.1")) && t.SrcPort == 8080) {
+		return &process_info.Owner{
+			PID:         42,
+			Command:     "checkout",
+			ContainerID: "3f4e8a1b2c5d",
+			Pod: &process_info.Pod{
+				Name:      "checkout-7d9f",
+				Namespace: "shop",
+				Labels:    map[string]string{"app": "checkout"},
+			},
+		}
+	}
+	return nil
+}
+
+func TestMatchOwner(t *testing.T) {
+	req := newRequest("GET", "/cart", nil, "")
+	other := newRequest("GET", "/cart", nil, "")
+	other.DstPort = 9090
+
+	for _, src := range []string{
+		"pid = 42",
+		"process = checkout",
+		"container ~ ^3f4e",
+		"pod = checkout-7d9f and pod_namespace = shop",
+		"pod_label[app] = checkout",
+	} {
+		e, err := Compile(src)
+		if !assert.NoError(t, err, src) {
+			continue
+		}
+		assert.True(t, e.NeedsOwners(), src)
+		assert.False(t, e.NeedsResponse(), src)
+
+		// Without a resolver, owner fields are never known.
+		assert.False(t, e.Match(req, nil), src)
+
+		e.SetOwners(fakeOwners{})
+		assert.True(t, e.Match(req, nil), src)
+		assert.True(t, e.Match(nil, newResponse(200, nil, "")), src)
+		assert.False(t, e.Match(other, nil), src)
+	}
+
+	e, err := Compile("pod_label[tier] present")
+	assert.NoError(t, err)
+	e.SetOwners(fakeOwners{})
+	assert.False(t, e.Match(req, nil))
+
+	e, err = Compile("method = GET")
+	assert.NoError(t, err)
+	assert.False(t, e.NeedsOwners())
+}
@@ -0,0 +1,395 @@
+package filter_expr
+
+import (
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+type tokenKind int
+
+const (
+	eofToken tokenKind = iota
+	wordToken
+	stringToken
+	opToken
+	lparenToken
+	rparenToken
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// Characters that end a word.
+const delimiters = `()"=!<>~`
+
+var operators = []string{"==", "!=", "!~", "<=", ">=", "=", "<", ">", "~"}
+
+// Splits an expression into tokens. Words are runs of characters other than
+// whitespace and delimiters, so that values such as 10.0.0.0/8, 1MB and
+// GET,POST need no quotes.
+func tokenize(src string) ([]token, error) {
+	var result []token
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			result = append(result, token{kind: lparenToken, text: "(", pos: i})
+			i++
+		case c == ')':
+			result = append(result, token{kind: rparenToken, text: ")", pos: i})
+			i++
+		case c == '"':
+			end := i + 1
+			for ; end < len(src) && src[end] != '"'; end++ {
+				if src[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(src) {
+				return nil, errors.Errorf("unterminated string at position %d", i)
+			}
+			s, err := strconv.Unquote(src[i : end+1])
+			if err != nil {
+				return nil, errors.Errorf("invalid string at position %d", i)
+			}
+			result = append(result, token{kind: stringToken, text: s, pos: i})
+			i = end + 1
+		case strings.ContainsRune(delimiters, c):
+			op := ""
+			for _, o := range operators {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, errors.Errorf("unexpected %q at position %d", c, i)
+			}
+			result = append(result, token{kind: opToken, text: op, pos: i})
+			i += len(op)
+		default:
+			end := i
+			for end < len(src) && !unicode.IsSpace(rune(src[end])) && !strings.ContainsRune(delimiters, rune(src[end])) {
+				end++
+			}
+			result = append(result, token{kind: wordToken, text: src[i:end], pos: i})
+			i = end
+		}
+	}
+	return append(result, token{kind: eofToken, pos: len(src)}), nil
+}
+
+// Recursive-descent parser for the grammar
+//
+//	expr    = and { "or" and }
+//	and     = not { "and" not }
+//	not     = "not" not | primary
+//	primary = "(" expr ")" | field "present" | field "in" value | field op value
+type parser struct {
+	tokens []token
+	next   int
+
+	// Set if any field parsed is only known once the response is seen.
+	needsResponse bool
+
+	// Set if any field parsed describes the process that handled the exchange.
+	needsOwners bool
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) advance() token {
+	t := p.tokens[p.next]
+	if t.kind != eofToken {
+		p.next++
+	}
+	return t
+}
+
+// Returns true and consumes the next token if it is the given keyword.
+func (p *parser) keyword(kw string) bool {
+	t := p.peek()
+	if t.kind == wordToken && strings.EqualFold(t.text, kw) {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseExpr() (predicate, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = or(left, right)
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (predicate, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = and(left, right)
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (predicate, error) {
+	if p.keyword("not") {
+		inner, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return not(inner), nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (predicate, error) {
+	t := p.advance()
+	switch t.kind {
+	case lparenToken:
+		inner, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if end := p.advance(); end.kind != rparenToken {
+			return nil, errors.Errorf("expected ) at position %d", end.pos)
+		}
+		return inner, nil
+	case wordToken:
+	case eofToken:
+		return nil, errors.New("unexpected end of expression")
+	default:
+		return nil, errors.Errorf("expected a field name at position %d, found %q", t.pos, t.text)
+	}
+
+	f, err := lookupField(t.text)
+	if err != nil {
+		return nil, errors.Wrapf(err, "at position %d", t.pos)
+	}
+	if f.response {
+		p.needsResponse = true
+	}
+	if f.owner {
+		p.needsOwners = true
+	}
+
+	if p.keyword("present") {
+		return present(f), nil
+	}
+
+	op := p.advance()
+	if op.kind == wordToken && strings.EqualFold(op.text, "in") {
+		op.text = "in"
+	} else if op.kind != opToken {
+		return nil, errors.Errorf("expected an operator after %s at position %d", f.name, op.pos)
+	}
+
+	value := p.advance()
+	if value.kind != wordToken && value.kind != stringToken {
+		return nil, errors.Errorf("expected a value after %s %s at position %d", f.name, op.text, value.pos)
+	}
+
+	pred, err := compare(f, op.text, value.text)
+	if err != nil {
+		return nil, errors.Wrapf(err, "at position %d", op.pos)
+	}
+	return pred, nil
+}
+
+// Builds the predicate for a comparison between a field and a literal value.
+func compare(f *field, op, value string) (predicate, error) {
+	switch f.kind {
+	case stringKind:
+		return compareString(f, op, value)
+	case numberKind:
+		return compareNumber(f, op, value)
+	case ipKind:
+		return compareIP(f, op, value)
+	}
+	return nil, errors.Errorf("unknown kind of field %s", f.name)
+}
+
+func compareString(f *field, op, value string) (predicate, error) {
+	equal := func(a, b string) bool { return a == b }
+	if f.foldCase {
+		equal = strings.EqualFold
+	}
+
+	switch op {
+	case "=", "==", "!=":
+		want := op != "!="
+		return func(x *exchange) bool {
+			v, ok := f.str(x)
+			return ok && equal(v, value) == want
+		}, nil
+	case "~", "!~":
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid regular expression %q", value)
+		}
+		want := op == "~"
+		return func(x *exchange) bool {
+			v, ok := f.str(x)
+			return ok && re.MatchString(v) == want
+		}, nil
+	case "in":
+		values := splitList(value)
+		return func(x *exchange) bool {
+			v, ok := f.str(x)
+			if !ok {
+				return false
+			}
+			for _, want := range values {
+				if equal(v, want) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+	return nil, errors.Errorf("operator %s can't be used with %s", op, f.name)
+}
+
+func compareNumber(f *field, op, value string) (predicate, error) {
+	if op == "in" {
+		var values []int64
+		for _, s := range splitList(value) {
+			n, err := f.parse(s)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, n)
+		}
+		return func(x *exchange) bool {
+			v, ok := f.num(x)
+			if !ok {
+				return false
+			}
+			for _, want := range values {
+				if v == want {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+
+	want, err := f.parse(value)
+	if err != nil {
+		return nil, err
+	}
+	var cmp func(a, b int64) bool
+	switch op {
+	case "=", "==":
+		cmp = func(a, b int64) bool { return a == b }
+	case "!=":
+		cmp = func(a, b int64) bool { return a != b }
+	case "<":
+		cmp = func(a, b int64) bool { return a < b }
+	case "<=":
+		cmp = func(a, b int64) bool { return a <= b }
+	case ">":
+		cmp = func(a, b int64) bool { return a > b }
+	case ">=":
+		cmp = func(a, b int64) bool { return a >= b }
+	default:
+		return nil, errors.Errorf("operator %s can't be used with %s", op, f.name)
+	}
+	return func(x *exchange) bool {
+		v, ok := f.num(x)
+		return ok && cmp(v, want)
+	}, nil
+}
+
+func compareIP(f *field, op, value string) (predicate, error) {
+	switch op {
+	case "=", "==", "!=":
+		want := net.ParseIP(value)
+		if want == nil {
+			return nil, errors.Errorf("invalid IP address %q", value)
+		}
+		equal := op != "!="
+		return func(x *exchange) bool {
+			v, ok := f.ip(x)
+			return ok && v.Equal(want) == equal
+		}, nil
+	case "in":
+		var nets []*net.IPNet
+		for _, s := range splitList(value) {
+			if !strings.Contains(s, "/") {
+				ip := net.ParseIP(s)
+				if ip == nil {
+					return nil, errors.Errorf("invalid IP address %q", s)
+				}
+				bits := 8 * len(ip)
+				if ip4 := ip.To4(); ip4 != nil {
+					ip, bits = ip4, 32
+				}
+				nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+			_, n, err := net.ParseCIDR(s)
+			if err != nil {
+				return nil, errors.Errorf("invalid CIDR block %q", s)
+			}
+			nets = append(nets, n)
+		}
+		return func(x *exchange) bool {
+			v, ok := f.ip(x)
+			if !ok {
+				return false
+			}
+			for _, n := range nets {
+				if n.Contains(v) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+	return nil, errors.Errorf("operator %s can't be used with %s", op, f.name)
+}
+
+func splitList(s string) []string {
+	var result []string
+	for _, elt := range strings.Split(s, ",") {
+		if elt = strings.TrimSpace(elt); elt != "" {
+			result = append(result, elt)
+		}
+	}
+	return result
+}
+
+func parseInt(s string) (int64, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, errors.Errorf("invalid number %q", s)
+	}
+	return n, nil
+}
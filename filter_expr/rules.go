@@ -0,0 +1,109 @@
+package filter_expr
+
+import (
+	"io/ioutil"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+)
+
+// Format of the filter rules file, e.g.
+//
+//	allow:
+//	  - host = api.example.com
+//	exclude:
+//	  - path ~ ^/health
+//	  - method = OPTIONS
+//
+// Traffic is kept if it matches any allow rule, or there are none, and
+// matches no exclude rule.
+type Rules struct {
+	Allow   []string `json:"allow"`
+	Exclude []string `json:"exclude"`
+}
+
+// Reads filter rules from a YAML or JSON file.
+func LoadRules(path string) (Rules, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Rules{}, errors.Wrap(err, "failed to read filter rules")
+	}
+
+	var rules Rules
+	if err := yaml.Unmarshal(content, &rules); err != nil {
+		return Rules{}, errors.Wrapf(err, "failed to parse filter rules %s", path)
+	}
+	return rules, nil
+}
+
+func (r Rules) Empty() bool {
+	return len(r.Allow) == 0 && len(r.Exclude) == 0
+}
+
+// Compiles the rules into a single expression that matches the traffic to
+// keep. Returns nil if there are no rules.
+func (r Rules) Compile() (*Expr, error) {
+	if r.Empty() {
+		return nil, nil
+	}
+
+	var allow, exclude predicate
+	result := &Expr{}
+	for _, src := range r.Allow {
+		e, err := Compile(src)
+		if err != nil {
+			return nil, err
+		}
+		allow = orMaybe(allow, e.pred)
+		result.needsResponse = result.needsResponse || e.needsResponse
+		result.needsOwners = result.needsOwners || e.needsOwners
+	}
+	for _, src := range r.Exclude {
+		e, err := Compile(src)
+		if err != nil {
+			return nil, err
+		}
+		exclude = orMaybe(exclude, e.pred)
+		result.needsResponse = result.needsResponse || e.needsResponse
+		result.needsOwners = result.needsOwners || e.needsOwners
+	}
+
+	switch {
+	case allow == nil:
+		result.pred = not(exclude)
+	case exclude == nil:
+		result.pred = allow
+	default:
+		result.pred = and(allow, not(exclude))
+	}
+	result.src = r.String()
+	return result, nil
+}
+
+// Renders the rules as a single expression.
+func (r Rules) String() string {
+	s := ""
+	for i, src := range r.Allow {
+		if i > 0 {
+			s += " or "
+		}
+		s += "(" + src + ")"
+	}
+	if len(r.Allow) > 1 {
+		s = "(" + s + ")"
+	}
+	for _, src := range r.Exclude {
+		if s != "" {
+			s += " and "
+		}
+		s += "not (" + src + ")"
+	}
+	return s
+}
+
+func orMaybe(l, r predicate) predicate {
+	if l == nil {
+		return r
+	}
+	return or(l, r)
+}
@@ -1,12 +1,17 @@
 package har_loader
 
 import (
+	"compress/gzip"
 	"encoding/json"
+	"io"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/google/martian/v3/har"
 	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-libs/tags"
 )
 
 // Custom HAR loader to bypass type differences between martian/v3/har and
@@ -48,14 +53,50 @@ type CustomHAREntry struct {
 	Response        *har.Response  `json:"response"`
 	Comment         string         `json:"comment"`
 	Timings         *CustomTimings `json:"timings"`
+
+	// Tags of this entry alone, written by apidump.
+	Tags map[tags.Key]string `json:"_akita_tags,omitempty"`
+}
+
+// Returns true if the file at path is a gzipped HAR file, based on its name.
+func IsGzipped(path string) bool {
+	return strings.HasSuffix(strings.ToLower(path), ".gz")
+}
+
+// Opens a HAR file for reading, decompressing it if it is gzipped.
+func Open(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open HAR file")
+	}
+	if !IsGzipped(path) {
+		return f, nil
+	}
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "failed to decompress HAR file")
+	}
+	return gzipFile{Reader: gz, file: f}, nil
+}
+
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (g gzipFile) Close() error {
+	g.Reader.Close()
+	return g.file.Close()
 }
 
 func LoadCustomHARFromFile(path string) (CustomHAR, error) {
 	var harContent CustomHAR
 
-	f, err := os.Open(path)
+	f, err := Open(path)
 	if err != nil {
-		return harContent, errors.Wrap(err, "failed to open HAR file")
+		return harContent, err
 	}
 	defer f.Close()
 
@@ -0,0 +1,224 @@
+package har_writer
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/martian/v3/har"
+
+	"github.com/akitasoftware/akita-cli/printer"
+	"github.com/akitasoftware/akita-libs/tags"
+)
+
+const (
+	// Requests whose responses have not been seen within this long are written
+	// without a response.
+	DefaultUnpairedTimeout = time.Minute
+
+	// How often buffered entries are flushed to disk and unpaired entries are
+	// checked for timeouts.
+	flushInterval = 5 * time.Second
+)
+
+// Pairs up requests and responses by ID and streams the resulting entries to
+// a Writer as soon as they are complete. Unlike har.Logger, nothing is kept
+// in memory once an entry is written.
+type Logger struct {
+	w               *Writer
+	unpairedTimeout time.Duration
+
+	mutex   sync.Mutex
+	pending map[string]*pendingEntry
+
+	// Channel controlling the periodic flush, and the goroutine doing it.
+	done    chan struct{}
+	flushWG sync.WaitGroup
+
+	flushErr error
+}
+
+// An entry waiting for its request or response.
+type pendingEntry struct {
+	entry *har.Entry
+
+	// Set if the response arrived first.
+	response           *har.Response
+	responseTimestamps *har.MessageTimestamps
+
+	requestTimestamps *har.MessageTimestamps
+	added             time.Time
+
+	// Tags of the entry, from the request.
+	tags map[tags.Key]string
+}
+
+func NewLogger(w *Writer, unpairedTimeout time.Duration) *Logger {
+	l := &Logger{
+		w:               w,
+		unpairedTimeout: unpairedTimeout,
+		pending:         make(map[string]*pendingEntry),
+		done:            make(chan struct{}),
+	}
+	l.flushWG.Add(1)
+	go l.periodicFlush()
+	return l
+}
+
+// Records a request. Timestamps are optional; if nil, the current time is
+// used as the start time.
+func (l *Logger) RecordRequest(id string, req *http.Request, ts *har.MessageTimestamps) error {
+	return l.RecordTaggedRequest(id, req, ts, nil)
+}
+
+// Records a request whose entry is written with the given tags, which may be
+// nil.
+func (l *Logger) RecordTaggedRequest(id string, req *http.Request, ts *har.MessageTimestamps, entryTags map[tags.Key]string) error {
+	hreq, err := har.NewRequest(req, true)
+	if err != nil {
+		return err
+	}
+
+	entry := &har.Entry{
+		ID:              id,
+		StartedDateTime: time.Now().UTC(),
+		Request:         hreq,
+		Cache:           &har.Cache{},
+		Timings:         &har.Timings{},
+	}
+	if ts != nil {
+		entry.StartedDateTime = ts.StartTime
+		entry.Timings.Send = durationToMilliseconds(ts.EndTime.Sub(ts.StartTime))
+	}
+
+	l.mutex.Lock()
+	p, ok := l.pending[id]
+	if !ok {
+		l.pending[id] = &pendingEntry{entry: entry, requestTimestamps: ts, added: time.Now(), tags: entryTags}
+		l.mutex.Unlock()
+		return nil
+	}
+	delete(l.pending, id)
+	l.mutex.Unlock()
+
+	p.entry = entry
+	p.requestTimestamps = ts
+	p.tags = entryTags
+	return l.complete(p, p.response, p.responseTimestamps)
+}
+
+// Records a response. Timestamps are optional.
+func (l *Logger) RecordResponse(id string, res *http.Response, ts *har.MessageTimestamps) error {
+	hres, err := har.NewResponse(res, true)
+	if err != nil {
+		return err
+	}
+
+	l.mutex.Lock()
+	p, ok := l.pending[id]
+	if !ok || p.entry == nil {
+		// Wait for the request.
+		l.pending[id] = &pendingEntry{response: hres, responseTimestamps: ts, added: time.Now()}
+		l.mutex.Unlock()
+		return nil
+	}
+	delete(l.pending, id)
+	l.mutex.Unlock()
+
+	return l.complete(p, hres, ts)
+}
+
+// Adds the response to the entry and writes it.
+func (l *Logger) complete(p *pendingEntry, res *har.Response, ts *har.MessageTimestamps) error {
+	e := p.entry
+	e.Response = res
+	if ts != nil {
+		// |-----------------------Time---------------------|
+		// |   send      |    wait       |     receive      |
+		// |<-request--->|               |<---response----->|
+		e.Time = durationToMilliseconds(ts.EndTime.Sub(e.StartedDateTime))
+		if p.requestTimestamps != nil {
+			e.Timings.Wait = durationToMilliseconds(ts.StartTime.Sub(p.requestTimestamps.EndTime))
+			e.Timings.Receive = durationToMilliseconds(ts.EndTime.Sub(ts.StartTime))
+		}
+	} else {
+		e.Time = durationToMilliseconds(time.Since(e.StartedDateTime))
+	}
+	return l.w.WriteTaggedEntry(e, p.tags)
+}
+
+// Writes requests that have been waiting for their responses since before
+// cutoff, and drops responses that have been waiting for their requests. A
+// zero cutoff flushes everything.
+func (l *Logger) flushUnpaired(cutoff time.Time) error {
+	l.mutex.Lock()
+	var expired []*pendingEntry
+	for id, p := range l.pending {
+		if cutoff.IsZero() || p.added.Before(cutoff) {
+			expired = append(expired, p)
+			delete(l.pending, id)
+		}
+	}
+	l.mutex.Unlock()
+
+	dropped := 0
+	for _, p := range expired {
+		if p.entry == nil {
+			dropped++
+			continue
+		}
+		if err := l.w.WriteTaggedEntry(p.entry, p.tags); err != nil {
+			return err
+		}
+	}
+	if dropped > 0 {
+		printer.Debugf("Dropped %d HAR responses without matching requests\n", dropped)
+	}
+	return nil
+}
+
+func (l *Logger) periodicFlush() {
+	defer l.flushWG.Done()
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := l.flushUnpaired(time.Now().Add(-l.unpairedTimeout))
+			if err == nil {
+				err = l.w.Flush()
+			}
+			if err != nil {
+				printer.Errorf("Failed to write HAR file: %v\n", err)
+				l.mutex.Lock()
+				l.flushErr = err
+				l.mutex.Unlock()
+			}
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// Writes all unpaired requests and completes the current file, once any
+// periodic flush in progress has finished.
+func (l *Logger) Close() error {
+	close(l.done)
+	l.flushWG.Wait()
+
+	if err := l.flushUnpaired(time.Time{}); err != nil {
+		return err
+	}
+	if err := l.w.Close(); err != nil {
+		return err
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.flushErr
+}
+
+func durationToMilliseconds(d time.Duration) float32 {
+	return float32(d.Microseconds()) / 1000.0
+}
@@ -0,0 +1,294 @@
+package har_writer
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/martian/v3/har"
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-cli/version"
+	"github.com/akitasoftware/akita-libs/tags"
+)
+
+// Suffix of files that are still being written. Files are renamed once they
+// are complete, so any file without this suffix is a valid HAR file.
+const partialSuffix = ".partial"
+
+// Controls when a Writer starts a new file. A zero value disables the
+// corresponding limit.
+type Options struct {
+	// Maximum number of uncompressed bytes per file.
+	MaxFileBytes int64
+
+	// Maximum number of entries per file.
+	MaxFileEntries int
+
+	// Maximum time a file is kept open.
+	RotateInterval time.Duration
+
+	// If set, files are gzipped and given a .gz suffix.
+	Gzip bool
+}
+
+// Streams HAR entries to files in a directory, rotating them according to
+// Options. The first file is named <baseName>.har, and later ones
+// <baseName>.1.har, <baseName>.2.har, and so on.
+type Writer struct {
+	outDir   string
+	baseName string
+	opts     Options
+	ext      har.AkitaExtension
+
+	mutex   sync.Mutex
+	current *harFile
+	files   []string
+}
+
+func NewWriter(outDir, baseName string, opts Options, tags map[tags.Key]string) *Writer {
+	return &Writer{
+		outDir:   outDir,
+		baseName: baseName,
+		opts:     opts,
+		ext: har.AkitaExtension{
+			Outbound: false,
+			Tags:     tags,
+		},
+	}
+}
+
+// Returns the path of the nth file (counting from 0) written for baseName.
+func FilePath(outDir, baseName string, n int, gzipped bool) string {
+	name := baseName + ".har"
+	if n > 0 {
+		name = fmt.Sprintf("%s.%d.har", baseName, n)
+	}
+	if gzipped {
+		name += ".gz"
+	}
+	return filepath.Join(outDir, name)
+}
+
+// Returns the paths of all completed files written for baseName, including
+// files written by earlier runs.
+func FilePaths(outDir, baseName string) ([]string, error) {
+	var result []string
+	for _, pattern := range []string{"%s.har", "%s.*.har", "%s.har.gz", "%s.*.har.gz"} {
+		matches, err := filepath.Glob(filepath.Join(outDir, fmt.Sprintf(pattern, baseName)))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, matches...)
+	}
+	return result, nil
+}
+
+// An entry with tags of its own, in addition to the tags of the file.
+type taggedEntry struct {
+	*har.Entry
+	Tags map[tags.Key]string `json:"_akita_tags,omitempty"`
+}
+
+func (w *Writer) WriteEntry(e *har.Entry) error {
+	return w.WriteTaggedEntry(e, nil)
+}
+
+// Writes an entry with tags of its own, which may be nil.
+func (w *Writer) WriteTaggedEntry(e *har.Entry, entryTags map[tags.Key]string) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.current != nil && w.shouldRotate(time.Now()) {
+		if err := w.closeCurrent(); err != nil {
+			return err
+		}
+	}
+	if w.current == nil {
+		if err := w.openNext(); err != nil {
+			return err
+		}
+	}
+	return w.current.writeEntry(taggedEntry{Entry: e, Tags: entryTags})
+}
+
+// Flushes buffered entries to disk, and closes the current file if it has
+// been open longer than the rotation interval.
+func (w *Writer) Flush() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.current == nil {
+		return nil
+	}
+	if w.opts.RotateInterval > 0 && time.Since(w.current.opened) >= w.opts.RotateInterval {
+		return w.closeCurrent()
+	}
+	return w.current.flush()
+}
+
+// Completes the current file. The Writer may be reused afterwards, in which
+// case it starts a new file.
+func (w *Writer) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.current == nil {
+		return nil
+	}
+	return w.closeCurrent()
+}
+
+// Returns the paths of the files completed so far.
+func (w *Writer) Files() []string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return append([]string(nil), w.files...)
+}
+
+func (w *Writer) shouldRotate(now time.Time) bool {
+	c := w.current
+	return (w.opts.MaxFileBytes > 0 && c.bytes >= w.opts.MaxFileBytes) ||
+		(w.opts.MaxFileEntries > 0 && c.entries >= w.opts.MaxFileEntries) ||
+		(w.opts.RotateInterval > 0 && now.Sub(c.opened) >= w.opts.RotateInterval)
+}
+
+func (w *Writer) openNext() error {
+	p := FilePath(w.outDir, w.baseName, len(w.files), w.opts.Gzip)
+	f, err := newHARFile(p, w.opts.Gzip)
+	if err != nil {
+		return err
+	}
+	w.current = f
+	return nil
+}
+
+func (w *Writer) closeCurrent() error {
+	c := w.current
+	w.current = nil
+	if err := c.close(w.ext); err != nil {
+		return err
+	}
+	w.files = append(w.files, c.path)
+	return nil
+}
+
+// A HAR file being written. The log header is written when the file is
+// created, and the closing brackets when it is closed, so entries can be
+// streamed in between.
+type harFile struct {
+	path    string
+	file    *os.File
+	gz      *gzip.Writer
+	buf     *bufio.Writer
+	opened  time.Time
+	bytes   int64
+	entries int
+}
+
+func newHARFile(path string, gzipped bool) (*harFile, error) {
+	f, err := os.OpenFile(path+partialSuffix, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create HAR file")
+	}
+
+	h := &harFile{
+		path:   path,
+		file:   f,
+		opened: time.Now(),
+	}
+	var out io.Writer = f
+	if gzipped {
+		h.gz = gzip.NewWriter(f)
+		out = h.gz
+	}
+	h.buf = bufio.NewWriter(out)
+
+	creator, err := json.Marshal(har.Creator{
+		Name:    "Akita SuperLearn (https://akitasoftware.com)",
+		Version: version.CLIDisplayString(),
+	})
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "failed to marshal HAR creator")
+	}
+	if err := h.write([]byte(`{"log":{"version":"1.2","creator":`), creator, []byte(`,"entries":[`)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *harFile) write(chunks ...[]byte) error {
+	for _, c := range chunks {
+		n, err := h.buf.Write(c)
+		h.bytes += int64(n)
+		if err != nil {
+			return errors.Wrap(err, "failed to write HAR file")
+		}
+	}
+	return nil
+}
+
+func (h *harFile) writeEntry(e taggedEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal HAR entry to JSON")
+	}
+	if h.entries > 0 {
+		if err := h.write([]byte(",")); err != nil {
+			return err
+		}
+	}
+	if err := h.write(b); err != nil {
+		return err
+	}
+	h.entries++
+	return nil
+}
+
+func (h *harFile) flush() error {
+	if err := h.buf.Flush(); err != nil {
+		return errors.Wrap(err, "failed to flush HAR file")
+	}
+	if h.gz != nil {
+		if err := h.gz.Flush(); err != nil {
+			return errors.Wrap(err, "failed to flush HAR file")
+		}
+	}
+	return nil
+}
+
+// Writes the end of the log and renames the file to its final name.
+func (h *harFile) close(ext har.AkitaExtension) error {
+	defer h.file.Close()
+
+	extBytes, err := json.Marshal(ext)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal HAR extension")
+	}
+	if err := h.write([]byte(`]},"akita_ext":`), extBytes, []byte("}")); err != nil {
+		return err
+	}
+	if err := h.flush(); err != nil {
+		return err
+	}
+	if h.gz != nil {
+		if err := h.gz.Close(); err != nil {
+			return errors.Wrap(err, "failed to finish gzipped HAR file")
+		}
+	}
+	if err := h.file.Close(); err != nil {
+		return errors.Wrap(err, "failed to close HAR file")
+	}
+	if err := os.Rename(h.path+partialSuffix, h.path); err != nil {
+		return errors.Wrap(err, "failed to rename HAR file")
+	}
+	return nil
+}
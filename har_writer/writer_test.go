@@ -0,0 +1,101 @@
+package har_writer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/akitasoftware/akita-cli/har_loader"
+	"github.com/akitasoftware/akita-libs/tags"
+)
+
+func recordPair(t *testing.T, l *Logger, id string) {
+	req := httptest.NewRequest("GET", "http://example.com/v1/"+id, nil)
+	res := &http.Response{StatusCode: 200, Proto: "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1, Header: http.Header{}}
+	assert.NoError(t, l.RecordRequest(id, req, nil))
+	assert.NoError(t, l.RecordResponse(id, res, nil))
+}
+
+func TestRotateByEntries(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWriter(dir, "akita_lo", Options{MaxFileEntries: 2, Gzip: true}, nil)
+	l := NewLogger(w, time.Hour)
+
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		recordPair(t, l, id)
+	}
+
+	// Only completed files are visible.
+	assert.Equal(t, []string{
+		FilePath(dir, "akita_lo", 0, true),
+		FilePath(dir, "akita_lo", 1, true),
+	}, w.Files())
+	_, err := os.Stat(FilePath(dir, "akita_lo", 2, true) + partialSuffix)
+	assert.NoError(t, err)
+
+	assert.NoError(t, l.Close())
+	assert.Len(t, w.Files(), 3)
+
+	found, err := FilePaths(dir, "akita_lo")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, w.Files(), found)
+
+	var entries int
+	for _, p := range w.Files() {
+		h, err := har_loader.LoadCustomHARFromFile(p)
+		if assert.NoError(t, err, p) {
+			entries += len(h.Log.Entries)
+		}
+	}
+	assert.Equal(t, 5, entries)
+
+	matches, _ := filepath.Glob(filepath.Join(dir, "*"+partialSuffix))
+	assert.Empty(t, matches)
+}
+
+func TestUnpairedEntries(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWriter(dir, "akita_lo", Options{}, nil)
+	l := NewLogger(w, time.Hour)
+
+	// The response arrives before its request.
+	res := &http.Response{StatusCode: 204, Proto: "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1, Header: http.Header{}}
+	assert.NoError(t, l.RecordResponse("a", res, nil))
+	assert.NoError(t, l.RecordRequest("a", httptest.NewRequest("GET", "http://example.com/a", nil), nil))
+
+	// A request that never gets a response, and a response that never gets a
+	// request.
+	entryTags := map[tags.Key]string{"x-akita-pid": "42"}
+	assert.NoError(t, l.RecordTaggedRequest("b", httptest.NewRequest("GET", "http://example.com/b", nil), nil, entryTags))
+	assert.NoError(t, l.RecordResponse("c", res, nil))
+
+	assert.NoError(t, l.Close())
+
+	h, err := har_loader.LoadCustomHARFromFile(FilePath(dir, "akita_lo", 0, false))
+	if !assert.NoError(t, err) {
+		return
+	}
+	if assert.Len(t, h.Log.Entries, 2) {
+		assert.Equal(t, 204, h.Log.Entries[0].Response.Status)
+		assert.Equal(t, "http://example.com/b", h.Log.Entries[1].Request.URL)
+		assert.Nil(t, h.Log.Entries[1].Response)
+		assert.Nil(t, h.Log.Entries[0].Tags)
+		assert.Equal(t, entryTags, h.Log.Entries[1].Tags)
+	}
+}
+
+func TestNoEntries(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWriter(dir, "akita_lo", Options{}, nil)
+	assert.NoError(t, NewLogger(w, time.Hour).Close())
+
+	// No file is written without traffic.
+	assert.Empty(t, w.Files())
+	matches, _ := filepath.Glob(filepath.Join(dir, "*"))
+	assert.Empty(t, matches)
+}
@@ -0,0 +1,119 @@
+package learn
+
+import (
+	"io"
+	"sort"
+	"sync"
+)
+
+// Success and failure counts for decompressing HTTP bodies with a single
+// content encoding.
+type DecompressionCounts struct {
+	Encoding string
+
+	// Bodies decompressed as directed by the Content-Encoding header.
+	Succeeded int
+	Failed    int
+
+	// Trial decompressions of bodies that could not be parsed as-is.
+	FallbackSucceeded int
+	FallbackFailed    int
+}
+
+// Encodings not in this set are counted together so a misbehaving peer can't
+// grow the counters without bound.
+var knownEncodings = map[string]struct{}{
+	"gzip":     {},
+	"deflate":  {},
+	"identity": {},
+	"br":       {},
+	"zstd":     {},
+	"compress": {},
+}
+
+const otherEncoding = "other"
+
+type decompressionCounters struct {
+	mutex      sync.Mutex
+	byEncoding map[string]*DecompressionCounts
+}
+
+var decompressionCounts = &decompressionCounters{
+	byEncoding: make(map[string]*DecompressionCounts),
+}
+
+// Caller must hold c.mutex.
+func (c *decompressionCounters) get(encoding string) *DecompressionCounts {
+	encoding = normalizeContentEncoding(encoding)
+	if _, ok := knownEncodings[encoding]; !ok {
+		encoding = otherEncoding
+	}
+
+	counts, ok := c.byEncoding[encoding]
+	if !ok {
+		counts = &DecompressionCounts{Encoding: encoding}
+		c.byEncoding[encoding] = counts
+	}
+	return counts
+}
+
+func (c *decompressionCounters) record(encoding string, success bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if success {
+		c.get(encoding).Succeeded += 1
+	} else {
+		c.get(encoding).Failed += 1
+	}
+}
+
+func (c *decompressionCounters) recordFallback(encoding string, success bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if success {
+		c.get(encoding).FallbackSucceeded += 1
+	} else {
+		c.get(encoding).FallbackFailed += 1
+	}
+}
+
+// Wraps a decompressing reader so the outcome is recorded once the body has
+// been read to the end, or once reading fails.
+func (c *decompressionCounters) wrap(encoding string, r io.Reader) io.Reader {
+	return &countingDecompressReader{
+		counters: c,
+		encoding: encoding,
+		r:        r,
+	}
+}
+
+type countingDecompressReader struct {
+	counters *decompressionCounters
+	encoding string
+	r        io.Reader
+	recorded bool
+}
+
+func (cr *countingDecompressReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	if err != nil && !cr.recorded {
+		cr.recorded = true
+		cr.counters.record(cr.encoding, err == io.EOF)
+	}
+	return n, err
+}
+
+// Returns a snapshot of the decompression counters, sorted by encoding.
+func GetDecompressionCounts() []DecompressionCounts {
+	decompressionCounts.mutex.Lock()
+	defer decompressionCounts.mutex.Unlock()
+
+	result := make([]DecompressionCounts, 0, len(decompressionCounts.byEncoding))
+	for _, counts := range decompressionCounts.byEncoding {
+		result = append(result, *counts)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Encoding < result[j].Encoding
+	})
+	return result
+}
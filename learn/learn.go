@@ -8,21 +8,21 @@ import (
 	"github.com/golang/protobuf/proto"
 	"github.com/pkg/errors"
 
+	"github.com/akitasoftware/akita-cli/pair_cache"
 	"github.com/akitasoftware/akita-cli/printer"
+	"github.com/akitasoftware/akita-cli/traffic_direction"
 	pb "github.com/akitasoftware/akita-ir/go/api_spec"
 	"github.com/akitasoftware/akita-libs/akid"
 	"github.com/akitasoftware/akita-libs/akinet"
 	kgxapi "github.com/akitasoftware/akita-libs/api_schema"
 	"github.com/akitasoftware/akita-libs/spec_util"
 	"github.com/akitasoftware/akita-libs/spec_util/ir_hash"
+	"github.com/akitasoftware/akita-libs/tags"
 )
 
 const (
-	// We stop trying to pair partial witnesses older than pairCacheExpiration.
-	pairCacheExpiration = time.Minute
-
 	// How often we clean out stale partial witnesses from pairCache.
-	pairCacheCleanupInterval = 30 * time.Second
+	pairCacheCleanupInterval = 5 * time.Second
 )
 
 type witnessResult struct {
@@ -33,6 +33,10 @@ type witnessResult struct {
 	witness         *pb.Witness
 	observationTime time.Time
 	id              akid.WitnessID
+	direction       traffic_direction.Direction
+
+	// Set if the witness was never paired with its request or response.
+	unpaired bool
 }
 
 func (r witnessResult) toReport() (*kgxapi.WitnessReport, error) {
@@ -45,6 +49,17 @@ func (r witnessResult) toReport() (*kgxapi.WitnessReport, error) {
 		return nil, errors.Wrap(err, "failed to marshal witness proto")
 	}
 
+	var reportTags map[tags.Key]string
+	if r.unpaired || r.direction == traffic_direction.Outbound {
+		reportTags = make(map[tags.Key]string, 2)
+	}
+	if r.unpaired {
+		reportTags[pair_cache.UnpairedTag] = "true"
+	}
+	if r.direction == traffic_direction.Outbound {
+		reportTags[traffic_direction.DirectionTag] = string(traffic_direction.Outbound)
+	}
+
 	return &kgxapi.WitnessReport{
 		Direction:       kgxapi.Inbound,
 		OriginAddr:      r.srcIP,
@@ -56,6 +71,7 @@ func (r witnessResult) toReport() (*kgxapi.WitnessReport, error) {
 		ClientWitnessTime: r.observationTime,
 		Hash:              hash,
 		ID:                r.id,
+		Tags:              reportTags,
 	}, nil
 }
 
@@ -76,21 +92,27 @@ func CombineMatchers(matchers []SensitiveDataMatcher) SensitiveDataMatcher {
 
 type PartialWitnessParser func(akinet.ParsedNetworkContent) (*PartialWitness, error)
 
-// The returned channel is closed after elemChan closes.
-func startLearning(elemChan <-chan akinet.ParsedNetworkTraffic) <-chan *witnessResult {
+// The returned channel is closed after elemChan closes. If dir is nil, all
+// witnesses are reported as inbound.
+func startLearning(elemChan <-chan akinet.ParsedNetworkTraffic, pairCacheOpts pair_cache.Options, dir *traffic_direction.Detector) <-chan *witnessResult {
 	resultChan := make(chan *witnessResult, 50)
 
 	// Cache un-paired partial witnesses by pair key.
-	pairCache := map[akid.WitnessID]*witnessResult{}
+	pairCache := pair_cache.New(pairCacheOpts)
+	sendUnpaired := func(entries []interface{}) {
+		for _, e := range entries {
+			r := e.(*witnessResult)
+			r.unpaired = true
+			resultChan <- r
+		}
+	}
 
 	go func() {
 		defer close(resultChan)
 
 		defer func() {
 			// Flush any unpaired partial witnesses.
-			for _, e := range pairCache {
-				resultChan <- e
-			}
+			sendUnpaired(pairCache.Drain())
 		}()
 
 		pairCacheCleanup := time.NewTicker(pairCacheCleanupInterval)
@@ -103,6 +125,8 @@ func startLearning(elemChan <-chan akinet.ParsedNetworkTraffic) <-chan *witnessR
 					return
 				}
 
+				dir.Observe(newElem)
+
 				parser, err := getPartialWitnessParser(newElem.Content)
 				if err != nil {
 					printer.V(4).Infof("Couldn't find witness parser for network traffic: %v\n", err)
@@ -115,11 +139,12 @@ func startLearning(elemChan <-chan akinet.ParsedNetworkTraffic) <-chan *witnessR
 					continue
 				}
 
-				if pair, ok := pairCache[partial.PairKey]; ok {
+				if val, ok := pairCache.Take(partial.PairKey); ok {
+					pair := val.(*witnessResult)
+
 					// Combine the pair, merging the result into the existing item
 					// rather than the new partial.
 					MergeWitness(pair.witness, partial.Witness)
-					delete(pairCache, partial.PairKey)
 
 					// If newElem is the request, flip the src/dst in the pair before
 					// reporting.
@@ -131,7 +156,7 @@ func startLearning(elemChan <-chan akinet.ParsedNetworkTraffic) <-chan *witnessR
 				} else {
 					// Store the partial witness for now, waiting for its pair or a
 					// flush timeout.
-					pairCache[partial.PairKey] = &witnessResult{
+					sendUnpaired(pairCache.Add(partial.PairKey, &witnessResult{
 						srcIP:           newElem.SrcIP,
 						srcPort:         uint16(newElem.SrcPort),
 						dstIP:           newElem.DstIP,
@@ -139,17 +164,12 @@ func startLearning(elemChan <-chan akinet.ParsedNetworkTraffic) <-chan *witnessR
 						witness:         partial.Witness,
 						observationTime: newElem.ObservationTime,
 						id:              partial.PairKey,
-					}
+						direction:       dir.Direction(newElem),
+					}))
 				}
 			case <-pairCacheCleanup.C:
 				// Periodically clear up unpaired partial witnesses that are too old.
-				cutoffTime := time.Now().Add(-pairCacheExpiration)
-				for k, e := range pairCache {
-					if e.observationTime.Before(cutoffTime) {
-						resultChan <- e
-						delete(pairCache, k)
-					}
-				}
+				sendUnpaired(pairCache.Expire(time.Now()))
 			}
 		}
 	}()
@@ -1,24 +1,19 @@
 package learn
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"math"
-	"net/http"
-	"path"
-	"strconv"
 
 	"github.com/OneOfOne/xxhash"
-	"github.com/google/martian/v3/har"
 	"github.com/pkg/errors"
 	"github.com/spf13/viper"
 
+	"github.com/akitasoftware/akita-cli/har_writer"
+	"github.com/akitasoftware/akita-cli/pair_cache"
 	col "github.com/akitasoftware/akita-cli/pcap"
 	"github.com/akitasoftware/akita-cli/printer"
+	"github.com/akitasoftware/akita-cli/traffic_direction"
 	"github.com/akitasoftware/akita-cli/util"
-	"github.com/akitasoftware/akita-cli/version"
 	"github.com/akitasoftware/akita-libs/akid"
 	"github.com/akitasoftware/akita-libs/akinet"
 	akihttp "github.com/akitasoftware/akita-libs/akinet/http"
@@ -37,11 +32,14 @@ type WitnessProcessor interface {
 type HAROptions struct {
 	SampleRate float64
 	OutDir     string
+
+	// Controls rotation and compression of the HAR files written to OutDir.
+	Files har_writer.Options
 }
 
 // Starts collecting witnesses and blocks until stop is closed.
 // Closes proc upon return.
-func CollectWitnesses(stop <-chan struct{}, intf, bpfFilter string, proc WitnessProcessor, harOpts *HAROptions) error {
+func CollectWitnesses(stop <-chan struct{}, intf, bpfFilter string, proc WitnessProcessor, harOpts *HAROptions, pairCacheOpts pair_cache.Options) error {
 	facts := []akinet.TCPParserFactory{
 		akihttp.NewHTTPRequestParserFactory(),
 		akihttp.NewHTTPResponseParserFactory(),
@@ -65,7 +63,14 @@ func CollectWitnesses(stop <-chan struct{}, intf, bpfFilter string, proc Witness
 		}()
 	}
 
-	err = CollectWitnessesFromChannel(parsedChan, proc)
+	var dir *traffic_direction.Detector
+	if addrs, err := col.InterfaceAddrs(intf); err == nil {
+		dir = traffic_direction.NewDetector(addrs)
+	} else {
+		printer.Warningf("Reporting all traffic as inbound: %v\n", err)
+	}
+
+	err = CollectWitnessesFromChannel(parsedChan, proc, pairCacheOpts, dir)
 
 	// Wait for the HAR file to be done.
 	if harDone != nil {
@@ -76,10 +81,11 @@ func CollectWitnesses(stop <-chan struct{}, intf, bpfFilter string, proc Witness
 	return err
 }
 
-func CollectWitnessesFromChannel(parsedChan <-chan akinet.ParsedNetworkTraffic, proc WitnessProcessor) error {
+// If dir is nil, all witnesses are reported as inbound.
+func CollectWitnessesFromChannel(parsedChan <-chan akinet.ParsedNetworkTraffic, proc WitnessProcessor, pairCacheOpts pair_cache.Options, dir *traffic_direction.Detector) error {
 	defer proc.Close()
 
-	witnessChan := startLearning(parsedChan)
+	witnessChan := startLearning(parsedChan, pairCacheOpts, dir)
 	for r := range witnessChan {
 		// Skip witnesses of CLI to backend API calls that were accidentally
 		// captured.
@@ -105,7 +111,14 @@ func createHARFile(in <-chan akinet.ParsedNetworkTraffic, interfaceName string,
 		return float64(h.Sum32()) < threshold
 	}
 
-	l := har.NewLogger()
+	w := har_writer.NewWriter(opts.OutDir, fmt.Sprintf("akita_%s", interfaceName), opts.Files, nil)
+	l := har_writer.NewLogger(w, har_writer.DefaultUnpairedTimeout)
+	defer func() {
+		if err := l.Close(); err != nil {
+			printer.Errorf("Failed to write HAR file: %v\n", err)
+		}
+	}()
+
 	for t := range in {
 		if util.ContainsCLITraffic(t) {
 			if !viper.GetBool("dogfood") {
@@ -113,6 +126,7 @@ func createHARFile(in <-chan akinet.ParsedNetworkTraffic, interfaceName string,
 			}
 		}
 
+		var err error
 		switch c := t.Content.(type) {
 		case akinet.HTTPRequest:
 			id := toWitnessID(c.StreamID, c.Seq)
@@ -120,21 +134,7 @@ func createHARFile(in <-chan akinet.ParsedNetworkTraffic, interfaceName string,
 			// Sample based on pair key so the request and response are either both
 			// selected or both excluded.
 			if includeSample(akid.String(id)) {
-				req := &http.Request{
-					Method:        c.Method,
-					URL:           c.URL,
-					Proto:         fmt.Sprintf("HTTP/%d.%d", c.ProtoMajor, c.ProtoMinor),
-					ProtoMajor:    c.ProtoMajor,
-					ProtoMinor:    c.ProtoMinor,
-					Header:        c.Header,
-					Body:          ioutil.NopCloser(bytes.NewReader(c.Body)),
-					ContentLength: int64(len(c.Body)),
-					Host:          c.Host,
-				}
-				for _, cookie := range c.Cookies {
-					req.AddCookie(cookie)
-				}
-				l.RecordRequest(akid.String(id), req)
+				err = l.RecordRequest(akid.String(id), c.ToStdRequest(), nil)
 			}
 		case akinet.HTTPResponse:
 			id := toWitnessID(c.StreamID, c.Seq)
@@ -142,46 +142,11 @@ func createHARFile(in <-chan akinet.ParsedNetworkTraffic, interfaceName string,
 			// Sample based on pair key so the request and response are either both
 			// selected or both excluded.
 			if includeSample(akid.String(id)) {
-				resp := &http.Response{
-					Status:        strconv.Itoa(c.StatusCode) + " " + http.StatusText(c.StatusCode),
-					StatusCode:    c.StatusCode,
-					Proto:         fmt.Sprintf("HTTP/%d.%d", c.ProtoMajor, c.ProtoMinor),
-					ProtoMajor:    c.ProtoMajor,
-					ProtoMinor:    c.ProtoMinor,
-					Header:        c.Header,
-					Body:          ioutil.NopCloser(bytes.NewReader(c.Body)),
-					ContentLength: int64(len(c.Body)),
-				}
-				l.RecordResponse(akid.String(id), resp)
+				err = l.RecordResponse(akid.String(id), c.ToStdResponse(), nil)
 			}
 		}
-	}
-
-	harContent := l.ExportAndReset()
-
-	if log := harContent.Log; log != nil {
-		if len(log.Entries) == 0 {
-			// No need to write an empty file.
-			return
+		if err != nil {
+			printer.Debugf("Failed to record HAR entry: %v\n", err)
 		}
-
-		// Customize the creator info.
-		log.Creator = &har.Creator{
-			Name:    "Akita SuperLearn (https://akitasoftware.com)",
-			Version: version.CLIDisplayString(),
-		}
-	} else {
-		// No need to write an empty file.
-		return
-	}
-
-	harBytes, err := json.Marshal(harContent)
-	if err != nil {
-		printer.Errorf("Failed to marshal HAR to JSON: %v\n", err)
-	}
-
-	outPath := path.Join(opts.OutDir, fmt.Sprintf("akita_%s.har", interfaceName))
-	if err := ioutil.WriteFile(outPath, harBytes, 0644); err != nil {
-		printer.Errorf("Failed to write HAR file: %v\n", err)
 	}
 }
@@ -11,6 +11,7 @@ import (
 
 	pb "github.com/akitasoftware/akita-ir/go/api_spec"
 
+	"github.com/akitasoftware/akita-cli/pair_cache"
 	"github.com/akitasoftware/akita-libs/akinet"
 	"github.com/akitasoftware/akita-libs/spec_util"
 )
@@ -221,7 +222,7 @@ func TestPairPartialWitness(t *testing.T) {
 			close(inputChan)
 		}()
 
-		resultChan := startLearning(inputChan)
+		resultChan := startLearning(inputChan, pair_cache.Options{}, nil)
 		actual := []*pb.Witness{}
 		for r := range resultChan {
 			actual = append(actual, r.witness)
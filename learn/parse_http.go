@@ -17,6 +17,7 @@ import (
 
 	"github.com/andybalholm/brotli"
 	"github.com/google/uuid"
+	"github.com/klauspost/compress/zstd"
 	"github.com/pkg/errors"
 	"golang.org/x/text/encoding/ianaindex"
 	"golang.org/x/text/transform"
@@ -33,9 +34,14 @@ var (
 	// List of compression algorithms to use as fallback if we can't decode the
 	// body and no Content-Encoding header is set.
 	// https://app.clubhouse.io/akita-software/story/1656
+	//
+	// Brotli has no magic number, so it accepts more garbage than the others and
+	// is tried last.
 	fallbackDecompressions = []string{
 		"deflate",
 		"gzip",
+		"zstd",
+		"compress",
 		"br",
 	}
 )
@@ -47,6 +53,11 @@ const (
 	MaxFallbackInput  = 1 * 1024 * 1024
 	MaxFallbackOutput = 10 * 1024 * 1024
 
+	// Some decompressors (zstd, compress) produce their output eagerly rather
+	// than streaming it, so we need a cap on how much they may consume and
+	// produce outside of the fallback.
+	MaxDecompressedBody = 100 * 1024 * 1024
+
 	// This limit is used for non-YAML and non-JSON types that we can have some hope of parsing.
 	MaxBufferedBody = 5 * 1024 * 1024
 
@@ -188,10 +199,13 @@ func ParseHTTP(elem akinet.ParsedNetworkContent) (*PartialWitness, error) {
 // TODO: some of the compression algorithms return a ReadCloser, but it
 // doesn't look like there's a good standard library way to propogate closes
 // all the way back.  So they'd all have to be deferred here?
-func decompress(compression string, body io.Reader) (io.Reader, error) {
+//
+// Decompressors that produce their output eagerly read at most maxInput bytes
+// of the body, and fail if they would produce more than maxOutput bytes.
+func decompress(compression string, body io.Reader, maxInput, maxOutput int64) (io.Reader, error) {
 	printer.Debugf("Decompressing body using %s\n", compression)
 	var dr io.Reader
-	switch compression {
+	switch normalizeContentEncoding(compression) {
 	case "gzip":
 		if r, err := gzip.NewReader(body); err != nil {
 			return nil, err
@@ -204,12 +218,59 @@ func decompress(compression string, body io.Reader) (io.Reader, error) {
 		dr = body
 	case "br":
 		dr = brotli.NewReader(body)
+	case "zstd":
+		if r, err := newZstdReader(io.LimitReader(body, maxInput), maxOutput); err != nil {
+			return nil, err
+		} else {
+			dr = r
+		}
+	case "compress":
+		if r, err := newUnixCompressReader(io.LimitReader(body, maxInput), int(maxOutput)); err != nil {
+			return nil, err
+		} else {
+			dr = r
+		}
 	default:
 		return nil, errors.New("unsupported compression type")
 	}
 	return dr, nil
 }
 
+// Decompresses the body using a streaming zstd decoder, so that no more than
+// maxOutput bytes are ever buffered. The decoder runs goroutines until it is
+// closed, so the body is decompressed eagerly rather than handing the decoder
+// to callers that might not read it to the end.
+func newZstdReader(body io.Reader, maxOutput int64) (io.Reader, error) {
+	d, err := zstd.NewReader(body, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(uint64(maxOutput)))
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+
+	out, err := ioutil.ReadAll(io.LimitReader(d, maxOutput+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)) > maxOutput {
+		return nil, errors.New("decompressed body too large")
+	}
+	return bytes.NewReader(out), nil
+}
+
+// Maps aliases of content codings to the name we use in decompress.
+// RFC 7230 section 4.2.3 says x-gzip and x-compress are equivalent to gzip and
+// compress.
+func normalizeContentEncoding(compression string) string {
+	c := strings.ToLower(strings.TrimSpace(compression))
+	switch c {
+	case "x-gzip":
+		return "gzip"
+	case "x-compress":
+		return "compress"
+	}
+	return c
+}
+
 // Our only means of success seems to be reading all the way to the end.
 // We limit the amount of space that can be produced that way (and the largest
 // body we are willing to try.)
@@ -219,15 +280,18 @@ func attemptDecompress(body []byte) (io.Reader, error) {
 	}
 
 	for _, algorithm := range fallbackDecompressions {
-		dr, err := decompress(algorithm, bytes.NewReader(body))
+		dr, err := decompress(algorithm, bytes.NewReader(body), MaxFallbackInput, MaxFallbackOutput)
 		if err != nil {
+			decompressionCounts.recordFallback(algorithm, false)
 			continue
 		}
 		limitReader := &io.LimitedReader{R: dr, N: MaxFallbackOutput}
 		bufferedResult, err := ioutil.ReadAll(limitReader)
 		if err == nil {
+			decompressionCounts.recordFallback(algorithm, true)
 			return bytes.NewReader(bufferedResult), nil
 		}
+		decompressionCounts.recordFallback(algorithm, false)
 	}
 	return nil, errors.New("unrecognized compression type")
 }
@@ -236,18 +300,19 @@ func attemptDecompress(body []byte) (io.Reader, error) {
 func decodeBody(headers http.Header, body io.Reader, bodyDecompressed bool) (io.Reader, error) {
 	// Handle decompression first.
 	if !bodyDecompressed {
-		compressions := headers[http.CanonicalHeaderKey("Content-Encoding")]
+		compressions := contentEncodings(headers)
 		if len(compressions) > 0 {
 			printer.Debugf("Detected Content-Encoding header: %s\n", compressions)
 		}
 		// Content-Encoding is listed in the order applied, so we reverse the order to
 		// decompress.
-		sort.Reverse(sort.StringSlice(compressions))
-		for _, c := range compressions {
-			if b, err := decompress(c, body); err != nil {
+		for i := len(compressions) - 1; i >= 0; i-- {
+			c := compressions[i]
+			if b, err := decompress(c, body, MaxDecompressedBody, MaxDecompressedBody); err != nil {
+				decompressionCounts.record(c, false)
 				return nil, errors.Wrapf(err, "failed to decompress body with %s", c)
 			} else {
-				body = b
+				body = decompressionCounts.wrap(c, b)
 			}
 		}
 	}
@@ -276,6 +341,36 @@ func decodeBody(headers http.Header, body io.Reader, bodyDecompressed bool) (io.
 	return body, nil
 }
 
+// Undoes the content codings listed in the Content-Encoding headers, so that
+// the body can be rewritten as plain text. Unlike decodeBody, this does not
+// fall back to guessing the compression algorithm.
+func DecompressBody(headers http.Header, body []byte) ([]byte, error) {
+	var r io.Reader = bytes.NewReader(body)
+	compressions := contentEncodings(headers)
+	for i := len(compressions) - 1; i >= 0; i-- {
+		dr, err := decompress(compressions[i], r, MaxDecompressedBody, MaxDecompressedBody)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decompress body with %s", compressions[i])
+		}
+		r = dr
+	}
+	return limitedBufferBody(r, MaxDecompressedBody)
+}
+
+// Returns the content codings from all Content-Encoding headers, in the order
+// they were applied. A single header may list several comma-separated codings.
+func contentEncodings(headers http.Header) []string {
+	var result []string
+	for _, h := range headers[http.CanonicalHeaderKey("Content-Encoding")] {
+		for _, c := range strings.Split(h, ",") {
+			if c = strings.TrimSpace(c); c != "" {
+				result = append(result, c)
+			}
+		}
+	}
+	return result
+}
+
 func limitedBufferBody(bodyStream io.Reader, limit int64) ([]byte, error) {
 	body, err := ioutil.ReadAll(io.LimitReader(bodyStream, limit))
 	if err != nil {
@@ -3,6 +3,8 @@ package learn
 import (
 	"bytes"
 	"compress/flate"
+	"compress/gzip"
+	"io/ioutil"
 	"net/http"
 	"strings"
 	"testing"
@@ -11,6 +13,7 @@ import (
 	"github.com/akitasoftware/akita-libs/akinet"
 	"github.com/akitasoftware/akita-libs/spec_util"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/spf13/viper"
 )
 
@@ -20,6 +23,14 @@ const (
 )
 
 var deflatedBody bytes.Buffer
+var zstdBody bytes.Buffer
+
+// `{"34302ecf": "this is prince"}` in compress(1) format.
+var unixCompressedBody = []byte{
+	0x1f, 0x9d, 0x90, 0x7b, 0x44, 0xcc, 0xa0, 0x31, 0x03, 0x86, 0x8c, 0x32,
+	0x63, 0xcc, 0x88, 0xd0, 0x01, 0x42, 0x04, 0x1d, 0x34, 0x69, 0xe6, 0x80,
+	0x88, 0x08, 0x02, 0x8e, 0x9c, 0x34, 0x6e, 0xc6, 0x94, 0x11, 0xd1, 0x07,
+}
 
 func init() {
 	dw, err := flate.NewWriter(&deflatedBody, flate.BestCompression)
@@ -28,6 +39,13 @@ func init() {
 	}
 	dw.Write([]byte(`{"34302ecf": "this is prince"}`))
 	dw.Close()
+
+	zw, err := zstd.NewWriter(&zstdBody)
+	if err != nil {
+		panic(err)
+	}
+	zw.Write([]byte(`{"34302ecf": "this is prince"}`))
+	zw.Close()
 }
 
 var testBodyDict = `
@@ -442,6 +460,100 @@ prince:
 				UnknownHTTPMethodMeta(),
 			),
 		},
+		&parseTest{
+			name: "zstd body with content-encoding header",
+			testContent: newTestHTTPResponse(
+				200,
+				zstdBody.Bytes(),
+				"application/json",
+				map[string][]string{"Content-Encoding": {"zstd"}},
+				[]*http.Cookie{},
+			),
+			expectedMethod: newMethod(
+				nil,
+				[]*as.Data{
+					newDataHeader("Content-Encoding", 200, spec_util.NewPrimitiveString("zstd"), false),
+					newTestBodySpecFromStruct(
+						200,
+						as.HTTPBody_JSON,
+						map[string]*as.Data{
+							"34302ecf": dataFromPrimitive(spec_util.NewPrimitiveString("this is prince")),
+						},
+					),
+				},
+				UnknownHTTPMethodMeta(),
+			),
+		},
+		&parseTest{
+			name: "zstd body without content-encoding header",
+			testContent: newTestHTTPResponse(
+				200,
+				zstdBody.Bytes(),
+				"application/json",
+				map[string][]string{},
+				[]*http.Cookie{},
+			),
+			expectedMethod: newMethod(
+				nil,
+				[]*as.Data{
+					newTestBodySpecFromStruct(
+						200,
+						as.HTTPBody_JSON,
+						map[string]*as.Data{
+							"34302ecf": dataFromPrimitive(spec_util.NewPrimitiveString("this is prince")),
+						},
+					),
+				},
+				UnknownHTTPMethodMeta(),
+			),
+		},
+		&parseTest{
+			name: "compress body with x-compress content-encoding header",
+			testContent: newTestHTTPResponse(
+				200,
+				unixCompressedBody,
+				"application/json",
+				map[string][]string{"Content-Encoding": {"x-compress"}},
+				[]*http.Cookie{},
+			),
+			expectedMethod: newMethod(
+				nil,
+				[]*as.Data{
+					newDataHeader("Content-Encoding", 200, spec_util.NewPrimitiveString("x-compress"), false),
+					newTestBodySpecFromStruct(
+						200,
+						as.HTTPBody_JSON,
+						map[string]*as.Data{
+							"34302ecf": dataFromPrimitive(spec_util.NewPrimitiveString("this is prince")),
+						},
+					),
+				},
+				UnknownHTTPMethodMeta(),
+			),
+		},
+		&parseTest{
+			name: "compress body without content-encoding header",
+			testContent: newTestHTTPResponse(
+				200,
+				unixCompressedBody,
+				"application/json",
+				map[string][]string{},
+				[]*http.Cookie{},
+			),
+			expectedMethod: newMethod(
+				nil,
+				[]*as.Data{
+					newTestBodySpecFromStruct(
+						200,
+						as.HTTPBody_JSON,
+						map[string]*as.Data{
+							"34302ecf": dataFromPrimitive(spec_util.NewPrimitiveString("this is prince")),
+						},
+					),
+				},
+				UnknownHTTPMethodMeta(),
+			),
+		},
 		&parseTest{
 			// Log error and skip the body if we can't parse it, instead of aborting
 			// the whole endpoint.
@@ -511,13 +623,116 @@ prince:
 func TestFallbackDecompressionList(t *testing.T) {
 	junk := []byte("abcdefghijklmnopqrstuvwxyz")
 	for _, fc := range fallbackDecompressions {
-		_, err := decompress(fc, bytes.NewReader(junk))
+		_, err := decompress(fc, bytes.NewReader(junk), MaxFallbackInput, MaxFallbackOutput)
 		if err != nil && err.Error() == "unsupported compression type" {
 			t.Errorf("%s is not supported by decompress", fc)
 		}
 	}
 }
 
+func TestDecompressAliases(t *testing.T) {
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	gw.Write([]byte("prince"))
+	gw.Close()
+
+	for _, encoding := range []string{"gzip", "x-gzip", "X-GZIP", " gzip "} {
+		r, err := decompress(encoding, bytes.NewReader(gzipped.Bytes()), MaxDecompressedBody, MaxDecompressedBody)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", encoding, err)
+		}
+		if b, err := ioutil.ReadAll(r); err != nil || string(b) != "prince" {
+			t.Errorf("%q: got %q, %v", encoding, b, err)
+		}
+	}
+}
+
+func TestZstdLimits(t *testing.T) {
+	plain := bytes.Repeat([]byte("prince "), 1000)
+	var compressed bytes.Buffer
+	zw, err := zstd.NewWriter(&compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw.Write(plain)
+	zw.Close()
+
+	r, err := decompress("zstd", bytes.NewReader(compressed.Bytes()), MaxFallbackInput, int64(len(plain)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b, _ := ioutil.ReadAll(r); !bytes.Equal(b, plain) {
+		t.Errorf("expected %d bytes, got %d", len(plain), len(b))
+	}
+
+	if _, err := decompress("zstd", bytes.NewReader(compressed.Bytes()), MaxFallbackInput, int64(len(plain)-1)); err == nil {
+		t.Errorf("expected output over the limit to fail")
+	}
+	if _, err := decompress("zstd", bytes.NewReader(compressed.Bytes()), int64(compressed.Len()-1), MaxFallbackOutput); err == nil {
+		t.Errorf("expected input over the limit to fail")
+	}
+}
+
+// testdata/users.ndjson.Z holds testdata/users.ndjson in compress(1) format
+// with 10-bit codes, and decodes correctly with gunzip. It is large enough that
+// codes grow past 9 bits and the full table is cleared once the compression
+// ratio falls, so it covers the padding to the end of a group of codes at each
+// of those boundaries.
+func TestUnixDecompressFixture(t *testing.T) {
+	compressed, err := ioutil.ReadFile("testdata/users.ndjson.Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := ioutil.ReadFile("testdata/users.ndjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := unixDecompress(compressed, MaxDecompressedBody)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(out, expected) {
+		t.Errorf("decompressed %d bytes, which differ from the %d expected", len(out), len(expected))
+	}
+}
+
+func TestContentEncodings(t *testing.T) {
+	headers := http.Header{
+		"Content-Encoding": {"gzip, br", "zstd"},
+	}
+	got := contentEncodings(headers)
+	expected := []string{"gzip", "br", "zstd"}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestDecompressionCounts(t *testing.T) {
+	counts := &decompressionCounters{byEncoding: map[string]*DecompressionCounts{}}
+
+	r := counts.wrap("x-gzip", bytes.NewReader([]byte("prince")))
+	ioutil.ReadAll(r)
+	counts.record("zstd", false)
+	counts.recordFallback("br", true)
+	counts.record("no-such-encoding", false)
+
+	expected := map[string]DecompressionCounts{
+		"gzip":  {Encoding: "gzip", Succeeded: 1},
+		"zstd":  {Encoding: "zstd", Failed: 1},
+		"br":    {Encoding: "br", FallbackSucceeded: 1},
+		"other": {Encoding: "other", Failed: 1},
+	}
+	if len(counts.byEncoding) != len(expected) {
+		t.Errorf("expected %d encodings, got %d", len(expected), len(counts.byEncoding))
+	}
+	for k, v := range expected {
+		if got, ok := counts.byEncoding[k]; !ok || *got != v {
+			t.Errorf("%s: expected %+v, got %+v", k, v, got)
+		}
+	}
+}
+
 func TestFailingParse(t *testing.T) {
 	// Look at the debug messages
 	// TODO: is there any way to grab them programatically?  Install a new Stderr, maybe?
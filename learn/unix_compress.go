@@ -0,0 +1,169 @@
+package learn
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// Decoder for the Unix compress(1) format, used by the "compress" HTTP content
+// coding. The standard library's compress/lzw implements the GIF/TIFF variant
+// of LZW, which differs from compress(1) in its header, its handling of the
+// CLEAR code, and how the code width changes, so we can't use it here.
+//
+// See https://github.com/vapier/ncompress for the reference implementation.
+
+const (
+	unixCompressMagic0 = 0x1f
+	unixCompressMagic1 = 0x9d
+
+	// Flags in the third header byte.
+	unixCompressMaxBitsMask   = 0x1f
+	unixCompressBlockModeFlag = 0x80
+
+	unixCompressInitBits = 9
+	unixCompressMaxBits  = 16
+	unixCompressClear    = 256
+)
+
+// Decompresses the body using the compress(1) format. The body is decompressed
+// eagerly, so the output is limited to maxOutput bytes.
+func newUnixCompressReader(body io.Reader, maxOutput int) (io.Reader, error) {
+	in, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := unixDecompress(in, maxOutput)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(out), nil
+}
+
+func unixDecompress(in []byte, maxOutput int) ([]byte, error) {
+	if len(in) < 3 || in[0] != unixCompressMagic0 || in[1] != unixCompressMagic1 {
+		return nil, errors.New("missing compress header")
+	}
+	maxBits := uint(in[2] & unixCompressMaxBitsMask)
+	blockMode := in[2]&unixCompressBlockModeFlag != 0
+	if maxBits < unixCompressInitBits || maxBits > unixCompressMaxBits {
+		return nil, errors.Errorf("unsupported compress code width %d", maxBits)
+	}
+	data := in[3:]
+	totalBits := len(data) * 8
+
+	maxMaxCode := 1 << maxBits
+	prefix := make([]uint16, maxMaxCode)
+	suffix := make([]byte, maxMaxCode)
+	for i := 0; i < 256; i++ {
+		suffix[i] = byte(i)
+	}
+
+	nBits := uint(unixCompressInitBits)
+	maxCode := 1<<nBits - 1
+	freeEnt := 256
+	if blockMode {
+		freeEnt = 257
+	}
+
+	// Codes are written in groups of eight, so a group of codes spans exactly
+	// nBits bytes. When the code width changes, the remainder of the current
+	// group is skipped. groupStart tracks the bit offset where the current run
+	// of groups began.
+	pos, groupStart := 0, 0
+	skipToGroupEnd := func() {
+		groupBits := int(nBits) * 8
+		if rem := (pos - groupStart) % groupBits; rem != 0 {
+			pos += groupBits - rem
+		}
+		groupStart = pos
+	}
+
+	readCode := func() int {
+		code := 0
+		for i := uint(0); i < nBits; i++ {
+			bit := pos + int(i)
+			if data[bit/8]&(1<<(uint(bit)%8)) != 0 {
+				code |= 1 << i
+			}
+		}
+		pos += int(nBits)
+		return code
+	}
+
+	var out bytes.Buffer
+	stack := make([]byte, 0, maxMaxCode)
+	oldCode := -1
+	var finChar byte
+	for {
+		if freeEnt > maxCode {
+			skipToGroupEnd()
+			nBits++
+			if nBits == maxBits {
+				maxCode = maxMaxCode
+			} else {
+				maxCode = 1<<nBits - 1
+			}
+		}
+		if pos+int(nBits) > totalBits {
+			break
+		}
+		code := readCode()
+
+		if oldCode == -1 {
+			if code >= 256 {
+				return nil, errors.New("corrupt compress data: bad first code")
+			}
+			oldCode = code
+			finChar = byte(code)
+			out.WriteByte(finChar)
+			continue
+		}
+
+		if code == unixCompressClear && blockMode {
+			for i := range prefix {
+				prefix[i] = 0
+			}
+			freeEnt = 256
+			skipToGroupEnd()
+			nBits = unixCompressInitBits
+			maxCode = 1<<nBits - 1
+			continue
+		}
+
+		inCode := code
+		stack = stack[:0]
+		if code >= freeEnt {
+			// The KwKwK case: the code refers to the entry we're about to add.
+			if code > freeEnt {
+				return nil, errors.New("corrupt compress data: code out of range")
+			}
+			stack = append(stack, finChar)
+			code = oldCode
+		}
+		for code >= 256 {
+			stack = append(stack, suffix[code])
+			code = int(prefix[code])
+		}
+		finChar = suffix[code]
+		stack = append(stack, finChar)
+		for i := len(stack) - 1; i >= 0; i-- {
+			out.WriteByte(stack[i])
+		}
+		if out.Len() > maxOutput {
+			return nil, errors.New("decompressed body too large")
+		}
+
+		if freeEnt < maxMaxCode {
+			prefix[freeEnt] = uint16(oldCode)
+			suffix[freeEnt] = finChar
+			freeEnt++
+		}
+		oldCode = inCode
+	}
+
+	return out.Bytes(), nil
+}
@@ -0,0 +1,98 @@
+package listen_ports
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-cli/procfs"
+)
+
+// Limits the listening sockets that are found. At most one field is set. If
+// neither is set, all listening sockets in apidump's network namespace are
+// found.
+type Scope struct {
+	// Only sockets held open by this process.
+	PID int
+
+	// Only sockets in the network namespace of this container, given by an ID
+	// or a prefix of one.
+	Container string
+}
+
+func (s Scope) String() string {
+	if s.PID != 0 {
+		return fmt.Sprintf("process %d", s.PID)
+	} else if s.Container != "" {
+		return fmt.Sprintf("container %s", s.Container)
+	}
+	return "this host"
+}
+
+// Returns the sorted TCP ports with listening sockets in the given scope.
+func Find(scope Scope) ([]uint16, error) {
+	// The process whose network namespace to look in, or 0 for apidump's.
+	pid := 0
+	var inodes map[string]bool
+	if scope.PID != 0 {
+		var err error
+		if inodes, err = procfs.SocketInodes(scope.PID); err != nil {
+			return nil, err
+		}
+		pid = scope.PID
+	} else if scope.Container != "" {
+		var err error
+		if pid, err = containerPID(scope.Container); err != nil {
+			return nil, err
+		}
+	}
+
+	sockets, err := procfs.Sockets(pid)
+	if err != nil {
+		return nil, err
+	}
+	found := map[uint16]bool{}
+	for _, s := range sockets {
+		if s.Listening && (inodes == nil || inodes[s.Inode]) {
+			found[s.LocalPort] = true
+		}
+	}
+
+	result := make([]uint16, 0, len(found))
+	for p := range found {
+		result = append(result, p)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result, nil
+}
+
+// Returns a BPF filter matching TCP traffic on any of the given ports, or an
+// empty string if there are none.
+func Filter(ports []uint16) string {
+	clauses := make([]string, len(ports))
+	for i, p := range ports {
+		clauses[i] = fmt.Sprintf("tcp port %d", p)
+	}
+	return strings.Join(clauses, " or ")
+}
+
+// Returns a process running in the given container.
+func containerPID(container string) (int, error) {
+	pids, err := procfs.PIDs()
+	if err != nil {
+		return 0, err
+	}
+	for _, pid := range pids {
+		cgroup, err := procfs.Cgroup(pid)
+		if err != nil {
+			// The process exited.
+			continue
+		}
+		if id := procfs.ContainerID(cgroup); id != "" && strings.HasPrefix(id, container) {
+			return pid, nil
+		}
+	}
+	return 0, errors.Errorf("no running process found in container %s", container)
+}
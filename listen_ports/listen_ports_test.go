@@ -0,0 +1,81 @@
+package listen_ports
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/akitasoftware/akita-cli/procfs"
+)
+
+const procNetTCP = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1001 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:0CEA 00000000:0000 0A 00000000:00000000 00:00000000 00000000   999        0 1002 1 0000000000000000 100 0 0 10 0
+   2: 0100007F:1F90 0100007F:D6B2 01 00000000:00000000 00:00000000 00000000     0        0 1003 1 0000000000000000 20 4 30 10 -1
+`
+
+const procNetTCP6 = `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000000000000:01BB 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1004 1 0000000000000000 100 0 0 10 0
+   1: 00000000000000000000000000000000:1F90 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1005 1 0000000000000000 100 0 0 10 0
+`
+
+func TestFilter(t *testing.T) {
+	assert.Equal(t, "", Filter(nil))
+	assert.Equal(t, "tcp port 80", Filter([]uint16{80}))
+	assert.Equal(t, "tcp port 80 or tcp port 443", Filter([]uint16{80, 443}))
+}
+
+func TestFind(t *testing.T) {
+	containerID := "3f4e8a1b2c5d6e7f8091a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f7"
+	root := t.TempDir()
+	defer func(old string) { procfs.Root = old }(procfs.Root)
+	procfs.Root = root
+
+	write := func(path, contents string) {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("net/tcp", procNetTCP)
+	write("net/tcp6", procNetTCP6)
+
+	// Process 42 holds socket 1004 and runs in a container with only a
+	// listener on port 9000.
+	write("42/net/tcp", strings.Replace(procNetTCP, "1F90", "2328", 1))
+	write("42/net/tcp6", procNetTCP6)
+	write("42/cgroup", "0::/docker/"+containerID+"\n")
+	if err := os.MkdirAll(filepath.Join(root, "42/fd"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("socket:[1004]", filepath.Join(root, "42/fd/3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/dev/null", filepath.Join(root, "42/fd/0")); err != nil {
+		t.Fatal(err)
+	}
+
+	ports, err := Find(Scope{})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint16{443, 3306, 8080}, ports)
+
+	ports, err = Find(Scope{PID: 42})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint16{443}, ports)
+
+	ports, err = Find(Scope{Container: containerID[:12]})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint16{443, 3306, 8080, 9000}, ports)
+
+	_, err = Find(Scope{Container: "0123456789ab"})
+	assert.Error(t, err)
+	_, err = Find(Scope{PID: 43})
+	assert.Error(t, err)
+}
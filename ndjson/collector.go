@@ -0,0 +1,258 @@
+package ndjson
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"time"
+
+	"github.com/google/martian/v3/har"
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-cli/learn"
+	"github.com/akitasoftware/akita-cli/printer"
+	"github.com/akitasoftware/akita-cli/process_info"
+	"github.com/akitasoftware/akita-cli/trace"
+	"github.com/akitasoftware/akita-libs/akid"
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/tags"
+)
+
+const (
+	// Requests whose responses have not been seen within this long are written
+	// without a response.
+	unpairedTimeout = time.Minute
+
+	// How often the output is flushed and unpaired requests are checked for
+	// timeouts.
+	flushInterval = 5 * time.Second
+)
+
+// Writes HTTP traffic to an NDJSON file, one line per request/response pair,
+// as soon as each pair is complete. The file is only created once there is
+// something to write.
+type Collector struct {
+	interfaceName string
+	path          string
+	tags          map[tags.Key]string
+
+	// If set, exchanges are tagged with the process that handled them.
+	owners *process_info.Resolver
+
+	// If set, exchanges are tagged with the phase they were observed in.
+	phases *trace.Phases
+
+	file *os.File
+	out  *bufio.Writer
+	enc  *json.Encoder
+
+	// Requests and responses waiting for their counterpart.
+	pending   map[akid.WitnessID]*pendingExchange
+	lastFlush time.Time
+}
+
+type pendingExchange struct {
+	exchange *Exchange
+
+	// Set if the response arrived first.
+	response        *har.Response
+	responseTraffic akinet.ParsedNetworkTraffic
+
+	requestEnd time.Time
+	added      time.Time
+}
+
+var _ trace.Collector = (*Collector)(nil)
+
+func NewCollector(interfaceName, outDir string, tags map[tags.Key]string) *Collector {
+	return &Collector{
+		interfaceName: interfaceName,
+		path:          FilePath(outDir, interfaceName),
+		tags:          tags,
+		pending:       make(map[akid.WitnessID]*pendingExchange),
+		lastFlush:     time.Now(),
+	}
+}
+
+// Tags each exchange with the process, container and pod that handled it.
+// Should be called before Process.
+func (c *Collector) SetOwners(r *process_info.Resolver) {
+	c.owners = r
+}
+
+// Tags each exchange with the phase it was observed in. Should be called
+// before Process.
+func (c *Collector) SetPhases(p *trace.Phases) {
+	c.phases = p
+}
+
+// Returns the path of the file written by this collector.
+func (c *Collector) Path() string {
+	return c.path
+}
+
+func (c *Collector) Process(t akinet.ParsedNetworkTraffic) error {
+	var err error
+	switch content := t.Content.(type) {
+	case akinet.HTTPRequest:
+		err = c.recordRequest(t, content)
+	case akinet.HTTPResponse:
+		err = c.recordResponse(t, content)
+	}
+	if err != nil {
+		// Don't stop the capture because of a single bad exchange.
+		printer.Debugf("Failed to record NDJSON exchange: %v\n", err)
+	}
+
+	if time.Since(c.lastFlush) >= flushInterval {
+		c.lastFlush = time.Now()
+		if err := c.flushUnpaired(c.lastFlush.Add(-unpairedTimeout)); err != nil {
+			return err
+		}
+		if c.out != nil {
+			if err := c.out.Flush(); err != nil {
+				return errors.Wrap(err, "failed to write NDJSON file")
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Collector) recordRequest(t akinet.ParsedNetworkTraffic, req akinet.HTTPRequest) error {
+	hreq, err := har.NewRequest(req.ToStdRequest(), true)
+	if err != nil {
+		return err
+	}
+
+	id := learn.ToWitnessID(req.StreamID, req.Seq)
+	e := &Exchange{
+		WitnessID: akid.String(id),
+		Interface: c.interfaceName,
+		Tags:      process_info.MergeTags(c.phases.TagsAt(c.tags, t.ObservationTime), c.owners.ForTraffic(t)),
+		Connection: Connection{
+			Protocol: "tcp",
+			SrcIP:    ipString(t.SrcIP),
+			SrcPort:  t.SrcPort,
+			DstIP:    ipString(t.DstIP),
+			DstPort:  t.DstPort,
+		},
+		StartedDateTime: t.ObservationTime,
+		Request:         hreq,
+		Timings: Timings{
+			Send: durationToMilliseconds(t.FinalPacketTime.Sub(t.ObservationTime)),
+		},
+	}
+
+	p, ok := c.pending[id]
+	if !ok {
+		c.pending[id] = &pendingExchange{exchange: e, requestEnd: t.FinalPacketTime, added: time.Now()}
+		return nil
+	}
+	delete(c.pending, id)
+
+	p.exchange = e
+	p.requestEnd = t.FinalPacketTime
+	return c.complete(p, p.response, p.responseTraffic)
+}
+
+func (c *Collector) recordResponse(t akinet.ParsedNetworkTraffic, resp akinet.HTTPResponse) error {
+	hresp, err := har.NewResponse(resp.ToStdResponse(), true)
+	if err != nil {
+		return err
+	}
+
+	id := learn.ToWitnessID(resp.StreamID, resp.Seq)
+	p, ok := c.pending[id]
+	if !ok || p.exchange == nil {
+		// Wait for the request.
+		c.pending[id] = &pendingExchange{response: hresp, responseTraffic: t, added: time.Now()}
+		return nil
+	}
+	delete(c.pending, id)
+
+	return c.complete(p, hresp, t)
+}
+
+// Adds the response to the exchange and writes it.
+func (c *Collector) complete(p *pendingExchange, resp *har.Response, t akinet.ParsedNetworkTraffic) error {
+	e := p.exchange
+	e.Response = resp
+	e.Time = durationToMilliseconds(t.FinalPacketTime.Sub(e.StartedDateTime))
+	e.Timings.Wait = durationToMilliseconds(t.ObservationTime.Sub(p.requestEnd))
+	e.Timings.Receive = durationToMilliseconds(t.FinalPacketTime.Sub(t.ObservationTime))
+	return c.write(e)
+}
+
+func (c *Collector) write(e *Exchange) error {
+	if c.file == nil {
+		f, err := os.OpenFile(c.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return errors.Wrap(err, "failed to create NDJSON file")
+		}
+		c.file = f
+		c.out = bufio.NewWriter(f)
+		c.enc = json.NewEncoder(c.out)
+	}
+
+	// Encode writes a trailing newline after each exchange.
+	if err := c.enc.Encode(e); err != nil {
+		return errors.Wrap(err, "failed to write NDJSON exchange")
+	}
+	return nil
+}
+
+// Writes requests that have been waiting for their responses since before
+// cutoff, and drops responses that have been waiting for their requests. A
+// zero cutoff flushes everything.
+func (c *Collector) flushUnpaired(cutoff time.Time) error {
+	dropped := 0
+	for id, p := range c.pending {
+		if !cutoff.IsZero() && !p.added.Before(cutoff) {
+			continue
+		}
+		delete(c.pending, id)
+
+		if p.exchange == nil {
+			dropped++
+			continue
+		}
+		if err := c.write(p.exchange); err != nil {
+			return err
+		}
+	}
+	if dropped > 0 {
+		printer.Debugf("Dropped %d NDJSON responses without matching requests\n", dropped)
+	}
+	return nil
+}
+
+// Writes all unpaired requests and closes the file.
+func (c *Collector) Close() error {
+	if err := c.flushUnpaired(time.Time{}); err != nil {
+		return err
+	}
+	if c.file == nil {
+		return nil
+	}
+	defer c.file.Close()
+
+	if err := c.out.Flush(); err != nil {
+		return errors.Wrap(err, "failed to write NDJSON file")
+	}
+	if err := c.file.Close(); err != nil {
+		return errors.Wrap(err, "failed to close NDJSON file")
+	}
+	return nil
+}
+
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+func durationToMilliseconds(d time.Duration) float32 {
+	return float32(d.Microseconds()) / 1000.0
+}
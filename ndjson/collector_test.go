@@ -0,0 +1,106 @@
+package ndjson
+
+import (
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/akitasoftware/akita-cli/learn"
+	"github.com/akitasoftware/akita-libs/akid"
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/tags"
+)
+
+func TestCollector(t *testing.T) {
+	dir := t.TempDir()
+	traceTags := map[tags.Key]string{"env": "test"}
+	c := NewCollector("lo", dir, traceTags)
+
+	streamID := uuid.New()
+	start := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+	u, _ := url.Parse("http://example.com/v1/users?limit=2")
+	request := func(seq int) akinet.ParsedNetworkTraffic {
+		return akinet.ParsedNetworkTraffic{
+			SrcIP:           net.ParseIP("10.0.0.1"),
+			SrcPort:         51234,
+			DstIP:           net.ParseIP("10.0.0.2"),
+			DstPort:         8080,
+			ObservationTime: start,
+			FinalPacketTime: start.Add(2 * time.Millisecond),
+			Content: akinet.HTTPRequest{
+				StreamID:   streamID,
+				Seq:        seq,
+				Method:     "GET",
+				URL:        u,
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+			},
+		}
+	}
+	response := func(seq int) akinet.ParsedNetworkTraffic {
+		return akinet.ParsedNetworkTraffic{
+			ObservationTime: start.Add(10 * time.Millisecond),
+			FinalPacketTime: start.Add(13 * time.Millisecond),
+			Content: akinet.HTTPResponse{
+				StreamID:   streamID,
+				Seq:        seq,
+				StatusCode: 200,
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				Body:       []byte(`{"id": 1}`),
+			},
+		}
+	}
+
+	// A complete pair, with the response first; a request without a response;
+	// and a response without a request, which is dropped.
+	assert.NoError(t, c.Process(response(1)))
+	assert.NoError(t, c.Process(request(1)))
+	assert.NoError(t, c.Process(request(2)))
+	assert.NoError(t, c.Process(response(3)))
+	assert.NoError(t, c.Close())
+
+	var exchanges []*Exchange
+	err := ReadFile(c.Path(), func(e *Exchange) error {
+		exchanges = append(exchanges, e)
+		return nil
+	})
+	assert.NoError(t, err)
+	if !assert.Len(t, exchanges, 2) {
+		return
+	}
+
+	e := exchanges[0]
+	assert.Equal(t, akid.String(learn.ToWitnessID(streamID, 1)), e.WitnessID)
+	assert.Equal(t, "lo", e.Interface)
+	assert.Equal(t, traceTags, e.Tags)
+	assert.Equal(t, Connection{
+		Protocol: "tcp",
+		SrcIP:    "10.0.0.1",
+		SrcPort:  51234,
+		DstIP:    "10.0.0.2",
+		DstPort:  8080,
+	}, e.Connection)
+	assert.True(t, start.Equal(e.StartedDateTime))
+	assert.Equal(t, float32(13), e.Time)
+	assert.Equal(t, Timings{Send: 2, Wait: 8, Receive: 3}, e.Timings)
+	assert.Equal(t, "http://example.com/v1/users?limit=2", e.Request.URL)
+	if assert.NotNil(t, e.Response) {
+		assert.Equal(t, 200, e.Response.Status)
+		assert.Equal(t, `{"id": 1}`, string(e.Response.Content.Text))
+	}
+
+	assert.Equal(t, akid.String(learn.ToWitnessID(streamID, 2)), exchanges[1].WitnessID)
+	assert.Nil(t, exchanges[1].Response)
+}
+
+func TestIsNDJSONFile(t *testing.T) {
+	assert.True(t, IsNDJSONFile("akita_lo.ndjson"))
+	assert.True(t, IsNDJSONFile("/tmp/akita_lo.NDJSON.gz"))
+	assert.False(t, IsNDJSONFile("akita_lo.har"))
+	assert.False(t, IsNDJSONFile("akita_lo.har.gz"))
+}
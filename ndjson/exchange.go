@@ -0,0 +1,94 @@
+package ndjson
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/martian/v3/har"
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-cli/har_loader"
+	"github.com/akitasoftware/akita-libs/tags"
+)
+
+// Extension of NDJSON trace files.
+const FileExtension = ".ndjson"
+
+// A single request/response exchange, written as one line of an NDJSON trace.
+// Requests and responses use the same representation as HAR entries, so
+// existing HAR tooling can be reused on them.
+type Exchange struct {
+	WitnessID string              `json:"witnessId"`
+	Interface string              `json:"interface,omitempty"`
+	Tags      map[tags.Key]string `json:"tags,omitempty"`
+
+	// The connection on which the request was seen, from the point of view of
+	// the client.
+	Connection Connection `json:"connection"`
+
+	StartedDateTime time.Time `json:"startedDateTime"`
+
+	// Total time of the exchange, in milliseconds.
+	Time float32 `json:"time"`
+
+	Request *har.Request `json:"request,omitempty"`
+
+	// Unset if no response was seen.
+	Response *har.Response `json:"response,omitempty"`
+
+	Timings Timings `json:"timings"`
+}
+
+type Connection struct {
+	Protocol string `json:"protocol"`
+	SrcIP    string `json:"srcIp,omitempty"`
+	SrcPort  int    `json:"srcPort,omitempty"`
+	DstIP    string `json:"dstIp,omitempty"`
+	DstPort  int    `json:"dstPort,omitempty"`
+}
+
+// Phases of the exchange, in milliseconds, as in HAR.
+type Timings struct {
+	Send    float32 `json:"send"`
+	Wait    float32 `json:"wait"`
+	Receive float32 `json:"receive"`
+}
+
+// Returns the path of the NDJSON trace written for the given interface.
+func FilePath(outDir, interfaceName string) string {
+	return filepath.Join(outDir, fmt.Sprintf("akita_%s%s", interfaceName, FileExtension))
+}
+
+// Returns true if the file at path is an NDJSON trace, based on its name.
+// Gzipped traces are also recognized.
+func IsNDJSONFile(path string) bool {
+	path = strings.TrimSuffix(strings.ToLower(path), ".gz")
+	return strings.HasSuffix(path, FileExtension)
+}
+
+// Calls f with each exchange in the NDJSON trace at path, in order. Stops at
+// the first error.
+func ReadFile(path string, f func(*Exchange) error) error {
+	r, err := har_loader.Open(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	dec := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var e Exchange
+		if err := dec.Decode(&e); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrapf(err, "failed to parse exchange %d", line)
+		}
+		if err := f(&e); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package otlp_export
+
+import (
+	"time"
+
+	"github.com/akitasoftware/akita-cli/learn"
+	"github.com/akitasoftware/akita-cli/pair_cache"
+	"github.com/akitasoftware/akita-cli/trace"
+	"github.com/akitasoftware/akita-cli/traffic_direction"
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+// Pairs HTTP requests with their responses and exports a span for each pair,
+// and passes on all traffic as is. Requests without responses aren't
+// exported.
+type collector struct {
+	exporter *Exporter
+	detector *traffic_direction.Detector
+	next     trace.Collector
+
+	// Requests and responses waiting for their other half. Those that wait
+	// too long are forgotten.
+	pending *pair_cache.Cache
+}
+
+var _ trace.Collector = (*collector)(nil)
+
+// The detector determines whether spans are reported as server or client
+// spans, and may be nil.
+func NewCollector(e *Exporter, detector *traffic_direction.Detector, next trace.Collector) trace.Collector {
+	return &collector{
+		exporter: e,
+		detector: detector,
+		next:     next,
+		pending:  pair_cache.New(pair_cache.Options{Name: "otlp_spans"}),
+	}
+}
+
+func (c *collector) Process(t akinet.ParsedNetworkTraffic) error {
+	c.pending.Expire(time.Now())
+
+	switch content := t.Content.(type) {
+	case akinet.HTTPRequest:
+		key := learn.ToWitnessID(content.StreamID, content.Seq)
+		if v, ok := c.pending.Take(key); ok {
+			other := v.(akinet.ParsedNetworkTraffic)
+			if _, isResponse := other.Content.(akinet.HTTPResponse); isResponse {
+				c.exporter.export(t, other, c.detector.Direction(t))
+				break
+			}
+		}
+		c.pending.Add(key, t)
+	case akinet.HTTPResponse:
+		key := learn.ToWitnessID(content.StreamID, content.Seq)
+		if v, ok := c.pending.Take(key); ok {
+			other := v.(akinet.ParsedNetworkTraffic)
+			if _, isRequest := other.Content.(akinet.HTTPRequest); isRequest {
+				c.exporter.export(other, t, c.detector.Direction(other))
+				break
+			}
+		}
+		c.pending.Add(key, t)
+	}
+	return c.next.Process(t)
+}
+
+// Doesn't close the exporter, which is shared by other collectors.
+func (c *collector) Close() error {
+	return c.next.Close()
+}
@@ -0,0 +1,193 @@
+package otlp_export
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/akitasoftware/akita-cli/trace"
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	traceID, parentID, ok := parseTraceparent("00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01")
+	assert.True(t, ok)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", traceID)
+	assert.Equal(t, "00f067aa0ba902b7", parentID)
+
+	for _, bad := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01",
+	} {
+		_, _, ok := parseTraceparent(bad)
+		assert.False(t, ok, bad)
+	}
+}
+
+func attrs(s span) map[string]string {
+	result := make(map[string]string, len(s.Attributes))
+	for _, kv := range s.Attributes {
+		if kv.Value.StringValue != nil {
+			result[kv.Key] = *kv.Value.StringValue
+		} else {
+			result[kv.Key] = *kv.Value.IntValue
+		}
+	}
+	return result
+}
+
+func TestExport(t *testing.T) {
+	var mutex sync.Mutex
+	var received []exportRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, DefaultTracesPath, r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "secret", r.Header.Get("Authorization"))
+
+		var req exportRequest
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.NoError(t, json.Unmarshal(body, &req))
+		mutex.Lock()
+		received = append(received, req)
+		mutex.Unlock()
+	}))
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "spans.json")
+	e, err := NewExporter(Options{
+		Endpoint: server.URL,
+		Headers:  map[string]string{"Authorization": "secret"},
+		File:     file,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	col := NewCollector(e, nil, trace.NewDummyCollector())
+
+	start := time.Unix(1600000000, 0)
+	stream := uuid.New()
+	send := func(seq int, path string, status int, header http.Header, responseFirst bool) {
+		u, _ := url.Parse(path)
+		req := akinet.ParsedNetworkTraffic{
+			SrcIP:           net.ParseIP("10.0.0.1"),
+			SrcPort:         50000,
+			DstIP:           net.ParseIP("10.0.0.2"),
+			DstPort:         8080,
+			Content:         akinet.HTTPRequest{StreamID: stream, Seq: seq, Method: "GET", Host: "api.example.com:8080", URL: u, Header: header, ProtoMajor: 1, ProtoMinor: 1},
+			ObservationTime: start,
+			FinalPacketTime: start.Add(time.Millisecond),
+		}
+		resp := akinet.ParsedNetworkTraffic{
+			Content:         akinet.HTTPResponse{StreamID: stream, Seq: seq, StatusCode: status, Body: []byte("{}")},
+			ObservationTime: start.Add(10 * time.Millisecond),
+			FinalPacketTime: start.Add(12 * time.Millisecond),
+		}
+		if responseFirst {
+			req, resp = resp, req
+		}
+		assert.NoError(t, col.Process(req))
+		if status != 0 {
+			assert.NoError(t, col.Process(resp))
+		}
+	}
+
+	send(1, "/users/1?token=x", 200, http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}}, false)
+	send(2, "/users/2", 503, nil, true)
+	send(3, "/users/3", 0, nil, false)
+	assert.NoError(t, col.Close())
+	assert.NoError(t, e.Close())
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if !assert.Len(t, received, 1) || !assert.Len(t, received[0].ResourceSpans, 1) {
+		return
+	}
+	rs := received[0].ResourceSpans[0]
+	assert.Equal(t, "service.name", rs.Resource.Attributes[0].Key)
+	assert.Equal(t, "api.example.com", *rs.Resource.Attributes[0].Value.StringValue)
+
+	spans := rs.ScopeSpans[0].Spans
+	if assert.Len(t, spans, 2) {
+		ok := spans[0]
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", ok.TraceID)
+		assert.Equal(t, "00f067aa0ba902b7", ok.ParentSpanID)
+		assert.Len(t, ok.SpanID, 16)
+		assert.Equal(t, "GET /users/{arg1}", ok.Name)
+		assert.Equal(t, spanKindServer, ok.Kind)
+		assert.Equal(t, uint64(start.UnixNano()), ok.StartTimeUnixNano)
+		assert.Equal(t, uint64(start.Add(12*time.Millisecond).UnixNano()), ok.EndTimeUnixNano)
+		assert.Equal(t, 0, ok.Status.Code)
+		assert.Equal(t, map[string]string{
+			"http.method":                  "GET",
+			"http.target":                  "/users/1",
+			"http.route":                   "/users/{arg1}",
+			"http.flavor":                  "1.1",
+			"http.status_code":             "200",
+			"http.host":                    "api.example.com:8080",
+			"net.host.name":                "api.example.com",
+			"http.response_content_length": "2",
+			"net.host.ip":                  "10.0.0.2",
+			"net.host.port":                "8080",
+			"net.peer.ip":                  "10.0.0.1",
+			"net.peer.port":                "50000",
+		}, attrs(ok))
+
+		failed := spans[1]
+		assert.Len(t, failed.TraceID, 32)
+		assert.Empty(t, failed.ParentSpanID)
+		assert.Equal(t, statusCodeError, failed.Status.Code)
+	}
+
+	// The file holds the same export request, on one line.
+	contents, err := ioutil.ReadFile(file)
+	if assert.NoError(t, err) {
+		lines := strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n")
+		if assert.Len(t, lines, 1) {
+			assert.Contains(t, lines[0], `"startTimeUnixNano":"1600000000000000000"`)
+			assert.Contains(t, lines[0], `"intValue":"503"`)
+		}
+	}
+}
+
+func TestExportFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	e, err := NewExporter(Options{Endpoint: server.URL + "/custom", ServiceName: "svc"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, server.URL+"/custom", e.endpoint)
+
+	u, _ := url.Parse("/")
+	e.export(
+		akinet.ParsedNetworkTraffic{Content: akinet.HTTPRequest{Method: "GET", URL: u}},
+		akinet.ParsedNetworkTraffic{Content: akinet.HTTPResponse{StatusCode: 200}},
+		"",
+	)
+	err = e.Close()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "400 Bad Request")
+	}
+	// Closing again is harmless.
+	assert.Equal(t, err, e.Close())
+
+	_, err = NewExporter(Options{Endpoint: "localhost:4318"}, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,304 @@
+package otlp_export
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-cli/path_inference"
+	"github.com/akitasoftware/akita-cli/printer"
+	"github.com/akitasoftware/akita-cli/traffic_direction"
+	"github.com/akitasoftware/akita-cli/version"
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+const (
+	// Path to which spans are posted if the endpoint URL has no path.
+	DefaultTracesPath = "/v1/traces"
+
+	// Reported as service.name for spans whose service can't be determined.
+	unknownService = "unknown_service"
+
+	// Most spans sent in one export request.
+	maxBatchSize = 512
+
+	// Spans waiting to be exported beyond this are dropped.
+	maxQueuedSpans = 8 * maxBatchSize
+
+	// How often queued spans are exported.
+	flushInterval = 5 * time.Second
+
+	// How long to wait for the OTLP endpoint to accept a batch.
+	exportTimeout = 10 * time.Second
+)
+
+type Options struct {
+	// URL of an OTLP/HTTP receiver, e.g. http://localhost:4318. Spans are
+	// posted to DefaultTracesPath unless the URL has a path.
+	Endpoint string
+
+	// Extra HTTP headers sent with each export request, e.g. for
+	// authentication.
+	Headers map[string]string
+
+	// If set, spans are appended to this file in the OTLP JSON file format:
+	// one export request per line.
+	File string
+
+	// Reported as service.name for all spans. If unset, each span belongs to
+	// the service named by the Host header of its request.
+	ServiceName string
+}
+
+// Exports spans for HTTP exchanges to an OTLP/HTTP endpoint, a file, or
+// both. Spans are queued and exported in batches in the background.
+//
+// Safe for concurrent use, and meant to be shared by all collectors.
+type Exporter struct {
+	opts     Options
+	endpoint string
+	client   *http.Client
+	file     *os.File
+
+	mutex    sync.Mutex
+	inferrer *path_inference.Inferrer
+	queue    []span
+	exported int
+	dropped  int
+	failed   int
+
+	// Signals the background goroutine to export the queue early.
+	full chan struct{}
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// Span routes are path templates from the given inferrer, which may be shared
+// with other consumers. If it is nil, a private one is used.
+func NewExporter(opts Options, inferrer *path_inference.Inferrer) (*Exporter, error) {
+	if opts.Endpoint == "" && opts.File == "" {
+		return nil, errors.New("either an OTLP endpoint or a file must be given")
+	}
+
+	if inferrer == nil {
+		inferrer = path_inference.NewInferrer(nil, nil)
+	}
+
+	e := &Exporter{
+		opts:     opts,
+		client:   &http.Client{Timeout: exportTimeout},
+		inferrer: inferrer,
+		full:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+
+	if opts.Endpoint != "" {
+		u, err := url.Parse(opts.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, errors.Errorf("bad OTLP endpoint %q; must be an http or https URL", opts.Endpoint)
+		}
+		if u.Path == "" || u.Path == "/" {
+			u.Path = DefaultTracesPath
+		}
+		e.endpoint = u.String()
+	}
+
+	if opts.File != "" {
+		f, err := os.OpenFile(opts.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to open OTLP span file %s", opts.File)
+		}
+		e.file = f
+	}
+
+	e.wg.Add(1)
+	go e.periodicFlush()
+	return e, nil
+}
+
+// Queues a span for a request and its response.
+func (e *Exporter) export(req, resp akinet.ParsedNetworkTraffic, direction traffic_direction.Direction) {
+	httpReq := req.Content.(akinet.HTTPRequest)
+	path := "/"
+	if u := httpReq.URL; u != nil && u.Path != "" {
+		path = u.Path
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	route := e.inferrer.ObserveEndpoint(httpReq.Method, httpReq.Host, path).Template
+	if len(e.queue) >= maxQueuedSpans {
+		e.dropped++
+		return
+	}
+	e.queue = append(e.queue, newSpan(req, resp, direction, route, e.opts.ServiceName))
+	if len(e.queue) == maxBatchSize {
+		select {
+		case e.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (e *Exporter) periodicFlush() {
+	defer e.wg.Done()
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.done:
+			return
+		case <-ticker.C:
+		case <-e.full:
+		}
+		e.flush()
+	}
+}
+
+// Exports all queued spans, in batches of at most maxBatchSize. Batches that
+// fail to export are dropped.
+func (e *Exporter) flush() error {
+	var lastErr error
+	for {
+		e.mutex.Lock()
+		n := len(e.queue)
+		if n > maxBatchSize {
+			n = maxBatchSize
+		}
+		batch := e.queue[:n:n]
+		e.queue = e.queue[n:]
+		e.mutex.Unlock()
+
+		if len(batch) == 0 {
+			return lastErr
+		}
+
+		err := e.send(batch)
+		e.mutex.Lock()
+		firstFailure := err != nil && e.failed == 0
+		if err != nil {
+			e.failed += len(batch)
+			lastErr = err
+		} else {
+			e.exported += len(batch)
+		}
+		e.mutex.Unlock()
+
+		// Only warn once, so that an unreachable endpoint doesn't flood the
+		// output. The total is reported by Close.
+		if firstFailure {
+			printer.Warningf("Failed to export %d spans: %v\n", len(batch), err)
+		} else if err != nil {
+			printer.Debugf("Failed to export %d spans: %v\n", len(batch), err)
+		}
+	}
+}
+
+func (e *Exporter) send(batch []span) error {
+	body, err := json.Marshal(newExportRequest(batch))
+	if err != nil {
+		return errors.Wrap(err, "failed to encode spans")
+	}
+
+	if e.file != nil {
+		if _, err := e.file.Write(append(body, '\n')); err != nil {
+			return errors.Wrapf(err, "failed to write to %s", e.opts.File)
+		}
+	}
+
+	if e.endpoint != "" {
+		req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range e.opts.Headers {
+			req.Header.Set(k, v)
+		}
+
+		resp, err := e.client.Do(req)
+		if err != nil {
+			return errors.Wrapf(err, "failed to post to %s", e.endpoint)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+			return errors.Errorf("%s responded with %s: %s", e.endpoint, resp.Status, bytes.TrimSpace(msg))
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+	}
+	return nil
+}
+
+// Groups spans into one resource per service, ordered by service name.
+func newExportRequest(spans []span) exportRequest {
+	byService := make(map[string][]span)
+	for _, s := range spans {
+		service := s.service
+		if service == "" {
+			service = unknownService
+		}
+		byService[service] = append(byService[service], s)
+	}
+
+	services := make([]string, 0, len(byService))
+	for service := range byService {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	result := exportRequest{ResourceSpans: make([]resourceSpans, 0, len(services))}
+	for _, service := range services {
+		result.ResourceSpans = append(result.ResourceSpans, resourceSpans{
+			Resource: resource{
+				Attributes: []keyValue{stringAttr("service.name", service)},
+			},
+			ScopeSpans: []scopeSpans{{
+				Scope: scope{Name: scopeName, Version: version.ReleaseVersion().String()},
+				Spans: byService[service],
+			}},
+		})
+	}
+	return result
+}
+
+// Stops the background export, exports any spans still queued, and prints
+// a summary. Returns an error if the last batch couldn't be exported. Later
+// calls return the same error without doing anything.
+func (e *Exporter) Close() error {
+	e.closeOnce.Do(func() { e.closeErr = e.close() })
+	return e.closeErr
+}
+
+func (e *Exporter) close() error {
+	close(e.done)
+	e.wg.Wait()
+	err := e.flush()
+
+	if e.file != nil {
+		if closeErr := e.file.Close(); closeErr != nil && err == nil {
+			err = errors.Wrapf(closeErr, "failed to close %s", e.opts.File)
+		}
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	printer.Stderr.Infof("Exported %d spans over OTLP\n", e.exported)
+	if e.failed > 0 || e.dropped > 0 {
+		printer.Stderr.Warningf("%d spans failed to export and %d were dropped because the export queue was full\n", e.failed, e.dropped)
+	}
+	return err
+}
@@ -0,0 +1,238 @@
+package otlp_export
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/akitasoftware/akita-cli/traffic_direction"
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+// Span kinds and status codes, as numbered in the OTLP protobuf definitions.
+const (
+	spanKindServer = 2
+	spanKindClient = 3
+
+	// Spans that aren't errors leave the status unset.
+	statusCodeError = 2
+)
+
+// Name of the instrumentation scope reported with each span.
+const scopeName = "github.com/akitasoftware/akita-cli"
+
+// The body of an OTLP/HTTP export request, in the JSON encoding of
+// ExportTraceServiceRequest. IDs are hex-encoded and 64-bit integers are
+// strings, as required by OTLP/JSON.
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope  `json:"scope"`
+	Spans []span `json:"spans"`
+}
+
+type scope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type span struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano uint64     `json:"startTimeUnixNano,string"`
+	EndTimeUnixNano   uint64     `json:"endTimeUnixNano,string"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            status     `json:"status"`
+
+	// Name of the service the span belongs to. Spans are grouped into
+	// resources by service.
+	service string
+}
+
+type status struct {
+	Code int `json:"code"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+// Exactly one field is set.
+type anyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+func stringAttr(key, value string) keyValue {
+	return keyValue{Key: key, Value: anyValue{StringValue: &value}}
+}
+
+func intAttr(key string, value int64) keyValue {
+	s := strconv.FormatInt(value, 10)
+	return keyValue{Key: key, Value: anyValue{IntValue: &s}}
+}
+
+// Builds a span for an HTTP exchange, using the HTTP semantic conventions.
+// The span runs from the first packet of the request to the last packet of
+// the response. Route is the request path with path parameters replaced by
+// placeholders, and is used to name the span.
+//
+// If the request carries a W3C traceparent header, the span joins that trace
+// as a child of the caller's span. Otherwise, it starts a new trace.
+//
+// Query strings, bodies and headers other than User-Agent aren't included,
+// since they may contain sensitive values.
+func newSpan(req akinet.ParsedNetworkTraffic, resp akinet.ParsedNetworkTraffic, direction traffic_direction.Direction, route, serviceName string) span {
+	httpReq := req.Content.(akinet.HTTPRequest)
+	httpResp := resp.Content.(akinet.HTTPResponse)
+
+	s := span{
+		SpanID:            randomHex(8),
+		Name:              httpReq.Method + " " + route,
+		Kind:              spanKindServer,
+		StartTimeUnixNano: unixNano(req.ObservationTime),
+		EndTimeUnixNano:   unixNano(lastPacketTime(resp)),
+		service:           serviceName,
+	}
+	if traceID, parentID, ok := parseTraceparent(httpReq.Header.Get("traceparent")); ok {
+		s.TraceID, s.ParentSpanID = traceID, parentID
+	} else {
+		s.TraceID = randomHex(16)
+	}
+	if s.EndTimeUnixNano < s.StartTimeUnixNano {
+		s.EndTimeUnixNano = s.StartTimeUnixNano
+	}
+
+	host, port := splitHostPort(httpReq.Host)
+	if s.service == "" {
+		s.service = host
+	}
+	path := route
+	if httpReq.URL != nil {
+		path = httpReq.URL.Path
+	}
+	s.Attributes = []keyValue{
+		stringAttr("http.method", httpReq.Method),
+		stringAttr("http.target", path),
+		stringAttr("http.route", route),
+		stringAttr("http.flavor", strconv.Itoa(httpReq.ProtoMajor)+"."+strconv.Itoa(httpReq.ProtoMinor)),
+		intAttr("http.status_code", int64(httpResp.StatusCode)),
+	}
+	if host != "" {
+		s.Attributes = append(s.Attributes, stringAttr("http.host", httpReq.Host))
+		s.Attributes = append(s.Attributes, stringAttr("net.host.name", host))
+	}
+	if ua := httpReq.Header.Get("User-Agent"); ua != "" {
+		s.Attributes = append(s.Attributes, stringAttr("http.user_agent", ua))
+	}
+	if httpReq.Body != nil {
+		s.Attributes = append(s.Attributes, intAttr("http.request_content_length", int64(len(httpReq.Body))))
+	}
+	if httpResp.Body != nil {
+		s.Attributes = append(s.Attributes, intAttr("http.response_content_length", int64(len(httpResp.Body))))
+	}
+
+	// For server spans, the peer is the client that sent the request; for
+	// client spans, it is the server that received it.
+	hostIP, hostPort, peerIP, peerPort := req.DstIP, req.DstPort, req.SrcIP, req.SrcPort
+	if direction == traffic_direction.Outbound {
+		hostIP, hostPort, peerIP, peerPort = req.SrcIP, req.SrcPort, req.DstIP, req.DstPort
+	}
+	if hostPort == 0 && port != "" {
+		hostPort, _ = strconv.Atoi(port)
+	}
+	if hostIP != nil {
+		s.Attributes = append(s.Attributes, stringAttr("net.host.ip", hostIP.String()))
+	}
+	if hostPort != 0 {
+		s.Attributes = append(s.Attributes, intAttr("net.host.port", int64(hostPort)))
+	}
+	if peerIP != nil {
+		s.Attributes = append(s.Attributes, stringAttr("net.peer.ip", peerIP.String()))
+	}
+	if peerPort != 0 {
+		s.Attributes = append(s.Attributes, intAttr("net.peer.port", int64(peerPort)))
+	}
+
+	// Server spans are errors only for 5xx responses; client spans are errors
+	// for 4xx responses as well.
+	if direction == traffic_direction.Outbound {
+		s.Kind = spanKindClient
+		if httpResp.StatusCode >= 400 {
+			s.Status.Code = statusCodeError
+		}
+	} else if httpResp.StatusCode >= 500 {
+		s.Status.Code = statusCodeError
+	}
+	if httpResp.StatusCode < 100 || httpResp.StatusCode > 599 {
+		s.Status.Code = statusCodeError
+	}
+	return s
+}
+
+func lastPacketTime(t akinet.ParsedNetworkTraffic) time.Time {
+	if t.FinalPacketTime.IsZero() {
+		return t.ObservationTime
+	}
+	return t.FinalPacketTime
+}
+
+func unixNano(t time.Time) uint64 {
+	if t.IsZero() || t.UnixNano() < 0 {
+		return 0
+	}
+	return uint64(t.UnixNano())
+}
+
+func splitHostPort(hostport string) (string, string) {
+	if host, port, err := net.SplitHostPort(hostport); err == nil {
+		return host, port
+	}
+	return hostport, ""
+}
+
+// Extracts the trace ID and parent span ID from a W3C traceparent header of
+// the form "00-<32 hex digits>-<16 hex digits>-<2 hex digits>".
+func parseTraceparent(header string) (traceID, parentID string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return "", "", false
+	}
+	traceID, parentID = strings.ToLower(parts[1]), strings.ToLower(parts[2])
+	if len(traceID) != 32 || len(parentID) != 16 || !isHex(traceID) || !isHex(parentID) {
+		return "", "", false
+	}
+	if strings.Trim(traceID, "0") == "" || strings.Trim(parentID, "0") == "" {
+		return "", "", false
+	}
+	return traceID, parentID, true
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
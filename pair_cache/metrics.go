@@ -0,0 +1,110 @@
+package pair_cache
+
+import (
+	"sync"
+
+	"github.com/akitasoftware/akita-cli/prom_metrics"
+)
+
+type Metrics struct {
+	// Lookups that found the other half of a witness.
+	Hits int
+
+	// Lookups that found nothing, after which the caller normally adds the
+	// partial witness to the cache.
+	Misses int
+
+	// Entries removed because they were older than the TTL.
+	Expirations int
+
+	// Entries removed because the cache was full.
+	Evictions int
+}
+
+// Totals across all caches in this process, by cache name.
+var totals = metricTotals{
+	metrics: map[string]*Metrics{},
+	entries: map[string]int{},
+}
+
+type metricTotals struct {
+	mutex   sync.Mutex
+	metrics map[string]*Metrics
+
+	// Number of entries held.
+	entries map[string]int
+}
+
+func (t *metricTotals) add(name string, m Metrics) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	total, ok := t.metrics[name]
+	if !ok {
+		total = &Metrics{}
+		t.metrics[name] = total
+	}
+	total.Hits += m.Hits
+	total.Misses += m.Misses
+	total.Expirations += m.Expirations
+	total.Evictions += m.Evictions
+}
+
+func (t *metricTotals) addEntries(name string, n int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.entries[name] += n
+}
+
+// Returns the sum of the metrics of all witness pair caches created in this
+// process.
+func GetTotals() Metrics {
+	return GetTotalsFor(WitnessCache)
+}
+
+// Returns the sum of the metrics of all caches with the given name created in
+// this process.
+func GetTotalsFor(name string) Metrics {
+	totals.mutex.Lock()
+	defer totals.mutex.Unlock()
+	if m, ok := totals.metrics[name]; ok {
+		return *m
+	}
+	return Metrics{}
+}
+
+// Number of entries held by all witness pair caches in this process.
+func TotalEntries() int {
+	totals.mutex.Lock()
+	defer totals.mutex.Unlock()
+	return totals.entries[WitnessCache]
+}
+
+func init() {
+	prom_metrics.Default.NewGaugeFunc(
+		"akita_pair_cache_entries",
+		"Partial witnesses, or requests and responses, held while waiting for their pair, by cache.",
+		[]string{"cache"},
+		func(emit func(float64, ...string)) {
+			totals.mutex.Lock()
+			defer totals.mutex.Unlock()
+			for name, n := range totals.entries {
+				emit(float64(n), name)
+			}
+		},
+	)
+	prom_metrics.Default.NewCounterFunc(
+		"akita_pair_cache_events_total",
+		"Pair cache lookups and removals, by cache and outcome.",
+		[]string{"cache", "event"},
+		func(emit func(float64, ...string)) {
+			totals.mutex.Lock()
+			defer totals.mutex.Unlock()
+			for name, m := range totals.metrics {
+				emit(float64(m.Hits), name, "hit")
+				emit(float64(m.Misses), name, "miss")
+				emit(float64(m.Expirations), name, "expiration")
+				emit(float64(m.Evictions), name, "eviction")
+			}
+		},
+	)
+}
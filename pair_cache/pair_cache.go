@@ -0,0 +1,189 @@
+package pair_cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/akitasoftware/akita-libs/akid"
+	"github.com/akitasoftware/akita-libs/tags"
+)
+
+const (
+	// Default limit on the number of partial witnesses held in a cache.
+	DefaultMaxEntries = 10000
+
+	// By default, we stop trying to pair partial witnesses after this long.
+	DefaultTTL = time.Minute
+
+	// Reports of witnesses that were never paired carry this tag, with the
+	// value "true".
+	UnpairedTag tags.Key = "x-akita-unpaired"
+
+	// The default name of a cache, for caches of partial witnesses waiting to
+	// be uploaded or written.
+	WitnessCache = "witnesses"
+)
+
+type Options struct {
+	// Names the cache in metrics. Caches that pair traffic for other
+	// purposes, e.g. to compute metrics or spans, should be named after that
+	// purpose, so that their lookups are counted apart from the witness
+	// caches'. Defaults to WitnessCache.
+	Name string
+
+	// Maximum number of entries. When the cache is full, adding an entry
+	// evicts the oldest one. Defaults to DefaultMaxEntries.
+	MaxEntries int
+
+	// How long entries wait for their pair before they expire. Defaults to
+	// DefaultTTL.
+	TTL time.Duration
+}
+
+// Holds partial witnesses -- requests or responses -- until their other half
+// arrives. The cache is bounded in both size and age: entries that are
+// evicted or expire are handed back to the caller, which is expected to
+// process them as unpaired witnesses.
+//
+// Eviction and expiry are first in, first out. Entries are never looked at
+// without being removed by Take, so there is no use to track beyond the time
+// an entry was added. Adding a key that is already present moves it to the
+// back.
+//
+// Safe for concurrent use.
+type Cache struct {
+	opts Options
+
+	mutex   sync.Mutex
+	entries map[akid.WitnessID]*list.Element
+
+	// Entries in the order they were added, oldest first.
+	order *list.List
+
+	metrics Metrics
+}
+
+type entry struct {
+	key   akid.WitnessID
+	value interface{}
+	added time.Time
+}
+
+func New(opts Options) *Cache {
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = DefaultMaxEntries
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultTTL
+	}
+	if opts.Name == "" {
+		opts.Name = WitnessCache
+	}
+	return &Cache{
+		opts:    opts,
+		entries: make(map[akid.WitnessID]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (c *Cache) TTL() time.Duration {
+	return c.opts.TTL
+}
+
+// Removes and returns the entry for key, if any.
+func (c *Cache) Take(key akid.WitnessID) (interface{}, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		c.metrics.Misses++
+		totals.add(c.opts.Name, Metrics{Misses: 1})
+		return nil, false
+	}
+	c.remove(elem)
+	c.metrics.Hits++
+	totals.add(c.opts.Name, Metrics{Hits: 1})
+	return elem.Value.(*entry).value, true
+}
+
+// Adds an entry for key, replacing any existing one. If the cache is full,
+// the oldest entries are evicted and returned.
+func (c *Cache) Add(key akid.WitnessID, value interface{}) []interface{} {
+	return c.AddAt(key, value, time.Now())
+}
+
+// Like Add, but the entry is treated as added at the given time, e.g. when
+// its traffic was observed, for the purposes of Expire. Since expiry is first
+// in, first out, entries should be added in roughly the order of their times.
+func (c *Cache) AddAt(key akid.WitnessID, value interface{}, at time.Time) []interface{} {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	c.entries[key] = c.order.PushBack(&entry{key: key, value: value, added: at})
+	totals.addEntries(c.opts.Name, 1)
+
+	var evicted []interface{}
+	for c.order.Len() > c.opts.MaxEntries {
+		evicted = append(evicted, c.remove(c.order.Front()))
+	}
+	c.metrics.Evictions += len(evicted)
+	totals.add(c.opts.Name, Metrics{Evictions: len(evicted)})
+	return evicted
+}
+
+// Removes and returns all entries that have been in the cache for longer than
+// the TTL as of now.
+func (c *Cache) Expire(now time.Time) []interface{} {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	cutoff := now.Add(-c.opts.TTL)
+	var expired []interface{}
+	for c.order.Len() > 0 {
+		front := c.order.Front()
+		if !front.Value.(*entry).added.Before(cutoff) {
+			break
+		}
+		expired = append(expired, c.remove(front))
+	}
+	c.metrics.Expirations += len(expired)
+	totals.add(c.opts.Name, Metrics{Expirations: len(expired)})
+	return expired
+}
+
+// Removes and returns all entries, oldest first.
+func (c *Cache) Drain() []interface{} {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	result := make([]interface{}, 0, c.order.Len())
+	for c.order.Len() > 0 {
+		result = append(result, c.remove(c.order.Front()))
+	}
+	return result
+}
+
+func (c *Cache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.order.Len()
+}
+
+// Returns a snapshot of this cache's metrics.
+func (c *Cache) Metrics() Metrics {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.metrics
+}
+
+func (c *Cache) remove(elem *list.Element) interface{} {
+	e := c.order.Remove(elem).(*entry)
+	delete(c.entries, e.key)
+	totals.addEntries(c.opts.Name, -1)
+	return e.value
+}
@@ -0,0 +1,75 @@
+package pair_cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/akitasoftware/akita-libs/akid"
+)
+
+func newKey() akid.WitnessID {
+	return akid.GenerateWitnessID()
+}
+
+func TestEviction(t *testing.T) {
+	c := New(Options{MaxEntries: 2})
+	k1, k2, k3 := newKey(), newKey(), newKey()
+
+	assert.Empty(t, c.Add(k1, "one"))
+	assert.Empty(t, c.Add(k2, "two"))
+	assert.Equal(t, []interface{}{"one"}, c.Add(k3, "three"))
+	assert.Equal(t, 2, c.Len())
+
+	_, ok := c.Take(k1)
+	assert.False(t, ok)
+	v, ok := c.Take(k2)
+	assert.True(t, ok)
+	assert.Equal(t, "two", v)
+
+	assert.Equal(t, []interface{}{"three"}, c.Drain())
+	assert.Equal(t, 0, c.Len())
+	assert.Equal(t, Metrics{Hits: 1, Misses: 1, Evictions: 1}, c.Metrics())
+}
+
+func TestReAddMovesToBack(t *testing.T) {
+	c := New(Options{MaxEntries: 2})
+	k1, k2, k3 := newKey(), newKey(), newKey()
+
+	c.Add(k1, "one")
+	c.Add(k2, "two")
+	assert.Empty(t, c.Add(k1, "one again"))
+	assert.Equal(t, []interface{}{"two"}, c.Add(k3, "three"))
+	assert.Equal(t, []interface{}{"one again", "three"}, c.Drain())
+}
+
+func TestExpire(t *testing.T) {
+	c := New(Options{TTL: time.Minute})
+	k1, k2 := newKey(), newKey()
+
+	c.Add(k1, "one")
+	c.Add(k2, "two")
+	assert.Empty(t, c.Expire(time.Now()))
+	assert.Equal(t, []interface{}{"one", "two"}, c.Expire(time.Now().Add(2*time.Minute)))
+	assert.Equal(t, 0, c.Len())
+	assert.Equal(t, Metrics{Expirations: 2}, c.Metrics())
+}
+
+func TestDefaults(t *testing.T) {
+	c := New(Options{})
+	assert.Equal(t, DefaultTTL, c.TTL())
+	assert.Equal(t, DefaultMaxEntries, c.opts.MaxEntries)
+}
+
+func TestTotalsByName(t *testing.T) {
+	before := GetTotals()
+	c := New(Options{Name: "test"})
+	k := newKey()
+	c.Take(k)
+	c.Add(k, "one")
+	c.Take(k)
+
+	assert.Equal(t, Metrics{Hits: 1, Misses: 1}, GetTotalsFor("test"))
+	assert.Equal(t, before, GetTotals(), "witness cache totals changed")
+}
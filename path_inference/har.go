@@ -0,0 +1,159 @@
+package path_inference
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-cli/har_loader"
+)
+
+// HAR files are handled as generic JSON, rather than through har_loader, so
+// that fields we don't otherwise care about survive the rewrite.
+type harFile map[string]interface{}
+
+func loadHARFile(path string) (harFile, error) {
+	f, err := har_loader.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var h harFile
+	if err := json.NewDecoder(f).Decode(&h); err != nil {
+		return nil, errors.Wrap(err, "failed to parse HAR file")
+	}
+	return h, nil
+}
+
+// Calls f with the request object of each entry in the HAR file.
+func (h harFile) forEachRequest(f func(req map[string]interface{})) {
+	log, _ := h["log"].(map[string]interface{})
+	entries, _ := log["entries"].([]interface{})
+	for _, e := range entries {
+		entry, _ := e.(map[string]interface{})
+		if req, ok := entry["request"].(map[string]interface{}); ok {
+			f(req)
+		}
+	}
+}
+
+func requestURL(req map[string]interface{}) (*url.URL, bool) {
+	raw, ok := req["url"].(string)
+	if !ok {
+		return nil, false
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, false
+	}
+	return u, true
+}
+
+// Returns the URL as a string with its path replaced by the given template,
+// as returned by Template along with args. Unlike url.URL.String, the braces
+// around path parameters aren't escaped, though any other braces are.
+func withTemplatePath(u *url.URL, template string, args []PathArg) string {
+	prefix := *u
+	prefix.Path, prefix.RawPath = "", ""
+	prefix.RawQuery, prefix.ForceQuery = "", false
+	prefix.Fragment, prefix.RawFragment = "", ""
+
+	// Template keeps the segments of the path, replacing those that are path
+	// parameters, in order, with placeholders.
+	segments := strings.Split(template, "/")
+	concrete := strings.Split(u.Path, "/")
+	for i, s := range segments {
+		if len(args) > 0 && i < len(concrete) && s == "{"+args[0].Name+"}" && concrete[i] == args[0].Value {
+			args = args[1:]
+			continue
+		}
+		segments[i] = (&url.URL{Path: s}).EscapedPath()
+	}
+
+	result := prefix.String() + strings.Join(segments, "/")
+	if u.ForceQuery || u.RawQuery != "" {
+		result += "?" + u.RawQuery
+	}
+	if u.Fragment != "" {
+		result += "#" + u.EscapedFragment()
+	}
+	return result
+}
+
+// Records the request paths in a HAR file for use in inference.
+func (inf *Inferrer) ObserveHARFile(path string) error {
+	h, err := loadHARFile(path)
+	if err != nil {
+		return err
+	}
+	h.forEachRequest(func(req map[string]interface{}) {
+		if u, ok := requestURL(req); ok {
+			inf.Observe(u.Path)
+		}
+	})
+	return nil
+}
+
+// Rewrites the request URLs in a HAR file in place, replacing concrete paths
+// with their inferred templates.
+func (inf *Inferrer) RewriteHARFile(path string) error {
+	h, err := loadHARFile(path)
+	if err != nil {
+		return err
+	}
+	h.forEachRequest(func(req map[string]interface{}) {
+		if u, ok := requestURL(req); ok {
+			template, args := inf.Template(u.Path)
+			req["url"] = withTemplatePath(u, template, args)
+		}
+	})
+
+	content, err := json.Marshal(h)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal HAR to JSON")
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	// Keep gzipped files gzipped.
+	if har_loader.IsGzipped(path) {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		if _, err := gz.Write(content); err != nil {
+			return errors.Wrap(err, "failed to compress HAR file")
+		}
+		if err := gz.Close(); err != nil {
+			return errors.Wrap(err, "failed to compress HAR file")
+		}
+		content = buf.Bytes()
+	}
+	if err := ioutil.WriteFile(path, content, fi.Mode()); err != nil {
+		return errors.Wrap(err, "failed to write HAR file")
+	}
+	return nil
+}
+
+// Infers path parameters from all the given HAR files, then rewrites each of
+// them to use the inferred path templates.
+func InferHARFiles(paths []string, inf *Inferrer) error {
+	for _, p := range paths {
+		if err := inf.ObserveHARFile(p); err != nil {
+			return errors.Wrapf(err, "failed to load %s", p)
+		}
+	}
+	for _, p := range paths {
+		if err := inf.RewriteHARFile(p); err != nil {
+			return errors.Wrapf(err, "failed to rewrite %s", p)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,297 @@
+package path_inference
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	pb "github.com/akitasoftware/akita-ir/go/api_spec"
+	pp "github.com/akitasoftware/akita-libs/path_pattern"
+	"github.com/akitasoftware/akita-libs/spec_util"
+	"github.com/akitasoftware/akita-libs/spec_util/ir_hash"
+)
+
+// If more than this many distinct values are seen at the same position in
+// otherwise-identical paths, the position is assumed to be a path parameter,
+// even if the individual values don't look like IDs.
+const DefaultMaxDistinctValues = 50
+
+// Infers path parameters from concrete request paths, so that paths like
+// /users/8f3a.../orders/12 can be generalized to /users/{arg1}/orders/{arg2}
+// without the help of Akita Cloud.
+//
+// Paths are clustered by segment position in a trie. A segment becomes a
+// parameter if its value looks like an ID (UUIDs, integers, hashes, dates), or
+// if too many distinct values have been seen at its position.
+//
+// User-supplied patterns, in the same format as --path-parameters, take
+// precedence over inference. Paths matching any of the exclusions are neither
+// used for inference nor rewritten.
+type Inferrer struct {
+	mutex sync.Mutex
+	root  *node
+
+	patterns          []pp.Pattern
+	exclusions        []*regexp.Regexp
+	maxDistinctValues int
+}
+
+func NewInferrer(patterns []pp.Pattern, exclusions []*regexp.Regexp) *Inferrer {
+	return &Inferrer{
+		root:              newNode(),
+		patterns:          patterns,
+		exclusions:        exclusions,
+		maxDistinctValues: DefaultMaxDistinctValues,
+	}
+}
+
+type node struct {
+	// Children for segments that are a fixed part of the path.
+	literals map[string]*node
+
+	// Child for the segment, if it is a path parameter.
+	param *node
+
+	// True once too many distinct literals have been seen at this position.
+	collapsed bool
+}
+
+func newNode() *node {
+	return &node{literals: make(map[string]*node)}
+}
+
+// Returns the child for the given segment, creating it if necessary.
+func (n *node) child(s string, force forcedKind, maxDistinctValues int) *node {
+	if force == forceParam || (force == notForced && s != "" && (n.collapsed || isIDSegment(s))) {
+		if n.param == nil {
+			n.param = newNode()
+		}
+		return n.param
+	}
+
+	c, ok := n.literals[s]
+	if !ok {
+		c = newNode()
+		n.literals[s] = c
+		if force == notForced && s != "" && len(n.literals) > maxDistinctValues {
+			n.collapse()
+			return n.param
+		}
+	}
+	return c
+}
+
+// Returns the child for the given segment, or nil if it has not been observed,
+// and whether the segment is a path parameter.
+func (n *node) lookup(s string, force forcedKind) (*node, bool) {
+	if force == forceParam {
+		return n.param, true
+	}
+	if c, ok := n.literals[s]; ok && (force == forceLiteral || !isIDSegment(s)) {
+		return c, false
+	}
+	if force == notForced && s != "" && (n.collapsed || isIDSegment(s)) {
+		return n.param, true
+	}
+	return nil, false
+}
+
+// Folds all non-empty literal children into the parameter child.
+func (n *node) collapse() {
+	for s, c := range n.literals {
+		if s == "" {
+			continue
+		}
+		n.param = mergeNodes(n.param, c)
+		delete(n.literals, s)
+	}
+	n.collapsed = true
+}
+
+func mergeNodes(dst, src *node) *node {
+	if dst == nil {
+		return src
+	}
+	for s, c := range src.literals {
+		dst.literals[s] = mergeNodes(dst.literals[s], c)
+	}
+	dst.param = mergeNodes(dst.param, src.param)
+	dst.collapsed = dst.collapsed || src.collapsed
+	return dst
+}
+
+// How a user-supplied pattern constrains a path segment.
+type forcedKind int
+
+const (
+	notForced forcedKind = iota
+	forceParam
+	forceLiteral
+)
+
+func forcedKindOf(c pp.Component) forcedKind {
+	switch c.(type) {
+	case pp.Var:
+		return forceParam
+	case pp.Val, pp.Placeholder:
+		return forceLiteral
+	}
+	return notForced
+}
+
+func (inf *Inferrer) isExcluded(path string) bool {
+	for _, r := range inf.exclusions {
+		if r.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the first user-supplied pattern matching the path, if any.
+func (inf *Inferrer) patternFor(path string) pp.Pattern {
+	for _, p := range inf.patterns {
+		if p.Match(path) {
+			return p
+		}
+	}
+	return nil
+}
+
+// Records a concrete request path for use in inference.
+func (inf *Inferrer) Observe(path string) {
+	if inf.isExcluded(path) {
+		return
+	}
+	pattern := inf.patternFor(path)
+
+	inf.mutex.Lock()
+	defer inf.mutex.Unlock()
+
+	n := inf.root
+	for i, s := range strings.Split(path, "/") {
+		force := notForced
+		if i < len(pattern) {
+			force = forcedKindOf(pattern[i])
+		}
+		n = n.child(s, force, inf.maxDistinctValues)
+	}
+}
+
+// A concrete value of a path parameter.
+type PathArg struct {
+	Name  string
+	Value string
+}
+
+// Returns the path template for the given concrete path, along with the values
+// of any path parameters in it. Paths that have not been observed are
+// generalized based on the shape of their segments alone.
+func (inf *Inferrer) Template(path string) (string, []PathArg) {
+	if inf.isExcluded(path) {
+		return path, nil
+	}
+	pattern := inf.patternFor(path)
+
+	inf.mutex.Lock()
+	defer inf.mutex.Unlock()
+
+	segments := strings.Split(path, "/")
+	result := make([]string, len(segments))
+	var args []PathArg
+	n := inf.root
+	for i, s := range segments {
+		var component pp.Component
+		force := notForced
+		if i < len(pattern) {
+			component = pattern[i]
+			force = forcedKindOf(component)
+		}
+
+		isParam := false
+		if n != nil {
+			n, isParam = n.lookup(s, force)
+		} else {
+			isParam = force == forceParam || (force == notForced && isIDSegment(s))
+		}
+
+		if !isParam {
+			result[i] = s
+			continue
+		}
+
+		name := fmt.Sprintf("arg%d", len(args)+1)
+		if v, ok := component.(pp.Var); ok {
+			name = string(v)
+		}
+		result[i] = "{" + name + "}"
+		args = append(args, PathArg{Name: name, Value: s})
+	}
+	return strings.Join(result, "/"), args
+}
+
+// An HTTP endpoint, identified by its method, host and path template.
+type Endpoint struct {
+	Method   string
+	Host     string
+	Template string
+}
+
+// E.g. "GET example.com/users/{arg1}".
+func (e Endpoint) String() string {
+	return e.Method + " " + e.Host + e.Template
+}
+
+// Records a concrete request path for use in inference, and returns the
+// endpoint of the request.
+func (inf *Inferrer) ObserveEndpoint(method, host, path string) Endpoint {
+	inf.Observe(path)
+	template, _ := inf.Template(path)
+	return Endpoint{Method: method, Host: host, Template: template}
+}
+
+// Records the path of an HTTP witness for use in inference.
+func (inf *Inferrer) ObserveWitness(w *pb.Witness) {
+	if meta := spec_util.HTTPMetaFromMethod(w.GetMethod()); meta != nil {
+		inf.Observe(meta.PathTemplate)
+	}
+}
+
+// Replaces the concrete path of an HTTP witness with its inferred template,
+// and adds the concrete values of the path parameters to the witness as
+// arguments. The witness is modified in place.
+func (inf *Inferrer) GeneralizeWitness(w *pb.Witness) {
+	method := w.GetMethod()
+	meta := spec_util.HTTPMetaFromMethod(method)
+	if meta == nil {
+		return
+	}
+
+	template, args := inf.Template(meta.PathTemplate)
+	meta.PathTemplate = template
+	if len(args) == 0 {
+		return
+	}
+
+	if method.Args == nil {
+		method.Args = make(map[string]*pb.Data)
+	}
+	for _, arg := range args {
+		argData := &pb.Data{
+			Value: &pb.Data_Primitive{
+				Primitive: spec_util.CategorizeString(arg.Value).ToProto(),
+			},
+			Meta: &pb.DataMeta{
+				Meta: &pb.DataMeta_Http{
+					Http: &pb.HTTPMeta{
+						Location: &pb.HTTPMeta_Path{
+							Path: &pb.HTTPPath{Key: arg.Name},
+						},
+					},
+				},
+			},
+		}
+		method.Args[ir_hash.HashDataToString(argData)] = argData
+	}
+}
@@ -0,0 +1,214 @@
+package path_inference
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	pb "github.com/akitasoftware/akita-ir/go/api_spec"
+	pp "github.com/akitasoftware/akita-libs/path_pattern"
+)
+
+func TestIsIDSegment(t *testing.T) {
+	ids := []string{
+		"12",
+		"8f3a5c2e-1b7d-4c3e-9a2f-6d1e0b9c4a7f",
+		"d41d8cd98f00b204e9800998ecf8427e",
+		"507f1f77bcf86cd799439011",
+		"2021-11-23",
+		"2021-11-23T03:03:39Z",
+		"svc_6vmrQrTXzg3b2Jz4ZpVd9w",
+	}
+	for _, s := range ids {
+		assert.True(t, isIDSegment(s), s)
+	}
+
+	notIDs := []string{
+		"",
+		"users",
+		"v1",
+		"organization_settings",
+		"deadbeefdeadbeefdeadbeef",
+	}
+	for _, s := range notIDs {
+		assert.False(t, isIDSegment(s), s)
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	inf := NewInferrer(nil, nil)
+	inf.Observe("/users/8f3a5c2e-1b7d-4c3e-9a2f-6d1e0b9c4a7f/orders/12")
+	inf.Observe("/users/me/orders")
+
+	testCases := []struct {
+		path         string
+		expected     string
+		expectedArgs []PathArg
+	}{
+		{
+			"/users/8f3a5c2e-1b7d-4c3e-9a2f-6d1e0b9c4a7f/orders/12",
+			"/users/{arg1}/orders/{arg2}",
+			[]PathArg{
+				{"arg1", "8f3a5c2e-1b7d-4c3e-9a2f-6d1e0b9c4a7f"},
+				{"arg2", "12"},
+			},
+		},
+		{"/users/me/orders", "/users/me/orders", nil},
+		{"/users/me/orders/", "/users/me/orders/", nil},
+		// Not observed, but still generalized based on the shape of the segments.
+		{"/pets/42", "/pets/{arg1}", []PathArg{{"arg1", "42"}}},
+	}
+	for _, tc := range testCases {
+		template, args := inf.Template(tc.path)
+		assert.Equal(t, tc.expected, template, tc.path)
+		assert.Equal(t, tc.expectedArgs, args, tc.path)
+	}
+}
+
+func TestObserveEndpoint(t *testing.T) {
+	inf := NewInferrer(nil, nil)
+	e := inf.ObserveEndpoint("GET", "example.com", "/users/42/orders")
+	assert.Equal(t, Endpoint{"GET", "example.com", "/users/{arg1}/orders"}, e)
+	assert.Equal(t, "GET example.com/users/{arg1}/orders", e.String())
+}
+
+func TestTemplateHighCardinality(t *testing.T) {
+	inf := NewInferrer(nil, nil)
+	for i := 0; i <= DefaultMaxDistinctValues; i++ {
+		inf.Observe(fmt.Sprintf("/files/name%c%c/meta", 'a'+i%26, 'a'+i/26))
+	}
+	inf.Observe("/files/")
+
+	template, _ := inf.Template("/files/nameaa/meta")
+	assert.Equal(t, "/files/{arg1}/meta", template)
+
+	// Values never seen before are generalized too.
+	template, _ = inf.Template("/files/readme/meta")
+	assert.Equal(t, "/files/{arg1}/meta", template)
+
+	// Empty segments are never parameters.
+	template, _ = inf.Template("/files/")
+	assert.Equal(t, "/files/", template)
+}
+
+func TestUserPatternsAndExclusions(t *testing.T) {
+	inf := NewInferrer(
+		[]pp.Pattern{
+			pp.Parse("/v1/{team}"),
+			pp.Parse("/v2/^/123"),
+		},
+		[]*regexp.Regexp{regexp.MustCompile(`^/static/`)},
+	)
+
+	testCases := []struct {
+		path     string
+		expected string
+	}{
+		{"/v1/akita/members/7", "/v1/{team}/members/{arg2}"},
+		{"/v2/42/123/9", "/v2/42/123/{arg1}"},
+		{"/static/12.png", "/static/12.png"},
+	}
+	for _, tc := range testCases {
+		inf.Observe(tc.path)
+		template, _ := inf.Template(tc.path)
+		assert.Equal(t, tc.expected, template, tc.path)
+	}
+}
+
+func TestGeneralizeWitness(t *testing.T) {
+	inf := NewInferrer(nil, nil)
+	w := &pb.Witness{
+		Method: &pb.Method{
+			Meta: &pb.MethodMeta{
+				Meta: &pb.MethodMeta_Http{
+					Http: &pb.HTTPMethodMeta{
+						Method:       "GET",
+						PathTemplate: "/v1/doggos/42",
+						Host:         "example.com",
+					},
+				},
+			},
+		},
+	}
+	inf.ObserveWitness(w)
+	inf.GeneralizeWitness(w)
+
+	assert.Equal(t, "/v1/doggos/{arg1}", w.GetMethod().GetMeta().GetHttp().GetPathTemplate())
+	if assert.Len(t, w.GetMethod().GetArgs(), 1) {
+		for _, arg := range w.GetMethod().GetArgs() {
+			assert.Equal(t, "arg1", arg.GetMeta().GetHttp().GetPath().GetKey())
+		}
+	}
+}
+
+func TestInferHARFiles(t *testing.T) {
+	dir := t.TempDir()
+	harPath := filepath.Join(dir, "akita_lo.har")
+	content := `{"log": {"version": "1.2", "entries": [
+		{"request": {"method": "GET", "url": "http://example.com/users/12/orders?verbose=true"}, "comment": "kept"},
+		{"request": {"method": "GET", "url": "http://example.com/users/13/orders"}},
+		{"request": {"method": "GET", "url": "http://example.com/users/14/%7Bid%7D"}}
+	]}}`
+	if err := ioutil.WriteFile(harPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := InferHARFiles([]string{harPath}, NewInferrer(nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := loadHARFile(harPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var urls []string
+	h.forEachRequest(func(req map[string]interface{}) {
+		urls = append(urls, req["url"].(string))
+	})
+	assert.Equal(t, []string{
+		"http://example.com/users/{arg1}/orders?verbose=true",
+		"http://example.com/users/{arg1}/orders",
+		// Literal braces stay escaped.
+		"http://example.com/users/{arg1}/%7Bid%7D",
+	}, urls)
+
+	rewritten, _ := ioutil.ReadFile(harPath)
+	assert.True(t, strings.Contains(string(rewritten), `"comment":"kept"`))
+}
+
+func TestInferGzippedHARFiles(t *testing.T) {
+	dir := t.TempDir()
+	harPath := filepath.Join(dir, "akita_lo.har.gz")
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(`{"log": {"version": "1.2", "entries": [
+		{"request": {"method": "GET", "url": "http://example.com/users/12"}},
+		{"request": {"method": "GET", "url": "http://example.com/users/13"}}
+	]}}`))
+	gz.Close()
+	if err := ioutil.WriteFile(harPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := InferHARFiles([]string{harPath}, NewInferrer(nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The rewritten file is still gzipped.
+	h, err := loadHARFile(harPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var urls []string
+	h.forEachRequest(func(req map[string]interface{}) {
+		urls = append(urls, req["url"].(string))
+	})
+	assert.Equal(t, []string{"http://example.com/users/{arg1}", "http://example.com/users/{arg1}"}, urls)
+}
@@ -0,0 +1,76 @@
+package path_inference
+
+import (
+	"regexp"
+	"time"
+	"unicode"
+)
+
+var (
+	uuidRegexp    = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	integerRegexp = regexp.MustCompile(`^[0-9]+$`)
+
+	// Hex-encoded hashes and object IDs, e.g. MD5, SHA-1, or MongoDB ObjectIds.
+	hexRegexp = regexp.MustCompile(`^[0-9a-fA-F]{16,}$`)
+
+	// Opaque tokens, such as base64url-encoded IDs.
+	tokenRegexp = regexp.MustCompile(`^[0-9a-zA-Z_\-]{20,}$`)
+)
+
+// Layouts of dates and timestamps that commonly appear in paths.
+var dateLayouts = []string{
+	"2006-01-02",
+	"20060102",
+	time.RFC3339,
+	time.RFC3339Nano,
+}
+
+// Returns true if the given path segment looks like a concrete value of a path
+// parameter, rather than part of a fixed endpoint path.
+func isIDSegment(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	if integerRegexp.MatchString(s) || uuidRegexp.MatchString(s) {
+		return true
+	}
+
+	for _, layout := range dateLayouts {
+		if _, err := time.Parse(layout, s); err == nil {
+			return true
+		}
+	}
+
+	// Words like "deadbeefcafebabe" are unlikely, but require a digit so that
+	// long words made of the letters a-f are not mistaken for hashes.
+	if hexRegexp.MatchString(s) && hasDigit(s) {
+		return true
+	}
+
+	// Long tokens must mix letters and digits, so that long-but-readable
+	// segments like "organization_settings" are left alone.
+	if tokenRegexp.MatchString(s) && hasDigit(s) && hasLetter(s) {
+		return true
+	}
+
+	return false
+}
+
+func hasDigit(s string) bool {
+	for _, r := range s {
+		if unicode.IsDigit(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasLetter(s string) bool {
+	for _, r := range s {
+		if unicode.IsLetter(r) {
+			return true
+		}
+	}
+	return false
+}
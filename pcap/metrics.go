@@ -0,0 +1,20 @@
+package pcap
+
+import (
+	"sync/atomic"
+
+	"github.com/akitasoftware/akita-cli/prom_metrics"
+)
+
+func init() {
+	prom_metrics.Default.NewCounterFunc(
+		"akita_assembler_context_errors_total",
+		"Problems with packet assembly contexts, which may cause packets to be missing from the trace.",
+		[]string{"kind"},
+		func(emit func(float64, ...string)) {
+			emit(float64(atomic.LoadUint64(&CountNilAssemblerContext)), "nil")
+			emit(float64(atomic.LoadUint64(&CountNilAssemblerContextAfterParse)), "nil_after_parse")
+			emit(float64(atomic.LoadUint64(&CountBadAssemblerContextType)), "bad_type")
+		},
+	)
+}
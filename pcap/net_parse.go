@@ -76,6 +76,13 @@ type NetworkTrafficParser struct {
 	clock       clockWrapper
 	observer    NetworkTrafficObserver // This function is called for every packet.
 	bufferShare float32
+
+	// Replacement BPF filters, applied while parsing.
+	filterUpdates <-chan string
+
+	// If set, sentinels are injected into the capture and recorded once
+	// parsed.
+	sentinels *Sentinels
 }
 
 func NewNetworkTrafficParser(bufferShare float32) *NetworkTrafficParser {
@@ -93,6 +100,18 @@ func (p *NetworkTrafficParser) InstallObserver(observer NetworkTrafficObserver)
 	p.observer = observer
 }
 
+// Replaces the BPF filter with each filter received on the given channel.
+// Should be called before starting ParseFromInterface.
+func (p *NetworkTrafficParser) InstallFilterUpdates(updates <-chan string) {
+	p.filterUpdates = updates
+}
+
+// Lets the given sentinels synchronize with the capture. Should be called
+// before starting ParseFromInterface.
+func (p *NetworkTrafficParser) InstallSentinels(s *Sentinels) {
+	p.sentinels = s
+}
+
 // Parses network traffic from an interface.
 // This function will attempt to parse the traffic with the highest level of
 // protocol details as possible. For instance, it will try to piece together
@@ -101,8 +120,10 @@ func (p *NetworkTrafficParser) InstallObserver(observer NetworkTrafficObserver)
 // parser has been accepted, no other parser will be used.
 func (p *NetworkTrafficParser) ParseFromInterface(interfaceName, bpfFilter string, signalClose <-chan struct{}, fs ...akinet.TCPParserFactory) (<-chan akinet.ParsedNetworkTraffic, error) {
 	// Read in packets, pass to assembler
-	packets, err := p.pcap.capturePackets(signalClose, interfaceName, bpfFilter)
+	packets, err := p.pcap.capturePackets(signalClose, interfaceName, bpfFilter, p.filterUpdates, p.sentinels)
 	if err != nil {
+		// Don't leave anyone waiting to sync with the capture.
+		p.sentinels.attach(nil, nil)
 		return nil, errors.Wrapf(err, "failed begin capturing packets from %s", interfaceName)
 	}
 
@@ -145,6 +166,13 @@ func (p *NetworkTrafficParser) ParseFromInterface(interfaceName, bpfFilter strin
 
 					return
 				}
+				// Sentinels aren't traffic, whichever capture injected them.
+				if id, seq, ok := parseSentinel(packet); ok {
+					if p.sentinels != nil && id == p.sentinels.id {
+						p.sentinels.seen(seq)
+					}
+					continue
+				}
 				p.observer(packet)
 				p.packetToParsedNetworkTraffic(out, assembler, packet)
 			case <-ticker.C:
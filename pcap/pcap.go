@@ -5,7 +5,7 @@ import (
 	"time"
 
 	"github.com/google/gopacket"
-	_ "github.com/google/gopacket/layers"
+	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
 	"github.com/pkg/errors"
 
@@ -18,19 +18,22 @@ const (
 )
 
 type pcapWrapper interface {
-	capturePackets(done <-chan struct{}, interfaceName, bpfFilter string) (<-chan gopacket.Packet, error)
+	// The BPF filter is replaced with each filter received on filterUpdates.
+	// If sentinels is set, they are let through the filter and attached to the
+	// interface once capture has started.
+	capturePackets(done <-chan struct{}, interfaceName, bpfFilter string, filterUpdates <-chan string, sentinels *Sentinels) (<-chan gopacket.Packet, error)
 	getInterfaceAddrs(interfaceName string) ([]net.IP, error)
 }
 
 type pcapImpl struct{}
 
-func (p *pcapImpl) capturePackets(done <-chan struct{}, interfaceName, bpfFilter string) (<-chan gopacket.Packet, error) {
+func (p *pcapImpl) capturePackets(done <-chan struct{}, interfaceName, bpfFilter string, filterUpdates <-chan string, sentinels *Sentinels) (<-chan gopacket.Packet, error) {
 	handle, err := pcap.OpenLive(interfaceName, defaultSnapLen, true, pcap.BlockForever)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to open pcap to %s", interfaceName)
 	}
 	if bpfFilter != "" {
-		if err := handle.SetBPFFilter(bpfFilter); err != nil {
+		if err := handle.SetBPFFilter(sentinels.filter(bpfFilter)); err != nil {
 			handle.Close()
 			return nil, errors.Wrap(err, "failed to set BPF filter")
 		}
@@ -41,6 +44,11 @@ func (p *pcapImpl) capturePackets(done <-chan struct{}, interfaceName, bpfFilter
 	packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
 	pktChan := packetSource.Packets()
 
+	var injectHandle *pcap.Handle
+	if sentinels != nil {
+		injectHandle = attachSentinels(sentinels, interfaceName, handle.LinkType())
+	}
+
 	// TODO: tune the packet channel buffer
 	wrappedChan := make(chan gopacket.Packet, 10)
 	go func() {
@@ -49,6 +57,10 @@ func (p *pcapImpl) capturePackets(done <-chan struct{}, interfaceName, bpfFilter
 		// wait for the handle to close in this goroutine.
 		defer func() {
 			close(wrappedChan)
+			if injectHandle != nil {
+				sentinels.detach()
+				injectHandle.Close()
+			}
 			handle.Close()
 		}()
 
@@ -58,6 +70,12 @@ func (p *pcapImpl) capturePackets(done <-chan struct{}, interfaceName, bpfFilter
 			select {
 			case <-done:
 				return
+			case f := <-filterUpdates:
+				if err := handle.SetBPFFilter(sentinels.filter(f)); err != nil {
+					printer.Warningf("Failed to update BPF filter on %s to %q: %v\n", interfaceName, f, err)
+				} else {
+					printer.Debugf("Updated BPF filter on %s to %q\n", interfaceName, f)
+				}
 			case pkt, ok := <-pktChan:
 				if ok {
 					wrappedChan <- pkt
@@ -76,6 +94,38 @@ func (p *pcapImpl) capturePackets(done <-chan struct{}, interfaceName, bpfFilter
 	return wrappedChan, nil
 }
 
+// Opens a second handle on the interface for injecting sentinels, since a
+// handle doesn't capture the packets it sends itself. Returns the handle, or
+// nil if sentinels can't be injected.
+func attachSentinels(sentinels *Sentinels, interfaceName string, linkType layers.LinkType) *pcap.Handle {
+	var hwAddr net.HardwareAddr
+	if iface, err := net.InterfaceByName(interfaceName); err == nil {
+		hwAddr = iface.HardwareAddr
+	}
+	header, ok := sentinelLinkHeader(linkType, hwAddr)
+	if !ok {
+		printer.Debugf("Can't inject sentinel packets on %s with link type %s\n", interfaceName, linkType)
+		sentinels.attach(nil, nil)
+		return nil
+	}
+
+	// Nothing is read from this handle, so capture as little as possible.
+	handle, err := pcap.OpenLive(interfaceName, 64, false, pcap.BlockForever)
+	if err == nil {
+		err = handle.SetBPFFilter("less 1")
+		if err != nil {
+			handle.Close()
+		}
+	}
+	if err != nil {
+		printer.Debugf("Can't inject sentinel packets on %s: %v\n", interfaceName, err)
+		sentinels.attach(nil, nil)
+		return nil
+	}
+	sentinels.attach(handle.WritePacketData, header)
+	return handle
+}
+
 func (p *pcapImpl) getInterfaceAddrs(interfaceName string) ([]net.IP, error) {
 	iface, err := net.InterfaceByName(interfaceName)
 	if err != nil {
@@ -92,11 +142,7 @@ func (p *pcapImpl) getInterfaceAddrs(interfaceName string) ([]net.IP, error) {
 			} else if udpAddr, ok := addr.(*net.UDPAddr); ok {
 				hostIPs = append(hostIPs, udpAddr.IP)
 			} else if ipNet, ok := addr.(*net.IPNet); ok {
-				// TODO: Remove assumption that the host IP is the first IP in the
-				// network.
-				ip := ipNet.IP.Mask(ipNet.Mask)
-				nextIP(ip)
-				hostIPs = append(hostIPs, ip)
+				hostIPs = append(hostIPs, ipNet.IP)
 			} else {
 				printer.Warningf("Ignoring host address of unknown type: %v\n", addr)
 			}
@@ -105,11 +151,7 @@ func (p *pcapImpl) getInterfaceAddrs(interfaceName string) ([]net.IP, error) {
 	return hostIPs, nil
 }
 
-func nextIP(ip net.IP) {
-	for i := len(ip) - 1; i >= 0; i-- {
-		ip[i]++
-		if ip[i] > 0 {
-			break
-		}
-	}
+// Returns the IP addresses assigned to the named network interface.
+func InterfaceAddrs(interfaceName string) ([]net.IP, error) {
+	return (&pcapImpl{}).getInterfaceAddrs(interfaceName)
 }
@@ -0,0 +1,239 @@
+package pcap
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/pkg/errors"
+)
+
+// Sentinel packets are UDP packets between two addresses reserved for
+// documentation (RFC 5737), to the discard port, so that nothing answers them
+// even if they leave the host.
+var (
+	sentinelSrcIP = net.IPv4(192, 0, 2, 1).To4()
+	sentinelDstIP = net.IPv4(192, 0, 2, 2).To4()
+)
+
+const (
+	sentinelPort = 9
+
+	// Followed by the ID of the Sentinels that sent the packet and its
+	// sequence number.
+	sentinelMagic = "AKITA-SENTINEL"
+)
+
+// Matches sentinel packets, so that they pass the capture's BPF filter.
+var sentinelFilter = fmt.Sprintf("(udp and src host %s and dst host %s and dst port %d)", sentinelSrcIP, sentinelDstIP, sentinelPort)
+
+// Synchronizes with a capture by injecting sentinel packets on its interface
+// and waiting for them to come out of the parser. Once a sentinel has been
+// seen, every packet captured before it has been parsed.
+//
+// Safe for concurrent use. A nil *Sentinels never syncs.
+type Sentinels struct {
+	// Distinguishes these sentinels from those of other captures on the same
+	// interface.
+	id uint64
+
+	// Closed once the capture has started, or failed to.
+	attached chan struct{}
+
+	mutex sync.Mutex
+
+	// Nil until the capture starts, or if sentinels can't be injected on the
+	// interface.
+	inject     func(data []byte) error
+	linkHeader []byte
+
+	// The sequence number of the next sentinel.
+	next uint32
+
+	// Closed once the sentinel with the given sequence number has been seen.
+	waiters map[uint32]chan struct{}
+}
+
+func NewSentinels() *Sentinels {
+	var id [8]byte
+	rand.Read(id[:])
+	return &Sentinels{
+		id:       binary.BigEndian.Uint64(id[:]),
+		attached: make(chan struct{}),
+		waiters:  map[uint32]chan struct{}{},
+	}
+}
+
+// Returns the BPF filter extended to let sentinels through.
+func (s *Sentinels) filter(bpfFilter string) string {
+	if s == nil || bpfFilter == "" {
+		return bpfFilter
+	}
+	return fmt.Sprintf("(%s) or %s", bpfFilter, sentinelFilter)
+}
+
+// Lets Sync inject sentinels with the given function, which writes a packet
+// with the given link-layer header to the interface. A nil function means
+// that sentinels can't be injected. Only the first call has an effect.
+func (s *Sentinels) attach(inject func(data []byte) error, linkHeader []byte) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	select {
+	case <-s.attached:
+		return
+	default:
+	}
+	s.inject = inject
+	s.linkHeader = linkHeader
+	close(s.attached)
+}
+
+// Stops injecting sentinels, once the capture has stopped.
+func (s *Sentinels) detach() {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.inject = nil
+}
+
+// Injects a sentinel and waits until the parser has seen it. Returns false if
+// it isn't seen within the timeout, including the time taken for the capture
+// to start, or if sentinels can't be injected.
+func (s *Sentinels) Sync(timeout time.Duration) bool {
+	if s == nil {
+		return false
+	}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	select {
+	case <-s.attached:
+	case <-deadline.C:
+		return false
+	}
+
+	s.mutex.Lock()
+	if s.inject == nil {
+		s.mutex.Unlock()
+		return false
+	}
+	seq := s.next
+	s.next++
+	// Injected while holding the mutex, so that detach can't close the
+	// handle meanwhile.
+	if err := s.inject(s.packet(seq)); err != nil {
+		s.mutex.Unlock()
+		return false
+	}
+	seen := make(chan struct{})
+	s.waiters[seq] = seen
+	s.mutex.Unlock()
+
+	select {
+	case <-seen:
+		return true
+	case <-deadline.C:
+		return false
+	}
+}
+
+// Records that the sentinel with the given sequence number has come out of
+// the parser. Sentinels are seen in order, so earlier ones are done too.
+func (s *Sentinels) seen(seq uint32) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for n, c := range s.waiters {
+		if n <= seq {
+			close(c)
+			delete(s.waiters, n)
+		}
+	}
+}
+
+// Returns the sentinel with the given sequence number, including the
+// link-layer header.
+func (s *Sentinels) packet(seq uint32) []byte {
+	payload := make([]byte, len(sentinelMagic)+12)
+	copy(payload, sentinelMagic)
+	binary.BigEndian.PutUint64(payload[len(sentinelMagic):], s.id)
+	binary.BigEndian.PutUint32(payload[len(sentinelMagic)+8:], seq)
+
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      1,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP:    sentinelSrcIP,
+		DstIP:    sentinelDstIP,
+	}
+	udp := &layers.UDP{
+		SrcPort: sentinelPort,
+		DstPort: sentinelPort,
+	}
+	udp.SetNetworkLayerForChecksum(ip)
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ip, udp, gopacket.Payload(payload)); err != nil {
+		// Only fails on programming errors.
+		panic(errors.Wrap(err, "failed to serialize sentinel"))
+	}
+	return append(append([]byte{}, s.linkHeader...), buf.Bytes()...)
+}
+
+// Returns the ID and sequence number of a sentinel packet, or false if the
+// packet isn't one.
+func parseSentinel(packet gopacket.Packet) (uint64, uint32, bool) {
+	udp, ok := packet.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	if !ok || udp.DstPort != sentinelPort {
+		return 0, 0, false
+	}
+	payload := udp.LayerPayload()
+	if len(payload) != len(sentinelMagic)+12 || !bytes.HasPrefix(payload, []byte(sentinelMagic)) {
+		return 0, 0, false
+	}
+	payload = payload[len(sentinelMagic):]
+	return binary.BigEndian.Uint64(payload), binary.BigEndian.Uint32(payload[8:]), true
+}
+
+// Returns the link-layer header for injecting IPv4 packets on an interface
+// with the given link type, or false if injection isn't supported.
+func sentinelLinkHeader(linkType layers.LinkType, hwAddr net.HardwareAddr) ([]byte, bool) {
+	switch linkType {
+	case layers.LinkTypeEthernet:
+		// Addressed to the interface itself, so that switches don't flood it.
+		mac := hwAddr
+		if len(mac) != 6 {
+			mac = make(net.HardwareAddr, 6)
+		}
+		header := make([]byte, 14)
+		copy(header[0:6], mac)
+		copy(header[6:12], mac)
+		binary.BigEndian.PutUint16(header[12:], uint16(layers.EthernetTypeIPv4))
+		return header, true
+	case layers.LinkTypeNull:
+		// The address family in host byte order, which is little-endian on
+		// every platform we support.
+		header := make([]byte, 4)
+		binary.LittleEndian.PutUint32(header, uint32(layers.ProtocolFamilyIPv4))
+		return header, true
+	case layers.LinkTypeLoop:
+		header := make([]byte, 4)
+		binary.BigEndian.PutUint32(header, uint32(layers.ProtocolFamilyIPv4))
+		return header, true
+	case layers.LinkTypeRaw, layers.LinkTypeIPv4:
+		return nil, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,129 @@
+package pcap
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+// Fake pcap that captures the packets sent on its channel, including
+// injected sentinels.
+type sentinelPcap chan gopacket.Packet
+
+func (f sentinelPcap) capturePackets(done <-chan struct{}, _, _ string, _ <-chan string, sentinels *Sentinels) (<-chan gopacket.Packet, error) {
+	header, _ := sentinelLinkHeader(layers.LinkTypeEthernet, net.HardwareAddr{2, 0, 0, 0, 0, 1})
+	sentinels.attach(func(data []byte) error {
+		f <- gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default)
+		return nil
+	}, header)
+
+	outChan := make(chan gopacket.Packet)
+	go func() {
+		defer close(outChan)
+		for {
+			select {
+			case <-done:
+				return
+			case p := <-f:
+				select {
+				case <-done:
+					return
+				case outChan <- p:
+				}
+			}
+		}
+	}()
+	return outChan, nil
+}
+
+func (f sentinelPcap) getInterfaceAddrs(interfaceName string) ([]net.IP, error) {
+	return []net.IP{brokerIP}, nil
+}
+
+func TestSentinels(t *testing.T) {
+	capture := make(sentinelPcap, 10)
+	sentinels := NewSentinels()
+	parser := NewNetworkTrafficParser(1.0)
+	parser.pcap = capture
+	parser.clock = &fakeClock{testTime}
+	parser.InstallSentinels(sentinels)
+
+	done := make(chan struct{})
+	defer close(done)
+	out, err := parser.ParseFromInterface("eth0", "tcp port 80", done)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.True(t, sentinels.Sync(5*time.Second))
+
+	// Traffic captured before a sentinel has been parsed once it is seen.
+	msg := &testMessage{testEndpoint1, testEndpoint2, []byte("hello")}
+	capture <- makeUDPPackets(1, msg)[0]
+
+	// Sentinels of other captures on the interface are dropped too.
+	other := NewSentinels()
+	other.attach(func([]byte) error { return nil }, nil)
+	capture <- gopacket.NewPacket(other.packet(0), layers.LayerTypeIPv4, gopacket.Default)
+
+	assert.True(t, sentinels.Sync(5*time.Second))
+	select {
+	case nt := <-out:
+		assert.IsType(t, akinet.RawBytes{}, nt.Content)
+		assert.Equal(t, port1, nt.SrcPort)
+	default:
+		t.Error("traffic before the sentinel wasn't parsed")
+	}
+	select {
+	case nt := <-out:
+		t.Errorf("unexpected traffic: %v", nt)
+	default:
+	}
+}
+
+func TestSentinelsUnsupported(t *testing.T) {
+	s := NewSentinels()
+	assert.False(t, s.Sync(10*time.Millisecond), "not attached")
+
+	s.attach(nil, nil)
+	assert.False(t, s.Sync(time.Second))
+
+	var none *Sentinels
+	assert.False(t, none.Sync(time.Second))
+	assert.Equal(t, "tcp port 80", none.filter("tcp port 80"))
+}
+
+func TestSentinelFilter(t *testing.T) {
+	s := NewSentinels()
+	assert.Equal(t, "", s.filter(""))
+	assert.Equal(t,
+		"(tcp port 80) or (udp and src host 192.0.2.1 and dst host 192.0.2.2 and dst port 9)",
+		s.filter("tcp port 80"))
+}
+
+func TestSentinelPacket(t *testing.T) {
+	for _, linkType := range []layers.LinkType{layers.LinkTypeEthernet, layers.LinkTypeNull, layers.LinkTypeLoop, layers.LinkTypeRaw} {
+		header, ok := sentinelLinkHeader(linkType, nil)
+		if !assert.True(t, ok, linkType.String()) {
+			continue
+		}
+		s := NewSentinels()
+		s.attach(func([]byte) error { return nil }, header)
+
+		packet := gopacket.NewPacket(s.packet(7), linkType, gopacket.Default)
+		id, seq, ok := parseSentinel(packet)
+		if assert.True(t, ok, linkType.String()) {
+			assert.Equal(t, s.id, id)
+			assert.Equal(t, uint32(7), seq)
+		}
+	}
+
+	_, ok := sentinelLinkHeader(layers.LinkTypeLinuxSLL, nil)
+	assert.False(t, ok)
+}
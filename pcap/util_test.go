@@ -31,7 +31,7 @@ var (
 
 type fakePcap []gopacket.Packet
 
-func (f fakePcap) capturePackets(done <-chan struct{}, interfaceName, bpfFilter string) (<-chan gopacket.Packet, error) {
+func (f fakePcap) capturePackets(done <-chan struct{}, interfaceName, bpfFilter string, _ <-chan string, _ *Sentinels) (<-chan gopacket.Packet, error) {
 	outChan := make(chan gopacket.Packet)
 	go func() {
 		defer close(outChan)
@@ -54,7 +54,7 @@ func (f fakePcap) getInterfaceAddrs(interfaceName string) ([]net.IP, error) {
 // cancelled.
 type forceCancelPcap []gopacket.Packet
 
-func (f forceCancelPcap) capturePackets(done <-chan struct{}, interfaceName, bpfFilter string) (<-chan gopacket.Packet, error) {
+func (f forceCancelPcap) capturePackets(done <-chan struct{}, interfaceName, bpfFilter string, _ <-chan string, _ *Sentinels) (<-chan gopacket.Packet, error) {
 	outChan := make(chan gopacket.Packet)
 	go func() {
 		defer close(outChan)
@@ -0,0 +1,152 @@
+package pii
+
+import (
+	"fmt"
+	"strings"
+
+	pb "github.com/akitasoftware/akita-ir/go/api_spec"
+	"github.com/akitasoftware/akita-libs/spec_util"
+	. "github.com/akitasoftware/akita-libs/visitors"
+	vis "github.com/akitasoftware/akita-libs/visitors/http_rest"
+
+	"github.com/akitasoftware/akita-cli/path_inference"
+	"github.com/akitasoftware/akita-cli/plugin"
+)
+
+// Annotates sensitive values in witnesses and records them in a report.
+// Matching primitives are marked as sensitive, and the names of the matching
+// detectors are added to their data formats.
+//
+// Annotator implements plugin.AkitaPlugin, so it can be applied anywhere
+// plugins are, before values are obfuscated.
+type Annotator struct {
+	registry *Registry
+	report   *Report
+
+	// Generalizes paths, so that the report has one entry per endpoint.
+	inferrer *path_inference.Inferrer
+}
+
+var _ plugin.AkitaPlugin = (*Annotator)(nil)
+
+// Endpoints in the report are named using the given inferrer, which may be
+// shared with other consumers. If it is nil, a private one is used.
+func NewAnnotator(registry *Registry, inferrer *path_inference.Inferrer) *Annotator {
+	if inferrer == nil {
+		inferrer = path_inference.NewInferrer(nil, nil)
+	}
+	return &Annotator{
+		registry: registry,
+		report:   NewReport(),
+		inferrer: inferrer,
+	}
+}
+
+func (a *Annotator) Name() string {
+	return "pii_detector"
+}
+
+func (a *Annotator) Transform(m *pb.Method) error {
+	v := annotationVisitor{
+		registry: a.registry,
+		report:   a.report,
+		endpoint: a.endpointName(m),
+	}
+	vis.Apply(&v, m)
+	return nil
+}
+
+// Returns the summary of all values annotated so far.
+func (a *Annotator) Report() *Report {
+	return a.report
+}
+
+// Identifies an endpoint in the report, e.g. "GET example.com/users/{arg1}".
+func (a *Annotator) endpointName(m *pb.Method) string {
+	meta := spec_util.HTTPMetaFromMethod(m)
+	if meta == nil || meta.Method == "" {
+		// Responses that were never paired with their requests.
+		return "unknown endpoint"
+	}
+	return a.inferrer.ObserveEndpoint(meta.Method, meta.Host, meta.PathTemplate).String()
+}
+
+type annotationVisitor struct {
+	vis.DefaultSpecVisitorImpl
+
+	registry *Registry
+	report   *Report
+	endpoint string
+}
+
+var _ vis.DefaultSpecVisitor = (*annotationVisitor)(nil)
+
+func (v *annotationVisitor) EnterData(self interface{}, ctx vis.SpecVisitorContext, d *pb.Data) Cont {
+	dp, isPrimitive := d.GetValue().(*pb.Data_Primitive)
+	if !isPrimitive {
+		return Continue
+	}
+
+	// Only strings are checked. Numeric IDs, counts and timestamps often pass
+	// the card and phone number checks by chance.
+	sv, ok := dp.Primitive.GetValue().(*pb.Primitive_StringValue)
+	if !ok {
+		return Continue
+	}
+	value := sv.StringValue.GetValue()
+
+	matches := v.registry.Detect(value)
+	if len(matches) == 0 {
+		return Continue
+	}
+
+	p := dp.Primitive
+	if p.AkitaAnnotations == nil {
+		p.AkitaAnnotations = &pb.AkitaAnnotations{}
+	}
+	p.AkitaAnnotations.IsSensitive = true
+	if p.Formats == nil {
+		p.Formats = make(map[string]bool, len(matches))
+	}
+	for _, name := range matches {
+		p.Formats[name] = true
+	}
+
+	v.report.record(v.endpoint, fieldName(ctx), matches)
+	return Continue
+}
+
+// Describes the location of a field within a witness, e.g.
+// "response 200 Body items[].email". Array indices are omitted so that all
+// elements of an array are reported together.
+func fieldName(ctx vis.SpecVisitorContext) string {
+	var parts []string
+	if ctx.IsArg() {
+		parts = append(parts, "request")
+	} else {
+		parts = append(parts, "response")
+		if code := ctx.GetResponseCode(); code != nil {
+			parts = append(parts, *code)
+		}
+	}
+	parts = append(parts, ctx.GetValueType().String())
+
+	var path strings.Builder
+	for _, elt := range ctx.GetFieldPath() {
+		switch {
+		case elt.IsArrayElement():
+			path.WriteString("[]")
+		case elt.IsFieldName():
+			if path.Len() > 0 {
+				path.WriteString(".")
+			}
+			path.WriteString(elt.String())
+		default:
+			path.WriteString(fmt.Sprintf("(%s)", elt))
+		}
+	}
+	if path.Len() > 0 {
+		parts = append(parts, path.String())
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,106 @@
+package pii
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/akitasoftware/akita-cli/learn"
+	pb "github.com/akitasoftware/akita-ir/go/api_spec"
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+func TestCollectorAnnotatesAndReports(t *testing.T) {
+	streamID := uuid.New()
+	reqURL, _ := url.Parse("/v1/users/42?email=alice@example.com")
+	req := akinet.HTTPRequest{
+		StreamID:   streamID,
+		Seq:        1,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Method:     "POST",
+		URL:        reqURL,
+		Host:       "example.com",
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       []byte(`{"name": "Alice", "cards": ["4111111111111111", "5500 0000 0000 0004"]}`),
+	}
+	resp := akinet.HTTPResponse{
+		StreamID:   streamID,
+		Seq:        1,
+		StatusCode: 201,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		// Numbers are never sensitive, even if they look like phone numbers.
+		Body: []byte(`{"id": 7, "ssn": "123-45-6789", "callback": 4155550132}`),
+	}
+
+	annotator := NewAnnotator(NewRegistry(), nil)
+	c := NewCollector(annotator)
+	for _, content := range []akinet.ParsedNetworkContent{req, resp} {
+		if err := c.Process(akinet.ParsedNetworkTraffic{Content: content}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := annotator.Report().WriteJSON(&out); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{
+  "endpoints": {
+    "POST example.com/v1/users/{arg1}": {
+      "request Body cards[]": {
+        "credit_card": 2
+      },
+      "request Query email": {
+        "email": 1
+      },
+      "response 201 Body ssn": {
+        "ssn": 1
+      }
+    }
+  }
+}
+`
+	assert.Equal(t, expected, out.String())
+}
+
+func TestTransformMarksSensitiveValues(t *testing.T) {
+	reqURL, _ := url.Parse("/login?user=bob&ip=10.1.2.3")
+	partial, err := learn.ParseHTTP(akinet.HTTPRequest{
+		StreamID: uuid.New(),
+		Method:   "GET",
+		URL:      reqURL,
+		Host:     "example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	annotator := NewAnnotator(NewRegistry(), nil)
+	if err := annotator.Transform(partial.Witness.Method); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, arg := range partial.Witness.Method.Args {
+		p := arg.GetPrimitive()
+		switch arg.GetMeta().GetHttp().GetQuery().GetKey() {
+		case "ip":
+			assert.True(t, p.GetAkitaAnnotations().GetIsSensitive())
+			assert.Equal(t, map[string]bool{IPAddress: true}, p.GetFormats())
+		case "user":
+			assert.Equal(t, (*pb.AkitaAnnotations)(nil), p.GetAkitaAnnotations())
+			assert.Empty(t, p.GetFormats())
+		default:
+			t.Errorf("unexpected arg %v", arg)
+		}
+	}
+}
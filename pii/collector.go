@@ -0,0 +1,69 @@
+package pii
+
+import (
+	"time"
+
+	"github.com/akitasoftware/akita-cli/learn"
+	"github.com/akitasoftware/akita-cli/pair_cache"
+	"github.com/akitasoftware/akita-cli/printer"
+	"github.com/akitasoftware/akita-cli/trace"
+	pb "github.com/akitasoftware/akita-ir/go/api_spec"
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+// Runs the annotator over HTTP traffic that is not otherwise turned into
+// witnesses, e.g. when capturing only to local HAR files. The witnesses are
+// discarded once annotated; only the report is kept.
+type collector struct {
+	annotator *Annotator
+
+	// Partial witnesses waiting for their pair. Those that are evicted or
+	// expire are annotated unpaired.
+	pairCache *pair_cache.Cache
+}
+
+func NewCollector(a *Annotator) trace.Collector {
+	return &collector{
+		annotator: a,
+		pairCache: pair_cache.New(pair_cache.Options{Name: "pii"}),
+	}
+}
+
+func (c *collector) Process(t akinet.ParsedNetworkTraffic) error {
+	if err := c.annotateUnpaired(c.pairCache.Expire(time.Now())); err != nil {
+		return err
+	}
+
+	switch t.Content.(type) {
+	case akinet.HTTPRequest, akinet.HTTPResponse:
+	default:
+		return nil
+	}
+
+	partial, err := learn.ParseHTTP(t.Content)
+	if err != nil {
+		printer.Debugf("Failed to parse HTTP, skipping: %v\n", err)
+		return nil
+	}
+
+	if val, ok := c.pairCache.Take(partial.PairKey); ok {
+		pair := val.(*pb.Witness)
+		learn.MergeWitness(pair, partial.Witness)
+		return c.annotator.Transform(pair.GetMethod())
+	}
+	return c.annotateUnpaired(c.pairCache.Add(partial.PairKey, partial.Witness))
+}
+
+// Annotates witnesses that were never paired.
+func (c *collector) Close() error {
+	return c.annotateUnpaired(c.pairCache.Drain())
+}
+
+func (c *collector) annotateUnpaired(witnesses []interface{}) error {
+	for _, w := range witnesses {
+		if err := c.annotator.Transform(w.(*pb.Witness).GetMethod()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
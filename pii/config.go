@@ -0,0 +1,65 @@
+package pii
+
+import (
+	"io/ioutil"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+)
+
+// Format of the detector config file, e.g.
+//
+//	detectors:
+//	  - name: employee_id
+//	    pattern: '^E\d{6}$'
+type Config struct {
+	Detectors []DetectorConfig `json:"detectors"`
+}
+
+type DetectorConfig struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+}
+
+// Reads user-defined detectors from a YAML or JSON config file.
+func LoadDetectors(path string) ([]Detector, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read PII detector config")
+	}
+
+	var cfg Config
+	if err := yaml.Unmarshal(content, &cfg); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse PII detector config %s", path)
+	}
+
+	result := make([]Detector, 0, len(cfg.Detectors))
+	for _, dc := range cfg.Detectors {
+		d, err := NewRegexpDetector(dc.Name, dc.Pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "bad PII detector config %s", path)
+		}
+		result = append(result, d)
+	}
+	return result, nil
+}
+
+// Creates a registry with the built-in detectors, plus any user-defined
+// detectors from the config file at the given path, if non-empty.
+func NewRegistryFromConfig(path string) (*Registry, error) {
+	r := NewRegistry()
+	if path == "" {
+		return r, nil
+	}
+
+	detectors, err := LoadDetectors(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range detectors {
+		if err := r.Register(d); err != nil {
+			return nil, errors.Wrapf(err, "bad PII detector config %s", path)
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,182 @@
+package pii
+
+import (
+	"math/big"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-cli/learn"
+)
+
+// Names of the built-in detectors. These are also recorded as data formats on
+// matching values.
+const (
+	Email        = "email"
+	PhoneNumber  = "phone_number"
+	SSN          = "ssn"
+	IBAN         = "iban"
+	IPAddress    = "ip_address"
+	AWSAccessKey = "aws_access_key"
+	CreditCard   = "credit_card"
+)
+
+var (
+	emailRegexp        = regexp.MustCompile(`^[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}$`)
+	phoneNumberRegexp  = regexp.MustCompile(`^(\+\d{1,3}[\s.\-]?)?\(?\d{3}\)?[\s.\-]?\d{3}[\s.\-]?\d{4}$`)
+	ssnRegexp          = regexp.MustCompile(`^(\d{3})-(\d{2})-(\d{4})$`)
+	ibanRegexp         = regexp.MustCompile(`^[A-Z]{2}\d{2}[A-Z0-9]{11,30}$`)
+	awsAccessKeyRegexp = regexp.MustCompile(`^(AKIA|ASIA)[A-Z0-9]{16}$`)
+	cardSeparators     = strings.NewReplacer(" ", "", "-", "")
+	detectorNameRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+)
+
+// A Detector recognizes one kind of sensitive value.
+type Detector struct {
+	Name  string
+	Match learn.SensitiveDataMatcher
+}
+
+// Returns the built-in detectors.
+func BuiltinDetectors() []Detector {
+	return []Detector{
+		{Email, emailRegexp.MatchString},
+		{PhoneNumber, isPhoneNumber},
+		{SSN, isSSN},
+		{IBAN, isIBAN},
+		{IPAddress, isIPAddress},
+		{AWSAccessKey, awsAccessKeyRegexp.MatchString},
+		{CreditCard, isCreditCard},
+	}
+}
+
+// Returns a detector that matches values against the given regular
+// expression.
+func NewRegexpDetector(name, pattern string) (Detector, error) {
+	if !detectorNameRegexp.MatchString(name) {
+		return Detector{}, errors.Errorf("bad detector name %q: must match %s", name, detectorNameRegexp)
+	}
+	r, err := regexp.Compile(pattern)
+	if err != nil {
+		return Detector{}, errors.Wrapf(err, "bad regular expression for detector %q", name)
+	}
+	return Detector{Name: name, Match: r.MatchString}, nil
+}
+
+// A set of detectors, applied in the order they were registered.
+type Registry struct {
+	detectors []Detector
+	byName    map[string]Detector
+}
+
+// Creates a registry containing the built-in detectors.
+func NewRegistry() *Registry {
+	r := &Registry{byName: make(map[string]Detector)}
+	for _, d := range BuiltinDetectors() {
+		r.Register(d)
+	}
+	return r
+}
+
+// Adds a detector to the registry. Detector names must be unique.
+func (r *Registry) Register(d Detector) error {
+	if _, exists := r.byName[d.Name]; exists {
+		return errors.Errorf("detector %q is already registered", d.Name)
+	}
+	r.byName[d.Name] = d
+	r.detectors = append(r.detectors, d)
+	return nil
+}
+
+// Returns the names of all registered detectors, sorted.
+func (r *Registry) Names() []string {
+	result := make([]string, 0, len(r.detectors))
+	for _, d := range r.detectors {
+		result = append(result, d.Name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Returns true if a detector with the given name is registered.
+func (r *Registry) Has(name string) bool {
+	_, ok := r.byName[name]
+	return ok
+}
+
+// Returns true if the named detector matches the value. Unknown detectors
+// never match.
+func (r *Registry) Matches(name, value string) bool {
+	d, ok := r.byName[name]
+	return ok && d.Match(value)
+}
+
+// Returns the names of the detectors that match the value, in registration
+// order.
+func (r *Registry) Detect(value string) []string {
+	var result []string
+	for _, d := range r.detectors {
+		if d.Match(value) {
+			result = append(result, d.Name)
+		}
+	}
+	return result
+}
+
+// Returns a matcher that reports whether any registered detector matches.
+func (r *Registry) Matcher() learn.SensitiveDataMatcher {
+	matchers := make([]learn.SensitiveDataMatcher, 0, len(r.detectors))
+	for _, d := range r.detectors {
+		matchers = append(matchers, d.Match)
+	}
+	return learn.CombineMatchers(matchers)
+}
+
+// Bare runs of 10 digits are more likely IDs or timestamps than phone
+// numbers, so a country code, separator or parentheses are required.
+func isPhoneNumber(s string) bool {
+	return phoneNumberRegexp.MatchString(s) && strings.ContainsAny(s, "+() .-")
+}
+
+func isSSN(s string) bool {
+	m := ssnRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return false
+	}
+	// Area numbers 000, 666 and 900-999 are never assigned, nor are all-zero
+	// group or serial numbers.
+	area, group, serial := m[1], m[2], m[3]
+	return area != "000" && area != "666" && area[0] != '9' && group != "00" && serial != "0000"
+}
+
+// Validates the IBAN check digits using the ISO 7064 mod 97-10 scheme.
+func isIBAN(s string) bool {
+	s = strings.ToUpper(strings.ReplaceAll(s, " ", ""))
+	if !ibanRegexp.MatchString(s) {
+		return false
+	}
+
+	var digits strings.Builder
+	for _, c := range s[4:] + s[:4] {
+		if c >= 'A' && c <= 'Z' {
+			digits.WriteString(strconv.Itoa(int(c - 'A' + 10)))
+		} else {
+			digits.WriteRune(c)
+		}
+	}
+	n, ok := new(big.Int).SetString(digits.String(), 10)
+	return ok && new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+func isIPAddress(s string) bool {
+	return net.ParseIP(s) != nil
+}
+
+func isCreditCard(s string) bool {
+	s = cardSeparators.Replace(s)
+	return len(s) >= 13 && len(s) <= 19 && learn.ValidLuhn(s)
+}
@@ -0,0 +1,79 @@
+package pii
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Summarizes the sensitive values seen, per endpoint and field.
+type Report struct {
+	mutex sync.Mutex
+
+	// Endpoint -> field -> detector name -> number of matching values.
+	endpoints map[string]map[string]map[string]int
+}
+
+func NewReport() *Report {
+	return &Report{endpoints: make(map[string]map[string]map[string]int)}
+}
+
+func (r *Report) record(endpoint, field string, detectors []string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	fields, ok := r.endpoints[endpoint]
+	if !ok {
+		fields = make(map[string]map[string]int)
+		r.endpoints[endpoint] = fields
+	}
+	counts, ok := fields[field]
+	if !ok {
+		counts = make(map[string]int)
+		fields[field] = counts
+	}
+	for _, d := range detectors {
+		counts[d]++
+	}
+}
+
+// Returns the number of endpoints and fields in which sensitive values were
+// detected.
+func (r *Report) Size() (endpoints, fields int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, f := range r.endpoints {
+		fields += len(f)
+	}
+	return len(r.endpoints), fields
+}
+
+// Writes the report as JSON. Map keys are sorted, so the output is
+// deterministic.
+func (r *Report) WriteJSON(w io.Writer) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Endpoints map[string]map[string]map[string]int `json:"endpoints"`
+	}{r.endpoints})
+}
+
+func (r *Report) WriteFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open PII report file %s", path)
+	}
+	defer f.Close()
+
+	if err := r.WriteJSON(f); err != nil {
+		return errors.Wrap(err, "failed to write PII report")
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/logrusorgru/aurora"
@@ -14,11 +15,41 @@ import (
 )
 
 var (
-	Stderr = NewP(os.Stderr)
+	Stderr = NewP(stderr)
 	Stdout = NewP(os.Stdout)
 	Color  = aurora.NewAurora(true)
 )
 
+// Where Stderr writes to. Can be redirected while other goroutines print.
+var stderr = &redirectableWriter{out: os.Stderr}
+
+// Sends output printed to Stderr to w instead, until the returned function is
+// called. Safe to call while other goroutines print.
+func RedirectStderr(w io.Writer) (restore func()) {
+	previous := stderr.set(w)
+	return func() { stderr.set(previous) }
+}
+
+type redirectableWriter struct {
+	mutex sync.Mutex
+	out   io.Writer
+}
+
+func (w *redirectableWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.out.Write(p)
+}
+
+// Replaces the destination, returning the previous one.
+func (w *redirectableWriter) set(out io.Writer) io.Writer {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	previous := w.out
+	w.out = out
+	return previous
+}
+
 func Infoln(args ...interface{}) {
 	Stderr.Infoln(args...)
 }
@@ -0,0 +1,55 @@
+package process_info
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Where the kubelet keeps the logs of each pod, in directories named
+// "<namespace>_<name>_<uid>". Replaced in tests.
+var podLogsRoot = "/var/log/pods"
+
+// Where Docker keeps the configuration of each container. Replaced in tests.
+var dockerRoot = "/var/lib/docker"
+
+// Labels that the kubelet adds to Docker containers.
+const (
+	dockerPodNameLabel      = "io.kubernetes.pod.name"
+	dockerPodNamespaceLabel = "io.kubernetes.pod.namespace"
+	dockerSandboxLabel      = "io.kubernetes.sandbox.id"
+)
+
+// Returns the namespace and name of the pod with the given UID from the
+// names of the kubelet's log directories, or empty strings if there are none.
+func podNameFromLogs(uid string) (string, string) {
+	dirs, err := ioutil.ReadDir(podLogsRoot)
+	if err != nil {
+		return "", ""
+	}
+	for _, d := range dirs {
+		parts := strings.SplitN(d.Name(), "_", 3)
+		if len(parts) == 3 && parts[2] == uid {
+			return parts[0], parts[1]
+		}
+	}
+	return "", ""
+}
+
+// Returns the labels of a Docker container, or nil if they can't be read.
+func dockerLabels(containerID string) map[string]string {
+	b, err := ioutil.ReadFile(filepath.Join(dockerRoot, "containers", containerID, "config.v2.json"))
+	if err != nil {
+		return nil
+	}
+	var config struct {
+		Config struct {
+			Labels map[string]string
+		}
+	}
+	if err := json.Unmarshal(b, &config); err != nil {
+		return nil
+	}
+	return config.Config.Labels
+}
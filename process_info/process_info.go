@@ -0,0 +1,336 @@
+package process_info
+
+import (
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akitasoftware/akita-cli/printer"
+	"github.com/akitasoftware/akita-cli/procfs"
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/tags"
+)
+
+// Tags attached to witnesses and HAR entries to identify the process that
+// handled them.
+const (
+	PIDTag          tags.Key = "x-akita-pid"
+	CommandTag      tags.Key = "x-akita-process"
+	ContainerIDTag  tags.Key = "x-akita-container-id"
+	PodUIDTag       tags.Key = "x-akita-pod-uid"
+	PodNameTag      tags.Key = "x-akita-pod-name"
+	PodNamespaceTag tags.Key = "x-akita-pod-namespace"
+
+	// Followed by the name of each pod label.
+	PodLabelTagPrefix = "x-akita-pod-label-"
+)
+
+// Sockets that aren't found are looked for again at most this often after the
+// last search finished, since finding them means reading every process's open
+// files.
+const minRefreshInterval = 5 * time.Second
+
+// Addresses not known to belong to the host are usually those of remote peers,
+// which are never found, so misses on them cause searches less often.
+const minUnknownRefreshInterval = time.Minute
+
+// A process that owns a local socket.
+type Owner struct {
+	PID     int
+	Command string
+
+	// Empty if the process isn't in a container.
+	ContainerID string
+
+	// Nil if the process isn't in a Kubernetes pod.
+	Pod *Pod
+}
+
+// A Kubernetes pod. Only the UID is always known.
+type Pod struct {
+	UID       string
+	Name      string
+	Namespace string
+	Labels    map[string]string
+}
+
+// Returns the tags identifying the owner.
+func (o *Owner) Tags() map[tags.Key]string {
+	result := map[tags.Key]string{
+		PIDTag:     strconv.Itoa(o.PID),
+		CommandTag: o.Command,
+	}
+	if o.ContainerID != "" {
+		result[ContainerIDTag] = o.ContainerID
+	}
+	if o.Pod != nil {
+		result[PodUIDTag] = o.Pod.UID
+		if o.Pod.Name != "" {
+			result[PodNameTag] = o.Pod.Name
+			result[PodNamespaceTag] = o.Pod.Namespace
+		}
+		for k, v := range o.Pod.Labels {
+			result[tags.Key(PodLabelTagPrefix+k)] = v
+		}
+	}
+	return result
+}
+
+// Returns the given tags together with the owner's. The given tags are not
+// modified. Either may be nil.
+func MergeTags(base map[tags.Key]string, o *Owner) map[tags.Key]string {
+	if o == nil {
+		return base
+	}
+	result := o.Tags()
+	for k, v := range base {
+		result[k] = v
+	}
+	return result
+}
+
+type socketKey struct {
+	ip   string
+	port uint16
+}
+
+func newSocketKey(ip net.IP, port uint16) socketKey {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return socketKey{ip: string(ip), port: port}
+}
+
+// Maps local sockets to the processes, containers and pods that own them.
+// Safe for concurrent use.
+type Resolver struct {
+	mutex sync.Mutex
+
+	// Sockets bound to a specific address, in any network namespace.
+	sockets map[socketKey]*Owner
+
+	// Sockets listening on all addresses in apidump's network namespace.
+	wildcard map[uint16]*Owner
+
+	// Addresses of the sockets in any network namespace.
+	localIPs map[string]struct{}
+
+	// When the last refresh finished, and whether one is running.
+	lastRefresh time.Time
+	refreshing  bool
+	refreshWG   sync.WaitGroup
+
+	// Pods by UID. Only used by refresh, which never runs concurrently with
+	// itself, so it isn't guarded by the mutex.
+	pods map[string]*Pod
+}
+
+// Finds the sockets open at the time of the call, so that traffic seen right
+// after is attributed.
+func NewResolver() *Resolver {
+	r := &Resolver{
+		sockets:    map[socketKey]*Owner{},
+		wildcard:   map[uint16]*Owner{},
+		localIPs:   map[string]struct{}{},
+		pods:       map[string]*Pod{},
+		refreshing: true,
+	}
+	r.refreshWG.Add(1)
+	r.refresh()
+	return r
+}
+
+// Waits for any refresh running in the background.
+func (r *Resolver) Close() {
+	if r != nil {
+		r.refreshWG.Wait()
+	}
+}
+
+// Returns the owner of the local socket with the given address, or nil if
+// there is none. Never blocks on a refresh: unknown sockets are looked for in
+// the background, and are found by lookups made once that finishes.
+func (r *Resolver) Lookup(ip net.IP, port int) *Owner {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if o := r.lookup(ip, port); o != nil {
+		return o
+	}
+	interval := minRefreshInterval
+	if _, ok := r.localIPs[newSocketKey(ip, 0).ip]; !ok {
+		interval = minUnknownRefreshInterval
+	}
+	if !r.refreshing && time.Since(r.lastRefresh) >= interval {
+		r.refreshing = true
+		r.refreshWG.Add(1)
+		go r.refresh()
+	}
+	return nil
+}
+
+func (r *Resolver) lookup(ip net.IP, port int) *Owner {
+	if o, ok := r.sockets[newSocketKey(ip, uint16(port))]; ok {
+		return o
+	}
+	return r.wildcard[uint16(port)]
+}
+
+// Returns the owner of whichever end of the traffic is a local socket,
+// preferring the server, or nil if neither is or r is nil.
+func (r *Resolver) ForTraffic(t akinet.ParsedNetworkTraffic) *Owner {
+	if r == nil {
+		return nil
+	}
+	serverIP, serverPort, clientIP, clientPort := t.DstIP, t.DstPort, t.SrcIP, t.SrcPort
+	if _, ok := t.Content.(akinet.HTTPResponse); ok {
+		serverIP, serverPort, clientIP, clientPort = clientIP, clientPort, serverIP, serverPort
+	}
+	if o := r.Lookup(serverIP, serverPort); o != nil {
+		return o
+	}
+	return r.Lookup(clientIP, clientPort)
+}
+
+// Rebuilds the maps of sockets without holding the mutex, then swaps them in.
+func (r *Resolver) refresh() {
+	defer r.refreshWG.Done()
+	sockets, wildcard, ok := r.findSockets()
+	localIPs := make(map[string]struct{}, len(sockets))
+	for k := range sockets {
+		localIPs[k.ip] = struct{}{}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if ok {
+		r.sockets, r.wildcard, r.localIPs = sockets, wildcard, localIPs
+	}
+	r.lastRefresh = time.Now()
+	r.refreshing = false
+}
+
+// Finds the owners of all sockets by matching the inodes of every process's
+// open sockets against the sockets in each network namespace. Returns false
+// if processes can't be listed.
+func (r *Resolver) findSockets() (map[socketKey]*Owner, map[uint16]*Owner, bool) {
+	pids, err := procfs.PIDs()
+	if err != nil {
+		printer.Debugf("Failed to attribute traffic to processes: %v\n", err)
+		return nil, nil, false
+	}
+	// Lowest PIDs first, so that sockets shared by forked workers are
+	// attributed to their parent.
+	sort.Ints(pids)
+
+	ownNamespace, _ := procfs.NetNamespace(os.Getpid())
+	owners := map[int]*Owner{}
+	inodeOwners := map[string]*Owner{}
+	namespacePIDs := map[string]int{}
+	for _, pid := range pids {
+		inodes, err := procfs.SocketInodes(pid)
+		if err != nil || len(inodes) == 0 {
+			continue
+		}
+		for inode := range inodes {
+			if _, ok := inodeOwners[inode]; !ok {
+				if owners[pid] == nil {
+					owners[pid] = r.owner(pid)
+				}
+				inodeOwners[inode] = owners[pid]
+			}
+		}
+		if ns, err := procfs.NetNamespace(pid); err == nil && ns != ownNamespace {
+			if _, ok := namespacePIDs[ns]; !ok {
+				namespacePIDs[ns] = pid
+			}
+		}
+	}
+
+	sockets := map[socketKey]*Owner{}
+	wildcard := map[uint16]*Owner{}
+	addSockets(0, inodeOwners, sockets, wildcard)
+	for _, pid := range namespacePIDs {
+		addSockets(pid, inodeOwners, sockets, wildcard)
+	}
+	return sockets, wildcard, true
+}
+
+// Adds the sockets in the network namespace of the given process, or in
+// apidump's namespace if pid is 0.
+func addSockets(pid int, inodeOwners map[string]*Owner, sockets map[socketKey]*Owner, wildcard map[uint16]*Owner) {
+	found, err := procfs.Sockets(pid)
+	if err != nil {
+		printer.Debugf("Failed to attribute traffic to processes: %v\n", err)
+		return
+	}
+	for _, s := range found {
+		o, ok := inodeOwners[s.Inode]
+		if !ok {
+			continue
+		}
+		if s.LocalIP.IsUnspecified() {
+			// Addresses in other namespaces aren't known, so only listeners in
+			// apidump's namespace can be matched by port alone.
+			if pid == 0 && s.Listening {
+				wildcard[s.LocalPort] = o
+			}
+			continue
+		}
+		sockets[newSocketKey(s.LocalIP, s.LocalPort)] = o
+	}
+}
+
+func (r *Resolver) owner(pid int) *Owner {
+	o := &Owner{PID: pid}
+	o.Command, _ = procfs.Command(pid)
+	if cgroup, err := procfs.Cgroup(pid); err == nil {
+		o.ContainerID = procfs.ContainerID(cgroup)
+		if uid := procfs.PodUID(cgroup); uid != "" {
+			o.Pod = r.pod(uid, o.ContainerID)
+		}
+	}
+	return o
+}
+
+// Returns the pod with the given UID, finding out what else is known about it
+// the first time it is seen.
+func (r *Resolver) pod(uid, containerID string) *Pod {
+	if p, ok := r.pods[uid]; ok {
+		return p
+	}
+	p := &Pod{UID: uid}
+	p.Namespace, p.Name = podNameFromLogs(uid)
+	if containerID != "" {
+		if labels := dockerLabels(containerID); labels != nil {
+			if p.Name == "" {
+				p.Namespace, p.Name = labels[dockerPodNamespaceLabel], labels[dockerPodNameLabel]
+			}
+			if sandbox := labels[dockerSandboxLabel]; sandbox != "" {
+				p.Labels = podLabels(dockerLabels(sandbox))
+			}
+		}
+	}
+	r.pods[uid] = p
+	return p
+}
+
+// Returns the labels of a pod from the labels of its Docker sandbox
+// container, without those added by the kubelet.
+func podLabels(sandboxLabels map[string]string) map[string]string {
+	var result map[string]string
+	for k, v := range sandboxLabels {
+		if strings.HasPrefix(k, "io.kubernetes.") || strings.HasPrefix(k, "annotation.") {
+			continue
+		}
+		if result == nil {
+			result = map[string]string{}
+		}
+		result[k] = v
+	}
+	return result
+}
@@ -0,0 +1,156 @@
+package process_info
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/akitasoftware/akita-cli/procfs"
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/tags"
+)
+
+const (
+	containerID = "3f4e8a1b2c5d6e7f8091a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f7"
+	sandboxID   = "9a8b7c6d5e4f30211203f4e5d6c7b8a99a8b7c6d5e4f30211203f4e5d6c7b8a9"
+	podUID      = "0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0"
+)
+
+type fakeRoot struct {
+	t    *testing.T
+	root string
+}
+
+func (f fakeRoot) write(path, contents string) {
+	path = filepath.Join(f.root, path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		f.t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func (f fakeRoot) link(path, target string) {
+	path = filepath.Join(f.root, path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		f.t.Fatal(err)
+	}
+	if err := os.Symlink(target, path); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+const tcpHeader = "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+
+func TestResolver(t *testing.T) {
+	root := fakeRoot{t: t, root: t.TempDir()}
+	defer func(proc, logs, docker string) {
+		procfs.Root, podLogsRoot, dockerRoot = proc, logs, docker
+	}(procfs.Root, podLogsRoot, dockerRoot)
+	procfs.Root = filepath.Join(root.root, "proc")
+	podLogsRoot = filepath.Join(root.root, "var/log/pods")
+	dockerRoot = filepath.Join(root.root, "var/lib/docker")
+
+	// Host namespace: nginx (PID 10) listens on *:80 and has accepted a
+	// connection on 10.0.0.1:80. A worker (PID 11) shares the listener.
+	root.write("proc/net/tcp", tcpHeader+
+		"   0: 00000000:0050 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1001 1\n"+
+		"   1: 0100000A:0050 0200000A:D6B2 01 00000000:00000000 00:00000000 00000000     0        0 1002 1\n")
+	for _, pid := range []string{"10", "11"} {
+		root.write("proc/"+pid+"/comm", "nginx\n")
+		root.write("proc/"+pid+"/cgroup", "0::/system.slice/nginx.service\n")
+		root.link("proc/"+pid+"/ns/net", "net:[4026531992]")
+		root.link("proc/"+pid+"/fd/3", "socket:[1001]")
+	}
+	root.link("proc/10/fd/4", "socket:[1002]")
+
+	// Pod namespace: a Docker container (PID 20) listening on *:8080, with a
+	// connection on 172.17.0.2:8080.
+	root.write("proc/20/net/tcp", tcpHeader+
+		"   0: 00000000:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 2001 1\n"+
+		"   1: 020011AC:1F90 010011AC:C000 01 00000000:00000000 00:00000000 00000000     0        0 2002 1\n")
+	root.write("proc/20/comm", "api-server\n")
+	root.write("proc/20/cgroup", "0::/kubepods/besteffort/pod"+podUID+"/"+containerID+"\n")
+	root.link("proc/20/ns/net", "net:[4026532300]")
+	root.link("proc/20/fd/5", "socket:[2001]")
+	root.link("proc/20/fd/6", "socket:[2002]")
+
+	root.write("var/log/pods/shop_api-7d9f_"+podUID+"/api/0.log", "")
+	root.write("var/lib/docker/containers/"+containerID+"/config.v2.json",
+		`{"Config": {"Labels": {"io.kubernetes.pod.name": "api-7d9f", "io.kubernetes.sandbox.id": "`+sandboxID+`"}}}`)
+	root.write("var/lib/docker/containers/"+sandboxID+"/config.v2.json",
+		`{"Config": {"Labels": {"app": "api", "io.kubernetes.pod.uid": "`+podUID+`", "annotation.kubernetes.io/config.seen": "x"}}}`)
+
+	r := NewResolver()
+	defer r.Close()
+
+	nginx := r.Lookup(net.ParseIP("10.0.0.1"), 80)
+	if assert.NotNil(t, nginx) {
+		assert.Equal(t, &Owner{PID: 10, Command: "nginx"}, nginx)
+	}
+	// Listeners on all addresses in apidump's namespace match any address.
+	assert.Equal(t, nginx, r.Lookup(net.ParseIP("192.168.1.5"), 80))
+
+	api := r.Lookup(net.ParseIP("172.17.0.2"), 8080)
+	if assert.NotNil(t, api) {
+		assert.Equal(t, 20, api.PID)
+		assert.Equal(t, containerID, api.ContainerID)
+		assert.Equal(t, &Pod{
+			UID:       podUID,
+			Name:      "api-7d9f",
+			Namespace: "shop",
+			Labels:    map[string]string{"app": "api"},
+		}, api.Pod)
+		assert.Equal(t, map[tags.Key]string{
+			PIDTag:                              "20",
+			CommandTag:                          "api-server",
+			ContainerIDTag:                      containerID,
+			PodUIDTag:                           podUID,
+			PodNameTag:                          "api-7d9f",
+			PodNamespaceTag:                     "shop",
+			tags.Key(PodLabelTagPrefix + "app"): "api",
+		}, api.Tags())
+	}
+
+	// The listener in the pod's namespace isn't known to apidump's.
+	assert.Nil(t, r.Lookup(net.ParseIP("10.0.0.1"), 8080))
+
+	// Responses are attributed to their source.
+	resp := akinet.ParsedNetworkTraffic{
+		SrcIP:   net.ParseIP("172.17.0.2"),
+		SrcPort: 8080,
+		DstIP:   net.ParseIP("172.17.0.1"),
+		DstPort: 49152,
+		Content: akinet.HTTPResponse{},
+	}
+	assert.Equal(t, api, r.ForTraffic(resp))
+
+	// Misses on remote addresses don't cause searches as often as misses on
+	// local ones.
+	r.mutex.Lock()
+	r.lastRefresh = time.Now().Add(-2 * minRefreshInterval)
+	r.mutex.Unlock()
+	assert.Nil(t, r.Lookup(net.ParseIP("93.184.216.34"), 443))
+	assert.False(t, r.refreshing)
+	assert.Nil(t, r.Lookup(net.ParseIP("10.0.0.1"), 9999))
+	r.Close()
+	assert.False(t, r.refreshing)
+	assert.True(t, time.Since(r.lastRefresh) < minRefreshInterval)
+}
+
+func TestMergeTags(t *testing.T) {
+	base := map[tags.Key]string{"env": "prod", PIDTag: "override"}
+	assert.Equal(t, base, MergeTags(base, nil))
+	assert.Equal(t, map[tags.Key]string{
+		"env":      "prod",
+		PIDTag:     "override",
+		CommandTag: "nginx",
+	}, MergeTags(base, &Owner{PID: 10, Command: "nginx"}))
+	assert.Len(t, base, 2)
+}
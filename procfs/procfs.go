@@ -0,0 +1,232 @@
+package procfs
+
+import (
+	"bufio"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Root of the proc filesystem. Replaced in tests.
+var Root = "/proc"
+
+// State of a listening socket in /proc/net/tcp.
+const tcpListen = "0A"
+
+// A TCP socket, from /proc/net/tcp or /proc/net/tcp6.
+type Socket struct {
+	LocalIP   net.IP
+	LocalPort uint16
+	Listening bool
+	Inode     string
+}
+
+// Returns the TCP sockets in the network namespace of a process, or in this
+// process's namespace if pid is 0.
+func Sockets(pid int) ([]Socket, error) {
+	netDir := filepath.Join(Root, "net")
+	if pid != 0 {
+		netDir = filepath.Join(Root, strconv.Itoa(pid), "net")
+	}
+
+	var result []Socket
+	for _, name := range []string{"tcp", "tcp6"} {
+		f, err := os.Open(filepath.Join(netDir, name))
+		if os.IsNotExist(err) && name == "tcp6" {
+			// IPv6 is disabled.
+			continue
+		} else if err != nil {
+			return nil, errors.Wrap(err, "failed to list TCP sockets")
+		}
+		sockets, err := parseSockets(f)
+		f.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %s", f.Name())
+		}
+		result = append(result, sockets...)
+	}
+	return result, nil
+}
+
+// Parses the contents of /proc/net/tcp or /proc/net/tcp6.
+func parseSockets(r io.Reader) ([]Socket, error) {
+	var sockets []Socket
+	scanner := bufio.NewScanner(r)
+	for first := true; scanner.Scan(); first = false {
+		if first {
+			// Column headings.
+			continue
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+
+		ip, port, err := parseAddr(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		sockets = append(sockets, Socket{
+			LocalIP:   ip,
+			LocalPort: port,
+			Listening: fields[3] == tcpListen,
+			Inode:     fields[9],
+		})
+	}
+	return sockets, scanner.Err()
+}
+
+// Parses a hex-encoded address, e.g. "0100007F:1F90" for 127.0.0.1:8080. The
+// kernel writes the IP as 32-bit words in host byte order, which is little
+// endian on every platform apidump supports.
+func parseAddr(s string) (net.IP, uint16, error) {
+	i := strings.LastIndexByte(s, ':')
+	if i < 0 {
+		return nil, 0, errors.Errorf("bad address %q", s)
+	}
+	port, err := strconv.ParseUint(s[i+1:], 16, 16)
+	if err != nil {
+		return nil, 0, errors.Errorf("bad address %q", s)
+	}
+	b, err := hex.DecodeString(s[:i])
+	if err != nil || (len(b) != net.IPv4len && len(b) != net.IPv6len) {
+		return nil, 0, errors.Errorf("bad address %q", s)
+	}
+	for w := 0; w < len(b); w += 4 {
+		b[w], b[w+1], b[w+2], b[w+3] = b[w+3], b[w+2], b[w+1], b[w]
+	}
+	return net.IP(b), uint16(port), nil
+}
+
+// Returns the IDs of all running processes.
+func PIDs() ([]int, error) {
+	entries, err := ioutil.ReadDir(Root)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list processes")
+	}
+	var pids []int
+	for _, e := range entries {
+		if pid, err := strconv.Atoi(e.Name()); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+// Returns the inodes of the sockets held open by a process.
+func SocketInodes(pid int) (map[string]bool, error) {
+	fdDir := filepath.Join(Root, strconv.Itoa(pid), "fd")
+	fds, err := ioutil.ReadDir(fdDir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list open files of process %d", pid)
+	}
+
+	inodes := map[string]bool{}
+	for _, fd := range fds {
+		// Sockets link to "socket:[inode]".
+		target, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+		if err != nil {
+			// The file was closed.
+			continue
+		}
+		if strings.HasPrefix(target, "socket:[") && strings.HasSuffix(target, "]") {
+			inodes[target[len("socket:["):len(target)-1]] = true
+		}
+	}
+	return inodes, nil
+}
+
+// Returns an identifier of the network namespace of a process.
+func NetNamespace(pid int) (string, error) {
+	ns, err := os.Readlink(filepath.Join(Root, strconv.Itoa(pid), "ns", "net"))
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to find network namespace of process %d", pid)
+	}
+	return ns, nil
+}
+
+// Returns the name of the command run by a process.
+func Command(pid int) (string, error) {
+	comm, err := ioutil.ReadFile(filepath.Join(Root, strconv.Itoa(pid), "comm"))
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read command of process %d", pid)
+	}
+	return strings.TrimSpace(string(comm)), nil
+}
+
+// Returns the contents of /proc/<pid>/cgroup.
+func Cgroup(pid int) (string, error) {
+	cgroup, err := ioutil.ReadFile(filepath.Join(Root, strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read cgroup of process %d", pid)
+	}
+	return string(cgroup), nil
+}
+
+// Returns the ID of the container named by the cgroup paths of a process, or
+// an empty string if it isn't in one. Docker, containerd and CRI-O name
+// cgroups after the full container ID, with a prefix such as "docker-" under
+// systemd.
+func ContainerID(cgroup string) string {
+	for _, elem := range cgroupElems(cgroup) {
+		if i := strings.LastIndexByte(elem, '-'); i >= 0 {
+			elem = elem[i+1:]
+		}
+		if isContainerID(elem) {
+			return elem
+		}
+	}
+	return ""
+}
+
+// Returns the UID of the Kubernetes pod named by the cgroup paths of a
+// process, or an empty string if it isn't in one. The kubelet names pod
+// cgroups "pod<uid>", or "kubepods-<qos>-pod<uid>.slice" with dashes in the
+// UID replaced by underscores under systemd.
+func PodUID(cgroup string) string {
+	for _, elem := range cgroupElems(cgroup) {
+		if i := strings.LastIndexByte(elem, '-'); i >= 0 && strings.HasPrefix(elem, "kubepods") {
+			elem = elem[i+1:]
+		}
+		if strings.HasPrefix(elem, "pod") && len(elem) == len("pod")+36 {
+			return strings.ReplaceAll(elem[len("pod"):], "_", "-")
+		}
+	}
+	return ""
+}
+
+// Returns the elements of the cgroup paths, without ".scope" and ".slice"
+// suffixes.
+func cgroupElems(cgroup string) []string {
+	var elems []string
+	for _, line := range strings.Split(cgroup, "\n") {
+		// Lines are "hierarchy-ID:controllers:path".
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		for _, elem := range strings.Split(parts[2], "/") {
+			elem = strings.TrimSuffix(elem, ".scope")
+			elem = strings.TrimSuffix(elem, ".slice")
+			if elem != "" {
+				elems = append(elems, elem)
+			}
+		}
+	}
+	return elems
+}
+
+func isContainerID(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
@@ -0,0 +1,62 @@
+package procfs
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const procNetTCP = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1001 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:1F90 0100007F:D6B2 01 00000000:00000000 00:00000000 00000000     0        0 1003 1 0000000000000000 20 4 30 10 -1
+`
+
+const procNetTCP6 = `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0000000000000000FFFF00000A00000A:01BB 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1004 1 0000000000000000 100 0 0 10 0
+`
+
+func TestParseSockets(t *testing.T) {
+	sockets, err := parseSockets(strings.NewReader(procNetTCP))
+	assert.NoError(t, err)
+	assert.Equal(t, []Socket{
+		{LocalIP: net.IPv4(0, 0, 0, 0).To4(), LocalPort: 8080, Listening: true, Inode: "1001"},
+		{LocalIP: net.IPv4(127, 0, 0, 1).To4(), LocalPort: 8080, Listening: false, Inode: "1003"},
+	}, sockets)
+
+	sockets, err = parseSockets(strings.NewReader(procNetTCP6))
+	assert.NoError(t, err)
+	if assert.Len(t, sockets, 1) {
+		assert.True(t, sockets[0].LocalIP.Equal(net.IPv4(10, 0, 0, 10)), sockets[0].LocalIP.String())
+		assert.Equal(t, uint16(443), sockets[0].LocalPort)
+	}
+
+	_, err = parseSockets(strings.NewReader("header\n 0: 00000000 00000000:0000 0A 0 0 0 0 0 1001\n"))
+	assert.Error(t, err)
+}
+
+func TestContainerID(t *testing.T) {
+	id := "3f4e8a1b2c5d6e7f8091a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f7"
+	for _, cgroup := range []string{
+		"12:pids:/docker/" + id + "\n11:memory:/docker/" + id,
+		"0::/system.slice/docker-" + id + ".scope",
+		"0::/kubepods/besteffort/pod0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0/" + id,
+		"0::/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod0f1e2d3c_4b5a_6978_8796_a5b4c3d2e1f0.slice/cri-containerd-" + id + ".scope",
+	} {
+		assert.Equal(t, id, ContainerID(cgroup), cgroup)
+	}
+	assert.Equal(t, "", ContainerID("0::/user.slice/user-1000.slice/session-2.scope"))
+}
+
+func TestPodUID(t *testing.T) {
+	uid := "0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0"
+	for _, cgroup := range []string{
+		"0::/kubepods/besteffort/pod" + uid + "/3f4e8a1b",
+		"0::/kubepods/pod" + uid,
+		"0::/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod" + strings.ReplaceAll(uid, "-", "_") + ".slice/cri-containerd-3f4e8a1b.scope",
+	} {
+		assert.Equal(t, uid, PodUID(cgroup), cgroup)
+	}
+	assert.Equal(t, "", PodUID("0::/docker/3f4e8a1b"))
+}
@@ -0,0 +1,367 @@
+package prom_metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Latency buckets, in seconds, used by default for histograms.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metricType string
+
+const (
+	counterType   metricType = "counter"
+	gaugeType     metricType = "gauge"
+	histogramType metricType = "histogram"
+)
+
+// A set of metrics exposed together in the Prometheus text format.
+//
+// Safe for concurrent use.
+type Registry struct {
+	mutex    sync.Mutex
+	families map[string]family
+}
+
+// Metrics registered by the packages that define them. Exposed by Serve.
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]family)}
+}
+
+// A metric and all its labeled series.
+type family interface {
+	header() (name, help string, typ metricType)
+
+	// Calls emit for each sample, in order. Suffix is appended to the metric
+	// name, e.g. "_bucket" for histograms.
+	samples(emit func(suffix string, labels []labelPair, value float64))
+}
+
+type labelPair struct {
+	name, value string
+}
+
+// Adds a metric family, replacing any registered with the same name.
+func (r *Registry) register(f family) {
+	name, _, _ := f.header()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.families[name] = f
+}
+
+// Removes the metric with the given name, if any.
+func (r *Registry) Unregister(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.families, name)
+}
+
+// Writes all metrics in the Prometheus text exposition format, ordered by
+// name.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mutex.Lock()
+	families := make([]family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mutex.Unlock()
+
+	sort.Slice(families, func(i, j int) bool {
+		a, _, _ := families[i].header()
+		b, _, _ := families[j].header()
+		return a < b
+	})
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		name, help, typ := f.header()
+		fmt.Fprintf(bw, "# HELP %s %s\n", name, escapeHelp(help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", name, typ)
+		f.samples(func(suffix string, labels []labelPair, value float64) {
+			bw.WriteString(name + suffix)
+			if len(labels) > 0 {
+				bw.WriteByte('{')
+				for i, l := range labels {
+					if i > 0 {
+						bw.WriteByte(',')
+					}
+					fmt.Fprintf(bw, "%s=\"%s\"", l.name, escapeLabelValue(l.value))
+				}
+				bw.WriteByte('}')
+			}
+			bw.WriteByte(' ')
+			bw.WriteString(formatValue(value))
+			bw.WriteByte('\n')
+		})
+	}
+	return bw.Flush()
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabelValue(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func pairLabels(names, values []string) []labelPair {
+	result := make([]labelPair, len(names))
+	for i := range names {
+		result[i] = labelPair{name: names[i], value: values[i]}
+	}
+	return result
+}
+
+// Series of a metric, keyed by their label values joined with a separator
+// that can't appear in UTF-8 text.
+type seriesMap struct {
+	labelNames []string
+
+	mutex  sync.Mutex
+	series map[string]interface{}
+}
+
+const labelSeparator = "\xff"
+
+func (m *seriesMap) get(values []string, create func() interface{}) interface{} {
+	if len(values) != len(m.labelNames) {
+		panic(fmt.Sprintf("expected %d label values, got %d", len(m.labelNames), len(values)))
+	}
+	key := strings.Join(values, labelSeparator)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if s, ok := m.series[key]; ok {
+		return s
+	}
+	s := create()
+	m.series[key] = s
+	return s
+}
+
+func (m *seriesMap) delete(values []string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.series, strings.Join(values, labelSeparator))
+}
+
+// Calls f on each series, ordered by label values.
+func (m *seriesMap) each(f func(labels []labelPair, s interface{})) {
+	m.mutex.Lock()
+	keys := make([]string, 0, len(m.series))
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	series := make([]interface{}, len(keys))
+	for i, k := range keys {
+		series[i] = m.series[k]
+	}
+	m.mutex.Unlock()
+
+	for i, k := range keys {
+		var values []string
+		if len(m.labelNames) > 0 {
+			values = strings.Split(k, labelSeparator)
+		}
+		f(pairLabels(m.labelNames, values), series[i])
+	}
+}
+
+type familyHeader struct {
+	name string
+	help string
+	typ  metricType
+}
+
+func (h familyHeader) header() (string, string, metricType) {
+	return h.name, h.help, h.typ
+}
+
+// A value that can go up and down, or, for counters, only up.
+type Value struct {
+	mutex sync.Mutex
+	value float64
+}
+
+func (v *Value) Add(delta float64) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.value += delta
+}
+
+func (v *Value) Inc() {
+	v.Add(1)
+}
+
+func (v *Value) Set(value float64) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.value = value
+}
+
+func (v *Value) Get() float64 {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.value
+}
+
+// A counter or gauge with the given label names.
+type ValueVec struct {
+	familyHeader
+	seriesMap
+}
+
+// Returns the series with the given label values, creating it if needed.
+// Panics if the number of values doesn't match the number of label names.
+func (v *ValueVec) With(labelValues ...string) *Value {
+	return v.get(labelValues, func() interface{} { return &Value{} }).(*Value)
+}
+
+// Removes the series with the given label values.
+func (v *ValueVec) Delete(labelValues ...string) {
+	v.delete(labelValues)
+}
+
+func (v *ValueVec) samples(emit func(string, []labelPair, float64)) {
+	v.each(func(labels []labelPair, s interface{}) {
+		emit("", labels, s.(*Value).Get())
+	})
+}
+
+// Registers a counter. Counters should only be increased.
+func (r *Registry) NewCounter(name, help string, labelNames ...string) *ValueVec {
+	return r.newValueVec(name, help, counterType, labelNames)
+}
+
+func (r *Registry) NewGauge(name, help string, labelNames ...string) *ValueVec {
+	return r.newValueVec(name, help, gaugeType, labelNames)
+}
+
+func (r *Registry) newValueVec(name, help string, typ metricType, labelNames []string) *ValueVec {
+	v := &ValueVec{
+		familyHeader: familyHeader{name: name, help: help, typ: typ},
+		seriesMap:    seriesMap{labelNames: labelNames, series: make(map[string]interface{})},
+	}
+	r.register(v)
+	return v
+}
+
+// Reports samples when metrics are written. Emit is called once for each
+// series, with one value for each label name.
+type SampleFunc func(emit func(value float64, labelValues ...string))
+
+type funcFamily struct {
+	familyHeader
+	labelNames []string
+	f          SampleFunc
+}
+
+func (ff *funcFamily) samples(emit func(string, []labelPair, float64)) {
+	ff.f(func(value float64, labelValues ...string) {
+		emit("", pairLabels(ff.labelNames, labelValues), value)
+	})
+}
+
+// Registers a counter whose values are read by f when metrics are written.
+func (r *Registry) NewCounterFunc(name, help string, labelNames []string, f SampleFunc) {
+	r.register(&funcFamily{familyHeader{name, help, counterType}, labelNames, f})
+}
+
+// Registers a gauge whose values are read by f when metrics are written.
+func (r *Registry) NewGaugeFunc(name, help string, labelNames []string, f SampleFunc) {
+	r.register(&funcFamily{familyHeader{name, help, gaugeType}, labelNames, f})
+}
+
+// Counts observations in buckets with the given upper bounds.
+type Histogram struct {
+	buckets []float64
+
+	mutex  sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+type HistogramVec struct {
+	familyHeader
+	seriesMap
+	buckets []float64
+}
+
+// Returns the series with the given label values, creating it if needed.
+func (v *HistogramVec) With(labelValues ...string) *Histogram {
+	return v.get(labelValues, func() interface{} {
+		return &Histogram{buckets: v.buckets, counts: make([]uint64, len(v.buckets))}
+	}).(*Histogram)
+}
+
+func (v *HistogramVec) samples(emit func(string, []labelPair, float64)) {
+	v.each(func(labels []labelPair, s interface{}) {
+		h := s.(*Histogram)
+		h.mutex.Lock()
+		counts := append([]uint64(nil), h.counts...)
+		count, sum := h.count, h.sum
+		h.mutex.Unlock()
+
+		var cumulative uint64
+		for i, upper := range v.buckets {
+			cumulative += counts[i]
+			emit("_bucket", append(labels, labelPair{"le", formatValue(upper)}), float64(cumulative))
+		}
+		emit("_bucket", append(labels, labelPair{"le", "+Inf"}), float64(count))
+		emit("_sum", labels, sum)
+		emit("_count", labels, float64(count))
+	})
+}
+
+// Registers a histogram with the given bucket upper bounds, in increasing
+// order.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	v := &HistogramVec{
+		familyHeader: familyHeader{name: name, help: help, typ: histogramType},
+		seriesMap:    seriesMap{labelNames: labelNames, series: make(map[string]interface{})},
+		buckets:      buckets,
+	}
+	r.register(v)
+	return v
+}
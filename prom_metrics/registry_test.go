@@ -0,0 +1,84 @@
+package prom_metrics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+
+	batches := r.NewCounter("uploads_total", "Batches uploaded,\nby result.", "result")
+	batches.With("success").Add(3)
+	batches.With("failure").Inc()
+
+	depth := r.NewGauge("queue_depth", "Queue depth.", "trace")
+	depth.With(`a"b`).Set(7)
+	depth.With("gone").Set(1)
+	depth.Delete("gone")
+
+	latency := r.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1})
+	latency.With().Observe(0.05)
+	latency.With().Observe(0.5)
+	latency.With().Observe(5)
+
+	r.NewGaugeFunc("entries", "Entries.", nil, func(emit func(float64, ...string)) {
+		emit(42)
+	})
+
+	var buf bytes.Buffer
+	assert.NoError(t, r.WriteText(&buf))
+	assert.Equal(t, `# HELP entries Entries.
+# TYPE entries gauge
+entries 42
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 5.55
+latency_seconds_count 3
+# HELP queue_depth Queue depth.
+# TYPE queue_depth gauge
+queue_depth{trace="a\"b"} 7
+# HELP uploads_total Batches uploaded,\nby result.
+# TYPE uploads_total counter
+uploads_total{result="failure"} 1
+uploads_total{result="success"} 3
+`, buf.String())
+
+	// Registering a metric with the same name replaces it.
+	r.NewGaugeFunc("entries", "Entries.", nil, func(emit func(float64, ...string)) {
+		emit(1)
+	})
+	r.Unregister("latency_seconds")
+	r.Unregister("queue_depth")
+	r.Unregister("uploads_total")
+	buf.Reset()
+	assert.NoError(t, r.WriteText(&buf))
+	assert.Equal(t, "# HELP entries Entries.\n# TYPE entries gauge\nentries 1\n", buf.String())
+}
+
+func TestServe(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("requests_total", "Requests.").With().Inc()
+
+	server, err := Serve("127.0.0.1:0", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	resp, err := http.Get("http://" + server.Addr + MetricsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), "requests_total 1\n")
+}
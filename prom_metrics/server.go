@@ -0,0 +1,46 @@
+package prom_metrics
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-cli/printer"
+)
+
+// Path at which Serve exposes metrics.
+const MetricsPath = "/metrics"
+
+// Returns a handler that writes the registry's metrics.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := r.WriteText(w); err != nil {
+			printer.Debugf("Failed to write metrics: %v\n", err)
+		}
+	})
+}
+
+// Starts serving the registry's metrics over HTTP at MetricsPath on the
+// given address, e.g. ":9090", in the background. Returns an error if the
+// address can't be listened on. The server's Addr is set to the address
+// listened on. Close the server to stop.
+func Serve(addr string, r *Registry) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to listen for metrics requests on %s", addr)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(MetricsPath, r.Handler())
+	server := &http.Server{Addr: listener.Addr().String(), Handler: mux}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			printer.Errorf("Metrics server stopped: %v\n", err)
+		}
+	}()
+
+	printer.Stderr.Infof("Serving Prometheus metrics at http://%s%s\n", server.Addr, MetricsPath)
+	return server, nil
+}
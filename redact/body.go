@@ -0,0 +1,184 @@
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/akitasoftware/akita-cli/learn"
+	"github.com/akitasoftware/akita-cli/printer"
+)
+
+// Redacts JSON and URL-encoded form bodies. Other bodies are returned
+// unchanged. Compressed bodies are decompressed first, in which case the
+// Content-Encoding header is removed from h.
+func (e *Engine) redactBody(h http.Header, body []byte, decompressed bool) ([]byte, bool) {
+	if len(body) == 0 || !e.hasBodyRules() {
+		return body, decompressed
+	}
+
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return body, decompressed
+	}
+	isJSON := mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+	isForm := mediaType == "application/x-www-form-urlencoded"
+	if !isJSON && !isForm {
+		return body, decompressed
+	}
+
+	plain := body
+	if !decompressed && len(h.Values("Content-Encoding")) > 0 {
+		if plain, err = learn.DecompressBody(h, body); err != nil {
+			printer.Debugf("Failed to decompress body for redaction, leaving as is: %v\n", err)
+			return body, decompressed
+		}
+	}
+
+	var redacted []byte
+	var changed bool
+	if isJSON {
+		redacted, changed = e.redactJSONBody(plain)
+	} else {
+		redacted, changed = e.redactFormBody(plain)
+	}
+	if !changed {
+		return body, decompressed
+	}
+
+	h.Del("Content-Encoding")
+	if h.Get("Content-Length") != "" {
+		h.Set("Content-Length", strconv.Itoa(len(redacted)))
+	}
+	return redacted, true
+}
+
+func (e *Engine) hasBodyRules() bool {
+	for _, r := range e.rules {
+		switch r.Location {
+		case AnyLocation, BodyLocation, FormLocation:
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Engine) redactFormBody(body []byte) ([]byte, bool) {
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return body, false
+	}
+	values, changed := e.redactValues(FormLocation, values)
+	return []byte(values.Encode()), changed
+}
+
+func (e *Engine) redactJSONBody(body []byte) ([]byte, bool) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		printer.Debugf("Failed to parse JSON body for redaction, leaving as is: %v\n", err)
+		return body, false
+	}
+
+	result, keep, changed := e.redactJSON(nil, v, nil)
+	if !changed {
+		return body, false
+	}
+	if !keep {
+		result = nil
+	}
+	redacted, err := json.Marshal(result)
+	if err != nil {
+		return body, false
+	}
+	return redacted, true
+}
+
+// Redacts a JSON value in place where possible. Returns the new value,
+// whether to keep it, and whether anything changed. If a rule matches an
+// object or array by path, its action applies to every value within it;
+// inherited carries that rule down.
+func (e *Engine) redactJSON(path []string, v interface{}, inherited *Rule) (interface{}, bool, bool) {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		r := e.match(BodyLocation, "", path, nil)
+		if r != nil && r.Action == DropAction {
+			return nil, false, true
+		} else if r != nil {
+			inherited = r
+		}
+
+		changed := false
+		for k, child := range x {
+			nv, keep, c := e.redactJSON(appendPath(path, k), child, inherited)
+			if !keep {
+				delete(x, k)
+			} else {
+				x[k] = nv
+			}
+			changed = changed || c
+		}
+		return x, true, changed
+
+	case []interface{}:
+		r := e.match(BodyLocation, "", path, nil)
+		if r != nil && r.Action == DropAction {
+			return nil, false, true
+		} else if r != nil {
+			inherited = r
+		}
+
+		changed := false
+		result := x[:0]
+		for _, child := range x {
+			nv, keep, c := e.redactJSON(appendPath(path, "[]"), child, inherited)
+			if keep {
+				result = append(result, nv)
+			}
+			changed = changed || c
+		}
+		return result, true, changed
+
+	case nil:
+		return nil, true, false
+
+	default:
+		s := jsonScalarString(x)
+		r := e.match(BodyLocation, "", path, &s)
+		if r == nil {
+			r = inherited
+		}
+		if r == nil {
+			return v, true, false
+		}
+		if r.Action == DropAction {
+			return nil, false, true
+		}
+		return e.apply(r, s), true, true
+	}
+}
+
+func jsonScalarString(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case json.Number:
+		return x.String()
+	case bool:
+		return strconv.FormatBool(x)
+	default:
+		return ""
+	}
+}
+
+// Appends to a copy of path, so that sibling paths don't share storage.
+func appendPath(path []string, elt string) []string {
+	result := make([]string, len(path), len(path)+1)
+	copy(result, path)
+	return append(result, elt)
+}
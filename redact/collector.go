@@ -0,0 +1,34 @@
+package redact
+
+import (
+	"github.com/akitasoftware/akita-cli/trace"
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+// Redacts HTTP traffic before passing it on. Placing this ahead of the
+// collectors that write or upload traffic ensures that none of them see the
+// original values.
+type collector struct {
+	engine *Engine
+	next   trace.Collector
+}
+
+var _ trace.Collector = (*collector)(nil)
+
+func NewCollector(e *Engine, next trace.Collector) trace.Collector {
+	return &collector{engine: e, next: next}
+}
+
+func (c *collector) Process(t akinet.ParsedNetworkTraffic) error {
+	switch content := t.Content.(type) {
+	case akinet.HTTPRequest:
+		t.Content = c.engine.RedactRequest(content)
+	case akinet.HTTPResponse:
+		t.Content = c.engine.RedactResponse(content)
+	}
+	return c.next.Process(t)
+}
+
+func (c *collector) Close() error {
+	return c.next.Close()
+}
@@ -0,0 +1,224 @@
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-cli/pii"
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+// Applies redaction rules to HTTP requests and responses.
+type Engine struct {
+	rules    []Rule
+	registry *pii.Registry
+	hashKey  []byte
+}
+
+func NewEngine(cfg Config, registry *pii.Registry) (*Engine, error) {
+	rules := make([]Rule, len(cfg.Rules))
+	copy(rules, cfg.Rules)
+	for i := range rules {
+		if err := rules[i].validate(registry); err != nil {
+			return nil, errors.Wrapf(err, "bad redaction rule %d", i+1)
+		}
+		if rules[i].Action == HashAction && cfg.HashKey == "" {
+			return nil, errors.Errorf("bad redaction rule %d: the hash action requires a key, set with hash_key or %s", i+1, HashKeyEnvVar)
+		}
+	}
+
+	return &Engine{
+		rules:    rules,
+		registry: registry,
+		hashKey:  []byte(cfg.HashKey),
+	}, nil
+}
+
+// Returns a redacted copy of the request. The original is left unmodified.
+func (e *Engine) RedactRequest(req akinet.HTTPRequest) akinet.HTTPRequest {
+	req.Header = e.redactHeader(req.Header, "Cookie")
+	req.Cookies = e.redactCookies(req.Cookies)
+	if cs := req.Header.Values("Cookie"); len(cs) > 0 {
+		parsed := (&http.Request{Header: http.Header{"Cookie": cs}}).Cookies()
+		pairs := make([]string, 0, len(parsed))
+		for _, c := range e.redactCookies(parsed) {
+			pairs = append(pairs, c.Name+"="+c.Value)
+		}
+		req.Header.Del("Cookie")
+		if len(pairs) > 0 {
+			req.Header.Set("Cookie", strings.Join(pairs, "; "))
+		}
+	}
+
+	if req.URL != nil && req.URL.RawQuery != "" {
+		if q, changed := e.redactValues(QueryLocation, req.URL.Query()); changed {
+			u := *req.URL
+			u.RawQuery = q.Encode()
+			req.URL = &u
+		}
+	}
+
+	req.Body, req.BodyDecompressed = e.redactBody(req.Header, req.Body, req.BodyDecompressed)
+	return req
+}
+
+// Returns a redacted copy of the response. The original is left unmodified.
+func (e *Engine) RedactResponse(resp akinet.HTTPResponse) akinet.HTTPResponse {
+	resp.Header = e.redactHeader(resp.Header, "Set-Cookie")
+	resp.Cookies = e.redactCookies(resp.Cookies)
+	if cs := resp.Header.Values("Set-Cookie"); len(cs) > 0 {
+		resp.Header.Del("Set-Cookie")
+		for _, v := range cs {
+			parsed := (&http.Response{Header: http.Header{"Set-Cookie": {v}}}).Cookies()
+			for _, c := range e.redactCookies(parsed) {
+				resp.Header.Add("Set-Cookie", c.String())
+			}
+		}
+	}
+
+	resp.Body, resp.BodyDecompressed = e.redactBody(resp.Header, resp.Body, resp.BodyDecompressed)
+	return resp
+}
+
+// Returns a redacted copy of the headers. The cookie header is copied as-is,
+// since cookies are redacted individually.
+func (e *Engine) redactHeader(h http.Header, cookieHeader string) http.Header {
+	if h == nil {
+		return nil
+	}
+	result := make(http.Header, len(h))
+	for k, vs := range h {
+		if http.CanonicalHeaderKey(k) == cookieHeader {
+			result[k] = append([]string(nil), vs...)
+			continue
+		}
+		for _, v := range vs {
+			if r := e.match(HeaderLocation, k, nil, &v); r != nil {
+				if r.Action == DropAction {
+					continue
+				}
+				v = e.apply(r, v)
+			}
+			result[k] = append(result[k], v)
+		}
+	}
+	return result
+}
+
+func (e *Engine) redactCookies(cs []*http.Cookie) []*http.Cookie {
+	if cs == nil {
+		return nil
+	}
+	result := make([]*http.Cookie, 0, len(cs))
+	for _, c := range cs {
+		if r := e.match(CookieLocation, c.Name, nil, &c.Value); r != nil {
+			if r.Action == DropAction {
+				continue
+			}
+			redacted := *c
+			redacted.Value = e.apply(r, c.Value)
+			c = &redacted
+		}
+		result = append(result, c)
+	}
+	return result
+}
+
+// Redacts query parameters or form fields. Returns whether any value changed,
+// so callers can leave the original encoding alone otherwise.
+func (e *Engine) redactValues(loc Location, values url.Values) (url.Values, bool) {
+	changed := false
+	result := make(url.Values, len(values))
+	for k, vs := range values {
+		for _, v := range vs {
+			if r := e.match(loc, k, nil, &v); r != nil {
+				changed = true
+				if r.Action == DropAction {
+					continue
+				}
+				v = e.apply(r, v)
+			}
+			result[k] = append(result[k], v)
+		}
+	}
+	return result, changed
+}
+
+// Returns the first rule that applies to a value. For JSON bodies, path is
+// the location of the value within the body; for other locations, name is
+// the name of the field. Value is nil for JSON objects and arrays, which are
+// only matched by path.
+func (e *Engine) match(loc Location, name string, path []string, value *string) *Rule {
+	for i := range e.rules {
+		r := &e.rules[i]
+		if r.Location != AnyLocation && r.Location != loc {
+			continue
+		}
+
+		if r.Name != "" {
+			switch loc {
+			case BodyLocation:
+				if !matchPath(r.path, path) {
+					continue
+				}
+			case HeaderLocation:
+				if !strings.EqualFold(r.Name, name) {
+					continue
+				}
+			default:
+				if r.Name != name {
+					continue
+				}
+			}
+		}
+
+		if r.Detector != "" && (value == nil || !e.registry.Matches(r.Detector, *value)) {
+			continue
+		}
+		return r
+	}
+	return nil
+}
+
+func matchPath(pattern, path []string) bool {
+	if len(pattern) != len(path) {
+		return false
+	}
+	for i, p := range pattern {
+		if p != path[i] && !(p == "*" && path[i] != "[]") {
+			return false
+		}
+	}
+	return true
+}
+
+// Applies a hash, mask or truncate action to a value.
+func (e *Engine) apply(r *Rule, value string) string {
+	switch r.Action {
+	case HashAction:
+		mac := hmac.New(sha256.New, e.hashKey)
+		mac.Write([]byte(value))
+		return hex.EncodeToString(mac.Sum(nil))
+	case MaskAction:
+		runes := []rune(value)
+		keep := r.Keep
+		if keep > len(runes) {
+			keep = len(runes)
+		}
+		return strings.Repeat("*", len(runes)-keep) + string(runes[len(runes)-keep:])
+	case TruncateAction:
+		runes := []rune(value)
+		if r.Keep < len(runes) {
+			runes = runes[:r.Keep]
+		}
+		return string(runes)
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,134 @@
+package redact
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/akitasoftware/akita-cli/pii"
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+func newTestEngine(t *testing.T, rules ...Rule) *Engine {
+	e, err := NewEngine(Config{HashKey: "secret", Rules: rules}, pii.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestRedactRequest(t *testing.T) {
+	e := newTestEngine(t,
+		Rule{Location: HeaderLocation, Name: "authorization", Action: DropAction},
+		Rule{Location: CookieLocation, Name: "session", Action: TruncateAction, Keep: 3},
+		Rule{Location: QueryLocation, Name: "token", Action: MaskAction, Keep: 2},
+		Rule{Location: BodyLocation, Name: "user.cards[].number", Action: MaskAction, Keep: 4},
+		Rule{Location: BodyLocation, Name: "user.secrets", Action: DropAction},
+		Rule{Detector: pii.Email, Action: HashAction},
+	)
+
+	u, _ := url.Parse("/v1/users?token=abcdef&q=1")
+	original := akinet.HTTPRequest{
+		Method: "POST",
+		URL:    u,
+		Header: http.Header{
+			"Authorization": {"Bearer xyz"},
+			"Content-Type":  {"application/json"},
+			"Cookie":        {"session=1234567; theme=dark"},
+			"X-Contact":     {"alice@example.com"},
+		},
+		Cookies: []*http.Cookie{{Name: "session", Value: "1234567"}, {Name: "theme", Value: "dark"}},
+		Body:    []byte(`{"user": {"email": "alice@example.com", "cards": [{"number": "4111111111111111"}], "secrets": [1, 2], "age": 30}}`),
+	}
+	redacted := e.RedactRequest(original)
+
+	emailHash := e.apply(&Rule{Action: HashAction}, "alice@example.com")
+	assert.Len(t, emailHash, 64)
+
+	assert.Equal(t, http.Header{
+		"Content-Type": {"application/json"},
+		"Cookie":       {"session=123; theme=dark"},
+		"X-Contact":    {emailHash},
+	}, redacted.Header)
+	assert.Equal(t, []*http.Cookie{{Name: "session", Value: "123"}, {Name: "theme", Value: "dark"}}, redacted.Cookies)
+	assert.Equal(t, url.Values{"q": {"1"}, "token": {"****ef"}}, redacted.URL.Query())
+	assert.JSONEq(t,
+		`{"user": {"email": "`+emailHash+`", "cards": [{"number": "************1111"}], "age": 30}}`,
+		string(redacted.Body))
+
+	// The original request is untouched.
+	assert.Equal(t, "Bearer xyz", original.Header.Get("Authorization"))
+	assert.Equal(t, "1234567", original.Cookies[0].Value)
+	assert.Equal(t, "token=abcdef&q=1", original.URL.RawQuery)
+}
+
+func TestRedactResponse(t *testing.T) {
+	e := newTestEngine(t,
+		Rule{Location: CookieLocation, Name: "session", Action: DropAction},
+		Rule{Location: BodyLocation, Name: "*.ssn", Action: HashAction},
+		Rule{Location: FormLocation, Name: "password", Action: DropAction},
+	)
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte(`{"alice": {"ssn": "123-45-6789"}, "list": [{"ssn": "x"}]}`))
+	w.Close()
+
+	resp := e.RedactResponse(akinet.HTTPResponse{
+		StatusCode: 200,
+		Header: http.Header{
+			"Content-Encoding": {"gzip"},
+			"Content-Type":     {"application/json"},
+			"Set-Cookie":       {"session=abc; Path=/", "theme=dark"},
+		},
+		Body: gz.Bytes(),
+	})
+	assert.True(t, resp.BodyDecompressed)
+	assert.Equal(t, "", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, []string{"theme=dark"}, resp.Header.Values("Set-Cookie"))
+	assert.JSONEq(t,
+		`{"alice": {"ssn": "`+e.apply(&Rule{Action: HashAction}, "123-45-6789")+`"}, "list": [{"ssn": "x"}]}`,
+		string(resp.Body))
+
+	form := e.RedactResponse(akinet.HTTPResponse{
+		Header: http.Header{"Content-Type": {"application/x-www-form-urlencoded"}},
+		Body:   []byte("user=bob&password=hunter2"),
+	})
+	assert.Equal(t, "user=bob", string(form.Body))
+
+	// Bodies that no rule applies to are left byte-for-byte unchanged.
+	untouched := []byte(`{ "b": 1, "a": 2 }`)
+	resp = e.RedactResponse(akinet.HTTPResponse{
+		Header: http.Header{"Content-Type": {"application/json"}},
+		Body:   untouched,
+	})
+	assert.Equal(t, untouched, resp.Body)
+}
+
+func TestBadRules(t *testing.T) {
+	badRules := []Rule{
+		{Name: "x"},
+		{Name: "x", Action: "scramble"},
+		{Location: "trailer", Name: "x", Action: DropAction},
+		{Action: DropAction},
+		{Detector: "no_such_detector", Action: DropAction},
+		{Name: "x", Action: MaskAction, Keep: -1},
+	}
+	for _, r := range badRules {
+		_, err := NewEngine(Config{Rules: []Rule{r}}, pii.NewRegistry())
+		assert.Error(t, err, "%+v", r)
+	}
+
+	_, err := NewEngine(Config{Rules: []Rule{{Name: "x", Action: HashAction}}}, pii.NewRegistry())
+	assert.Error(t, err, "hash without key")
+}
+
+func TestParseJSONPath(t *testing.T) {
+	assert.Equal(t, []string{"user", "cards", "[]", "number"}, parseJSONPath("$.user.cards[].number"))
+	assert.Equal(t, []string{"[]", "[]", "id"}, parseJSONPath("[][].id"))
+	assert.Equal(t, []string{"token"}, parseJSONPath("token"))
+}
@@ -0,0 +1,161 @@
+package redact
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-cli/pii"
+)
+
+// Environment variable holding the key used by the hash action. Overrides
+// hash_key in the rules file.
+const HashKeyEnvVar = "AKITA_REDACTION_KEY"
+
+// Where in a request or response a rule applies.
+type Location string
+
+const (
+	AnyLocation    Location = "any"
+	HeaderLocation Location = "header"
+	CookieLocation Location = "cookie"
+	QueryLocation  Location = "query"
+	BodyLocation   Location = "body" // JSON bodies
+	FormLocation   Location = "form" // URL-encoded form bodies
+)
+
+type Action string
+
+const (
+	// Removes the field entirely.
+	DropAction Action = "drop"
+
+	// Replaces the value with a keyed HMAC-SHA256 of it, so that equal values
+	// can still be correlated.
+	HashAction Action = "hash"
+
+	// Replaces all but the last Keep characters with '*'.
+	MaskAction Action = "mask"
+
+	// Keeps only the first Keep characters.
+	TruncateAction Action = "truncate"
+)
+
+// Format of the redaction rules file, e.g.
+//
+//	rules:
+//	  - location: header
+//	    name: Authorization
+//	    action: drop
+//	  - location: body
+//	    name: user.cards[].number
+//	    action: mask
+//	    keep: 4
+//	  - detector: email
+//	    action: hash
+type Config struct {
+	HashKey string `json:"hash_key"`
+	Rules   []Rule `json:"rules"`
+}
+
+// Selects values by location and field name, by PII detector, or both, and
+// redacts them with the given action. The first rule that matches a value
+// applies.
+type Rule struct {
+	// Defaults to AnyLocation.
+	Location Location `json:"location"`
+
+	// Name of the header, cookie, query parameter or form field. Header names
+	// are case-insensitive. For JSON bodies, this is a path such as
+	// "user.cards[].number", where "[]" selects all elements of an array and
+	// "*" matches any object key.
+	Name string `json:"name"`
+
+	// Name of a detector in the PII detector registry that the value must
+	// match.
+	Detector string `json:"detector"`
+
+	Action Action `json:"action"`
+
+	// Number of characters left in place by the mask and truncate actions.
+	Keep int `json:"keep"`
+
+	// Parsed form of Name, for JSON paths.
+	path []string
+}
+
+// Reads redaction rules from a YAML or JSON file.
+func LoadConfig(path string) (Config, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, errors.Wrap(err, "failed to read redaction rules")
+	}
+
+	var cfg Config
+	if err := yaml.Unmarshal(content, &cfg); err != nil {
+		return Config{}, errors.Wrapf(err, "failed to parse redaction rules %s", path)
+	}
+	if key := os.Getenv(HashKeyEnvVar); key != "" {
+		cfg.HashKey = key
+	}
+	return cfg, nil
+}
+
+func (r *Rule) validate(registry *pii.Registry) error {
+	switch r.Location {
+	case "":
+		r.Location = AnyLocation
+	case AnyLocation, HeaderLocation, CookieLocation, QueryLocation, BodyLocation, FormLocation:
+	default:
+		return errors.Errorf("unknown location %q", r.Location)
+	}
+
+	switch r.Action {
+	case DropAction, HashAction, MaskAction, TruncateAction:
+	case "":
+		return errors.New("missing action")
+	default:
+		return errors.Errorf("unknown action %q", r.Action)
+	}
+
+	if r.Keep < 0 {
+		return errors.Errorf("keep must not be negative")
+	}
+
+	if r.Name == "" && r.Detector == "" {
+		return errors.New("at least one of name and detector must be set")
+	}
+	if r.Detector != "" && !registry.Has(r.Detector) {
+		return errors.Errorf("unknown detector %q", r.Detector)
+	}
+
+	if r.Name != "" {
+		r.path = parseJSONPath(r.Name)
+	}
+	return nil
+}
+
+// Splits a JSON path such as "$.user.cards[].number" into its components,
+// e.g. ["user", "cards", "[]", "number"].
+func parseJSONPath(p string) []string {
+	p = strings.TrimPrefix(strings.TrimPrefix(p, "$"), ".")
+
+	var result []string
+	for _, elt := range strings.Split(p, ".") {
+		arrays := 0
+		for strings.HasSuffix(elt, "[]") {
+			elt = strings.TrimSuffix(elt, "[]")
+			arrays++
+		}
+		if elt != "" {
+			result = append(result, elt)
+		}
+		for i := 0; i < arrays; i++ {
+			result = append(result, "[]")
+		}
+	}
+	return result
+}
@@ -0,0 +1,199 @@
+package sampling
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/akitasoftware/akita-cli/learn"
+	"github.com/akitasoftware/akita-cli/pair_cache"
+	"github.com/akitasoftware/akita-cli/trace"
+	"github.com/akitasoftware/akita-libs/akid"
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/sampled_err"
+)
+
+// Holds each HTTP request until its response arrives, or vice versa, and lets
+// the engine decide whether to pass on the pair. The request and response are
+// always kept or dropped together. Other traffic is passed on as is.
+//
+// Pairs still waiting when traffic stops are decided when the next traffic
+// arrives or when the collector is closed.
+type collector struct {
+	engine *Engine
+	next   trace.Collector
+
+	// Pairs waiting for their other half. Those that wait too long, or are
+	// evicted when the cache is full, are decided on whatever is known.
+	pending *pair_cache.Cache
+}
+
+type pendingPair struct {
+	request  *akinet.ParsedNetworkTraffic
+	response *akinet.ParsedNetworkTraffic
+}
+
+var _ trace.Collector = (*collector)(nil)
+
+func NewCollector(e *Engine, next trace.Collector) trace.Collector {
+	return &collector{
+		engine:  e,
+		next:    next,
+		pending: pair_cache.New(pair_cache.Options{Name: "sampling"}),
+	}
+}
+
+func (c *collector) Process(t akinet.ParsedNetworkTraffic) error {
+	err := c.decideAll(c.pending.Expire(time.Now()))
+
+	var key akid.WitnessID
+	switch content := t.Content.(type) {
+	case akinet.HTTPRequest:
+		key = learn.ToWitnessID(content.StreamID, content.Seq)
+	case akinet.HTTPResponse:
+		key = learn.ToWitnessID(content.StreamID, content.Seq)
+	default:
+		if nextErr := c.next.Process(t); err == nil {
+			err = nextErr
+		}
+		return err
+	}
+
+	p := &pendingPair{}
+	if v, ok := c.pending.Take(key); ok {
+		p = v.(*pendingPair)
+	}
+	if _, isRequest := t.Content.(akinet.HTTPRequest); isRequest {
+		p.request = &t
+	} else {
+		p.response = &t
+	}
+
+	var pairErr error
+	if p.request != nil && p.response != nil {
+		pairErr = c.decide(p)
+	} else {
+		pairErr = c.decideAll(c.pending.Add(key, p))
+	}
+	if err == nil {
+		err = pairErr
+	}
+	return err
+}
+
+func (c *collector) Close() error {
+	errs := sampled_err.Errors{SampleCount: 5}
+	if err := c.decideAll(c.pending.Drain()); err != nil {
+		errs.Add(err)
+	}
+	if err := c.next.Close(); err != nil {
+		errs.Add(err)
+	}
+	if errs.TotalCount > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Decides on pairs removed from the cache. Returns the first error.
+func (c *collector) decideAll(pairs []interface{}) error {
+	var result error
+	for _, p := range pairs {
+		if err := c.decide(p.(*pendingPair)); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+// Passes on the request and response, if the engine decides to keep them.
+func (c *collector) decide(p *pendingPair) error {
+	if !c.engine.Decide(outcomeOf(p)).Kept() {
+		return nil
+	}
+	for _, t := range []*akinet.ParsedNetworkTraffic{p.request, p.response} {
+		if t == nil {
+			continue
+		}
+		if err := c.next.Process(*t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func outcomeOf(p *pendingPair) Outcome {
+	var o Outcome
+	if p.request != nil {
+		req := p.request.Content.(akinet.HTTPRequest)
+		o.Key = req.StreamID.String() + strconv.Itoa(req.Seq)
+		o.Method = req.Method
+		o.Host = req.Host
+		if req.URL != nil {
+			o.Path = req.URL.Path
+		}
+	}
+	if p.response != nil {
+		resp := p.response.Content.(akinet.HTTPResponse)
+		o.Key = resp.StreamID.String() + strconv.Itoa(resp.Seq)
+		o.StatusCode = resp.StatusCode
+	}
+
+	// Latency runs from the end of the request to the start of the response.
+	if p.request != nil && p.response != nil {
+		requestEnd := p.request.FinalPacketTime
+		if requestEnd.IsZero() {
+			requestEnd = p.request.ObservationTime
+		}
+		if latency := p.response.ObservationTime.Sub(requestEnd); latency > 0 {
+			o.Latency = latency
+		}
+	}
+	return o
+}
+
+// Passes on HTTP requests allowed by the endpoint rate limit, along with their
+// responses. Responses that arrive before their requests are dropped. Other
+// traffic is passed on as is.
+type endpointLimitCollector struct {
+	limit *EndpointRateLimit
+	next  trace.Collector
+
+	// Requests kept whose responses haven't been seen. Responses to requests
+	// that are forgotten are dropped.
+	kept *pair_cache.Cache
+}
+
+var _ trace.Collector = (*endpointLimitCollector)(nil)
+
+func NewEndpointRateLimitCollector(l *EndpointRateLimit, next trace.Collector) trace.Collector {
+	return &endpointLimitCollector{
+		limit: l,
+		next:  next,
+		kept:  pair_cache.New(pair_cache.Options{Name: "endpoint_limit"}),
+	}
+}
+
+func (c *endpointLimitCollector) Process(t akinet.ParsedNetworkTraffic) error {
+	c.kept.Expire(time.Now())
+
+	switch content := t.Content.(type) {
+	case akinet.HTTPRequest:
+		path := ""
+		if content.URL != nil {
+			path = content.URL.Path
+		}
+		if !c.limit.Allow(content.Method, content.Host, path) {
+			return nil
+		}
+		c.kept.Add(learn.ToWitnessID(content.StreamID, content.Seq), nil)
+	case akinet.HTTPResponse:
+		if _, ok := c.kept.Take(learn.ToWitnessID(content.StreamID, content.Seq)); !ok {
+			return nil
+		}
+	}
+	return c.next.Process(t)
+}
+
+func (c *endpointLimitCollector) Close() error {
+	return c.next.Close()
+}
@@ -0,0 +1,220 @@
+package sampling
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-cli/path_inference"
+)
+
+const (
+	// By default, each endpoint may use at most this share of the rate limit.
+	DefaultEndpointMaxShare = 0.25
+
+	// By default, each endpoint is guaranteed this many witnesses per minute.
+	DefaultEndpointMinPerMinute = 1
+
+	// Endpoints beyond maxTrackedEndpoints are counted under this name.
+	otherEndpoints = "(other)"
+
+	// Length of the window over which the rate limit is applied.
+	endpointLimitWindow = time.Minute
+)
+
+type EndpointLimitOptions struct {
+	// Total number of request/response pairs kept per minute.
+	WitnessesPerMinute float64
+
+	// Number of pairs per minute guaranteed to each endpoint seen, as long as
+	// the total allows. Defaults to DefaultEndpointMinPerMinute.
+	MinPerMinute int
+
+	// Share of WitnessesPerMinute that any one endpoint may use, between 0 and
+	// 1. Defaults to DefaultEndpointMaxShare.
+	MaxShare float64
+}
+
+// Divides a rate limit among endpoints -- distinct (method, host, path
+// template) triples -- so that frequently called endpoints, such as health
+// checks, don't starve rarely called ones.
+//
+// Each minute, every endpoint seen so far is guaranteed a minimum number of
+// witnesses; budget not set aside for these minimums is shared first come,
+// first served, up to a cap per endpoint.
+//
+// Safe for concurrent use, and meant to be shared by all collectors, so that
+// the limit applies to all of them together.
+type EndpointRateLimit struct {
+	budget         int
+	floor          int
+	maxPerEndpoint int
+
+	inferrer *path_inference.Inferrer
+
+	mutex       sync.Mutex
+	windowStart time.Time
+	windowKept  int
+	endpoints   map[string]*endpointCounts
+
+	// Number of witnesses set aside in the current window for endpoints that
+	// haven't reached their minimum.
+	outstanding int
+}
+
+type endpointCounts struct {
+	// Totals since the limiter was created.
+	observed int
+	kept     int
+
+	// Witnesses kept in the current window.
+	windowKept int
+}
+
+// Endpoints are named using the given inferrer, which may be shared with other
+// consumers. If it is nil, a private one is used.
+func NewEndpointRateLimit(opts EndpointLimitOptions, inferrer *path_inference.Inferrer) *EndpointRateLimit {
+	if inferrer == nil {
+		inferrer = path_inference.NewInferrer(nil, nil)
+	}
+	if opts.MinPerMinute <= 0 {
+		opts.MinPerMinute = DefaultEndpointMinPerMinute
+	}
+	if opts.MaxShare <= 0 || opts.MaxShare > 1 {
+		opts.MaxShare = DefaultEndpointMaxShare
+	}
+
+	budget := int(math.Max(1, math.Round(opts.WitnessesPerMinute)))
+	maxPerEndpoint := int(math.Max(float64(opts.MinPerMinute), math.Round(opts.MaxShare*float64(budget))))
+	return &EndpointRateLimit{
+		budget:         budget,
+		floor:          opts.MinPerMinute,
+		maxPerEndpoint: maxPerEndpoint,
+		inferrer:       inferrer,
+		endpoints:      make(map[string]*endpointCounts),
+	}
+}
+
+// Returns true if an HTTP request should be kept.
+func (l *EndpointRateLimit) Allow(method, host, path string) bool {
+	return l.allow(l.inferrer.ObserveEndpoint(method, host, path).String(), time.Now())
+}
+
+// Records a request that was dropped for reasons other than the rate limit.
+func (l *EndpointRateLimit) observe(endpoint string, now time.Time) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.rollWindow(now)
+	l.countsFor(endpoint).observed++
+}
+
+func (l *EndpointRateLimit) allow(endpoint string, now time.Time) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.rollWindow(now)
+
+	e := l.countsFor(endpoint)
+	e.observed++
+
+	if l.windowKept >= l.budget || e.windowKept >= l.maxPerEndpoint {
+		return false
+	}
+	belowFloor := e.windowKept < l.floor
+	if !belowFloor && l.windowKept+1+l.outstanding > l.budget {
+		// The rest of the budget is set aside for other endpoints' minimums.
+		return false
+	}
+
+	if belowFloor {
+		l.outstanding--
+	}
+	e.windowKept++
+	e.kept++
+	l.windowKept++
+	return true
+}
+
+// Starts a new window if the current one has ended. Caller must hold l.mutex.
+func (l *EndpointRateLimit) rollWindow(now time.Time) {
+	if now.Sub(l.windowStart) < endpointLimitWindow {
+		return
+	}
+	l.windowStart = now
+	l.windowKept = 0
+	for _, e := range l.endpoints {
+		e.windowKept = 0
+	}
+	l.outstanding = l.floor * len(l.endpoints)
+}
+
+// Caller must hold l.mutex.
+func (l *EndpointRateLimit) countsFor(endpoint string) *endpointCounts {
+	e, ok := l.endpoints[endpoint]
+	if ok {
+		return e
+	}
+	if len(l.endpoints) >= maxTrackedEndpoints {
+		endpoint = otherEndpoints
+		if e, ok := l.endpoints[endpoint]; ok {
+			return e
+		}
+	}
+	e = &endpointCounts{}
+	l.endpoints[endpoint] = e
+	l.outstanding += l.floor
+	return e
+}
+
+// Per-endpoint counts of requests observed and kept.
+type EndpointReport struct {
+	Endpoints []EndpointReportRow `json:"endpoints"`
+}
+
+type EndpointReportRow struct {
+	Endpoint string `json:"endpoint"`
+	Observed int    `json:"observed"`
+	Kept     int    `json:"kept"`
+}
+
+// Returns the counts so far, busiest endpoints first.
+func (l *EndpointRateLimit) Report() EndpointReport {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	rows := make([]EndpointReportRow, 0, len(l.endpoints))
+	for name, e := range l.endpoints {
+		rows = append(rows, EndpointReportRow{Endpoint: name, Observed: e.observed, Kept: e.kept})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Observed != rows[j].Observed {
+			return rows[i].Observed > rows[j].Observed
+		}
+		return rows[i].Endpoint < rows[j].Endpoint
+	})
+	return EndpointReport{Endpoints: rows}
+}
+
+func (r EndpointReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func (r EndpointReport) WriteFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open endpoint report file %s", path)
+	}
+	defer f.Close()
+
+	if err := r.WriteJSON(f); err != nil {
+		return errors.Wrap(err, "failed to write endpoint report")
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package sampling
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndpointRateLimit(t *testing.T) {
+	l := NewEndpointRateLimit(EndpointLimitOptions{
+		WitnessesPerMinute: 10,
+		MinPerMinute:       2,
+		MaxShare:           0.5,
+	}, nil)
+	now := time.Now()
+
+	// The health check is capped at half of the budget.
+	kept := 0
+	for i := 0; i < 20; i++ {
+		if l.allow("GET /health", now) {
+			kept++
+		}
+	}
+	assert.Equal(t, 5, kept)
+
+	// Another endpoint uses the rest, less the health check's minimum, which
+	// it has already used.
+	kept = 0
+	for i := 0; i < 20; i++ {
+		if l.allow("GET /users/{arg1}", now) {
+			kept++
+		}
+	}
+	assert.Equal(t, 5, kept)
+	assert.False(t, l.allow("POST /orders", now))
+
+	// In the next minute, the minimums of all three endpoints are set aside
+	// before the health check can use more than its own.
+	now = now.Add(time.Minute)
+	kept = 0
+	for i := 0; i < 20; i++ {
+		if l.allow("GET /health", now) {
+			kept++
+		}
+	}
+	assert.Equal(t, 5, kept)
+	for i := 0; i < 20; i++ {
+		l.allow("GET /users/{arg1}", now)
+	}
+	assert.True(t, l.allow("POST /orders", now))
+	assert.True(t, l.allow("POST /orders", now))
+	assert.False(t, l.allow("POST /orders", now))
+
+	report := l.Report()
+	assert.Equal(t, []EndpointReportRow{
+		{Endpoint: "GET /health", Observed: 40, Kept: 10},
+		{Endpoint: "GET /users/{arg1}", Observed: 40, Kept: 8},
+		{Endpoint: "POST /orders", Observed: 4, Kept: 2},
+	}, report.Endpoints)
+
+	var buf bytes.Buffer
+	assert.NoError(t, report.WriteJSON(&buf))
+	assert.Contains(t, buf.String(), `"endpoint": "GET /health"`)
+}
+
+func TestEndpointRateLimitNames(t *testing.T) {
+	l := NewEndpointRateLimit(EndpointLimitOptions{WitnessesPerMinute: 100}, nil)
+	assert.True(t, l.Allow("GET", "example.com", "/users/1"))
+	assert.True(t, l.Allow("GET", "example.com", "/users/2"))
+	assert.Equal(t, []EndpointReportRow{
+		{Endpoint: "GET example.com/users/{arg1}", Observed: 2, Kept: 2},
+	}, l.Report().Endpoints)
+}
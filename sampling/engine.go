@@ -0,0 +1,299 @@
+package sampling
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/OneOfOne/xxhash"
+
+	"github.com/akitasoftware/akita-cli/path_inference"
+	"github.com/akitasoftware/akita-cli/printer"
+)
+
+const (
+	// Responses with at least this status code are kept by default.
+	DefaultMinErrorStatus = 500
+
+	// By default, a request is a latency outlier if it took this many times
+	// longer than the average for its endpoint.
+	DefaultOutlierFactor = 3.0
+
+	// Number of requests to an endpoint needed before its average latency is
+	// trusted for finding outliers.
+	minLatencySamples = 20
+
+	// Weight of each new latency in an endpoint's moving average.
+	latencyAlpha = 0.1
+
+	// Limit on the number of endpoints tracked. Endpoints beyond this are
+	// never treated as first-seen.
+	maxTrackedEndpoints = 10000
+
+	// Share of the rate limit reserved for requests that are always kept.
+	// Sampled requests are dropped once the budget falls to this share, so
+	// that a burst of ordinary traffic can't crowd out errors.
+	reservedShare = 0.25
+)
+
+type Policy struct {
+	// Share of the remaining requests to keep, between 0 and 1.
+	SampleRate float64
+
+	// Caps the number of request/response pairs kept per minute, across all
+	// collectors sharing the engine. Zero means no limit.
+	WitnessesPerMinute float64
+
+	// Responses with at least this status code are always kept. Defaults to
+	// DefaultMinErrorStatus.
+	MinErrorStatus int
+
+	// Requests that take at least this long are always kept. Zero disables
+	// the fixed threshold; per-endpoint outliers are still kept.
+	SlowThreshold time.Duration
+
+	// Requests that take this many times longer than the average for their
+	// endpoint are always kept. Defaults to DefaultOutlierFactor.
+	OutlierFactor float64
+
+	// If set, divides the rate limit among endpoints, replacing
+	// WitnessesPerMinute.
+	EndpointLimit *EndpointRateLimit
+}
+
+// Why a request/response pair was kept or dropped.
+type Reason int
+
+const (
+	KeptError Reason = iota
+	KeptSlow
+	KeptNewEndpoint
+	KeptSampled
+	DroppedSampled
+	DroppedRateLimit
+
+	numReasons
+)
+
+func (r Reason) Kept() bool {
+	return r < DroppedSampled
+}
+
+func (r Reason) String() string {
+	switch r {
+	case KeptError:
+		return "error"
+	case KeptSlow:
+		return "slow"
+	case KeptNewEndpoint:
+		return "new_endpoint"
+	case KeptSampled:
+		return "sampled"
+	case DroppedSampled:
+		return "dropped_sampled"
+	case DroppedRateLimit:
+		return "dropped_rate_limit"
+	}
+	return "unknown"
+}
+
+// What is known about a request and its response when deciding whether to
+// keep them. A pair whose response was never seen has a zero StatusCode and
+// Latency.
+type Outcome struct {
+	// Identifies the pair, for sampling.
+	Key string
+
+	Method string
+	Host   string
+	Path   string
+
+	StatusCode int
+	Latency    time.Duration
+}
+
+// Decides which request/response pairs to keep once their outcome is known.
+// Errors, latency outliers and the first request to each endpoint are always
+// kept, subject to the rate limit; the rest are sampled.
+//
+// An Engine can be shared by several collectors, in which case the rate limit
+// applies to all of them together.
+type Engine struct {
+	policy          Policy
+	sampleThreshold float64
+
+	inferrer *path_inference.Inferrer
+
+	mutex     sync.Mutex
+	endpoints map[string]*endpointStats
+	limiter   *limiter
+	counts    [numReasons]int
+}
+
+type endpointStats struct {
+	count       int
+	meanLatency float64
+}
+
+// Endpoints are named using the given inferrer, which may be shared with other
+// consumers. If it is nil, a private one is used.
+func NewEngine(p Policy, inferrer *path_inference.Inferrer) *Engine {
+	if inferrer == nil {
+		inferrer = path_inference.NewInferrer(nil, nil)
+	}
+	if p.MinErrorStatus <= 0 {
+		p.MinErrorStatus = DefaultMinErrorStatus
+	}
+	if p.OutlierFactor <= 0 {
+		p.OutlierFactor = DefaultOutlierFactor
+	}
+
+	e := &Engine{
+		policy:          p,
+		sampleThreshold: float64(math.MaxUint32) * p.SampleRate,
+		inferrer:        inferrer,
+		endpoints:       make(map[string]*endpointStats),
+	}
+	if p.WitnessesPerMinute > 0 && p.EndpointLimit == nil {
+		e.limiter = newLimiter(p.WitnessesPerMinute, time.Now())
+	}
+	return e
+}
+
+func (e *Engine) Decide(o Outcome) Reason {
+	return e.decideAt(o, time.Now())
+}
+
+func (e *Engine) decideAt(o Outcome, now time.Time) Reason {
+	endpoint := e.inferrer.ObserveEndpoint(o.Method, o.Host, o.Path).String()
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	reason := e.classify(endpoint, o)
+	if l := e.policy.EndpointLimit; l != nil {
+		if !reason.Kept() {
+			l.observe(endpoint, now)
+		} else if !l.allow(endpoint, now) {
+			reason = DroppedRateLimit
+		}
+	} else if e.limiter != nil {
+		reserve := 0.0
+		if reason == KeptSampled {
+			reserve = reservedShare
+		}
+		if !e.limiter.take(now, reserve) {
+			reason = DroppedRateLimit
+		}
+	}
+	e.counts[reason]++
+	return reason
+}
+
+// Determines why the pair would be kept, ignoring the rate limit. Updates the
+// endpoint's statistics. Caller must hold e.mutex.
+func (e *Engine) classify(endpoint string, o Outcome) Reason {
+	stats, seen := e.endpoints[endpoint]
+	if !seen && len(e.endpoints) < maxTrackedEndpoints {
+		stats = &endpointStats{}
+		e.endpoints[endpoint] = stats
+	}
+
+	outlier := false
+	if stats != nil && o.Latency > 0 {
+		latency := float64(o.Latency)
+		outlier = stats.count >= minLatencySamples && latency > e.policy.OutlierFactor*stats.meanLatency
+		if stats.count == 0 {
+			stats.meanLatency = latency
+		} else {
+			stats.meanLatency = (1-latencyAlpha)*stats.meanLatency + latencyAlpha*latency
+		}
+		stats.count++
+	}
+
+	switch {
+	case o.StatusCode >= e.policy.MinErrorStatus:
+		return KeptError
+	case e.policy.SlowThreshold > 0 && o.Latency >= e.policy.SlowThreshold:
+		return KeptSlow
+	case outlier:
+		return KeptSlow
+	case !seen && stats != nil:
+		return KeptNewEndpoint
+	case e.includeSample(o.Key):
+		return KeptSampled
+	}
+	return DroppedSampled
+}
+
+// Changes the share of requests kept that aren't otherwise kept.
+func (e *Engine) SetSampleRate(rate float64) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.policy.SampleRate = rate
+	e.sampleThreshold = float64(math.MaxUint32) * rate
+}
+
+func (e *Engine) SampleRate() float64 {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.policy.SampleRate
+}
+
+// Caller must hold e.mutex.
+func (e *Engine) includeSample(key string) bool {
+	h := xxhash.New32()
+	h.WriteString(key)
+	return float64(h.Sum32()) < e.sampleThreshold
+}
+
+// Returns the number of pairs decided for each reason.
+func (e *Engine) Counts() map[Reason]int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	result := make(map[Reason]int, numReasons)
+	for r, n := range e.counts {
+		result[Reason(r)] = n
+	}
+	return result
+}
+
+// Prints a summary of the decisions made to stderr.
+func (e *Engine) PrintSummary() {
+	c := e.Counts()
+	printer.Stderr.Infof("Outcome sampling kept %d errors, %d slow requests, %d requests to new endpoints and %d sampled requests; dropped %d by sampling and %d by the rate limit\n",
+		c[KeptError], c[KeptSlow], c[KeptNewEndpoint], c[KeptSampled], c[DroppedSampled], c[DroppedRateLimit])
+}
+
+// A token bucket holding up to a minute's worth of witnesses.
+type limiter struct {
+	perSecond float64
+	capacity  float64
+	tokens    float64
+	last      time.Time
+}
+
+func newLimiter(perMinute float64, now time.Time) *limiter {
+	capacity := math.Max(perMinute, 1)
+	return &limiter{
+		perSecond: perMinute / 60,
+		capacity:  capacity,
+		tokens:    capacity,
+		last:      now,
+	}
+}
+
+// Takes a token if more than the given share of the capacity would remain
+// available before taking it.
+func (l *limiter) take(now time.Time, reserve float64) bool {
+	if elapsed := now.Sub(l.last).Seconds(); elapsed > 0 {
+		l.tokens = math.Min(l.capacity, l.tokens+elapsed*l.perSecond)
+		l.last = now
+	}
+	if l.tokens < 1 || l.tokens-1 < reserve*l.capacity {
+		return false
+	}
+	l.tokens--
+	return true
+}
@@ -0,0 +1,129 @@
+package sampling
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+func TestDecide(t *testing.T) {
+	e := NewEngine(Policy{SampleRate: 0}, nil)
+	get := func(key, path string, status int, latency time.Duration) Reason {
+		return e.Decide(Outcome{Key: key, Method: "GET", Host: "example.com", Path: path, StatusCode: status, Latency: latency})
+	}
+
+	assert.Equal(t, KeptNewEndpoint, get("a", "/users/1", 200, 10*time.Millisecond))
+	assert.Equal(t, DroppedSampled, get("b", "/users/2", 200, 10*time.Millisecond))
+	assert.Equal(t, KeptError, get("c", "/users/3", 503, 10*time.Millisecond))
+	assert.Equal(t, KeptNewEndpoint, get("d", "/orders", 200, 10*time.Millisecond))
+
+	for i := 0; i < minLatencySamples; i++ {
+		get(fmt.Sprint(i), "/users/4", 200, 10*time.Millisecond)
+	}
+	assert.Equal(t, KeptSlow, get("e", "/users/5", 200, time.Second))
+	assert.Equal(t, DroppedSampled, get("f", "/users/6", 200, 12*time.Millisecond))
+
+	counts := e.Counts()
+	assert.Equal(t, 1, counts[KeptError])
+	assert.Equal(t, 1, counts[KeptSlow])
+	assert.Equal(t, 2, counts[KeptNewEndpoint])
+}
+
+func TestSlowThreshold(t *testing.T) {
+	e := NewEngine(Policy{SampleRate: 0, SlowThreshold: 100 * time.Millisecond}, nil)
+	e.Decide(Outcome{Method: "GET", Path: "/"})
+	assert.Equal(t, KeptSlow, e.Decide(Outcome{Method: "GET", Path: "/", Latency: 150 * time.Millisecond}))
+	assert.Equal(t, DroppedSampled, e.Decide(Outcome{Method: "GET", Path: "/", Latency: 50 * time.Millisecond}))
+}
+
+func TestRateLimitReservesBudget(t *testing.T) {
+	e := NewEngine(Policy{SampleRate: 1, WitnessesPerMinute: 4}, nil)
+	now := time.Now()
+	decide := func(path string, status int) Reason {
+		return e.decideAt(Outcome{Method: "GET", Path: path, StatusCode: status}, now)
+	}
+
+	decide("/", 200)
+	assert.Equal(t, KeptSampled, decide("/", 200))
+	assert.Equal(t, KeptSampled, decide("/", 200))
+
+	// One token is left, which only errors may use.
+	assert.Equal(t, DroppedRateLimit, decide("/", 200))
+	assert.Equal(t, KeptError, decide("/", 500))
+	assert.Equal(t, DroppedRateLimit, decide("/", 500))
+
+	// The budget refills over time.
+	now = now.Add(15 * time.Second)
+	assert.Equal(t, KeptError, decide("/", 500))
+}
+
+type recordingCollector struct {
+	traffic []akinet.ParsedNetworkTraffic
+	closed  bool
+}
+
+func (c *recordingCollector) Process(t akinet.ParsedNetworkTraffic) error {
+	c.traffic = append(c.traffic, t)
+	return nil
+}
+
+func (c *recordingCollector) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestCollectorKeepsPairsTogether(t *testing.T) {
+	next := &recordingCollector{}
+	c := NewCollector(NewEngine(Policy{SampleRate: 0}, nil), next)
+
+	streamID := uuid.New()
+	start := time.Now()
+	u, _ := url.Parse("http://example.com/users/1")
+	request := func(seq int) akinet.ParsedNetworkTraffic {
+		return akinet.ParsedNetworkTraffic{
+			ObservationTime: start,
+			FinalPacketTime: start,
+			Content:         akinet.HTTPRequest{StreamID: streamID, Seq: seq, Method: "GET", URL: u},
+		}
+	}
+	response := func(seq, status int) akinet.ParsedNetworkTraffic {
+		return akinet.ParsedNetworkTraffic{
+			ObservationTime: start.Add(time.Millisecond),
+			Content:         akinet.HTTPResponse{StreamID: streamID, Seq: seq, StatusCode: status},
+		}
+	}
+
+	// The first request to the endpoint is kept, even though its response came
+	// first. The second is dropped along with its response. The third is kept
+	// because it failed. The fourth has no response yet, and is dropped when
+	// the collector closes.
+	assert.NoError(t, c.Process(response(1, 200)))
+	assert.NoError(t, c.Process(request(1)))
+	assert.NoError(t, c.Process(request(2)))
+	assert.NoError(t, c.Process(response(2, 200)))
+	assert.NoError(t, c.Process(request(3)))
+	assert.NoError(t, c.Process(akinet.ParsedNetworkTraffic{Content: akinet.TCPPacketMetadata{SYN: true}}))
+	assert.NoError(t, c.Process(response(3, 500)))
+	assert.NoError(t, c.Process(request(4)))
+	assert.NoError(t, c.Close())
+	assert.True(t, next.closed)
+
+	var seqs []string
+	for _, t := range next.traffic {
+		switch c := t.Content.(type) {
+		case akinet.HTTPRequest:
+			seqs = append(seqs, fmt.Sprintf("req%d", c.Seq))
+		case akinet.HTTPResponse:
+			seqs = append(seqs, fmt.Sprintf("resp%d", c.Seq))
+		default:
+			seqs = append(seqs, "other")
+		}
+	}
+	assert.Equal(t, []string{"req1", "resp1", "other", "req3", "resp3"}, seqs)
+}
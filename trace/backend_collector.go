@@ -6,16 +6,23 @@ import (
 	"net"
 	"net/http"
 	"reflect"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/pkg/errors"
 
+	"github.com/akitasoftware/akita-cli/dedup"
 	"github.com/akitasoftware/akita-cli/learn"
+	"github.com/akitasoftware/akita-cli/pair_cache"
+	"github.com/akitasoftware/akita-cli/path_inference"
 	"github.com/akitasoftware/akita-cli/plugin"
 	"github.com/akitasoftware/akita-cli/printer"
+	"github.com/akitasoftware/akita-cli/process_info"
 	"github.com/akitasoftware/akita-cli/rest"
+	"github.com/akitasoftware/akita-cli/traffic_direction"
+	"github.com/akitasoftware/akita-cli/upload_spool"
 	pb "github.com/akitasoftware/akita-ir/go/api_spec"
 	"github.com/akitasoftware/akita-libs/akid"
 	"github.com/akitasoftware/akita-libs/akinet"
@@ -23,20 +30,27 @@ import (
 	"github.com/akitasoftware/akita-libs/batcher"
 	"github.com/akitasoftware/akita-libs/spec_util"
 	"github.com/akitasoftware/akita-libs/spec_util/ir_hash"
+	"github.com/akitasoftware/akita-libs/tags"
 )
 
 const (
-	// We stop trying to pair partial witnesses older than pairCacheExpiration.
-	pairCacheExpiration = time.Minute
-
-	// How often we clean out stale partial witnesses from pairCache.
-	pairCacheCleanupInterval = 30 * time.Second
+	// How often we clean out stale partial witnesses from pairCache. Expiring
+	// entries is cheap, so this is kept short to honor short TTLs.
+	pairCacheCleanupInterval = 5 * time.Second
 
 	// Max size per upload batch.
 	uploadBatchMaxSize = 120
 
 	// How often to flush the upload batch.
 	uploadBatchFlushDuration = 30 * time.Second
+
+	// Timeout for each upload request.
+	uploadTimeout = 30 * time.Second
+
+	// Delay between attempts to upload spooled batches. The delay doubles after
+	// each failed attempt, up to the maximum.
+	spoolRetryMinDelay = 5 * time.Second
+	spoolRetryMaxDelay = 5 * time.Minute
 )
 
 type witnessWithInfo struct {
@@ -48,6 +62,18 @@ type witnessWithInfo struct {
 	id              akid.WitnessID
 	requestEnd      time.Time
 	responseStart   time.Time
+	direction       traffic_direction.Direction
+
+	// Set if the witness was never paired with its request or response.
+	unpaired bool
+
+	// If nonzero, the witness stands for this many duplicates, including
+	// itself.
+	duplicates int
+
+	// Identifies the phase the witness was observed in and the process that
+	// handled it, if known.
+	extraTags map[tags.Key]string
 
 	witness *pb.Witness
 }
@@ -62,6 +88,23 @@ func (r witnessWithInfo) toReport() (*kgxapi.WitnessReport, error) {
 		return nil, errors.Wrap(err, "failed to marshal witness proto")
 	}
 
+	var reportTags map[tags.Key]string
+	if r.unpaired || r.duplicates > 0 || r.direction == traffic_direction.Outbound || len(r.extraTags) > 0 {
+		reportTags = make(map[tags.Key]string, len(r.extraTags)+3)
+	}
+	for k, v := range r.extraTags {
+		reportTags[k] = v
+	}
+	if r.unpaired {
+		reportTags[pair_cache.UnpairedTag] = "true"
+	}
+	if r.duplicates > 0 {
+		reportTags[dedup.DuplicatesTag] = strconv.Itoa(r.duplicates)
+	}
+	if r.direction == traffic_direction.Outbound {
+		reportTags[traffic_direction.DirectionTag] = string(traffic_direction.Outbound)
+	}
+
 	return &kgxapi.WitnessReport{
 		Direction:       kgxapi.Inbound,
 		OriginAddr:      r.srcIP,
@@ -73,6 +116,7 @@ func (r witnessWithInfo) toReport() (*kgxapi.WitnessReport, error) {
 		ClientWitnessTime: r.observationTime,
 		Hash:              hash,
 		ID:                r.id,
+		Tags:              reportTags,
 	}, nil
 }
 
@@ -117,32 +161,132 @@ type BackendCollector struct {
 
 	// Cache un-paired partial witnesses by pair key.
 	// akid.WitnessID -> *witnessWithInfo
-	pairCache sync.Map
+	pairCache *pair_cache.Cache
 
 	// Batch of reports (witnesses, TCP-connection reports, etc.) pending upload.
+	// Replaced by Flush, so guarded by batchMutex.
+	batchMutex        sync.RWMutex
 	uploadReportBatch *batcher.InMemory
 
 	// Channel controlling periodic cache flush
 	flushDone chan struct{}
 
+	// Batches that failed to upload, waiting to be retried. Nil if no spool
+	// directory was given or the spool could not be opened, in which case
+	// failed batches are dropped.
+	spool *upload_spool.Spool
+
+	// Channel controlling retries of spooled batches.
+	retryDone chan struct{}
+	retryWG   sync.WaitGroup
+
+	// Determines the direction of each witness. If nil, all witnesses are
+	// reported as inbound.
+	direction *traffic_direction.Detector
+
+	// If set, suppresses witnesses of shapes that have already been uploaded.
+	dedup *dedup.Deduplicator
+
+	// If set, uploads are spooled instead while paused.
+	uploads *UploadSwitch
+
+	// If set, witnesses are tagged with the process that handled them.
+	owners *process_info.Resolver
+
+	// If set, witnesses are tagged with the phase they were observed in.
+	phases *Phases
+
+	// If set, paths in witnesses are replaced with path templates.
+	inferrer *path_inference.Inferrer
+
 	plugins []plugin.AkitaPlugin
 }
 
+type BackendCollectorOptions struct {
+	// Bounds the cache of partial witnesses waiting for their pair.
+	PairCache pair_cache.Options
+
+	// Determines the direction of each witness. The detector must be fed TCP
+	// metadata by another collector, such as the one returned by
+	// NewDirectionFilterCollector.
+	Direction *traffic_direction.Detector
+
+	// If set, witnesses of shapes that have already been uploaded are
+	// suppressed. The deduplicator may be shared by several collectors, in
+	// which case the last of them to close uploads the remaining summaries.
+	Dedup *dedup.Deduplicator
+
+	// If set, uploads can be paused and resumed. The switch may be shared by
+	// several collectors.
+	Uploads *UploadSwitch
+
+	// If set, witnesses are tagged with the process, container and pod that
+	// handled them. The resolver may be shared by several collectors.
+	Owners *process_info.Resolver
+
+	// If set, witnesses are tagged with the phase they were observed in.
+	Phases *Phases
+
+	// If set, concrete paths in witnesses are replaced with path templates
+	// inferred from the traffic seen so far, and the values of path
+	// parameters are added as arguments. The inferrer may be shared.
+	Inferrer *path_inference.Inferrer
+
+	// If set, batches that fail to upload are saved under this directory and
+	// retried, including by later runs that add to the same trace.
+	SpoolDir string
+}
+
 func NewBackendCollector(svc akid.ServiceID,
 	lrn akid.LearnSessionID, lc rest.LearnClient,
 	plugins []plugin.AkitaPlugin) Collector {
+	return NewBackendCollectorWithOptions(svc, lrn, lc, plugins, BackendCollectorOptions{})
+}
+
+func NewBackendCollectorWithOptions(svc akid.ServiceID,
+	lrn akid.LearnSessionID, lc rest.LearnClient,
+	plugins []plugin.AkitaPlugin, opts BackendCollectorOptions) *BackendCollector {
+	return newBackendCollector(svc, lrn, lc, plugins, opts, opts.SpoolDir)
+}
+
+func newBackendCollector(svc akid.ServiceID,
+	lrn akid.LearnSessionID, lc rest.LearnClient,
+	plugins []plugin.AkitaPlugin, opts BackendCollectorOptions,
+	spoolDir string) *BackendCollector {
 	col := &BackendCollector{
 		serviceID:      svc,
 		learnSessionID: lrn,
 		learnClient:    lc,
+		pairCache:      pair_cache.New(opts.PairCache),
+		direction:      opts.Direction,
+		dedup:          opts.Dedup,
+		uploads:        opts.Uploads,
+		owners:         opts.Owners,
+		phases:         opts.Phases,
+		inferrer:       opts.Inferrer,
 		flushDone:      make(chan struct{}),
+		retryDone:      make(chan struct{}),
 		plugins:        plugins,
 	}
 
-	col.uploadReportBatch = batcher.NewInMemory(
-		col.uploadReports,
-		uploadBatchMaxSize,
-		uploadBatchFlushDuration)
+	if spoolDir != "" {
+		if spool, err := upload_spool.Open(spoolDir, lrn, upload_spool.DefaultMaxBytes); err == nil {
+			col.spool = spool
+			if n := spool.Len(); n > 0 {
+				printer.Infof("Resuming upload of %d batches saved by an earlier run\n", n)
+			}
+			col.retryWG.Add(1)
+			go col.retrySpooledUploads()
+		} else {
+			printer.Warningf("Failed uploads will not be retried: %v\n", err)
+		}
+	}
+
+	if col.dedup != nil {
+		col.dedup.Retain()
+	}
+
+	col.uploadReportBatch = col.newBatch()
 
 	go col.periodicFlush()
 
@@ -173,7 +317,7 @@ func (c *BackendCollector) Process(t akinet.ParsedNetworkTraffic) error {
 		return nil
 	}
 
-	if val, ok := c.pairCache.LoadAndDelete(partial.PairKey); ok {
+	if val, ok := c.pairCache.Take(partial.PairKey); ok {
 		pair := val.(*witnessWithInfo)
 
 		// Combine the pair, merging the result into the existing item
@@ -200,10 +344,12 @@ func (c *BackendCollector) Process(t akinet.ParsedNetworkTraffic) error {
 			witness:         partial.Witness,
 			observationTime: t.ObservationTime,
 			id:              partial.PairKey,
+			direction:       c.direction.Direction(t),
 		}
+		w.extraTags = process_info.MergeTags(c.phases.TagsAt(nil, t.ObservationTime), c.owners.ForTraffic(t))
 		// Store whichever timestamp brackets the processing interval.
 		w.recordTimestamp(isRequest, t)
-		c.pairCache.Store(partial.PairKey, w)
+		c.queueUnpaired(c.pairCache.Add(partial.PairKey, w))
 
 	}
 	return nil
@@ -215,7 +361,7 @@ func (c *BackendCollector) processTCPConnection(packet akinet.ParsedNetworkTraff
 		srcAddr, srcPort, dstAddr, dstPort = dstAddr, dstPort, srcAddr, srcPort
 	}
 
-	c.uploadReportBatch.Add(&kgxapi.TCPConnectionReport{
+	c.addToBatch(&kgxapi.TCPConnectionReport{
 		ID:             tcp.ConnectionID,
 		SrcAddr:        srcAddr,
 		SrcPort:        uint16(srcPort),
@@ -230,7 +376,7 @@ func (c *BackendCollector) processTCPConnection(packet akinet.ParsedNetworkTraff
 }
 
 func (c *BackendCollector) processTLSHandshake(tls akinet.TLSHandshakeMetadata) error {
-	c.uploadReportBatch.Add(&kgxapi.TLSHandshakeReport{
+	c.addToBatch(&kgxapi.TLSHandshakeReport{
 		ID:                      tls.ConnectionID,
 		Version:                 tls.Version,
 		SNIHostname:             tls.SNIHostname,
@@ -250,19 +396,79 @@ func (c *BackendCollector) queueUpload(w *witnessWithInfo) {
 		}
 	}
 
+	// Generalize before obfuscating, so that the values of path parameters
+	// are obfuscated along with the other arguments.
+	if c.inferrer != nil {
+		c.inferrer.ObserveWitness(w.witness)
+		c.inferrer.GeneralizeWitness(w.witness)
+	}
+
 	// Obfuscate the original value so type inference engine can use it on the
 	// backend without revealing the actual value.
 	obfuscate(w.witness.GetMethod())
-	c.uploadReportBatch.Add(w)
+
+	if c.dedup != nil && !c.dedup.Add(w.witness, w, time.Now()) {
+		return
+	}
+	c.addToBatch(w)
+}
+
+// Uploads witnesses in place of the duplicates suppressed during a window.
+func (c *BackendCollector) queueDuplicateSummaries(summaries []dedup.Summary) {
+	for _, s := range summaries {
+		w := s.Value.(*witnessWithInfo)
+		w.duplicates = s.Count
+		c.addToBatch(w)
+	}
 }
 
 func (c *BackendCollector) Close() error {
 	close(c.flushDone)
-	c.flushPairCache(time.Now())
+	c.queueUnpaired(c.pairCache.Drain())
+	if c.dedup != nil {
+		c.queueDuplicateSummaries(c.dedup.Release())
+	}
+	c.batchMutex.RLock()
 	c.uploadReportBatch.Close()
+	c.batchMutex.RUnlock()
+
+	if c.spool != nil {
+		close(c.retryDone)
+		c.retryWG.Wait()
+
+		// Give spooled batches one last chance, and leave any that still fail
+		// for the next run.
+		c.uploadSpooled(nil)
+		if n := c.spool.Close(); n > 0 {
+			printer.Warningf("%d upload batches could not be sent to Akita Cloud. They are saved in %s, and will be retried the next time this trace is added to.\n", n, c.spool.Dir())
+		}
+	}
 	return nil
 }
 
+func (c *BackendCollector) newBatch() *batcher.InMemory {
+	return batcher.NewInMemory(c.uploadReports, uploadBatchMaxSize, uploadBatchFlushDuration)
+}
+
+func (c *BackendCollector) addToBatch(items ...interface{}) {
+	c.batchMutex.RLock()
+	defer c.batchMutex.RUnlock()
+	c.uploadReportBatch.Add(items...)
+}
+
+// Uploads the reports batched so far, without waiting for the batch to fill
+// up or the flush interval to pass. Partial witnesses waiting for their pair
+// are kept. Must not be called after Close.
+func (c *BackendCollector) Flush() {
+	c.batchMutex.Lock()
+	batch := c.uploadReportBatch
+	c.uploadReportBatch = c.newBatch()
+	c.batchMutex.Unlock()
+
+	// Closing the old batch uploads whatever is left in it.
+	batch.Close()
+}
+
 func (c *BackendCollector) uploadReports(in []interface{}) {
 	witnesses := make([]*kgxapi.WitnessReport, 0, len(in))
 	tcpConnections := make([]*kgxapi.TCPConnectionReport, 0, len(in))
@@ -288,36 +494,153 @@ func (c *BackendCollector) uploadReports(in []interface{}) {
 		}
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
 	upload := kgxapi.UploadReportsRequest{
 		Witnesses:      witnesses,
 		TCPConnections: tcpConnections,
 		TLSHandshakes:  tlsHandshakes,
 	}
-	err := c.learnClient.AsyncReportsUpload(ctx, c.learnSessionID, &upload)
-	if err != nil {
-		switch e := err.(type) {
-		case rest.HTTPError:
-			if e.StatusCode == http.StatusTooManyRequests {
-				// XXX Not all commands that call into this code have a --rate-limit
-				// option.
-				err = errors.Wrap(err, "your witness uploads are being throttled. Akita will generate partial results. Try reducing the --rate-limit value to avoid this.")
-			}
+	if c.uploads.Paused() && c.spool != nil {
+		err := c.spool.Add(&upload)
+		if err == nil {
+			upload_spool.CountSpooled()
+			printer.Debugf("Uploads paused, spooled %d witnesses\n", len(witnesses))
+			return
 		}
-		printer.Warningf("Failed to upload to Akita Cloud: %v\n", err)
+		printer.Debugf("Failed to spool upload batch while paused: %v\n", err)
+	}
+	if err := c.upload(&upload); err != nil {
+		c.spoolFailedUpload(&upload, err)
+		return
 	}
 	printer.Debugf("Uploaded %d witnesses and %d TCP connection reports\n", len(witnesses), len(tcpConnections))
 }
 
+func (c *BackendCollector) upload(req *kgxapi.UploadReportsRequest) error {
+	ctx, cancel := context.WithTimeout(context.Background(), uploadTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := c.learnClient.AsyncReportsUpload(ctx, c.learnSessionID, req)
+	uploadDuration.With().Observe(time.Since(start).Seconds())
+	if err != nil {
+		uploadBatches.With("failure").Inc()
+	} else {
+		uploadBatches.With("success").Inc()
+		uploadedWitnesses.With().Add(float64(len(req.Witnesses)))
+	}
+	return err
+}
+
+// Saves a batch that failed to upload so that it can be retried, unless the
+// failure is permanent or there is no room for it.
+func (c *BackendCollector) spoolFailedUpload(req *kgxapi.UploadReportsRequest, err error) {
+	if c.spool != nil && !isPermanentUploadError(err) {
+		spoolErr := c.spool.Add(req)
+		if spoolErr == nil {
+			upload_spool.CountSpooled()
+			printer.Warningf("Failed to upload to Akita Cloud, will retry: %v\n", err)
+			return
+		}
+		printer.Debugf("Failed to spool upload batch: %v\n", spoolErr)
+	}
+
+	upload_spool.CountAbandoned()
+	var httpErr rest.HTTPError
+	if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusTooManyRequests {
+		// XXX Not all commands that call into this code have a --rate-limit
+		// option.
+		err = errors.Wrap(err, "your witness uploads are being throttled. Akita will generate partial results. Try reducing the --rate-limit value to avoid this.")
+	}
+	printer.Warningf("Failed to upload to Akita Cloud: %v\n", err)
+}
+
+// Returns true if retrying the upload would not help.
+func isPermanentUploadError(err error) bool {
+	var httpErr rest.HTTPError
+	if !errors.As(err, &httpErr) {
+		return false
+	}
+	switch httpErr.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+	return httpErr.StatusCode >= 400 && httpErr.StatusCode < 500
+}
+
+// Periodically retries spooled batches, backing off while uploads fail.
+func (c *BackendCollector) retrySpooledUploads() {
+	defer c.retryWG.Done()
+
+	// Start right away, to resume uploading batches left by an earlier run.
+	delay := time.Duration(0)
+	for {
+		select {
+		case <-time.After(delay):
+		case <-c.retryDone:
+			return
+		}
+
+		if c.uploadSpooled(c.retryDone) {
+			delay = spoolRetryMinDelay
+		} else if delay *= 2; delay < spoolRetryMinDelay {
+			delay = spoolRetryMinDelay
+		} else if delay > spoolRetryMaxDelay {
+			delay = spoolRetryMaxDelay
+		}
+	}
+}
+
+// Uploads spooled batches, oldest first, until the spool is empty, an upload
+// fails, uploads are paused, or stop is closed. Returns false if an upload
+// failed.
+func (c *BackendCollector) uploadSpooled(stop <-chan struct{}) bool {
+	for {
+		select {
+		case <-stop:
+			return true
+		default:
+		}
+		if c.uploads.Paused() {
+			return true
+		}
+
+		b, err := c.spool.Take()
+		if err != nil {
+			upload_spool.CountAbandoned()
+			printer.Warningf("Dropping unreadable upload batch: %v\n", err)
+			continue
+		} else if b == nil {
+			return true
+		}
+
+		if err := c.upload(b.Request); err == nil {
+			upload_spool.CountRetried()
+			printer.Debugf("Uploaded spooled batch of %d witnesses\n", len(b.Request.Witnesses))
+		} else if isPermanentUploadError(err) {
+			upload_spool.CountAbandoned()
+			printer.Warningf("Akita Cloud rejected a spooled upload batch, dropping it: %v\n", err)
+		} else {
+			printer.Debugf("Failed to upload spooled batch: %v\n", err)
+			c.spool.Return(b)
+			return false
+		}
+
+		if err := c.spool.Remove(b); err != nil {
+			printer.Warningf("%v\n", err)
+		}
+	}
+}
+
 func (c *BackendCollector) periodicFlush() {
 	ticker := time.NewTicker(pairCacheCleanupInterval)
 
 	for true {
 		select {
 		case <-ticker.C:
-			c.flushPairCache(time.Now().Add(-1 * pairCacheExpiration))
+			c.queueUnpaired(c.pairCache.Expire(time.Now()))
+			if c.dedup != nil {
+				c.queueDuplicateSummaries(c.dedup.Expire(time.Now()))
+			}
 		case <-c.flushDone:
 			ticker.Stop()
 			return
@@ -325,13 +648,12 @@ func (c *BackendCollector) periodicFlush() {
 	}
 }
 
-func (c *BackendCollector) flushPairCache(cutoffTime time.Time) {
-	c.pairCache.Range(func(k, v interface{}) bool {
-		e := v.(*witnessWithInfo)
-		if e.observationTime.Before(cutoffTime) {
-			c.queueUpload(e)
-			c.pairCache.Delete(k)
-		}
-		return true
-	})
+// Uploads partial witnesses that were evicted from the pair cache or expired
+// before their pair arrived.
+func (c *BackendCollector) queueUnpaired(entries []interface{}) {
+	for _, e := range entries {
+		w := e.(*witnessWithInfo)
+		w.unpaired = true
+		c.queueUpload(w)
+	}
 }
@@ -2,7 +2,9 @@ package trace
 
 import (
 	"encoding/base64"
+	"net/http"
 	"net/url"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
@@ -12,13 +14,21 @@ import (
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/akitasoftware/akita-cli/dedup"
+	"github.com/akitasoftware/akita-cli/pair_cache"
+	"github.com/akitasoftware/akita-cli/path_inference"
+	"github.com/akitasoftware/akita-cli/process_info"
+	"github.com/akitasoftware/akita-cli/rest"
 	mockrest "github.com/akitasoftware/akita-cli/rest/mock"
+	"github.com/akitasoftware/akita-cli/traffic_direction"
+	"github.com/akitasoftware/akita-cli/upload_spool"
 	pb "github.com/akitasoftware/akita-ir/go/api_spec"
 	"github.com/akitasoftware/akita-libs/akid"
 	"github.com/akitasoftware/akita-libs/akinet"
 	kgxapi "github.com/akitasoftware/akita-libs/api_schema"
 	"github.com/akitasoftware/akita-libs/batcher"
 	"github.com/akitasoftware/akita-libs/spec_util"
+	"github.com/akitasoftware/akita-libs/tags"
 )
 
 var (
@@ -90,7 +100,7 @@ func TestObfuscate(t *testing.T) {
 		},
 	}
 
-	col := NewBackendCollector(fakeSvc, fakeLrn, mockClient, nil)
+	col := newBackendCollector(fakeSvc, fakeLrn, mockClient, nil, BackendCollectorOptions{}, t.TempDir())
 	assert.NoError(t, col.Process(req))
 	assert.NoError(t, col.Process(resp))
 	assert.NoError(t, col.Close())
@@ -225,7 +235,7 @@ func TestTiming(t *testing.T) {
 		FinalPacketTime: startTime.Add(13 * time.Millisecond),
 	}
 
-	col := NewBackendCollector(fakeSvc, fakeLrn, mockClient, nil)
+	col := newBackendCollector(fakeSvc, fakeLrn, mockClient, nil, BackendCollectorOptions{}, t.TempDir())
 	assert.NoError(t, col.Process(req))
 	assert.NoError(t, col.Process(resp))
 	assert.NoError(t, col.Close())
@@ -247,7 +257,7 @@ func TestMultipleInterfaces(t *testing.T) {
 		AnyTimes().
 		Return(nil)
 
-	bc := NewBackendCollector(fakeSvc, fakeLrn, mockClient, nil)
+	bc := newBackendCollector(fakeSvc, fakeLrn, mockClient, nil, BackendCollectorOptions{}, t.TempDir())
 
 	var wg sync.WaitGroup
 	fakeTrace := func(count int, start_seq int) {
@@ -306,3 +316,256 @@ func TestFlushExit(t *testing.T) {
 	b.periodicFlush()
 	// Test should exit immediately
 }
+
+// Batches that fail to upload are spooled and retried.
+func TestSpoolFailedUploads(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockClient := mockrest.NewMockLearnClient(ctrl)
+	defer ctrl.Finish()
+
+	var rec witnessRecorder
+	gomock.InOrder(
+		mockClient.EXPECT().
+			AsyncReportsUpload(gomock.Any(), gomock.Any(), gomock.Any()).
+			Return(rest.HTTPError{StatusCode: http.StatusServiceUnavailable}),
+		mockClient.EXPECT().
+			AsyncReportsUpload(gomock.Any(), gomock.Any(), gomock.Any()).
+			Do(rec.recordAsyncReportsUpload).
+			Return(nil),
+	)
+
+	spoolDir := t.TempDir()
+	before := upload_spool.GetCounts()
+
+	streamID := uuid.New()
+	col := newBackendCollector(fakeSvc, fakeLrn, mockClient, nil, BackendCollectorOptions{}, spoolDir)
+	assert.NoError(t, col.Process(akinet.ParsedNetworkTraffic{
+		Content: akinet.HTTPRequest{
+			StreamID: streamID,
+			Seq:      1,
+			Method:   "GET",
+			URL:      &url.URL{Path: "/v1/doggos"},
+			Host:     "example.com",
+		},
+	}))
+	assert.NoError(t, col.Process(akinet.ParsedNetworkTraffic{
+		Content: akinet.HTTPResponse{
+			StreamID:   streamID,
+			Seq:        1,
+			StatusCode: 200,
+		},
+	}))
+	assert.NoError(t, col.Close())
+
+	assert.Len(t, rec.witnesses, 1)
+	after := upload_spool.GetCounts()
+	assert.Equal(t, 1, after.Spooled-before.Spooled)
+	assert.Equal(t, 1, after.Retried-before.Retried)
+	assert.Equal(t, 0, after.Abandoned-before.Abandoned)
+
+	// The spool is cleaned up once it is empty.
+	matches, _ := filepath.Glob(filepath.Join(spoolDir, "*"))
+	assert.Empty(t, matches)
+}
+
+// Batches spooled by an earlier run for the same learn session are uploaded.
+func TestResumeSpooledUploads(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockClient := mockrest.NewMockLearnClient(ctrl)
+	defer ctrl.Finish()
+
+	var uploaded []*kgxapi.UploadReportsRequest
+	mockClient.EXPECT().
+		AsyncReportsUpload(gomock.Any(), fakeLrn, gomock.Any()).
+		Do(func(args ...interface{}) {
+			uploaded = append(uploaded, args[2].(*kgxapi.UploadReportsRequest))
+		}).
+		Times(2).
+		Return(nil)
+
+	spoolDir := t.TempDir()
+	spool, err := upload_spool.Open(spoolDir, fakeLrn, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"first", "second"} {
+		assert.NoError(t, spool.Add(&kgxapi.UploadReportsRequest{
+			Witnesses: []*kgxapi.WitnessReport{{WitnessProto: id}},
+		}))
+	}
+	assert.Equal(t, 2, spool.Close())
+
+	col := newBackendCollector(fakeSvc, fakeLrn, mockClient, nil, BackendCollectorOptions{}, spoolDir)
+	assert.NoError(t, col.Close())
+
+	if assert.Len(t, uploaded, 2) {
+		assert.Equal(t, "first", uploaded[0].Witnesses[0].WitnessProto)
+		assert.Equal(t, "second", uploaded[1].Witnesses[0].WitnessProto)
+	}
+}
+
+// While uploads are paused, batches are spooled, and they are uploaded once
+// uploads resume.
+func TestPausedUploads(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockClient := mockrest.NewMockLearnClient(ctrl)
+	defer ctrl.Finish()
+
+	var rec witnessRecorder
+	mockClient.EXPECT().
+		AsyncReportsUpload(gomock.Any(), gomock.Any(), gomock.Any()).
+		Do(rec.recordAsyncReportsUpload).
+		Return(nil)
+
+	spoolDir := t.TempDir()
+	uploads := &UploadSwitch{}
+	assert.True(t, uploads.Toggle())
+	assert.True(t, uploads.Paused())
+
+	streamID := uuid.New()
+	col := newBackendCollector(fakeSvc, fakeLrn, mockClient, nil, BackendCollectorOptions{Uploads: uploads}, spoolDir)
+	assert.NoError(t, col.Process(akinet.ParsedNetworkTraffic{
+		Content: akinet.HTTPRequest{
+			StreamID: streamID,
+			Seq:      1,
+			Method:   "GET",
+			URL:      &url.URL{Path: "/v1/doggos"},
+			Host:     "example.com",
+		},
+	}))
+	assert.NoError(t, col.Process(akinet.ParsedNetworkTraffic{
+		Content: akinet.HTTPResponse{
+			StreamID:   streamID,
+			Seq:        1,
+			StatusCode: 200,
+		},
+	}))
+	assert.NoError(t, col.Close())
+	assert.Empty(t, rec.witnesses)
+
+	// The paused batch is left in the spool, and is uploaded by the next
+	// collector once uploads resume.
+	assert.False(t, uploads.Toggle())
+	col = newBackendCollector(fakeSvc, fakeLrn, mockClient, nil, BackendCollectorOptions{Uploads: uploads}, spoolDir)
+	assert.NoError(t, col.Close())
+	assert.Len(t, rec.witnesses, 1)
+}
+
+// Flush uploads batched witnesses right away, and the collector keeps
+// batching afterwards.
+func TestFlushUploads(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockClient := mockrest.NewMockLearnClient(ctrl)
+	defer ctrl.Finish()
+
+	var rec witnessRecorder
+	mockClient.EXPECT().
+		AsyncReportsUpload(gomock.Any(), gomock.Any(), gomock.Any()).
+		Do(rec.recordAsyncReportsUpload).
+		Times(2).
+		Return(nil)
+
+	col := newBackendCollector(fakeSvc, fakeLrn, mockClient, nil, BackendCollectorOptions{}, t.TempDir())
+	send := func(seq int) {
+		streamID := uuid.New()
+		assert.NoError(t, col.Process(akinet.ParsedNetworkTraffic{
+			Content: akinet.HTTPRequest{
+				StreamID: streamID,
+				Seq:      seq,
+				Method:   "GET",
+				URL:      &url.URL{Path: "/v1/doggos"},
+				Host:     "example.com",
+			},
+		}))
+		assert.NoError(t, col.Process(akinet.ParsedNetworkTraffic{
+			Content: akinet.HTTPResponse{
+				StreamID:   streamID,
+				Seq:        seq,
+				StatusCode: 200,
+			},
+		}))
+	}
+
+	send(1)
+	assert.Empty(t, rec.witnesses)
+	col.Flush()
+	assert.Len(t, rec.witnesses, 1)
+
+	send(2)
+	assert.NoError(t, col.Close())
+	assert.Len(t, rec.witnesses, 2)
+}
+
+func TestReportTags(t *testing.T) {
+	w := witnessWithInfo{
+		witness:    &pb.Witness{},
+		unpaired:   true,
+		duplicates: 3,
+		direction:  traffic_direction.Outbound,
+		extraTags: process_info.MergeTags(
+			map[tags.Key]string{PhaseTag: "test"},
+			&process_info.Owner{PID: 42, Command: "nginx"},
+		),
+	}
+	report, err := w.toReport()
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[tags.Key]string{
+			pair_cache.UnpairedTag:         "true",
+			dedup.DuplicatesTag:            "3",
+			traffic_direction.DirectionTag: "outbound",
+			process_info.PIDTag:            "42",
+			process_info.CommandTag:        "nginx",
+			PhaseTag:                       "test",
+		}, report.Tags)
+		assert.Equal(t, kgxapi.Inbound, report.Direction)
+	}
+
+	report, err = witnessWithInfo{witness: &pb.Witness{}, direction: traffic_direction.Inbound}.toReport()
+	if assert.NoError(t, err) {
+		assert.Nil(t, report.Tags)
+	}
+}
+
+func TestGeneralizeUploadedWitnesses(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockClient := mockrest.NewMockLearnClient(ctrl)
+	defer ctrl.Finish()
+
+	var rec witnessRecorder
+	mockClient.
+		EXPECT().
+		AsyncReportsUpload(gomock.Any(), gomock.Any(), gomock.Any()).
+		Do(rec.recordAsyncReportsUpload).
+		AnyTimes().
+		Return(nil)
+
+	opts := BackendCollectorOptions{Inferrer: path_inference.NewInferrer(nil, nil)}
+	col := newBackendCollector(fakeSvc, fakeLrn, mockClient, nil, opts, "")
+	streamID := uuid.New()
+	assert.NoError(t, col.Process(akinet.ParsedNetworkTraffic{
+		Content: akinet.HTTPRequest{
+			StreamID: streamID,
+			Seq:      1,
+			Method:   "GET",
+			URL:      &url.URL{Path: "/v1/users/8f3a5c2e-1b7d-4c3e-9a2f-6d1e0b9c4a7f"},
+			Host:     "example.com",
+		},
+	}))
+	assert.NoError(t, col.Process(akinet.ParsedNetworkTraffic{
+		Content: akinet.HTTPResponse{StreamID: streamID, Seq: 1, StatusCode: 204},
+	}))
+	assert.NoError(t, col.Close())
+
+	if assert.Len(t, rec.witnesses, 1) {
+		method := rec.witnesses[0].GetMethod()
+		assert.Equal(t, "/v1/users/{arg1}", spec_util.HTTPMetaFromMethod(method).PathTemplate)
+		if assert.Len(t, method.GetArgs(), 1) {
+			for _, arg := range method.GetArgs() {
+				assert.Equal(t, "arg1", arg.GetMeta().GetHttp().GetPath().GetKey())
+				// The value of the path parameter is obfuscated.
+				assert.NotEmpty(t, arg.GetPrimitive().GetStringValue().GetValue())
+				assert.NotEqual(t, "8f3a5c2e-1b7d-4c3e-9a2f-6d1e0b9c4a7f", arg.GetPrimitive().GetStringValue().GetValue())
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package trace
+
+import (
+	"sync/atomic"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+// Pauses and resumes capture by the collectors that share it. While paused,
+// HTTP traffic is dropped before it is filtered, counted or recorded. TCP and
+// TLS metadata still passes, so that connections are tracked across the
+// pause.
+//
+// Safe for concurrent use. The zero value is not paused, and a nil
+// *CaptureSwitch is never paused.
+type CaptureSwitch struct {
+	paused int32
+}
+
+func (s *CaptureSwitch) Pause() {
+	atomic.StoreInt32(&s.paused, 1)
+}
+
+func (s *CaptureSwitch) Resume() {
+	atomic.StoreInt32(&s.paused, 0)
+}
+
+func (s *CaptureSwitch) Paused() bool {
+	return s != nil && atomic.LoadInt32(&s.paused) == 1
+}
+
+// Drops HTTP traffic while the switch is paused.
+type pausableCollector struct {
+	capture   *CaptureSwitch
+	collector Collector
+}
+
+func NewPausableCollector(capture *CaptureSwitch, collector Collector) Collector {
+	return &pausableCollector{
+		capture:   capture,
+		collector: collector,
+	}
+}
+
+func (c *pausableCollector) Process(t akinet.ParsedNetworkTraffic) error {
+	switch t.Content.(type) {
+	case akinet.TCPPacketMetadata, akinet.TCPConnectionMetadata, akinet.TLSHandshakeMetadata:
+	default:
+		if c.capture.Paused() {
+			return nil
+		}
+	}
+	return c.collector.Process(t)
+}
+
+func (c *pausableCollector) Close() error {
+	return c.collector.Close()
+}
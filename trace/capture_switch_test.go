@@ -0,0 +1,51 @@
+package trace
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+// HTTP traffic is dropped while paused, but connection metadata isn't.
+func TestPausableCollector(t *testing.T) {
+	next := &countingCollector{}
+	capture := &CaptureSwitch{}
+	col := NewPausableCollector(capture, next)
+
+	capture.Pause()
+	assert.True(t, capture.Paused())
+	assert.NoError(t, col.Process(akinet.ParsedNetworkTraffic{Content: akinet.HTTPRequest{}}))
+	assert.NoError(t, col.Process(akinet.ParsedNetworkTraffic{Content: akinet.TCPConnectionMetadata{}}))
+	assert.Equal(t, 1, next.GetNumPackets())
+
+	capture.Resume()
+	assert.NoError(t, col.Process(akinet.ParsedNetworkTraffic{Content: akinet.HTTPRequest{}}))
+	assert.Equal(t, 2, next.GetNumPackets())
+
+	assert.False(t, (*CaptureSwitch)(nil).Paused())
+}
+
+func TestSharedSamplingCollector(t *testing.T) {
+	next := &countingCollector{}
+	rate := NewSampleRate(1.0)
+	col := NewSharedSamplingCollector(rate, next)
+
+	send := func(n int) {
+		for i := 0; i < n; i++ {
+			assert.NoError(t, col.Process(akinet.ParsedNetworkTraffic{
+				Content: akinet.HTTPRequest{StreamID: uuid.New(), Seq: i},
+			}))
+		}
+	}
+
+	send(100)
+	assert.Equal(t, 100, next.GetNumPackets())
+
+	rate.Set(0.0)
+	assert.Equal(t, 0.0, rate.Get())
+	send(100)
+	assert.Equal(t, 100, next.GetNumPackets())
+}
@@ -1,11 +1,8 @@
 package trace
 
 import (
-	"math"
 	"strconv"
 
-	"github.com/OneOfOne/xxhash"
-
 	"github.com/akitasoftware/akita-cli/util"
 	"github.com/akitasoftware/akita-libs/akid"
 	"github.com/akitasoftware/akita-libs/akinet"
@@ -26,9 +23,7 @@ type Collector interface {
 
 // Wraps a Collector and performs sampling.
 type SamplingCollector struct {
-	// A sample is used if a coin flip is below this threshold.
-	sampleThreshold float64
-
+	rate      *SampleRate
 	collector Collector
 }
 
@@ -38,21 +33,18 @@ func NewSamplingCollector(sampleRate float64, collector Collector) Collector {
 	if sampleRate == 1.0 {
 		return collector
 	}
+	return NewSharedSamplingCollector(NewSampleRate(sampleRate), collector)
+}
 
+// Wraps a collector and samples at a rate that may be shared with other
+// collectors and changed while they run.
+func NewSharedSamplingCollector(rate *SampleRate, collector Collector) Collector {
 	return &SamplingCollector{
-		sampleThreshold: float64(math.MaxUint32) * sampleRate,
-		collector:       collector,
+		rate:      rate,
+		collector: collector,
 	}
 }
 
-// Sample based on stream ID and seq so a pair of request and response are
-// either both selected or both excluded.
-func (sc *SamplingCollector) includeSample(key string) bool {
-	h := xxhash.New32()
-	h.WriteString(key)
-	return float64(h.Sum32()) < sc.sampleThreshold
-}
-
 func (sc *SamplingCollector) Process(t akinet.ParsedNetworkTraffic) error {
 	var key string
 	switch c := t.Content.(type) {
@@ -67,7 +59,7 @@ func (sc *SamplingCollector) Process(t akinet.ParsedNetworkTraffic) error {
 	default:
 		key = ""
 	}
-	if sc.includeSample(key) {
+	if sc.rate.includeSample(key) {
 		return sc.collector.Process(t)
 	}
 	return nil
@@ -2,13 +2,28 @@ package trace
 
 import (
 	"regexp"
+	"time"
 
+	"github.com/akitasoftware/akita-cli/filter_expr"
 	"github.com/akitasoftware/akita-cli/learn"
+	"github.com/akitasoftware/akita-cli/pair_cache"
+	"github.com/akitasoftware/akita-cli/traffic_direction"
 	"github.com/akitasoftware/akita-libs/akid"
 	"github.com/akitasoftware/akita-libs/akinet"
 	"github.com/akitasoftware/akita-libs/trackers"
 )
 
+const (
+	// Limit on the number of requests held waiting for their response. When
+	// it is reached, the oldest requests are matched without their response.
+	maxPendingRequests = 10000
+
+	// Limit on the number of requests remembered as included without their
+	// response. Responses arriving after their request is forgotten are
+	// matched on their own.
+	maxIncludedRequests = 10000
+)
+
 // Filters out HTTP paths.
 // TODO: compile the N regular expressions into one for efficiency.
 func NewHTTPPathFilterCollector(matchers []*regexp.Regexp, col Collector) Collector {
@@ -75,6 +90,61 @@ func NewHTTPHostAllowlistCollector(matchers []*regexp.Regexp, col Collector) Col
 	}
 }
 
+// Allows only requests, and their responses, matched by a filter expression.
+// If the expression refers to the response, each request is held until its
+// response arrives, or until pair_cache.DefaultTTL has passed, in which case
+// it is matched on its own.
+func NewExpressionFilterCollector(e *filter_expr.Expr, col Collector) Collector {
+	fc := &genericRequestFilter{
+		Collector:    col,
+		exchangeFunc: e.Match,
+	}
+	if e.NeedsResponse() {
+		fc.waitForResponse = true
+		fc.pending = pair_cache.New(pair_cache.Options{
+			Name:       "filter_pending",
+			MaxEntries: maxPendingRequests,
+		})
+		fc.includedIDs = pair_cache.New(pair_cache.Options{
+			Name:       "filter_included",
+			MaxEntries: maxIncludedRequests,
+		})
+	}
+	return fc
+}
+
+// Feeds all traffic to the direction detector and filters out HTTP requests
+// and responses in directions not allowed by the filter.
+func NewDirectionFilterCollector(d *traffic_direction.Detector, f traffic_direction.Filter, col Collector) Collector {
+	return &directionFilter{
+		Collector: col,
+		detector:  d,
+		filter:    f,
+	}
+}
+
+type directionFilter struct {
+	Collector Collector
+	detector  *traffic_direction.Detector
+	filter    traffic_direction.Filter
+}
+
+func (fc *directionFilter) Process(t akinet.ParsedNetworkTraffic) error {
+	switch t.Content.(type) {
+	case akinet.HTTPRequest, akinet.HTTPResponse:
+		if !fc.filter.Allows(fc.detector.Direction(t)) {
+			return nil
+		}
+	default:
+		fc.detector.Observe(t)
+	}
+	return fc.Collector.Process(t)
+}
+
+func (fc *directionFilter) Close() error {
+	return fc.Collector.Close()
+}
+
 // Filters out third-party trackers.
 func New3PTrackerFilterCollector(col Collector) Collector {
 	return &genericRequestFilter{
@@ -96,6 +166,21 @@ type genericRequestFilter struct {
 	// Returns true if the request should be included.
 	filterFunc func(akinet.HTTPRequest) bool
 
+	// Returns true if the request and its response should be included. Either
+	// may be nil if it isn't needed or wasn't seen.
+	exchangeFunc func(req, resp *akinet.ParsedNetworkTraffic) bool
+
+	// If set, requests are held in pending until their response arrives, so
+	// that exchangeFunc can be called on both. Entries expire by observation
+	// time.
+	waitForResponse bool
+	pending         *pair_cache.Cache
+
+	// Records witness IDs of requests included without their response, so
+	// that the response is included if it arrives later. Only used if
+	// waitForResponse is set.
+	includedIDs *pair_cache.Cache
+
 	// Records witness IDs of filtered requests so we can filter out the
 	// corresponding responses.
 	// NOTE: we're assuming that we always see the request before the
@@ -106,19 +191,45 @@ type genericRequestFilter struct {
 }
 
 func (fc *genericRequestFilter) Process(t akinet.ParsedNetworkTraffic) error {
+	if fc.waitForResponse {
+		fc.includedIDs.Expire(t.ObservationTime)
+		if err := fc.matchAlone(fc.pending.Expire(t.ObservationTime), t.ObservationTime); err != nil {
+			return err
+		}
+	}
+
 	include := true
 	switch c := t.Content.(type) {
 	case akinet.HTTPRequest:
+		id := learn.ToWitnessID(c.StreamID, c.Seq)
 		if fc.filterFunc != nil && !fc.filterFunc(c) {
 			include = false
+		} else if fc.waitForResponse {
+			return fc.matchAlone(fc.pending.AddAt(id, t, t.ObservationTime), t.ObservationTime)
+		} else if fc.exchangeFunc != nil && !fc.exchangeFunc(&t, nil) {
+			include = false
+		}
 
-			if fc.filteredIDs == nil {
-				fc.filteredIDs = map[akid.WitnessID]struct{}{}
-			}
-			fc.filteredIDs[learn.ToWitnessID(c.StreamID, c.Seq)] = struct{}{}
+		if !include {
+			fc.filter(id)
 		}
 	case akinet.HTTPResponse:
-		if _, ok := fc.filteredIDs[learn.ToWitnessID(c.StreamID, c.Seq)]; ok {
+		id := learn.ToWitnessID(c.StreamID, c.Seq)
+		if _, ok := fc.filteredIDs[id]; ok {
+			include = false
+		} else if !fc.waitForResponse {
+			break
+		} else if _, ok := fc.includedIDs.Take(id); ok {
+			break
+		} else if val, ok := fc.pending.Take(id); ok {
+			req := val.(akinet.ParsedNetworkTraffic)
+			if !fc.exchangeFunc(&req, &t) {
+				return nil
+			}
+			if err := fc.Collector.Process(req); err != nil {
+				return err
+			}
+		} else if !fc.exchangeFunc(nil, &t) {
 			include = false
 		}
 	}
@@ -129,6 +240,40 @@ func (fc *genericRequestFilter) Process(t akinet.ParsedNetworkTraffic) error {
 	return nil
 }
 
+// Records the ID of a filtered request so that its response is filtered too.
+func (fc *genericRequestFilter) filter(id akid.WitnessID) {
+	if fc.filteredIDs == nil {
+		fc.filteredIDs = map[akid.WitnessID]struct{}{}
+	}
+	fc.filteredIDs[id] = struct{}{}
+}
+
+// Matches held requests that were evicted or expired, or are drained on
+// close, without their response. The IDs of included requests are recorded as
+// of now, so that their response is included if it arrives later.
+func (fc *genericRequestFilter) matchAlone(requests []interface{}, now time.Time) error {
+	for _, val := range requests {
+		req := val.(akinet.ParsedNetworkTraffic)
+		c := req.Content.(akinet.HTTPRequest)
+		id := learn.ToWitnessID(c.StreamID, c.Seq)
+		if !fc.exchangeFunc(&req, nil) {
+			fc.filter(id)
+			continue
+		}
+		fc.includedIDs.AddAt(id, struct{}{}, now)
+		if err := fc.Collector.Process(req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (fc *genericRequestFilter) Close() error {
+	if fc.waitForResponse {
+		if err := fc.matchAlone(fc.pending.Drain(), time.Now()); err != nil {
+			fc.Collector.Close()
+			return err
+		}
+	}
 	return fc.Collector.Close()
 }
@@ -0,0 +1,132 @@
+package trace
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/akitasoftware/akita-cli/filter_expr"
+	"github.com/akitasoftware/akita-cli/pair_cache"
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+type trafficRecorder struct {
+	traffic []akinet.ParsedNetworkTraffic
+	closed  bool
+}
+
+func (r *trafficRecorder) Process(t akinet.ParsedNetworkTraffic) error {
+	r.traffic = append(r.traffic, t)
+	return nil
+}
+
+func (r *trafficRecorder) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestExpressionFilterWaitsForResponse(t *testing.T) {
+	e, err := filter_expr.Compile("method = POST or status >= 500")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &trafficRecorder{}
+	col := NewExpressionFilterCollector(e, rec)
+
+	start := time.Now()
+	stream := uuid.New()
+	u, _ := url.Parse("/v1/users")
+	request := func(seq int, method string, at time.Time) akinet.ParsedNetworkTraffic {
+		return akinet.ParsedNetworkTraffic{
+			Content:         akinet.HTTPRequest{StreamID: stream, Seq: seq, Method: method, URL: u},
+			ObservationTime: at,
+		}
+	}
+	response := func(seq, status int, at time.Time) akinet.ParsedNetworkTraffic {
+		return akinet.ParsedNetworkTraffic{
+			Content:         akinet.HTTPResponse{StreamID: stream, Seq: seq, StatusCode: status},
+			ObservationTime: at,
+		}
+	}
+
+	// Requests are held until their response shows whether they match.
+	assert.NoError(t, col.Process(request(1, "GET", start)))
+	assert.NoError(t, col.Process(request(2, "GET", start)))
+	assert.Empty(t, rec.traffic)
+	assert.NoError(t, col.Process(response(1, 200, start)))
+	assert.NoError(t, col.Process(response(2, 502, start)))
+	if assert.Len(t, rec.traffic, 2) {
+		assert.Equal(t, 2, rec.traffic[0].Content.(akinet.HTTPRequest).Seq)
+		assert.Equal(t, 2, rec.traffic[1].Content.(akinet.HTTPResponse).Seq)
+	}
+
+	// A request whose response doesn't arrive in time is matched on its own.
+	rec.traffic = nil
+	assert.NoError(t, col.Process(request(3, "POST", start)))
+	assert.NoError(t, col.Process(request(4, "GET", start)))
+	later := start.Add(pair_cache.DefaultTTL + time.Second)
+	assert.NoError(t, col.Process(akinet.ParsedNetworkTraffic{Content: akinet.TCPPacketMetadata{}, ObservationTime: later}))
+	if assert.Len(t, rec.traffic, 2) {
+		assert.Equal(t, 3, rec.traffic[0].Content.(akinet.HTTPRequest).Seq)
+	}
+
+	// Late responses follow their request.
+	rec.traffic = nil
+	assert.NoError(t, col.Process(response(4, 500, later)))
+	assert.NoError(t, col.Process(response(3, 200, later)))
+	if assert.Len(t, rec.traffic, 1) {
+		assert.Equal(t, 3, rec.traffic[0].Content.(akinet.HTTPResponse).Seq)
+	}
+
+	// Requests still held when the collector closes are matched on their own.
+	rec.traffic = nil
+	assert.NoError(t, col.Process(request(5, "POST", later)))
+	assert.NoError(t, col.Close())
+	assert.Len(t, rec.traffic, 1)
+	assert.True(t, rec.closed)
+}
+
+func TestExpressionFilterEvictsPendingRequests(t *testing.T) {
+	e, err := filter_expr.Compile("method = POST or status >= 500")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &trafficRecorder{}
+	col := NewExpressionFilterCollector(e, rec)
+
+	now := time.Now()
+	stream := uuid.New()
+	u, _ := url.Parse("/v1/users")
+	for seq := 0; seq <= maxPendingRequests; seq++ {
+		assert.NoError(t, col.Process(akinet.ParsedNetworkTraffic{
+			Content:         akinet.HTTPRequest{StreamID: stream, Seq: seq, Method: "POST", URL: u},
+			ObservationTime: now,
+		}))
+	}
+
+	// The oldest request is matched on its own once the limit is reached, and
+	// its response follows it even though it doesn't match on its own.
+	if assert.Len(t, rec.traffic, 1) {
+		assert.Equal(t, 0, rec.traffic[0].Content.(akinet.HTTPRequest).Seq)
+	}
+	assert.NoError(t, col.Process(akinet.ParsedNetworkTraffic{
+		Content:         akinet.HTTPResponse{StreamID: stream, Seq: 0, StatusCode: 200},
+		ObservationTime: now,
+	}))
+	if assert.Len(t, rec.traffic, 2) {
+		assert.Equal(t, 0, rec.traffic[1].Content.(akinet.HTTPResponse).Seq)
+	}
+
+	// Requests matched on their own are forgotten once their response is overdue.
+	later := now.Add(2*pair_cache.DefaultTTL + time.Second)
+	assert.NoError(t, col.Process(akinet.ParsedNetworkTraffic{Content: akinet.TCPPacketMetadata{}, ObservationTime: later}))
+	rec.traffic = nil
+	assert.NoError(t, col.Process(akinet.ParsedNetworkTraffic{
+		Content:         akinet.HTTPResponse{StreamID: stream, Seq: 1, StatusCode: 200},
+		ObservationTime: later.Add(pair_cache.DefaultTTL + time.Second),
+	}))
+	assert.Empty(t, rec.traffic)
+}
@@ -1,95 +1,100 @@
 package trace
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"path"
 
 	"github.com/google/martian/v3/har"
-	"github.com/pkg/errors"
 
+	"github.com/akitasoftware/akita-cli/har_writer"
 	"github.com/akitasoftware/akita-cli/learn"
-	"github.com/akitasoftware/akita-cli/version"
+	"github.com/akitasoftware/akita-cli/printer"
+	"github.com/akitasoftware/akita-cli/process_info"
 	"github.com/akitasoftware/akita-libs/akid"
 	"github.com/akitasoftware/akita-libs/akinet"
 	"github.com/akitasoftware/akita-libs/tags"
 )
 
+// Streams HTTP traffic to HAR files as request/response pairs complete.
 type HARCollector struct {
-	logger        *har.Logger
-	interfaceName string
-	outDir        string
+	logger *har_writer.Logger
+	writer *har_writer.Writer
 
-	tags map[tags.Key]string
+	// If set, entries are tagged with the process that handled them.
+	owners *process_info.Resolver
+
+	// If set, entries are tagged with the phase they were observed in.
+	phases *Phases
 }
 
-func NewHARCollector(interfaceName, outDir string, tags map[tags.Key]string) *HARCollector {
+func NewHARCollector(interfaceName, outDir string, tags map[tags.Key]string, opts har_writer.Options) *HARCollector {
+	w := har_writer.NewWriter(outDir, harBaseName(interfaceName), opts, tags)
 	return &HARCollector{
-		logger:        har.NewLogger(),
-		interfaceName: interfaceName,
-		outDir:        outDir,
-		tags:          tags,
+		logger: har_writer.NewLogger(w, har_writer.DefaultUnpairedTimeout),
+		writer: w,
 	}
 }
 
+// Tags each entry with the process, container and pod that handled it.
+// Should be called before Process.
+func (h *HARCollector) SetOwners(r *process_info.Resolver) {
+	h.owners = r
+}
+
+// Tags each entry with the phase it was observed in. Should be called before
+// Process.
+func (h *HARCollector) SetPhases(p *Phases) {
+	h.phases = p
+}
+
 func (h *HARCollector) Process(t akinet.ParsedNetworkTraffic) error {
+	var err error
 	switch c := t.Content.(type) {
 	case akinet.HTTPRequest:
 		id := learn.ToWitnessID(c.StreamID, c.Seq)
-		h.logger.RecordRequestWithTimestamps(akid.String(id), c.ToStdRequest(),
+		err = h.logger.RecordTaggedRequest(akid.String(id), c.ToStdRequest(),
 			&har.MessageTimestamps{
 				StartTime: t.ObservationTime,
 				EndTime:   t.FinalPacketTime,
 			},
+			process_info.MergeTags(h.phases.TagsAt(nil, t.ObservationTime), h.owners.ForTraffic(t)),
 		)
 	case akinet.HTTPResponse:
 		id := learn.ToWitnessID(c.StreamID, c.Seq)
-		h.logger.RecordResponseWithTimestamps(akid.String(id), c.ToStdResponse(),
+		err = h.logger.RecordResponse(akid.String(id), c.ToStdResponse(),
 			&har.MessageTimestamps{
 				StartTime: t.ObservationTime,
 				EndTime:   t.FinalPacketTime,
 			},
 		)
 	}
+	if err != nil {
+		// Don't stop the capture because of a single bad entry.
+		printer.Debugf("Failed to record HAR entry: %v\n", err)
+	}
 	return nil
 }
 
-// TODO: output HAR files periodically instead of buffering everything in
-// memory.
+// Writes out any unpaired requests and completes the current HAR file.
 func (h *HARCollector) Close() error {
-	harContent := h.logger.ExportAndReset()
-
-	// Record AkitaExtension
-	harContent.AkitaExt = har.AkitaExtension{
-		Outbound: false,
-		Tags:     h.tags,
-	}
+	return h.logger.Close()
+}
 
-	if log := harContent.Log; log != nil {
-		if len(log.Entries) == 0 {
-			// No need to write an empty file.
-			return nil
-		}
+// Writes buffered entries to disk. Requests still waiting for their responses
+// are kept until they are paired or time out.
+func (h *HARCollector) Flush() error {
+	return h.writer.Flush()
+}
 
-		// Customize the creator info.
-		log.Creator = &har.Creator{
-			Name:    "Akita SuperLearn (https://akitasoftware.com)",
-			Version: version.CLIDisplayString(),
-		}
-	} else {
-		// No need to write an empty file.
-		return nil
-	}
+// Completes the current HAR file, so that later entries go to a new one.
+func (h *HARCollector) Rotate() error {
+	return h.writer.Close()
+}
 
-	harBytes, err := json.Marshal(harContent)
-	if err != nil {
-		return errors.Wrap(err, "failed to marshal HAR to JSON")
-	}
+// Returns the paths of the HAR files written so far.
+func (h *HARCollector) Files() []string {
+	return h.writer.Files()
+}
 
-	outPath := path.Join(h.outDir, fmt.Sprintf("akita_%s.har", h.interfaceName))
-	if err := ioutil.WriteFile(outPath, harBytes, 0644); err != nil {
-		return errors.Wrap(err, "failed to write HAR file")
-	}
-	return nil
+func harBaseName(interfaceName string) string {
+	return fmt.Sprintf("akita_%s", interfaceName)
 }
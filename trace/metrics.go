@@ -0,0 +1,66 @@
+package trace
+
+import (
+	"github.com/akitasoftware/akita-cli/prom_metrics"
+)
+
+var (
+	uploadBatches = prom_metrics.Default.NewCounter(
+		"akita_upload_batches_total",
+		"Batches of reports uploaded to Akita Cloud, by result. Retries of spooled batches are included.",
+		"result",
+	)
+
+	uploadDuration = prom_metrics.Default.NewHistogram(
+		"akita_upload_duration_seconds",
+		"Time taken to upload a batch of reports to Akita Cloud.",
+		prom_metrics.DefaultBuckets,
+	)
+
+	uploadedWitnesses = prom_metrics.Default.NewCounter(
+		"akita_uploaded_witnesses_total",
+		"Witnesses uploaded to Akita Cloud.",
+	)
+)
+
+// Registers metrics for the packets counted in the summary, by interface,
+// replacing any registered for another summary.
+func RegisterPacketCountMetrics(r *prom_metrics.Registry, s *PacketCountSummary) {
+	for _, m := range []struct {
+		name  string
+		help  string
+		count func(PacketCounters) int
+	}{
+		{"akita_tcp_packets_total", "TCP packets captured.", func(c PacketCounters) int { return c.TCPPackets }},
+		{"akita_http_requests_total", "HTTP requests parsed.", func(c PacketCounters) int { return c.HTTPRequests }},
+		{"akita_http_responses_total", "HTTP responses parsed.", func(c PacketCounters) int { return c.HTTPResponses }},
+		{"akita_unparsed_segments_total", "TCP segments that couldn't be parsed as HTTP.", func(c PacketCounters) int { return c.Unparsed }},
+	} {
+		count := m.count
+		r.NewCounterFunc(m.name, m.help, []string{"interface"}, func(emit func(float64, ...string)) {
+			for _, c := range s.AllInterfaces() {
+				emit(float64(count(c)), c.Interface)
+			}
+		})
+	}
+}
+
+// Counts of batches uploaded to Akita Cloud by this process.
+type UploadCounts struct {
+	// Batches uploaded successfully.
+	Batches int
+
+	// Failed upload attempts, including failed retries of spooled batches.
+	FailedBatches int
+
+	// Witnesses in the batches uploaded successfully.
+	Witnesses int
+}
+
+func GetUploadCounts() UploadCounts {
+	return UploadCounts{
+		Batches:       int(uploadBatches.With("success").Get()),
+		FailedBatches: int(uploadBatches.With("failure").Get()),
+		Witnesses:     int(uploadedWitnesses.With().Get()),
+	}
+}
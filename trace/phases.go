@@ -0,0 +1,210 @@
+package trace
+
+import (
+	"sync"
+	"time"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/tags"
+)
+
+// Tags traffic with the phase of "apidump --phase" during which it was
+// observed.
+const PhaseTag tags.Key = "x-akita-phase"
+
+// Records when each named phase of a capture started and ended, so that
+// traffic can be tagged with the phase it was observed in, dropped if that
+// phase is uncaptured, and counted per phase. Phases don't overlap, and their
+// names should be unique.
+//
+// Traffic is matched to phases by observation time rather than by when it is
+// processed, since reassembly and parsing lag behind capture.
+//
+// Safe for concurrent use. A nil *Phases has no phases.
+type Phases struct {
+	mutex sync.RWMutex
+
+	// In order of start time. The last phase may still be running.
+	spans []*phaseSpan
+}
+
+type phaseSpan struct {
+	name       string
+	uncaptured bool
+	start      time.Time
+
+	// Zero while the phase is running.
+	end time.Time
+
+	// HTTP requests counted by a phase count collector.
+	requests int
+}
+
+func NewPhases() *Phases {
+	return &Phases{}
+}
+
+// Starts the named phase at the given time, ending the current one if it is
+// still running.
+func (p *Phases) Start(name string, at time.Time) {
+	p.start(name, false, at)
+}
+
+// Like Start, but HTTP traffic observed during the phase is dropped by phase
+// filter collectors.
+func (p *Phases) StartUncaptured(name string, at time.Time) {
+	p.start(name, true, at)
+}
+
+func (p *Phases) start(name string, uncaptured bool, at time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.end(at)
+	p.spans = append(p.spans, &phaseSpan{name: name, uncaptured: uncaptured, start: at})
+}
+
+// Ends the current phase at the given time, if it is still running.
+func (p *Phases) End(at time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.end(at)
+}
+
+func (p *Phases) end(at time.Time) {
+	if n := len(p.spans); n > 0 && p.spans[n-1].end.IsZero() {
+		p.spans[n-1].end = at
+	}
+}
+
+// Returns the phase running at the given time, or nil if there was none.
+// Must be called with the mutex held.
+func (p *Phases) find(t time.Time) *phaseSpan {
+	for i := len(p.spans) - 1; i >= 0; i-- {
+		s := p.spans[i]
+		if t.Before(s.start) {
+			continue
+		}
+		if s.end.IsZero() || t.Before(s.end) {
+			return s
+		}
+		break
+	}
+	return nil
+}
+
+// Returns the name of the phase running at the given time, or "" if there
+// was none.
+func (p *Phases) At(t time.Time) string {
+	if p == nil {
+		return ""
+	}
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	if s := p.find(t); s != nil {
+		return s.name
+	}
+	return ""
+}
+
+// Returns false if traffic observed at the given time should be dropped
+// because it was observed during an uncaptured phase.
+func (p *Phases) Captured(t time.Time) bool {
+	if p == nil {
+		return true
+	}
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	s := p.find(t)
+	return s == nil || !s.uncaptured
+}
+
+// Returns the number of HTTP requests counted during the named phase.
+func (p *Phases) Requests(name string) int {
+	if p == nil {
+		return 0
+	}
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	for _, s := range p.spans {
+		if s.name == name {
+			return s.requests
+		}
+	}
+	return 0
+}
+
+func (p *Phases) countRequest(t time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if s := p.find(t); s != nil {
+		s.requests++
+	}
+}
+
+// Returns the given tags together with the phase running at the given time,
+// if any. The given tags are not modified and may be nil.
+func (p *Phases) TagsAt(base map[tags.Key]string, t time.Time) map[tags.Key]string {
+	name := p.At(t)
+	if name == "" {
+		return base
+	}
+	result := make(map[tags.Key]string, len(base)+1)
+	for k, v := range base {
+		result[k] = v
+	}
+	result[PhaseTag] = name
+	return result
+}
+
+// Drops HTTP traffic observed during uncaptured phases. Like a paused
+// CaptureSwitch, TCP and TLS metadata still passes.
+type phaseFilterCollector struct {
+	phases    *Phases
+	collector Collector
+}
+
+func NewPhaseFilterCollector(phases *Phases, collector Collector) Collector {
+	return &phaseFilterCollector{
+		phases:    phases,
+		collector: collector,
+	}
+}
+
+func (c *phaseFilterCollector) Process(t akinet.ParsedNetworkTraffic) error {
+	switch t.Content.(type) {
+	case akinet.TCPPacketMetadata, akinet.TCPConnectionMetadata, akinet.TLSHandshakeMetadata:
+	default:
+		if !c.phases.Captured(t.ObservationTime) {
+			return nil
+		}
+	}
+	return c.collector.Process(t)
+}
+
+func (c *phaseFilterCollector) Close() error {
+	return c.collector.Close()
+}
+
+// Counts HTTP requests against the phase they were observed in.
+type phaseCountCollector struct {
+	phases    *Phases
+	collector Collector
+}
+
+func NewPhaseCountCollector(phases *Phases, collector Collector) Collector {
+	return &phaseCountCollector{
+		phases:    phases,
+		collector: collector,
+	}
+}
+
+func (c *phaseCountCollector) Process(t akinet.ParsedNetworkTraffic) error {
+	if _, ok := t.Content.(akinet.HTTPRequest); ok {
+		c.phases.countRequest(t.ObservationTime)
+	}
+	return c.collector.Process(t)
+}
+
+func (c *phaseCountCollector) Close() error {
+	return c.collector.Close()
+}
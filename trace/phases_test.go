@@ -0,0 +1,77 @@
+package trace
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/tags"
+)
+
+func TestPhases(t *testing.T) {
+	start := time.Unix(1000, 0)
+	at := func(seconds int) time.Time {
+		return start.Add(time.Duration(seconds) * time.Second)
+	}
+
+	p := NewPhases()
+	p.StartUncaptured("setup", at(0))
+	p.Start("test", at(10))
+	p.End(at(20))
+	p.Start("teardown", at(25))
+
+	assert.Equal(t, "", p.At(at(-1)))
+	assert.Equal(t, "setup", p.At(at(0)))
+	assert.Equal(t, "setup", p.At(at(9)))
+	assert.Equal(t, "test", p.At(at(10)))
+	assert.Equal(t, "", p.At(at(20)))
+	assert.Equal(t, "teardown", p.At(at(100)))
+
+	base := map[tags.Key]string{"env": "ci"}
+	assert.Equal(t, map[tags.Key]string{"env": "ci", PhaseTag: "test"}, p.TagsAt(base, at(15)))
+	assert.Equal(t, base, p.TagsAt(base, at(22)))
+	assert.Len(t, base, 1)
+
+	assert.False(t, p.Captured(at(5)))
+	assert.True(t, p.Captured(at(10)))
+	assert.True(t, p.Captured(at(22)))
+
+	var none *Phases
+	assert.Equal(t, "", none.At(at(0)))
+	assert.Nil(t, none.TagsAt(nil, at(0)))
+	assert.True(t, none.Captured(at(0)))
+	assert.Equal(t, 0, none.Requests("test"))
+}
+
+func TestPhaseCollectors(t *testing.T) {
+	start := time.Unix(1000, 0)
+	at := func(seconds int) time.Time {
+		return start.Add(time.Duration(seconds) * time.Second)
+	}
+
+	p := NewPhases()
+	p.StartUncaptured("setup", at(0))
+	p.Start("test", at(10))
+	p.End(at(20))
+
+	sink := &countingCollector{}
+	c := NewPhaseFilterCollector(p, NewPhaseCountCollector(p, sink))
+
+	// Processed after the phases ended, as if parsing lagged behind capture.
+	for _, t := range []time.Time{at(5), at(15), at(16), at(25)} {
+		c.Process(akinet.ParsedNetworkTraffic{
+			Content:         akinet.HTTPRequest{},
+			ObservationTime: t,
+		})
+	}
+	c.Process(akinet.ParsedNetworkTraffic{
+		Content:         akinet.TCPPacketMetadata{},
+		ObservationTime: at(5),
+	})
+
+	assert.Equal(t, 0, p.Requests("setup"))
+	assert.Equal(t, 2, p.Requests("test"))
+	assert.Equal(t, 4, sink.GetNumPackets())
+}
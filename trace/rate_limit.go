@@ -161,6 +161,15 @@ func (r *SharedRateLimit) startNewEpoch(epochStart time.Time) {
 	}
 }
 
+// Returns whether witnesses are being captured, the number captured in the
+// current interval, and the current estimate of the time taken to capture
+// WitnessesPerEpoch.
+func (r *SharedRateLimit) State() (active bool, count int, estimatedInterval time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.SampleIntervalActive, r.SampleIntervalCount, r.EstimatedSampleInterval
+}
+
 // Check if request should be sampled; increase the count by one.
 func (r *SharedRateLimit) AllowHTTPRequest() bool {
 	r.lock.Lock()
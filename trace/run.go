@@ -9,7 +9,24 @@ import (
 	"github.com/akitasoftware/akita-libs/akinet/tls"
 )
 
-func Collect(stop <-chan struct{}, intf, bpfFilter string, bufferShare float32, proc Collector, packetCount PacketCountConsumer) error {
+// Optional settings for Collect.
+type CollectOptions struct {
+	// Share of the packet reassembly buffers that the capture may use.
+	BufferShare float32
+
+	// If set, the BPF filter is replaced with each filter received.
+	FilterUpdates <-chan string
+
+	// If set, counts the TCP packets captured.
+	PacketCount PacketCountConsumer
+
+	// If set, sentinel packets are injected into the capture, so that callers
+	// can tell when it has started and when it has drained.
+	Sentinels *col.Sentinels
+}
+
+// Collects traffic on the given interface until stop is closed.
+func Collect(stop <-chan struct{}, intf, bpfFilter string, proc Collector, opts CollectOptions) error {
 	defer proc.Close()
 
 	facts := []akinet.TCPParserFactory{
@@ -19,12 +36,15 @@ func Collect(stop <-chan struct{}, intf, bpfFilter string, bufferShare float32,
 		tls.NewTLSServerParserFactory(),
 	}
 
-	parser := col.NewNetworkTrafficParser(bufferShare)
+	parser := col.NewNetworkTrafficParser(opts.BufferShare)
 
-	if packetCount != nil {
-		parser.InstallObserver(CountTcpPackets(intf, packetCount))
+	if opts.PacketCount != nil {
+		parser.InstallObserver(CountTcpPackets(intf, opts.PacketCount))
 	}
 
+	parser.InstallFilterUpdates(opts.FilterUpdates)
+	parser.InstallSentinels(opts.Sentinels)
+
 	parsedChan, err := parser.ParseFromInterface(intf, bpfFilter, stop, facts...)
 	if err != nil {
 		return errors.Wrap(err, "couldn't start parsing from interface")
@@ -0,0 +1,36 @@
+package trace
+
+import (
+	"math"
+	"sync/atomic"
+
+	"github.com/OneOfOne/xxhash"
+)
+
+// A sample rate between 0.0 and 1.0 that can be changed while collectors are
+// using it. Safe for concurrent use.
+type SampleRate struct {
+	bits uint64
+}
+
+func NewSampleRate(rate float64) *SampleRate {
+	r := &SampleRate{}
+	r.Set(rate)
+	return r
+}
+
+func (r *SampleRate) Get() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&r.bits))
+}
+
+func (r *SampleRate) Set(rate float64) {
+	atomic.StoreUint64(&r.bits, math.Float64bits(rate))
+}
+
+// Sample based on stream ID and seq so a pair of request and response are
+// either both selected or both excluded.
+func (r *SampleRate) includeSample(key string) bool {
+	h := xxhash.New32()
+	h.WriteString(key)
+	return float64(h.Sum32()) < float64(math.MaxUint32)*r.Get()
+}
@@ -21,6 +21,9 @@ type PacketCounters struct {
 	HTTPRequests  int
 	HTTPResponses int
 	Unparsed      int
+
+	// Size of the TCP packets captured, including headers.
+	TCPBytes int64
 }
 
 func (c *PacketCounters) Add(d PacketCounters) {
@@ -28,6 +31,7 @@ func (c *PacketCounters) Add(d PacketCounters) {
 	c.HTTPRequests += d.HTTPRequests
 	c.HTTPResponses += d.HTTPResponses
 	c.Unparsed += d.Unparsed
+	c.TCPBytes += d.TCPBytes
 }
 
 // A consumer accepts incremental updates in the form
@@ -135,6 +139,17 @@ func (s *PacketCountSummary) TotalOnPort(port int) PacketCounters {
 	return PacketCounters{Interface: "*", SrcPort: port}
 }
 
+// Packet counters for all interfaces seen
+func (s *PacketCountSummary) AllInterfaces() []PacketCounters {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	ret := make([]PacketCounters, 0, len(s.byInterface))
+	for _, v := range s.byInterface {
+		ret = append(ret, *v)
+	}
+	return ret
+}
+
 // All available port numbers
 func (s *PacketCountSummary) AllPorts() []PacketCounters {
 	s.mutex.RLock()
@@ -156,6 +171,7 @@ func CountTcpPackets(ifc string, packetCount PacketCountConsumer) pcap.NetworkTr
 				SrcPort:    int(tcp.SrcPort),
 				DstPort:    int(tcp.DstPort),
 				TCPPackets: 1,
+				TCPBytes:   int64(len(p.Data())),
 			})
 		}
 	}
@@ -0,0 +1,37 @@
+package trace
+
+import "sync/atomic"
+
+// Pauses and resumes uploads to Akita Cloud by the back-end collectors that
+// share it. While paused, batches are saved to the upload spool, and are
+// uploaded once uploads resume. Batches that can't be spooled are uploaded
+// anyway.
+//
+// Safe for concurrent use. The zero value is not paused, and a nil
+// *UploadSwitch is never paused.
+type UploadSwitch struct {
+	paused int32
+}
+
+func (s *UploadSwitch) Pause() {
+	atomic.StoreInt32(&s.paused, 1)
+}
+
+func (s *UploadSwitch) Resume() {
+	atomic.StoreInt32(&s.paused, 0)
+}
+
+// Pauses uploads if they are running and resumes them if they are paused.
+// Returns true if uploads are now paused.
+func (s *UploadSwitch) Toggle() bool {
+	for {
+		old := atomic.LoadInt32(&s.paused)
+		if atomic.CompareAndSwapInt32(&s.paused, old, 1-old) {
+			return old == 0
+		}
+	}
+}
+
+func (s *UploadSwitch) Paused() bool {
+	return s != nil && atomic.LoadInt32(&s.paused) == 1
+}
@@ -0,0 +1,169 @@
+package traffic_direction
+
+import (
+	"net"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/tags"
+)
+
+// The direction of HTTP traffic relative to the host.
+type Direction string
+
+const (
+	// The host is the server.
+	Inbound Direction = "inbound"
+
+	// The host is the client.
+	Outbound Direction = "outbound"
+)
+
+// The API schema only defines an inbound direction, so witnesses of outbound
+// traffic are reported as inbound, with this tag set to "outbound".
+const DirectionTag tags.Key = "x-akita-direction"
+
+// Selects which traffic to capture, by direction.
+type Filter string
+
+const (
+	CaptureInbound  Filter = "inbound"
+	CaptureOutbound Filter = "outbound"
+	CaptureBoth     Filter = "both"
+)
+
+func ParseFilter(s string) (Filter, error) {
+	switch f := Filter(s); f {
+	case CaptureInbound, CaptureOutbound, CaptureBoth:
+		return f, nil
+	}
+	return "", errors.Errorf("unknown direction %q; must be %q, %q or %q", s, CaptureInbound, CaptureOutbound, CaptureBoth)
+}
+
+// Returns true if traffic in the given direction should be captured.
+func (f Filter) Allows(d Direction) bool {
+	switch f {
+	case CaptureInbound:
+		return d == Inbound
+	case CaptureOutbound:
+		return d == Outbound
+	}
+	return true
+}
+
+// Infers whether HTTP traffic seen on an interface is inbound -- the host is
+// the server -- or outbound -- the host is the client.
+//
+// The addresses of the interface usually settle the question. Traffic between
+// two services on the host (e.g. on the loopback interface) is both, and is
+// treated as inbound. When neither end is on the host (e.g. behind NAT), the
+// detector falls back on the ports the host has been seen accepting TCP
+// connections on. It learns these from TCP handshakes and from the connection
+// initiators reported by tcp_conn_tracker, so it should see all traffic on
+// the interface, not just HTTP.
+//
+// Traffic whose direction can't be determined is treated as inbound.
+//
+// A nil *Detector is valid and treats all traffic as inbound.
+type Detector struct {
+	hostIPs []net.IP
+
+	mutex sync.Mutex
+
+	// Ports on which the host has accepted TCP connections.
+	listeningPorts map[int]struct{}
+}
+
+func NewDetector(hostIPs []net.IP) *Detector {
+	return &Detector{
+		hostIPs:        hostIPs,
+		listeningPorts: make(map[int]struct{}),
+	}
+}
+
+// Learns from TCP metadata which ports the host listens on. Other traffic is
+// ignored.
+func (d *Detector) Observe(t akinet.ParsedNetworkTraffic) {
+	if d == nil {
+		return
+	}
+
+	switch c := t.Content.(type) {
+	case akinet.TCPPacketMetadata:
+		// A SYN-ACK is sent by the side accepting the connection.
+		if c.SYN && c.ACK {
+			d.observeServer(t.SrcIP, t.SrcPort)
+		}
+	case akinet.TCPConnectionMetadata:
+		switch c.Initiator {
+		case akinet.SourceInitiator:
+			d.observeServer(t.DstIP, t.DstPort)
+		case akinet.DestInitiator:
+			d.observeServer(t.SrcIP, t.SrcPort)
+		}
+	}
+}
+
+// Returns the direction of an HTTP request or response.
+func (d *Detector) Direction(t akinet.ParsedNetworkTraffic) Direction {
+	if d == nil {
+		return Inbound
+	}
+
+	var serverIP, clientIP net.IP
+	var serverPort int
+	switch t.Content.(type) {
+	case akinet.HTTPRequest:
+		serverIP, serverPort, clientIP = t.DstIP, t.DstPort, t.SrcIP
+	case akinet.HTTPResponse:
+		serverIP, serverPort, clientIP = t.SrcIP, t.SrcPort, t.DstIP
+	default:
+		return Inbound
+	}
+
+	serverLocal, clientLocal := d.isLocal(serverIP), d.isLocal(clientIP)
+	switch {
+	case serverLocal && !clientLocal:
+		d.addListeningPort(serverPort)
+		return Inbound
+	case clientLocal && !serverLocal:
+		return Outbound
+	case serverLocal && clientLocal:
+		// Both the client and the server are on the host. Report the traffic
+		// from the server's point of view.
+		return Inbound
+	}
+
+	// Neither address belongs to the interface. If we know which ports the
+	// host listens on, assume the server is the host only if it uses one of
+	// them.
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if _, ok := d.listeningPorts[serverPort]; ok || len(d.listeningPorts) == 0 {
+		return Inbound
+	}
+	return Outbound
+}
+
+func (d *Detector) observeServer(ip net.IP, port int) {
+	if d.isLocal(ip) {
+		d.addListeningPort(port)
+	}
+}
+
+func (d *Detector) addListeningPort(port int) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.listeningPorts[port] = struct{}{}
+}
+
+func (d *Detector) isLocal(ip net.IP) bool {
+	for _, h := range d.hostIPs {
+		if h.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
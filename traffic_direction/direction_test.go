@@ -0,0 +1,80 @@
+package traffic_direction
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+var (
+	hostIP   = net.ParseIP("10.0.0.1")
+	remoteIP = net.ParseIP("10.0.0.2")
+	otherIP  = net.ParseIP("10.0.0.3")
+)
+
+func request(srcIP net.IP, srcPort int, dstIP net.IP, dstPort int) akinet.ParsedNetworkTraffic {
+	return akinet.ParsedNetworkTraffic{
+		SrcIP:   srcIP,
+		SrcPort: srcPort,
+		DstIP:   dstIP,
+		DstPort: dstPort,
+		Content: akinet.HTTPRequest{},
+	}
+}
+
+func response(srcIP net.IP, srcPort int, dstIP net.IP, dstPort int) akinet.ParsedNetworkTraffic {
+	t := request(srcIP, srcPort, dstIP, dstPort)
+	t.Content = akinet.HTTPResponse{}
+	return t
+}
+
+func TestDirectionFromAddresses(t *testing.T) {
+	d := NewDetector([]net.IP{hostIP})
+
+	assert.Equal(t, Inbound, d.Direction(request(remoteIP, 51000, hostIP, 8080)))
+	assert.Equal(t, Inbound, d.Direction(response(hostIP, 8080, remoteIP, 51000)))
+	assert.Equal(t, Outbound, d.Direction(request(hostIP, 52000, remoteIP, 443)))
+	assert.Equal(t, Outbound, d.Direction(response(remoteIP, 443, hostIP, 52000)))
+	assert.Equal(t, Inbound, d.Direction(request(hostIP, 53000, hostIP, 9090)))
+}
+
+func TestDirectionFromListeningPorts(t *testing.T) {
+	d := NewDetector([]net.IP{hostIP})
+
+	// Nothing is known about the ports yet.
+	assert.Equal(t, Inbound, d.Direction(request(otherIP, 51000, remoteIP, 443)))
+
+	// The host accepts a connection on port 8080.
+	d.Observe(akinet.ParsedNetworkTraffic{
+		SrcIP:   hostIP,
+		SrcPort: 8080,
+		DstIP:   remoteIP,
+		DstPort: 51000,
+		Content: akinet.TCPPacketMetadata{SYN: true, ACK: true},
+	})
+
+	assert.Equal(t, Inbound, d.Direction(request(otherIP, 51000, remoteIP, 8080)))
+	assert.Equal(t, Outbound, d.Direction(request(otherIP, 51000, remoteIP, 443)))
+}
+
+func TestNilDetector(t *testing.T) {
+	var d *Detector
+	d.Observe(request(hostIP, 52000, remoteIP, 443))
+	assert.Equal(t, Inbound, d.Direction(request(hostIP, 52000, remoteIP, 443)))
+}
+
+func TestFilter(t *testing.T) {
+	f, err := ParseFilter("outbound")
+	assert.NoError(t, err)
+	assert.True(t, f.Allows(Outbound))
+	assert.False(t, f.Allows(Inbound))
+
+	assert.True(t, CaptureBoth.Allows(Outbound))
+	assert.True(t, CaptureInbound.Allows(Inbound))
+
+	_, err = ParseFilter("sideways")
+	assert.Error(t, err)
+}
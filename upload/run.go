@@ -16,6 +16,7 @@ import (
 	"github.com/akitasoftware/akita-cli/printer"
 	"github.com/akitasoftware/akita-cli/rest"
 	"github.com/akitasoftware/akita-cli/trace"
+	"github.com/akitasoftware/akita-cli/upload_spool"
 	"github.com/akitasoftware/akita-cli/util"
 )
 
@@ -54,7 +55,9 @@ func Run(args Args) error {
 		}
 
 	case akiuri.TRACE:
-		if err := uploadTraces(learnClient, args, svc, objectName); err != nil {
+		err := uploadTraces(learnClient, args, svc, objectName)
+		upload_spool.PrintCounts()
+		if err != nil {
 			return err
 		}
 
@@ -139,8 +142,8 @@ func uploadTraces(learnClient rest.LearnClient, args Args, serviceID akid.Servic
 
 	for _, harFileName := range args.FilePaths {
 		printer.Stderr.Infof("Uploading %q...\n", harFileName)
-		if _, err := apispec.ProcessHAR(inboundCollector, harFileName); err != nil {
-			return errors.Wrapf(err, "failed to process HAR file %q", harFileName)
+		if _, err := apispec.ProcessLocalTrace(inboundCollector, harFileName); err != nil {
+			return errors.Wrapf(err, "failed to process %q", harFileName)
 		}
 	}
 
@@ -0,0 +1,72 @@
+package upload_spool
+
+import (
+	"sync"
+
+	"github.com/akitasoftware/akita-cli/printer"
+)
+
+// Counts of upload batches handled by spools in this process.
+type Counts struct {
+	// Batches written to a spool after failing to upload, or while uploads
+	// were paused.
+	Spooled int
+
+	// Spooled batches that were later uploaded successfully.
+	Retried int
+
+	// Batches dropped because the spool was full, they could not be written
+	// to it, or Akita Cloud rejected them outright.
+	Abandoned int
+
+	// Batches still waiting in a spool when it was last closed.
+	Pending int
+}
+
+var counts struct {
+	mutex sync.Mutex
+	Counts
+}
+
+func CountSpooled() {
+	counts.mutex.Lock()
+	defer counts.mutex.Unlock()
+	counts.Spooled++
+}
+
+func CountRetried() {
+	counts.mutex.Lock()
+	defer counts.mutex.Unlock()
+	counts.Retried++
+}
+
+func CountAbandoned() {
+	counts.mutex.Lock()
+	defer counts.mutex.Unlock()
+	counts.Abandoned++
+}
+
+// Adds to the number of batches left pending when a spool is closed.
+func AddPending(n int) {
+	counts.mutex.Lock()
+	defer counts.mutex.Unlock()
+	counts.Pending += n
+}
+
+// Returns a snapshot of the counts.
+func GetCounts() Counts {
+	counts.mutex.Lock()
+	defer counts.mutex.Unlock()
+	return counts.Counts
+}
+
+// Prints a summary of the counts to stderr, if any batches were spooled or
+// abandoned.
+func PrintCounts() {
+	c := GetCounts()
+	if c == (Counts{}) {
+		return
+	}
+	printer.Stderr.Infof("Upload retries: %d batches spooled, %d uploaded on retry, %d abandoned, %d still pending\n",
+		c.Spooled, c.Retried, c.Abandoned, c.Pending)
+}
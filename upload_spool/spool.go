@@ -0,0 +1,238 @@
+package upload_spool
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-libs/akid"
+	kgxapi "github.com/akitasoftware/akita-libs/api_schema"
+)
+
+const (
+	// Default limit on the total size of batches spooled for a learn session.
+	DefaultMaxBytes = 100 * 1024 * 1024
+
+	batchSuffix = ".json"
+)
+
+// Returned by Add when the spool has no room for a batch.
+var ErrFull = errors.New("upload spool is full")
+
+// A directory of upload batches that could not be sent to Akita Cloud, kept
+// so they can be retried later. Each learn session has its own directory, so
+// batches left over from an earlier run are picked up by the next run that
+// adds to the same learn session.
+//
+// The directory is only created once a batch is added.
+type Spool struct {
+	dir      string
+	maxBytes int64
+
+	mutex sync.Mutex
+
+	// Number of callers of Open that have not yet called Close.
+	refs int
+
+	// Batches waiting to be retried, oldest first. Batches that have been
+	// taken for retrying are not included.
+	batches []spooledBatch
+
+	// Total size of all batches in the spool, including those taken.
+	bytes int64
+
+	// Used to make file names unique.
+	seq int
+}
+
+type spooledBatch struct {
+	path string
+	size int64
+}
+
+// A batch taken from the spool.
+type Batch struct {
+	Request *kgxapi.UploadReportsRequest
+
+	spooledBatch
+}
+
+// Spools that are open, by directory. Collectors for the same learn session
+// share a spool, so that each batch is only retried by one of them.
+var openSpools = struct {
+	sync.Mutex
+	byDir map[string]*Spool
+}{byDir: make(map[string]*Spool)}
+
+// Opens the spool for the given learn session under baseDir, picking up any
+// batches already in it. Each call must be matched by a call to Close.
+func Open(baseDir string, lrn akid.LearnSessionID, maxBytes int64) (*Spool, error) {
+	dir := filepath.Join(baseDir, akid.String(lrn))
+
+	openSpools.Lock()
+	defer openSpools.Unlock()
+
+	if s, ok := openSpools.byDir[dir]; ok {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.refs++
+		return s, nil
+	}
+
+	s := &Spool{
+		dir:      dir,
+		maxBytes: maxBytes,
+		refs:     1,
+	}
+
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "failed to read upload spool %s", s.dir)
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), batchSuffix) {
+			continue
+		}
+		s.batches = append(s.batches, spooledBatch{
+			path: filepath.Join(s.dir, e.Name()),
+			size: e.Size(),
+		})
+		s.bytes += e.Size()
+	}
+	// File names start with a timestamp, so this puts the oldest first.
+	sort.Slice(s.batches, func(i, j int) bool {
+		return s.batches[i].path < s.batches[j].path
+	})
+
+	openSpools.byDir[dir] = s
+	return s, nil
+}
+
+// Releases the spool. Batches still in it are left on disk for the next run.
+// If this was the last open reference, returns the number of batches left;
+// otherwise, returns 0.
+func (s *Spool) Close() int {
+	openSpools.Lock()
+	defer openSpools.Unlock()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.refs--
+	if s.refs > 0 {
+		return 0
+	}
+	delete(openSpools.byDir, s.dir)
+	AddPending(len(s.batches))
+	return len(s.batches)
+}
+
+func (s *Spool) Dir() string {
+	return s.dir
+}
+
+// Returns the number of batches waiting to be retried.
+func (s *Spool) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.batches)
+}
+
+// Writes a batch to the spool. Returns ErrFull if that would take the spool
+// over its size limit.
+func (s *Spool) Add(req *kgxapi.UploadReportsRequest) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal upload batch")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.maxBytes > 0 && s.bytes+int64(len(b)) > s.maxBytes {
+		return ErrFull
+	}
+
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return errors.Wrap(err, "failed to create upload spool")
+	}
+	s.seq++
+	p := filepath.Join(s.dir, fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), s.seq, batchSuffix))
+
+	// Write to a temporary file first so that a crash never leaves a partial
+	// batch in the spool.
+	tmp := p + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, "failed to write upload batch")
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, "failed to write upload batch")
+	}
+
+	s.batches = append(s.batches, spooledBatch{path: p, size: int64(len(b))})
+	s.bytes += int64(len(b))
+	return nil
+}
+
+// Takes the oldest batch from the spool for retrying, or returns nil if there
+// is none. The caller must either Remove the batch once it has been uploaded
+// or Return it to the spool.
+//
+// Batches that cannot be read are removed, and an error is returned.
+func (s *Spool) Take() (*Batch, error) {
+	s.mutex.Lock()
+	if len(s.batches) == 0 {
+		s.mutex.Unlock()
+		return nil, nil
+	}
+	sb := s.batches[0]
+	s.batches = s.batches[1:]
+	s.mutex.Unlock()
+
+	b := &Batch{spooledBatch: sb}
+	content, err := ioutil.ReadFile(sb.path)
+	if err == nil {
+		var req kgxapi.UploadReportsRequest
+		if err = json.Unmarshal(content, &req); err == nil {
+			b.Request = &req
+			return b, nil
+		}
+	}
+
+	s.Remove(b)
+	return nil, errors.Wrapf(err, "failed to read spooled batch %s", sb.path)
+}
+
+// Puts a batch taken from the spool back at the front.
+func (s *Spool) Return(b *Batch) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.batches = append([]spooledBatch{b.spooledBatch}, s.batches...)
+}
+
+// Deletes a batch taken from the spool.
+func (s *Spool) Remove(b *Batch) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.bytes -= b.size
+	if err := os.Remove(b.path); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "failed to remove spooled batch %s", b.path)
+	}
+
+	// Clean up the directory once it is empty. This fails harmlessly if
+	// anything else is in it.
+	if s.bytes == 0 {
+		os.Remove(s.dir)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package upload_spool
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/akitasoftware/akita-libs/akid"
+	kgxapi "github.com/akitasoftware/akita-libs/api_schema"
+)
+
+func batch(id string) *kgxapi.UploadReportsRequest {
+	return &kgxapi.UploadReportsRequest{
+		Witnesses: []*kgxapi.WitnessReport{{WitnessProto: id}},
+	}
+}
+
+func TestSpool(t *testing.T) {
+	dir := t.TempDir()
+	lrn := akid.NewLearnSessionID(uuid.New())
+
+	s, err := Open(dir, lrn, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, s.Add(batch("a")))
+	assert.NoError(t, s.Add(batch("b")))
+
+	// Opening the same learn session again shares the spool.
+	s2, err := Open(dir, lrn, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, s == s2)
+
+	// Batches are taken oldest first, and only once.
+	a, err := s.Take()
+	assert.NoError(t, err)
+	assert.Equal(t, "a", a.Request.Witnesses[0].WitnessProto)
+	assert.Equal(t, 1, s2.Len())
+
+	// Returned batches go back to the front.
+	s.Return(a)
+	a, _ = s2.Take()
+	assert.Equal(t, "a", a.Request.Witnesses[0].WitnessProto)
+	assert.NoError(t, s2.Remove(a))
+
+	assert.Equal(t, 0, s2.Close())
+	assert.Equal(t, 1, s.Close())
+
+	// Remaining batches are picked up when the spool is reopened.
+	s, err = Open(dir, lrn, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := s.Take()
+	assert.NoError(t, err)
+	assert.Equal(t, "b", b.Request.Witnesses[0].WitnessProto)
+	assert.NoError(t, s.Remove(b))
+	b, err = s.Take()
+	assert.NoError(t, err)
+	assert.Nil(t, b)
+	assert.Equal(t, 0, s.Close())
+}
+
+func TestSpoolFull(t *testing.T) {
+	b, _ := json.Marshal(batch("a"))
+
+	// Leave room for one batch only.
+	s, err := Open(t.TempDir(), akid.NewLearnSessionID(uuid.New()), int64(len(b)*3/2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	assert.NoError(t, s.Add(batch("a")))
+	assert.Equal(t, ErrFull, s.Add(batch("b")))
+	assert.Equal(t, 1, s.Len())
+}
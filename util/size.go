@@ -0,0 +1,50 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Parses a size in bytes, with an optional B, KB, MB or GB suffix, e.g.
+// "512KB" or "1.5GB". Units are powers of 1024.
+func ParseSize(s string) (int64, error) {
+	upper := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(upper, unit.suffix) {
+			upper = strings.TrimSpace(strings.TrimSuffix(upper, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(upper, 64)
+	if err != nil || n < 0 {
+		return 0, errors.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// Formats a size in bytes using the largest unit accepted by ParseSize that
+// it is at least one of, e.g. "1.5GB".
+func FormatSize(n int64) string {
+	for _, unit := range sizeUnits {
+		if n >= unit.multiplier && unit.multiplier > 1 {
+			return strconv.FormatFloat(float64(n)/float64(unit.multiplier), 'f', -1, 64) + unit.suffix
+		}
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+// Largest first, so that "B" is only matched on its own.
+var sizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
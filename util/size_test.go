@@ -0,0 +1,37 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSize(t *testing.T) {
+	for s, expected := range map[string]int64{
+		"0":      0,
+		"512":    512,
+		"512B":   512,
+		"2kb":    2 << 10,
+		"1.5MB":  3 << 19,
+		"2 GB":   2 << 30,
+		" 10KB ": 10 << 10,
+	} {
+		n, err := ParseSize(s)
+		if assert.NoError(t, err, s) {
+			assert.Equal(t, expected, n, s)
+		}
+	}
+
+	for _, s := range []string{"", "GB", "-1MB", "1TB", "ten"} {
+		_, err := ParseSize(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	assert.Equal(t, "0B", FormatSize(0))
+	assert.Equal(t, "1023B", FormatSize(1023))
+	assert.Equal(t, "1KB", FormatSize(1<<10))
+	assert.Equal(t, "1.5MB", FormatSize(3<<19))
+	assert.Equal(t, "2GB", FormatSize(2<<30))
+}